@@ -0,0 +1,258 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEChallengePath is the path prefix of ACME HTTP-01 challenge requests
+const ACMEChallengePath = "/.well-known/acme-challenge/"
+
+// ACMEEventType is the type of ACMEEvent
+type ACMEEventType int
+
+const (
+	// ACMECertificateObtained occurs when a new or renewed certificate is stored
+	ACMECertificateObtained ACMEEventType = iota
+	// ACMECertificateFailed occurs when the certificate could not be obtained
+	ACMECertificateFailed
+)
+
+// String implements fmt.Stringer
+func (t ACMEEventType) String() string {
+	switch t {
+	case ACMECertificateObtained:
+		return "obtained"
+	case ACMECertificateFailed:
+		return "failed"
+	}
+
+	return "unknown"
+}
+
+// ACMEEvent describes certificate issuance or renewal
+type ACMEEvent struct {
+	Type   ACMEEventType
+	Domain string
+	Err    error
+}
+
+// OptACMEDirectoryURL sets the ACME directory URL, e.g. a private CA
+// or a local test server. By default, Let's Encrypt production is used.
+func OptACMEDirectoryURL(url string) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.acmeDirectoryURL = url
+	}
+}
+
+// OptACMEHosts allows ListenAndServeAutoTLS to obtain certificates for the hosts.
+// Domains registered with app.Domain() are always allowed.
+// Without hosts, domains and OptACMEHostPolicy no certificates are obtained.
+func OptACMEHosts(hosts ...string) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.acmeHosts = append(app.acmeHosts, hosts...)
+	}
+}
+
+// OptACMEHostPolicy sets a custom policy of hosts ListenAndServeAutoTLS
+// obtains certificates for. It replaces the default policy,
+// that allows only app.Domain() domains and OptACMEHosts.
+func OptACMEHostPolicy(policy autocert.HostPolicy) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.acmeHostPolicy = policy
+	}
+}
+
+// OptACMECache sets the certificate cache, e.g. a shared storage for
+// multiple instances. It takes precedence over the cachePath
+// of ListenAndServeAutoTLS.
+func OptACMECache(cache autocert.Cache) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.acmeCache = cache
+	}
+}
+
+// OptACMEEvents sets the handler of certificate issuance and renewal events
+func OptACMEEvents(handler func(ACMEEvent)) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.acmeEvents = handler
+	}
+}
+
+// acmeManager returns the autocert manager, creating it on the first call
+func (app *App) acmeManager(cachePath string) *autocert.Manager {
+	app.acmeMu.Lock()
+	defer app.acmeMu.Unlock()
+
+	if app.acme != nil {
+		return app.acme
+	}
+
+	r := rand.New(rand.NewSource(time.Now().Unix()))
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Email:      app.TLSEmails[r.Intn(len(app.TLSEmails))],
+		HostPolicy: app.acmeHostPolicy,
+	}
+	if m.HostPolicy == nil {
+		m.HostPolicy = app.acmeDefaultHostPolicy
+		app.domainListLock.RLock()
+		noDomains := len(app.domains) == 0
+		app.domainListLock.RUnlock()
+		if len(app.acmeHosts) == 0 && noDomains {
+			app.internalLog.Warn("no ACME hosts or domains registered, certificates will not be obtained")
+		}
+	}
+	if len(app.acmeDirectoryURL) > 0 {
+		m.Client = &acme.Client{
+			DirectoryURL: app.acmeDirectoryURL,
+		}
+	}
+
+	cache := app.acmeCache
+	if cache == nil && cachePath != "" {
+		cache = autocert.DirCache(cachePath)
+	}
+	m.Cache = &acmeEventCache{
+		cache: cache,
+		app:   app,
+	}
+
+	app.acme = m
+	return m
+}
+
+// acmeDefaultHostPolicy allows domains registered with app.Domain()
+// and OptACMEHosts only. Any other host is denied.
+func (app *App) acmeDefaultHostPolicy(_ context.Context, host string) error {
+	for _, h := range app.acmeHosts {
+		if strings.EqualFold(h, host) {
+			return nil
+		}
+	}
+
+	app.domainListLock.RLock()
+	_, ok := app.domains[host]
+	app.domainListLock.RUnlock()
+	if ok {
+		return nil
+	}
+
+	return fmt.Errorf("acme: host %q is not allowed", host)
+}
+
+func (app *App) acmeEvent(e ACMEEvent) {
+	l := app.internalLog.WithField("domain", e.Domain).WithField("event", e.Type.String())
+	if e.Err != nil {
+		l.WithError(e.Err).Error("ACME certificate event")
+	} else {
+		l.Info("ACME certificate event")
+	}
+
+	if app.acmeEvents != nil {
+		app.acmeEvents(e)
+	}
+}
+
+// ACMEChallengeHandler serves ACME HTTP-01 challenges. ListenAndServeAll
+// mounts it on the HTTP server automatically; use it if you serve HTTP
+// with ListenAndServe on your own. Requests outside of ACMEChallengePath
+// or before ListenAndServeAutoTLS started receive 404 Not Found.
+func (app *App) ACMEChallengeHandler(ctx *Context) {
+	app.acmeMu.Lock()
+	m := app.acme
+	app.acmeMu.Unlock()
+
+	if m == nil || !strings.HasPrefix(string(ctx.Path()), ACMEChallengePath) {
+		ctx.NotFound()
+		return
+	}
+
+	NewGrameHandler(m.HTTPHandler(nil))(ctx)
+}
+
+// mountACMEChallenge registers middleware, that serves HTTP-01 challenges
+func (app *App) mountACMEChallenge() {
+	app.acmeMountOnce.Do(func() {
+		err := app.UsePre(func(ctx *Context) {
+			if !strings.HasPrefix(string(ctx.Path()), ACMEChallengePath) {
+				return
+			}
+			app.ACMEChallengeHandler(ctx)
+			ctx.MWKill()
+		})
+		if err != nil {
+			app.internalLog.WithError(err).Error("could not mount ACME challenge handler")
+		}
+	})
+}
+
+// acmeEventCache wraps autocert cache to emit events on certificate updates
+type acmeEventCache struct {
+	cache autocert.Cache
+	app   *App
+}
+
+func (c *acmeEventCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if c.cache == nil {
+		return nil, autocert.ErrCacheMiss
+	}
+	return c.cache.Get(ctx, key)
+}
+
+func (c *acmeEventCache) Put(ctx context.Context, key string, data []byte) error {
+	var err error
+	if c.cache != nil {
+		err = c.cache.Put(ctx, key, data)
+	}
+
+	if domain, ok := acmeCertKeyDomain(key); ok {
+		c.app.acmeEvent(ACMEEvent{
+			Type:   ACMECertificateObtained,
+			Domain: domain,
+			Err:    err,
+		})
+	}
+
+	return err
+}
+
+func (c *acmeEventCache) Delete(ctx context.Context, key string) error {
+	if c.cache == nil {
+		return nil
+	}
+	return c.cache.Delete(ctx, key)
+}
+
+// acmeCertKeyDomain returns the domain if the cache key stores a certificate,
+// not an account key or challenge data
+func acmeCertKeyDomain(key string) (string, bool) {
+	if strings.HasPrefix(key, "acme_account") ||
+		strings.HasSuffix(key, "+token") ||
+		strings.HasSuffix(key, "+http-01") {
+		return "", false
+	}
+
+	return strings.TrimSuffix(key, "+rsa"), true
+}
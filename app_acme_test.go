@@ -0,0 +1,127 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestACMEHostPolicy(t *testing.T) {
+	app := New()
+	if err := app.acmeDefaultHostPolicy(context.Background(), "any.example.com"); err == nil {
+		t.Fatalf("any host should be denied without domains")
+	}
+
+	app = New(OptACMEHosts("static.example.com"))
+	app.Domain("api.example.com")
+	for host, allowed := range map[string]bool{
+		"static.example.com": true,
+		"api.example.com":    true,
+		"evil.example.com":   false,
+	} {
+		err := app.acmeDefaultHostPolicy(context.Background(), host)
+		if (err == nil) != allowed {
+			t.Errorf("%q: unexpected policy result: %v", host, err)
+		}
+	}
+
+	app = New(
+		OptACMEHostPolicy(autocert.HostWhitelist("only.example.com")),
+		OptACMEDirectoryURL("https://127.0.0.1:14000/dir"),
+	)
+	app.TLSEmails = []string{"admin@example.com"}
+	m := app.acmeManager("")
+	if m.HostPolicy(context.Background(), "api.example.com") == nil {
+		t.Errorf("custom host policy should be used")
+	}
+	if m.Client == nil || m.Client.DirectoryURL != "https://127.0.0.1:14000/dir" {
+		t.Errorf("directory URL is not applied")
+	}
+	if app.acmeManager("other") != m {
+		t.Errorf("manager should be created once")
+	}
+}
+
+func TestACMEEventCache(t *testing.T) {
+	var events []ACMEEvent
+	app := New(OptACMEEvents(func(e ACMEEvent) {
+		events = append(events, e)
+	}))
+
+	c := &acmeEventCache{app: app}
+	if _, err := c.Get(context.Background(), "example.com"); err != autocert.ErrCacheMiss {
+		t.Fatalf("expected cache miss, got %v", err)
+	}
+	for _, key := range []string{"acme_account+key", "example.com+token", "token+http-01", "example.com", "example.org+rsa"} {
+		if err := c.Put(context.Background(), key, []byte("data")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if len(events) != 2 ||
+		events[0].Domain != "example.com" || events[0].Type != ACMECertificateObtained ||
+		events[1].Domain != "example.org" {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+
+	errPut := errors.New("put failed")
+	c.cache = failingCache{errPut}
+	if err := c.Put(context.Background(), "example.net", nil); err != errPut {
+		t.Fatalf("expected cache error, got %v", err)
+	}
+	if last := events[len(events)-1]; last.Domain != "example.net" || last.Err != errPut {
+		t.Fatalf("unexpected event: %+v", last)
+	}
+}
+
+type failingCache struct {
+	err error
+}
+
+func (c failingCache) Get(context.Context, string) ([]byte, error) { return nil, c.err }
+func (c failingCache) Put(context.Context, string, []byte) error   { return c.err }
+func (c failingCache) Delete(context.Context, string) error        { return c.err }
+
+func TestACMEChallengeMount(t *testing.T) {
+	app := New(OptACMEHosts("example.com"))
+	app.TLSEmails = []string{"admin@example.com"}
+	app.GET("/", "index")
+	app.mountACMEChallenge()
+	app.mountACMEChallenge()
+
+	serve := func(path string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(path)
+		ctx.Request.Header.SetHost("example.com")
+		app.handler()(ctx)
+		return ctx
+	}
+
+	if ctx := serve(ACMEChallengePath + "token"); ctx.Response.StatusCode() != fasthttp.StatusNotFound {
+		t.Fatalf("expected 404 before the manager started, got %d", ctx.Response.StatusCode())
+	}
+
+	app.acmeManager("")
+	if ctx := serve(ACMEChallengePath + "token"); ctx.Response.StatusCode() != fasthttp.StatusNotFound {
+		t.Fatalf("expected 404 for unknown token, got %d", ctx.Response.StatusCode())
+	}
+	if ctx := serve("/"); string(ctx.Response.Body()) != "index" {
+		t.Fatalf("unexpected response for regular request: %q", ctx.Response.Body())
+	}
+	if n := len(app.preMiddlewares); n != 1 {
+		t.Fatalf("challenge handler should be mounted once, got %d", n)
+	}
+}
@@ -1,13 +1,25 @@
-// +build cache
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
 
 package gramework
 
 import (
-	"encoding/json"
+	"bytes"
 	"errors"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/VictoriaMetrics/fastcache"
+	"github.com/valyala/fasthttp"
 )
 
 func (opts *CacheOptions) validate() error {
@@ -20,6 +32,9 @@ func (opts *CacheOptions) validate() error {
 	if opts.Cacheable == nil {
 		opts.Cacheable = defaultCacheOpts.Cacheable
 	}
+	if opts.CacheableStatusCodes == nil {
+		opts.CacheableStatusCodes = DefaultCacheableStatusCodes
+	}
 
 	return nil
 }
@@ -31,7 +46,8 @@ func NewCacheOptions() *CacheOptions {
 	return &CacheOptions{
 		TTL: 30 * time.Second,
 		Cacheable: func(ctx *Context) bool {
-			if len(ctx.Request.Header.Peek("Authentication")) > 0 {
+			if len(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization)) > 0 ||
+				len(ctx.Request.Header.Peek("Authentication")) > 0 {
 				return false
 			}
 
@@ -42,97 +58,489 @@ func NewCacheOptions() *CacheOptions {
 			return true
 		},
 		CacheKey: func(ctx *Context) []byte {
-			return ctx.Path()
+			key := make([]byte, 0, len(ctx.Method())+len(ctx.Host())+len(ctx.RequestURI())+2)
+			key = append(key, ctx.Method()...)
+			key = append(key, ' ')
+			key = append(key, ctx.Host()...)
+			key = append(key, ' ')
+			return append(key, ctx.RequestURI()...)
 		},
+		CacheableStatusCodes: DefaultCacheableStatusCodes,
 	}
 }
 
 // CacheFor is a shortcut to set ttl easily. See app.Cache() for docs.
 func (app *App) CacheFor(handler interface{}, ttl time.Duration) func(ctx *Context) {
-	opts := app.getCacheOpts()
+	opts := *app.getCacheOpts()
 
 	opts.TTL = ttl
-	return app.Cache(handler, opts)
+	return app.Cache(handler, &opts)
 }
 
 // Cache wrapper will cache given handler using provided options. If options parameter omitted,
 // this function will use default options.
 //
+// Only GET and HEAD requests are cached. The cache follows HTTP caching semantics:
+// only responses with status codes from CacheableStatusCodes are stored,
+// responses with Cache-Control: no-store, private or no-cache, with Set-Cookie
+// or with Vary: * are never stored. Response freshness is taken from s-maxage,
+// max-age or Expires, and TTL is used if the response has none of them.
+// Responses with Vary are stored per values of listed request headers.
+//
+// Clients may skip the cache with Cache-Control: no-store, or force the refresh
+// of the cached response with Cache-Control: no-cache or max-age=0.
+//
+// Cached responses are served with their status code and headers, the Age header
+// and the X-Cache header: HIT, STALE, MISS, REVALIDATED or BYPASS. Conditional client
+// requests are answered with 304 Not Modified from the cache. Expired responses
+// with ETag or Last-Modified are revalidated: the handler gets If-None-Match
+// or If-Modified-Since and may respond with 304 Not Modified to refresh the cached response.
+//
+// Concurrent requests for the same missing or expired response are coalesced:
+// the handler runs once and other requests wait for its response, see DisableCoalescing.
+// Expired responses are served stale during the stale-while-revalidate period
+// while they are refreshed in the background, and during the stale-if-error period
+// if the handler responds with 5xx status code. Periods are taken from the response
+// Cache-Control, or StaleWhileRevalidate and StaleIfError options.
+//
 // NOTE: Please, your CacheOptions' TTL must be more than 0.
 func (app *App) Cache(handler interface{}, options ...*CacheOptions) func(ctx *Context) {
-	opts := app.getCacheOpts(options...)
+	opts := *app.getCacheOpts(options...)
 
 	if err := opts.validate(); err != nil {
 		app.Logger.WithError(err).Fatal("could not initialize cache middleware: check options")
 	}
 
-	wrappedHandler := app.defaultRouter.determineHandler(handler)
-
 	if opts.ReadCache == nil || opts.StoreCache == nil {
-		cache := fastcache.New(1)
-		opts.ReadCache = readFastCache(cache)
-		opts.StoreCache = storeFastCache(cache)
-	}
-
-	return func(ctx *Context) {
-		if opts.Cacheable(ctx) {
-			cacheKey := opts.CacheKey(ctx)
-			if value, isValid := opts.ReadCache(ctx, cacheKey); isValid {
-				serializedHeaders, isValid := opts.ReadCache(ctx, append(cacheKey, []byte("-headers")...))
-				if isValid {
-					headers := map[string]string{}
-					err := json.Unmarshal(serializedHeaders, &headers)
-					if err == nil {
-						for name, value := range headers {
-							ctx.Response.Header.Set(name, value)
-						}
-						ctx.Response.SetBody(value)
-						return
-					}
+		opts.ReadCache = app.cache.read
+		opts.StoreCache = app.cache.write
+	}
+
+	h := &cacheHandler{
+		opts:     &opts,
+		handler:  app.defaultRouter.determineHandler(handler),
+		stats:    app.cache,
+		inFlight: make(map[string]chan struct{}),
+	}
+	return h.serve
+}
+
+// cacheHandler is the handler wrapper, returned by App.Cache
+type cacheHandler struct {
+	opts    *CacheOptions
+	handler func(*Context)
+	stats   *sharedCache
+
+	inFlightMu sync.Mutex
+	inFlight   map[string]chan struct{}
+}
+
+func (h *cacheHandler) serve(ctx *Context) {
+	if !ctx.IsGet() && !ctx.IsHead() || !h.opts.Cacheable(ctx) {
+		h.bypass(ctx)
+		return
+	}
+
+	reqCC := parseCacheControl(ctx.Request.Header.Peek(fasthttp.HeaderCacheControl))
+	if reqCC.noStore {
+		h.bypass(ctx)
+		return
+	}
+	skipRead := reqCC.noCache || reqCC.maxAge == 0 ||
+		bytes.Contains(ctx.Request.Header.Peek(fasthttp.HeaderPragma), []byte("no-cache"))
+
+	now := time.Now()
+	baseKey := append([]byte(nil), h.opts.CacheKey(ctx)...)
+
+	stale := h.opts.readEntry(ctx, baseKey)
+	if stale != nil && !skipRead {
+		if now.Before(stale.expiresAt) {
+			stale.serve(ctx, now, cacheHit)
+			h.stats.count(cacheHit)
+			return
+		}
+		if stale.servableStale(now, stale.staleWhileRevalidate) {
+			h.refresh(ctx, baseKey, stale)
+			stale.serve(ctx, now, cacheStale)
+			h.stats.count(cacheStale)
+			return
+		}
+	}
+
+	if !skipRead && !h.opts.DisableCoalescing {
+		done, leader := h.join(baseKey)
+		if leader {
+			defer h.leave(baseKey, done)
+		} else {
+			<-done
+			now = time.Now()
+			if entry := h.opts.readEntry(ctx, baseKey); entry != nil {
+				if now.Before(entry.expiresAt) {
+					entry.serve(ctx, now, cacheHit)
+					h.stats.count(cacheHit)
+					return
 				}
+				stale = entry
 			}
+		}
+	}
 
-			wrappedHandler(ctx)
+	h.stats.count(h.fetch(ctx, baseKey, stale, now))
+}
 
-			b := ctx.Response.Body()
+func (h *cacheHandler) bypass(ctx *Context) {
+	h.handler(ctx)
+	ctx.Response.Header.Set(XCacheHeader, cacheBypass)
+	h.stats.count(cacheBypass)
+}
 
-			opts.StoreCache(ctx, cacheKey, b, opts.TTL)
-			headers, ok := serializeHeaders(ctx, opts)
-			if ok {
-				opts.StoreCache(ctx, append(cacheKey, []byte("-headers")...), headers, opts.TTL)
+// fetch runs the handler, revalidating the stale entry if it has validators,
+// and stores the response. The stale entry is served if the handler
+// failed during its stale-if-error period. fetch returns the X-Cache value.
+func (h *cacheHandler) fetch(ctx *Context, baseKey []byte, stale *cacheEntry, now time.Time) string {
+	if stale != nil && stale.hasValidators() && !hasConditions(&ctx.Request) {
+		if h.opts.revalidate(ctx, h.handler, baseKey, stale, now) {
+			return cacheRevalidated
+		}
+	} else {
+		h.handler(ctx)
+	}
+
+	if stale != nil && ctx.Response.StatusCode() >= fasthttp.StatusInternalServerError &&
+		stale.servableStale(now, stale.staleIfError) {
+		stale.serve(ctx, now, cacheStale)
+		return cacheStale
+	}
+
+	h.opts.storeResponse(ctx, baseKey, now)
+	ctx.Response.Header.Set(XCacheHeader, cacheMiss)
+	return cacheMiss
+}
+
+// refresh refreshes the stale entry in the background,
+// unless the handler already runs for the key
+func (h *cacheHandler) refresh(ctx *Context, baseKey []byte, stale *cacheEntry) {
+	done, leader := h.join(baseKey)
+	if !leader {
+		return
+	}
+
+	bgCtx := ctx.detach()
+	go func() {
+		defer h.leave(baseKey, done)
+		defer func() {
+			if r := recover(); r != nil {
+				bgCtx.Logger.Errorf("cache: background refresh panicked: %v", r)
 			}
+		}()
+		h.fetch(bgCtx, baseKey, stale, time.Now())
+	}()
+}
+
+// join returns the channel, that is closed when the handler run for the key is done.
+// leader is true if the caller should run the handler and call leave.
+func (h *cacheHandler) join(key []byte) (done chan struct{}, leader bool) {
+	h.inFlightMu.Lock()
+	defer h.inFlightMu.Unlock()
+	if done, ok := h.inFlight[string(key)]; ok {
+		return done, false
+	}
+	done = make(chan struct{})
+	h.inFlight[string(key)] = done
+	return done, true
+}
+
+func (h *cacheHandler) leave(key []byte, done chan struct{}) {
+	h.inFlightMu.Lock()
+	delete(h.inFlight, string(key))
+	h.inFlightMu.Unlock()
+	close(done)
+}
+
+// detach returns a copy of the context, that may be used
+// after the request is served, e.g. to run the handler in background
+func (ctx *Context) detach() *Context {
+	fhctx := &fasthttp.RequestCtx{}
+	fhctx.Init(&ctx.Request, ctx.RemoteAddr(), nil)
+	ctx.VisitUserValues(func(k []byte, v interface{}) {
+		fhctx.SetUserValueBytes(k, v)
+	})
+	return &Context{
+		Logger:     ctx.Logger,
+		RequestCtx: fhctx,
+		App:        ctx.App,
+		writer:     fhctx.Write,
+		requestID:  ctx.requestID,
+	}
+}
+
+// readEntry reads the cached response for the key, resolving
+// the Vary index record if the response varies by request headers
+func (opts *CacheOptions) readEntry(ctx *Context, baseKey []byte) *cacheEntry {
+	record, ok := opts.ReadCache(ctx, baseKey)
+	if !ok || len(record) == 0 {
+		return nil
+	}
+	if record[0] == cacheRecordVary {
+		names := strings.Split(string(record[1:]), "\n")
+		record, ok = opts.ReadCache(ctx, varyKey(ctx, baseKey, names))
+		if !ok {
+			return nil
+		}
+	}
+
+	entry, err := unmarshalCacheEntry(record)
+	if err != nil {
+		return nil
+	}
+	return entry
+}
+
+// revalidate runs the handler with validators of the stale entry.
+// It returns true if the handler confirmed the entry with 304 Not Modified,
+// and the refreshed entry was served.
+func (opts *CacheOptions) revalidate(ctx *Context, handler func(*Context), baseKey []byte, stale *cacheEntry, now time.Time) bool {
+	if etag := stale.header(fasthttp.HeaderETag); len(etag) > 0 {
+		ctx.Request.Header.SetBytesV(fasthttp.HeaderIfNoneMatch, etag)
+	}
+	if lastModified := stale.header(fasthttp.HeaderLastModified); len(lastModified) > 0 {
+		ctx.Request.Header.SetBytesV(fasthttp.HeaderIfModifiedSince, lastModified)
+	}
+	handler(ctx)
+	ctx.Request.Header.Del(fasthttp.HeaderIfNoneMatch)
+	ctx.Request.Header.Del(fasthttp.HeaderIfModifiedSince)
+
+	if ctx.Response.StatusCode() == fasthttp.StatusOK && ctx.App != nil && ctx.App.etag != nil {
+		// the handler ignored validators, but the ETag middleware
+		// may tell, that the response has not changed
+		ctx.App.etag.set(ctx)
+		if etagMatch(stale.header(fasthttp.HeaderETag), ctx.Response.Header.Peek(fasthttp.HeaderETag)) {
+			ctx.Response.SetStatusCode(fasthttp.StatusNotModified)
+		}
+	}
+	if ctx.Response.StatusCode() != fasthttp.StatusNotModified {
+		return false
+	}
+
+	// RFC 9111, 4.3.4: update the stored response with headers from the 304 response.
+	// The stale entry is updated as a copy, since it may be served concurrently, see cacheHandler.refresh
+	updated := *stale
+	updated.headers = append([][2][]byte(nil), stale.headers...)
+	ctx.Response.Header.VisitAll(func(k, v []byte) {
+		name := string(k)
+		if _, skip := notCachedHeaders[name]; skip || name == fasthttp.HeaderContentType || opts.nonCacheable(name) {
+			return
+		}
+		updated.setHeader(name, v)
+	})
+
+	cc := parseCacheControl(updated.header(fasthttp.HeaderCacheControl))
+	ttl := freshness(cc, updated.header(fasthttp.HeaderExpires), now, opts.TTL)
+	updated.storedAt = now
+	updated.expiresAt = now.Add(ttl)
+	updated.staleWhileRevalidate, updated.staleIfError = opts.stalePeriods(cc, ttl)
+	if ttl > 0 {
+		opts.StoreCache(ctx, opts.entryKey(ctx, baseKey), updated.marshal(), opts.retention(&updated, ttl))
+	}
+
+	updated.serve(ctx, now, cacheRevalidated)
+	return true
+}
+
+// storeResponse stores the current response, if it is cacheable
+func (opts *CacheOptions) storeResponse(ctx *Context, baseKey []byte, now time.Time) {
+	resp := &ctx.Response
+	if !opts.statusCacheable(resp.StatusCode()) || resp.IsBodyStream() {
+		return
+	}
+
+	cc := parseCacheControl(resp.Header.Peek(fasthttp.HeaderCacheControl))
+	if cc.noStore || cc.private || cc.noCache || len(resp.Header.Peek(fasthttp.HeaderSetCookie)) > 0 {
+		return
+	}
+
+	vary := parseVary(resp.Header.Peek(fasthttp.HeaderVary))
+	for _, name := range vary {
+		if name == "*" {
+			return
+		}
+	}
+
+	ttl := freshness(cc, resp.Header.Peek(fasthttp.HeaderExpires), now, opts.TTL)
+	if ttl <= 0 {
+		return
+	}
+
+	if resp.StatusCode() == fasthttp.StatusOK && ctx.App != nil && ctx.App.etag != nil {
+		// store the ETag with the response, so cached responses can be revalidated
+		ctx.App.etag.set(ctx)
+	}
+
+	entry := &cacheEntry{
+		storedAt:   now,
+		expiresAt:  now.Add(ttl),
+		statusCode: resp.StatusCode(),
+		body:       append([]byte(nil), resp.Body()...),
+	}
+	entry.staleWhileRevalidate, entry.staleIfError = opts.stalePeriods(cc, ttl)
+	resp.Header.VisitAll(func(k, v []byte) {
+		name := string(k)
+		if _, skip := notCachedHeaders[name]; skip || opts.nonCacheable(name) {
 			return
 		}
+		entry.headers = append(entry.headers, [2][]byte{[]byte(name), append([]byte(nil), v...)})
+	})
 
-		wrappedHandler(ctx)
+	retention := opts.retention(entry, ttl)
+	key := baseKey
+	if len(vary) > 0 {
+		opts.StoreCache(ctx, baseKey, marshalVary(vary), retention)
+		key = varyKey(ctx, baseKey, vary)
 	}
+	opts.StoreCache(ctx, key, entry.marshal(), retention)
 }
 
-func serializeHeaders(ctx *Context, opts *CacheOptions) ([]byte, bool) {
-	headers := map[string]string{
-		"Content-Type":   string(ctx.Response.Header.Peek("Content-Type")),
-		"Content-Length": string(ctx.Response.Header.Peek("Content-Length")),
+// entryKey returns the key of the response entry, taking the Vary index into account
+func (opts *CacheOptions) entryKey(ctx *Context, baseKey []byte) []byte {
+	record, ok := opts.ReadCache(ctx, baseKey)
+	if ok && len(record) > 0 && record[0] == cacheRecordVary {
+		return varyKey(ctx, baseKey, strings.Split(string(record[1:]), "\n"))
 	}
-	for _, header := range opts.CacheableHeaders {
-		headers[header] = string(ctx.Response.Header.Peek(header))
+	return baseKey
+}
+
+func (opts *CacheOptions) statusCacheable(statusCode int) bool {
+	for _, code := range opts.CacheableStatusCodes {
+		if code == statusCode {
+			return true
+		}
 	}
+	return false
+}
+
+func (opts *CacheOptions) nonCacheable(name string) bool {
 	for _, header := range opts.NonCacheableHeaders {
-		delete(headers, header)
+		if strings.EqualFold(header, name) {
+			return true
+		}
 	}
-	serialized, err := json.Marshal(headers)
-	return serialized, err == nil
+	return false
 }
 
-func readFastCache(cache *fastcache.Cache) func(_ *Context, key []byte) (value []byte, isValid bool) {
-	return func(_ *Context, key []byte) ([]byte, bool) {
-		return cache.GetWithTimeout(nil, key)
+// stalePeriods returns stale-while-revalidate and stale-if-error periods of the response
+// with given Cache-Control and freshness lifetime
+func (opts *CacheOptions) stalePeriods(cc cacheControl, ttl time.Duration) (whileRevalidate, ifError time.Duration) {
+	if cc.mustRevalidate {
+		return 0, 0
+	}
+
+	whileRevalidate, ifError = opts.StaleWhileRevalidate, opts.StaleIfError
+	if cc.staleWhileRevalidate >= 0 {
+		whileRevalidate = cc.staleWhileRevalidate
+	}
+	if cc.staleIfError >= 0 {
+		ifError = cc.staleIfError
+	}
+
+	if opts.HardTTL > 0 {
+		limit := opts.HardTTL - ttl
+		if limit < 0 {
+			limit = 0
+		}
+		if whileRevalidate > limit {
+			whileRevalidate = limit
+		}
+		if ifError > limit {
+			ifError = limit
+		}
+	}
+	return whileRevalidate, ifError
+}
+
+// retention returns how long the entry is kept in the cache: HardTTL if it is set,
+// or while the entry may be served stale otherwise. Entries with validators
+// are kept at least twice as long as they are fresh, so they can be revalidated
+// instead of refetched.
+func (opts *CacheOptions) retention(e *cacheEntry, ttl time.Duration) time.Duration {
+	if opts.HardTTL > 0 {
+		return opts.HardTTL
+	}
+
+	retention := ttl + e.staleWhileRevalidate
+	if r := ttl + e.staleIfError; r > retention {
+		retention = r
+	}
+	if e.hasValidators() && retention < 2*ttl {
+		retention = 2 * ttl
+	}
+	return retention
+}
+
+// serve writes the cached response to the context
+func (e *cacheEntry) serve(ctx *Context, now time.Time, xCache string) {
+	for _, h := range e.headers {
+		ctx.Response.Header.SetBytesKV(h[0], h[1])
+	}
+	age := now.Sub(e.storedAt) / time.Second
+	if age < 0 {
+		age = 0
+	}
+	ctx.Response.Header.SetBytesV(fasthttp.HeaderAge, fasthttp.AppendUint(nil, int(age)))
+	ctx.Response.Header.Set(XCacheHeader, xCache)
+
+	if e.statusCode == fasthttp.StatusOK &&
+		!ctx.applyPreconditions(evalPreconditions(&ctx.Request, e.header(fasthttp.HeaderETag), e.header(fasthttp.HeaderLastModified))) {
+		return
+	}
+
+	ctx.Response.SetStatusCode(e.statusCode)
+	ctx.Response.SetBody(e.body)
+}
+
+func (e *cacheEntry) setHeader(name string, value []byte) {
+	value = append([]byte(nil), value...)
+	for i := range e.headers {
+		if strings.EqualFold(string(e.headers[i][0]), name) {
+			e.headers[i][1] = value
+			return
+		}
+	}
+	e.headers = append(e.headers, [2][]byte{[]byte(name), value})
+}
+
+func hasConditions(req *fasthttp.Request) bool {
+	return len(req.Header.Peek(fasthttp.HeaderIfNoneMatch)) > 0 ||
+		len(req.Header.Peek(fasthttp.HeaderIfModifiedSince)) > 0
+}
+
+// parseVary returns sorted canonical names of headers, listed in the Vary header
+func parseVary(h []byte) []string {
+	if len(h) == 0 {
+		return nil
+	}
+	var names []string
+	for _, name := range strings.Split(string(h), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		names = append(names, textproto.CanonicalMIMEHeaderKey(name))
 	}
+	sort.Strings(names)
+	return names
 }
 
-func storeFastCache(cache *fastcache.Cache) func(_ *Context, key, value []byte, ttl time.Duration) {
-	return func(_ *Context, key, value []byte, ttl time.Duration) {
-		cache.SetWithTimeout(key, value, ttl)
+// varyKey returns the cache key of the response variant,
+// selected by values of given request headers
+func varyKey(ctx *Context, baseKey []byte, names []string) []byte {
+	key := append([]byte(nil), baseKey...)
+	for _, name := range names {
+		key = append(key, '\n')
+		key = append(key, name...)
+		key = append(key, ':')
+		key = append(key, ctx.Request.Header.Peek(name)...)
 	}
+	return key
 }
 
 func (app *App) getCacheOpts(options ...*CacheOptions) *CacheOptions {
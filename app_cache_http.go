@@ -0,0 +1,289 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// XCacheHeader reports how the response was served by App.Cache:
+	// HIT, STALE, MISS, REVALIDATED or BYPASS
+	XCacheHeader = "X-Cache"
+
+	cacheHit         = "HIT"
+	cacheStale       = "STALE"
+	cacheMiss        = "MISS"
+	cacheRevalidated = "REVALIDATED"
+	cacheBypass      = "BYPASS"
+)
+
+// DefaultCacheableStatusCodes are the status codes, that App.Cache stores by default.
+// These are the codes, that are cacheable by default according to RFC 9110.
+var DefaultCacheableStatusCodes = []int{
+	fasthttp.StatusOK,
+	fasthttp.StatusNonAuthoritativeInfo,
+	fasthttp.StatusNoContent,
+	fasthttp.StatusMultipleChoices,
+	fasthttp.StatusMovedPermanently,
+	fasthttp.StatusPermanentRedirect,
+	fasthttp.StatusNotFound,
+	fasthttp.StatusMethodNotAllowed,
+	fasthttp.StatusGone,
+	fasthttp.StatusRequestURITooLong,
+	fasthttp.StatusNotImplemented,
+}
+
+// cacheControl is a parsed Cache-Control header.
+// Durations are -1 if the directive is absent.
+type cacheControl struct {
+	noStore        bool
+	noCache        bool
+	private        bool
+	mustRevalidate bool
+
+	maxAge               time.Duration
+	sMaxAge              time.Duration
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+}
+
+func parseCacheControl(h []byte) cacheControl {
+	cc := cacheControl{
+		maxAge:               -1,
+		sMaxAge:              -1,
+		staleWhileRevalidate: -1,
+		staleIfError:         -1,
+	}
+	for _, directive := range strings.Split(string(h), ",") {
+		name, value := strings.TrimSpace(directive), ""
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name, value = strings.TrimSpace(name[:i]), strings.Trim(strings.TrimSpace(name[i+1:]), `"`)
+		}
+		switch strings.ToLower(name) {
+		case "no-store":
+			cc.noStore = true
+		case "no-cache":
+			cc.noCache = true
+		case "private":
+			cc.private = true
+		case "must-revalidate", "proxy-revalidate":
+			cc.mustRevalidate = true
+		case "max-age":
+			cc.maxAge = parseDeltaSeconds(value)
+		case "s-maxage":
+			cc.sMaxAge = parseDeltaSeconds(value)
+		case "stale-while-revalidate":
+			cc.staleWhileRevalidate = parseDeltaSeconds(value)
+		case "stale-if-error":
+			cc.staleIfError = parseDeltaSeconds(value)
+		}
+	}
+	return cc
+}
+
+func parseDeltaSeconds(v string) time.Duration {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return time.Duration(n) * time.Second
+}
+
+// freshness returns the response freshness lifetime from its
+// Cache-Control and Expires headers, or defaultTTL if there are none
+func freshness(cc cacheControl, expires []byte, now time.Time, defaultTTL time.Duration) time.Duration {
+	switch {
+	case cc.sMaxAge >= 0:
+		return cc.sMaxAge
+	case cc.maxAge >= 0:
+		return cc.maxAge
+	}
+
+	if len(expires) > 0 {
+		t, err := fasthttp.ParseHTTPDate(expires)
+		if err != nil {
+			// invalid Expires means already expired
+			return 0
+		}
+		return t.Sub(now)
+	}
+
+	return defaultTTL
+}
+
+// notCachedHeaders are connection-specific headers and headers,
+// that are generated for every response served from the cache
+var notCachedHeaders = map[string]struct{}{
+	fasthttp.HeaderConnection:       {},
+	fasthttp.HeaderKeepAlive:        {},
+	fasthttp.HeaderTransferEncoding: {},
+	fasthttp.HeaderTrailer:          {},
+	fasthttp.HeaderUpgrade:          {},
+	fasthttp.HeaderContentLength:    {},
+	fasthttp.HeaderDate:             {},
+	fasthttp.HeaderSetCookie:        {},
+	fasthttp.HeaderAge:              {},
+	XCacheHeader:                    {},
+}
+
+const (
+	cacheRecordEntry byte = iota + 1
+	cacheRecordVary
+)
+
+var errInvalidCacheRecord = errors.New("invalid cache record")
+
+// cacheEntry is a cached response
+type cacheEntry struct {
+	storedAt   time.Time
+	expiresAt  time.Time
+	statusCode int
+	headers    [][2][]byte
+	body       []byte
+
+	// staleWhileRevalidate and staleIfError are the periods after expiresAt,
+	// while the entry may be served stale
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+}
+
+func (e *cacheEntry) header(name string) []byte {
+	for _, h := range e.headers {
+		if strings.EqualFold(string(h[0]), name) {
+			return h[1]
+		}
+	}
+	return nil
+}
+
+func (e *cacheEntry) hasValidators() bool {
+	return len(e.header(fasthttp.HeaderETag)) > 0 || len(e.header(fasthttp.HeaderLastModified)) > 0
+}
+
+// servableStale reports if the expired entry may be served within the given stale period
+func (e *cacheEntry) servableStale(now time.Time, period time.Duration) bool {
+	return period > 0 && now.Before(e.expiresAt.Add(period))
+}
+
+// marshal encodes the entry as a cache record:
+// type, stored at, expires at, stale periods, status code, headers count, headers and body
+func (e *cacheEntry) marshal() []byte {
+	size := 1 + 6*binary.MaxVarintLen64 + len(e.body)
+	for _, h := range e.headers {
+		size += 2*binary.MaxVarintLen64 + len(h[0]) + len(h[1])
+	}
+
+	b := make([]byte, 0, size)
+	b = append(b, cacheRecordEntry)
+	b = appendVarint(b, e.storedAt.UnixNano())
+	b = appendVarint(b, e.expiresAt.UnixNano())
+	b = appendVarint(b, int64(e.staleWhileRevalidate))
+	b = appendVarint(b, int64(e.staleIfError))
+	b = appendUvarint(b, uint64(e.statusCode))
+	b = appendUvarint(b, uint64(len(e.headers)))
+	for _, h := range e.headers {
+		b = appendCacheBytes(b, h[0])
+		b = appendCacheBytes(b, h[1])
+	}
+	return append(b, e.body...)
+}
+
+func unmarshalCacheEntry(b []byte) (*cacheEntry, error) {
+	if len(b) == 0 || b[0] != cacheRecordEntry {
+		return nil, errInvalidCacheRecord
+	}
+	r := cacheReader{b: b[1:]}
+	e := &cacheEntry{
+		storedAt:             time.Unix(0, r.varint()),
+		expiresAt:            time.Unix(0, r.varint()),
+		staleWhileRevalidate: time.Duration(r.varint()),
+		staleIfError:         time.Duration(r.varint()),
+	}
+	e.statusCode = int(r.uvarint())
+	n := r.uvarint()
+	if r.err != nil || n > uint64(len(r.b)) {
+		return nil, errInvalidCacheRecord
+	}
+	e.headers = make([][2][]byte, 0, n)
+	for i := uint64(0); i < n; i++ {
+		e.headers = append(e.headers, [2][]byte{r.bytes(), r.bytes()})
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	e.body = r.b
+	return e, nil
+}
+
+// marshalVary encodes the names of request headers, the response varies by
+func marshalVary(names []string) []byte {
+	return append([]byte{cacheRecordVary}, strings.Join(names, "\n")...)
+}
+
+func appendCacheBytes(b, v []byte) []byte {
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendVarint and appendUvarint are binary.AppendVarint and binary.AppendUvarint,
+// that are not available in Go 1.18
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+type cacheReader struct {
+	b   []byte
+	err error
+}
+
+func (r *cacheReader) varint() int64 {
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.err = errInvalidCacheRecord
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *cacheReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = errInvalidCacheRecord
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *cacheReader) bytes() []byte {
+	l := r.uvarint()
+	if r.err != nil || l > uint64(len(r.b)) {
+		r.err = errInvalidCacheRecord
+		return nil
+	}
+	v := r.b[:l:l]
+	r.b = r.b[l:]
+	return v
+}
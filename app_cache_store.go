@@ -0,0 +1,375 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/valyala/fasthttp"
+)
+
+// DefaultCacheSize is the default size of the shared App.Cache storage in bytes.
+// Note, that the storage can't be smaller than 32MB.
+const DefaultCacheSize = 32 << 20
+
+// minCachePruneSize is the index size, after which expired and evicted entries are pruned
+const minCachePruneSize = 1024
+
+// OptCacheSize sets the size of the shared App.Cache storage in bytes, see App.SetCacheSize
+func OptCacheSize(maxBytes int) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.SetCacheSize(maxBytes)
+	}
+}
+
+// SetCacheSize sets the size of the shared App.Cache storage in bytes.
+// The storage is shared by all handlers, wrapped with App.Cache without custom
+// ReadCache and StoreCache, and is allocated on the first use.
+// Changing the size drops all cached responses.
+func (app *App) SetCacheSize(maxBytes int) {
+	app.cache.mu.Lock()
+	app.cache.maxBytes = maxBytes
+	if app.cache.store != nil {
+		app.cache.store.Reset()
+		app.cache.store = nil
+		app.cache.resetIndex()
+	}
+	app.cache.mu.Unlock()
+}
+
+// CacheTags assigns tags to the response, that is stored by App.Cache,
+// so it can be purged by tag with App.PurgeCache and CachePurgeTag
+func (ctx *Context) CacheTags(tags ...string) {
+	ctx.cacheTags = append(ctx.cacheTags, tags...)
+}
+
+// CachePurge selects responses to purge from the shared App.Cache storage,
+// see CachePurgeKey, CachePurgePrefix and CachePurgeTag
+type CachePurge struct {
+	key    string
+	prefix string
+	tag    string
+}
+
+// CachePurgeKey selects the response with given cache key, including all its Vary variants.
+// The default cache key is "METHOD host request-uri", e.g. "GET example.com /users?page=2".
+func CachePurgeKey(key string) CachePurge {
+	return CachePurge{key: key}
+}
+
+// CachePurgePrefix selects responses with cache keys, starting with the prefix,
+// e.g. "GET example.com /users"
+func CachePurgePrefix(prefix string) CachePurge {
+	return CachePurge{prefix: prefix}
+}
+
+// CachePurgeTag selects responses, tagged with Context.CacheTags
+func CachePurgeTag(tag string) CachePurge {
+	return CachePurge{tag: tag}
+}
+
+// PurgeCache removes selected responses from the shared App.Cache storage
+// and returns the count of removed records. Responses stored
+// with custom ReadCache and StoreCache are not affected.
+func (app *App) PurgeCache(targets ...CachePurge) int {
+	return app.cache.purge(targets)
+}
+
+// CacheStats is App.Cache statistics
+type CacheStats struct {
+	// Hits is the count of responses, served from the cache
+	Hits uint64 `json:"hits"`
+	// StaleHits is the count of expired responses, served from the cache
+	// during their stale-while-revalidate or stale-if-error periods
+	StaleHits uint64 `json:"stale_hits"`
+	// Revalidations is the count of expired responses, confirmed by the handler with 304 Not Modified
+	Revalidations uint64 `json:"revalidations"`
+	// Misses is the count of responses, served by the handler
+	Misses uint64 `json:"misses"`
+	// Bypasses is the count of requests, that are not cacheable
+	Bypasses uint64 `json:"bypasses"`
+
+	// Evictions is the count of responses, evicted from the shared storage before they expired
+	Evictions uint64 `json:"evictions"`
+	// Purged is the count of records, removed with App.PurgeCache
+	Purged uint64 `json:"purged"`
+	// Entries is the count of records in the shared storage
+	Entries uint64 `json:"entries"`
+	// BytesSize and MaxBytesSize are the current and max size of the shared storage
+	BytesSize    uint64 `json:"bytes_size"`
+	MaxBytesSize uint64 `json:"max_bytes_size"`
+}
+
+// CacheStats returns App.Cache statistics
+func (app *App) CacheStats() CacheStats {
+	c := app.cache
+	stats := CacheStats{
+		Hits:          atomic.LoadUint64(&c.hits),
+		StaleHits:     atomic.LoadUint64(&c.staleHits),
+		Revalidations: atomic.LoadUint64(&c.revalidations),
+		Misses:        atomic.LoadUint64(&c.misses),
+		Bypasses:      atomic.LoadUint64(&c.bypasses),
+		Evictions:     atomic.LoadUint64(&c.evictions),
+		Purged:        atomic.LoadUint64(&c.purged),
+	}
+
+	c.mu.Lock()
+	stats.Entries = uint64(len(c.index))
+	store := c.store
+	c.mu.Unlock()
+	if store != nil {
+		var s fastcache.Stats
+		store.UpdateStats(&s)
+		stats.BytesSize, stats.MaxBytesSize = s.BytesSize, s.MaxBytesSize
+	}
+	return stats
+}
+
+// sharedCache is the default App.Cache storage. fastcache can't list or expire
+// keys, so the index tracks stored keys, their deadlines and tags.
+type sharedCache struct {
+	hits          uint64
+	staleHits     uint64
+	revalidations uint64
+	misses        uint64
+	bypasses      uint64
+	evictions     uint64
+	purged        uint64
+
+	mu        sync.Mutex
+	maxBytes  int
+	store     *fastcache.Cache
+	index     map[string]*cacheIndexEntry
+	tags      map[string]map[string]struct{}
+	nextPrune int
+}
+
+type cacheIndexEntry struct {
+	deadline int64
+	tags     []string
+}
+
+func newSharedCache() *sharedCache {
+	c := &sharedCache{
+		maxBytes: DefaultCacheSize,
+	}
+	c.resetIndex()
+	return c
+}
+
+func (c *sharedCache) resetIndex() {
+	c.index = make(map[string]*cacheIndexEntry)
+	c.tags = make(map[string]map[string]struct{})
+	c.nextPrune = minCachePruneSize
+}
+
+// count records how the response was served by App.Cache
+func (c *sharedCache) count(xCache string) {
+	switch xCache {
+	case cacheHit:
+		atomic.AddUint64(&c.hits, 1)
+	case cacheStale:
+		atomic.AddUint64(&c.staleHits, 1)
+	case cacheRevalidated:
+		atomic.AddUint64(&c.revalidations, 1)
+	case cacheMiss:
+		atomic.AddUint64(&c.misses, 1)
+	case cacheBypass:
+		atomic.AddUint64(&c.bypasses, 1)
+	}
+}
+
+func (c *sharedCache) read(_ *Context, key []byte) ([]byte, bool) {
+	c.mu.Lock()
+	store, entry := c.store, c.index[string(key)]
+	c.mu.Unlock()
+	if store == nil || entry == nil {
+		return nil, false
+	}
+
+	value := store.GetBig(nil, key)
+	now := time.Now().UnixNano()
+	if len(value) > 0 && now < entry.deadline {
+		return value, true
+	}
+
+	c.mu.Lock()
+	// the entry could be replaced while we were reading it
+	if c.index[string(key)] == entry {
+		if len(value) == 0 && now < entry.deadline {
+			atomic.AddUint64(&c.evictions, 1)
+		}
+		c.removeLocked(string(key), entry)
+		store.Del(key)
+	}
+	c.mu.Unlock()
+	return nil, false
+}
+
+func (c *sharedCache) write(ctx *Context, key, value []byte, ttl time.Duration) {
+	entry := &cacheIndexEntry{
+		deadline: time.Now().Add(ttl).UnixNano(),
+	}
+	if ctx != nil && len(ctx.cacheTags) > 0 {
+		entry.tags = append([]string(nil), ctx.cacheTags...)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.store == nil {
+		c.store = fastcache.New(c.maxBytes)
+	}
+
+	k := string(key)
+	if old := c.index[k]; old != nil {
+		c.removeLocked(k, old)
+	}
+	c.index[k] = entry
+	for _, tag := range entry.tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = make(map[string]struct{})
+		}
+		c.tags[tag][k] = struct{}{}
+	}
+	// the value is stored under the lock, so the index
+	// never points to a value of the purged entry
+	c.store.SetBig(key, value)
+
+	if len(c.index) >= c.nextPrune {
+		c.pruneLocked()
+	}
+}
+
+// pruneLocked removes expired and evicted entries from the index
+func (c *sharedCache) pruneLocked() {
+	now := time.Now().UnixNano()
+	for k, entry := range c.index {
+		switch {
+		case now >= entry.deadline:
+			c.store.Del([]byte(k))
+		case !c.store.Has([]byte(k)):
+			atomic.AddUint64(&c.evictions, 1)
+		default:
+			continue
+		}
+		c.removeLocked(k, entry)
+	}
+
+	c.nextPrune = 2 * len(c.index)
+	if c.nextPrune < minCachePruneSize {
+		c.nextPrune = minCachePruneSize
+	}
+}
+
+func (c *sharedCache) removeLocked(k string, entry *cacheIndexEntry) {
+	delete(c.index, k)
+	for _, tag := range entry.tags {
+		delete(c.tags[tag], k)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
+func (c *sharedCache) purge(targets []CachePurge) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.store == nil {
+		return 0
+	}
+
+	keys := make(map[string]struct{})
+	for _, target := range targets {
+		switch {
+		case target.tag != "":
+			for k := range c.tags[target.tag] {
+				keys[k] = struct{}{}
+			}
+		case target.prefix != "":
+			for k := range c.index {
+				if strings.HasPrefix(k, target.prefix) {
+					keys[k] = struct{}{}
+				}
+			}
+		case target.key != "":
+			for k := range c.index {
+				// Vary variants are stored as "{key}\n{header}:{value}"
+				if k == target.key || strings.HasPrefix(k, target.key+"\n") {
+					keys[k] = struct{}{}
+				}
+			}
+		}
+	}
+
+	for k := range keys {
+		c.removeLocked(k, c.index[k])
+		c.store.Del([]byte(k))
+	}
+	atomic.AddUint64(&c.purged, uint64(len(keys)))
+	return len(keys)
+}
+
+// ServeCachePurge registers the endpoint, that purges App.Cache responses on POST or DELETE
+// requests with key, prefix and tag query arguments, e.g.
+//
+//	POST {path}?tag=user:42&prefix=GET+example.com+/users
+//
+// and responds with the count of removed records as JSON: {"purged": 3}.
+//
+// The endpoint is protected, see Context.IsAuthorized: whitelisted clients are trusted
+// only if no authorize functions are given, otherwise all clients, including loopback ones,
+// must pass them. Pass authorize functions when the app is behind a local reverse proxy.
+// Requests with rejected Authorization header are reported with Context.HackAttemptDetected.
+func (app *App) ServeCachePurge(path string, authorize ...func(ctx *Context) bool) *App {
+	app.Protect(path)
+
+	trustWhitelisted := len(authorize) == 0
+	handler := func(ctx *Context) {
+		if !ctx.IsAuthorized(trustWhitelisted, authorize...) {
+			// only rejected credentials are hack attempts, not their absence
+			if len(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization)) > 0 {
+				ctx.HackAttemptDetected()
+			}
+			ctx.Forbidden()
+			return
+		}
+
+		var targets []CachePurge
+		args := ctx.QueryArgs()
+		for _, v := range args.PeekMulti("key") {
+			targets = append(targets, CachePurgeKey(string(v)))
+		}
+		for _, v := range args.PeekMulti("prefix") {
+			targets = append(targets, CachePurgePrefix(string(v)))
+		}
+		for _, v := range args.PeekMulti("tag") {
+			targets = append(targets, CachePurgeTag(string(v)))
+		}
+		if len(targets) == 0 {
+			ctx.BadRequest(errors.New("no key, prefix or tag to purge"))
+			return
+		}
+
+		if err := ctx.JSON(map[string]int{"purged": app.PurgeCache(targets...)}); err != nil {
+			ctx.jsonErrorLog(err)
+		}
+	}
+
+	app.POST(path, handler)
+	app.DELETE(path, handler)
+	return app
+}
@@ -0,0 +1,167 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestCachePurge(t *testing.T) {
+	app := New()
+	var calls int32
+	app.GET("/users/:id", app.Cache(func(ctx *Context) {
+		ctx.CacheTags("user:" + ctx.RouteArg("id"))
+		ctx.Response.Header.Set(fasthttp.HeaderVary, "Accept-Language")
+		fmt.Fprintf(ctx, "%s %d", ctx.RouteArg("id"), atomic.AddInt32(&calls, 1))
+	}))
+	app.GET("/posts/:id", app.Cache(func(ctx *Context) {
+		fmt.Fprintf(ctx, "post %s %d", ctx.RouteArg("id"), atomic.AddInt32(&calls, 1))
+	}))
+
+	get := func(uri string, headers ...string) string {
+		return string(testCacheRequest(app, uri, headers...).Response.Body())
+	}
+	cached := func(uri string, headers ...string) bool {
+		return string(testCacheRequest(app, uri, headers...).Response.Header.Peek(XCacheHeader)) == cacheHit
+	}
+
+	for _, uri := range []string{"/users/1", "/users/2", "/posts/1", "/posts/2"} {
+		get(uri)
+		get(uri, "Accept-Language", "de")
+	}
+
+	// the vary index record and both variants
+	if n := app.PurgeCache(CachePurgeTag("user:1")); n != 3 {
+		t.Fatalf("expected 3 records purged by tag, got %d", n)
+	}
+	if cached("/users/1") || cached("/users/1", "Accept-Language", "de") || !cached("/users/2") {
+		t.Fatalf("only user:1 responses should be purged")
+	}
+
+	if n := app.PurgeCache(CachePurgeKey("GET  /users/2")); n != 3 {
+		t.Fatalf("expected 3 records purged by key, got %d", n)
+	}
+	if cached("/users/2", "Accept-Language", "de") {
+		t.Fatalf("all Vary variants should be purged by key")
+	}
+
+	if n := app.PurgeCache(CachePurgePrefix("GET  /posts/")); n != 2 {
+		t.Fatalf("expected 2 records purged by prefix, got %d", n)
+	}
+	if cached("/posts/1") || cached("/posts/2") {
+		t.Fatalf("posts should be purged by prefix")
+	}
+
+	stats := app.CacheStats()
+	if stats.Purged != 8 || stats.Hits == 0 || stats.Misses == 0 || stats.MaxBytesSize == 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCacheEvictions(t *testing.T) {
+	app := New(OptCacheSize(DefaultCacheSize))
+	app.GET("/", app.Cache(func(ctx *Context) {
+		ctx.WriteString("ok")
+	}))
+
+	testCacheRequest(app, "/")
+	app.cache.store.Del([]byte("GET  /"))
+	if ctx := testCacheRequest(app, "/"); string(ctx.Response.Header.Peek(XCacheHeader)) != cacheMiss {
+		t.Fatalf("evicted response should be a miss")
+	}
+	if stats := app.CacheStats(); stats.Evictions != 1 || stats.Entries != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestServeCachePurge(t *testing.T) {
+	app := New()
+	app.GET("/", app.Cache(func(ctx *Context) {
+		ctx.CacheTags("index")
+		ctx.WriteString("ok")
+	}))
+	app.ServeCachePurge("/internal/cache/purge", func(ctx *Context) bool {
+		return string(ctx.Request.Header.Peek("X-Purge-Token")) == "secret"
+	})
+
+	purge := func(uri string, headers ...string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(fasthttp.MethodPost)
+		ctx.Request.SetRequestURI(uri)
+		for i := 0; i+1 < len(headers); i += 2 {
+			ctx.Request.Header.Set(headers[i], headers[i+1])
+		}
+		app.handler()(ctx)
+		return ctx
+	}
+
+	testCacheRequest(app, "/")
+	if ctx := purge("/internal/cache/purge?tag=index"); ctx.Response.StatusCode() != fasthttp.StatusForbidden {
+		t.Fatalf("unauthorized purge should be forbidden, got %d", ctx.Response.StatusCode())
+	}
+	if ctx := purge("/internal/cache/purge", "X-Purge-Token", "secret"); ctx.Response.StatusCode() != fasthttp.StatusBadRequest {
+		t.Fatalf("purge without targets should be rejected, got %d", ctx.Response.StatusCode())
+	}
+
+	ctx := purge("/internal/cache/purge?tag=index", "X-Purge-Token", "secret")
+	if ctx.Response.StatusCode() != fasthttp.StatusOK || strings.TrimSpace(string(ctx.Response.Body())) != `{"purged":1}` {
+		t.Fatalf("unexpected purge response %d: %q", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+}
+
+func TestServeCachePurgeAuthorization(t *testing.T) {
+	app := New()
+	app.ServeCachePurge("/trusted")
+	app.ServeCachePurge("/private", func(ctx *Context) bool {
+		return string(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization)) == "Bearer secret"
+	})
+
+	purge := func(uri string, ip net.IP, headers ...string) *Context {
+		req := &fasthttp.Request{}
+		req.Header.SetMethod(fasthttp.MethodPost)
+		req.SetRequestURI(uri)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		fhctx := &fasthttp.RequestCtx{}
+		fhctx.Init(req, &net.TCPAddr{IP: ip}, nil)
+		app.handler()(fhctx)
+		return &Context{RequestCtx: fhctx, App: app}
+	}
+
+	loopback, remote := net.IPv4(127, 0, 0, 1), net.IPv4(203, 0, 113, 7)
+	if ctx := purge("/trusted?tag=index", loopback); ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Errorf("loopback client should be trusted without authorize functions, got %d", ctx.Response.StatusCode())
+	}
+	if ctx := purge("/private?tag=index", loopback); ctx.Response.StatusCode() != fasthttp.StatusForbidden {
+		t.Errorf("loopback client should pass authorize functions, got %d", ctx.Response.StatusCode())
+	}
+	if ctx := purge("/private?tag=index", loopback, fasthttp.HeaderAuthorization, "Bearer secret"); ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Errorf("authorized loopback client should purge, got %d", ctx.Response.StatusCode())
+	}
+
+	ctx := purge("/private?tag=index", remote)
+	if ctx.Response.StatusCode() != fasthttp.StatusForbidden || ctx.SuspectsHackAttempts() != 0 {
+		t.Errorf("client without credentials should be forbidden but not suspected, got %d with %d attempts",
+			ctx.Response.StatusCode(), ctx.SuspectsHackAttempts())
+	}
+	ctx = purge("/private?tag=index", remote, fasthttp.HeaderAuthorization, "Bearer wrong")
+	if ctx.Response.StatusCode() != fasthttp.StatusForbidden || ctx.SuspectsHackAttempts() != 1 {
+		t.Errorf("rejected credentials should be reported, got %d with %d attempts",
+			ctx.Response.StatusCode(), ctx.SuspectsHackAttempts())
+	}
+}
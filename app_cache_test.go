@@ -0,0 +1,380 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func testCacheRequest(app *App, uri string, headers ...string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI(uri)
+	for i := 0; i+1 < len(headers); i += 2 {
+		ctx.Request.Header.Set(headers[i], headers[i+1])
+	}
+	app.handler()(ctx)
+	return ctx
+}
+
+func TestCacheStatusCodesAndCacheControl(t *testing.T) {
+	app := New()
+	var calls int32
+	app.GET("/:type", app.Cache(func(ctx *Context) {
+		n := atomic.AddInt32(&calls, 1)
+		switch ctx.RouteArg("type") {
+		case "error":
+			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		case "missing":
+			ctx.SetStatusCode(fasthttp.StatusNotFound)
+			ctx.Response.Header.Set("X-Reason", "gone fishing")
+		case "nostore":
+			ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "no-store")
+		case "private":
+			ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "private, max-age=60")
+		case "expired":
+			ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=0")
+		case "cookie":
+			ctx.Response.Header.Set(fasthttp.HeaderSetCookie, "session=1")
+		}
+		fmt.Fprintf(ctx, "%s %d", ctx.RouteArg("type"), n)
+	}))
+
+	cases := []struct {
+		path   string
+		cached bool
+	}{
+		{"/ok", true},
+		{"/error", false},
+		{"/missing", true},
+		{"/nostore", false},
+		{"/private", false},
+		{"/expired", false},
+		{"/cookie", false},
+	}
+	for _, c := range cases {
+		first := testCacheRequest(app, c.path)
+		second := testCacheRequest(app, c.path)
+		if got := string(first.Response.Header.Peek(XCacheHeader)); got != cacheMiss {
+			t.Errorf("%s: expected first response to be a MISS, got %q", c.path, got)
+		}
+		hit := string(second.Response.Header.Peek(XCacheHeader)) == cacheHit
+		if hit != c.cached {
+			t.Errorf("%s: expected cached=%v, got X-Cache %q", c.path, c.cached, second.Response.Header.Peek(XCacheHeader))
+		}
+		if c.cached {
+			if second.Response.StatusCode() != first.Response.StatusCode() {
+				t.Errorf("%s: cached status %d, expected %d", c.path, second.Response.StatusCode(), first.Response.StatusCode())
+			}
+			if string(second.Response.Body()) != string(first.Response.Body()) {
+				t.Errorf("%s: cached body %q, expected %q", c.path, second.Response.Body(), first.Response.Body())
+			}
+			if len(second.Response.Header.Peek(fasthttp.HeaderAge)) == 0 {
+				t.Errorf("%s: cached response has no Age header", c.path)
+			}
+		} else if string(second.Response.Body()) == string(first.Response.Body()) {
+			t.Errorf("%s: response should not be cached, got %q twice", c.path, first.Response.Body())
+		}
+	}
+
+	ctx := testCacheRequest(app, "/missing")
+	if got := string(ctx.Response.Header.Peek("X-Reason")); got != "gone fishing" {
+		t.Errorf("custom header is not restored from the cache: %q", got)
+	}
+}
+
+func TestCacheKeysAndRequestDirectives(t *testing.T) {
+	app := New()
+	var calls int32
+	app.GET("/vary", app.Cache(func(ctx *Context) {
+		n := atomic.AddInt32(&calls, 1)
+		ctx.Response.Header.Set(fasthttp.HeaderVary, "Accept-Language")
+		fmt.Fprintf(ctx, "%s %s %d", ctx.GETParam("q"), ctx.Request.Header.Peek("Accept-Language"), n)
+	}))
+
+	body := func(ctx *fasthttp.RequestCtx) string {
+		return string(ctx.Response.Body())
+	}
+
+	if a, b := body(testCacheRequest(app, "/vary?q=a")), body(testCacheRequest(app, "/vary?q=b")); a == b {
+		t.Fatalf("query string is ignored by the cache key: %q", a)
+	}
+	en := body(testCacheRequest(app, "/vary?q=a", "Accept-Language", "en"))
+	de := body(testCacheRequest(app, "/vary?q=a", "Accept-Language", "de"))
+	if en == de {
+		t.Fatalf("Vary is ignored: %q", en)
+	}
+	if got := body(testCacheRequest(app, "/vary?q=a", "Accept-Language", "en")); got != en {
+		t.Fatalf("expected cached en variant %q, got %q", en, got)
+	}
+
+	ctx := testCacheRequest(app, "/vary?q=a", "Accept-Language", "en", fasthttp.HeaderCacheControl, "no-store")
+	if string(ctx.Response.Header.Peek(XCacheHeader)) != cacheBypass || body(ctx) == en {
+		t.Fatalf("no-store request should bypass the cache, got %q", body(ctx))
+	}
+
+	refreshed := body(testCacheRequest(app, "/vary?q=a", "Accept-Language", "en", fasthttp.HeaderCacheControl, "no-cache"))
+	if refreshed == en {
+		t.Fatalf("no-cache request should refresh the cached response")
+	}
+	if got := body(testCacheRequest(app, "/vary?q=a", "Accept-Language", "en")); got != refreshed {
+		t.Fatalf("expected refreshed response %q, got %q", refreshed, got)
+	}
+}
+
+func TestCacheConditionalRequests(t *testing.T) {
+	app := New()
+	var calls, notModified int32
+	app.GET("/etag", app.Cache(func(ctx *Context) {
+		atomic.AddInt32(&calls, 1)
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=1")
+		ctx.Response.Header.Set(fasthttp.HeaderETag, `"v1"`)
+		if string(ctx.Request.Header.Peek(fasthttp.HeaderIfNoneMatch)) == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			ctx.SetStatusCode(fasthttp.StatusNotModified)
+			return
+		}
+		ctx.WriteString("payload")
+	}))
+
+	testCacheRequest(app, "/etag")
+
+	ctx := testCacheRequest(app, "/etag", fasthttp.HeaderIfNoneMatch, `W/"v1"`)
+	if ctx.Response.StatusCode() != fasthttp.StatusNotModified || len(ctx.Response.Body()) > 0 {
+		t.Fatalf("expected 304 from the cache, got %d: %q", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("conditional request should be served from the cache")
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	ctx = testCacheRequest(app, "/etag")
+	if got := string(ctx.Response.Header.Peek(XCacheHeader)); got != cacheRevalidated {
+		t.Fatalf("expected REVALIDATED, got %q", got)
+	}
+	if ctx.Response.StatusCode() != fasthttp.StatusOK || string(ctx.Response.Body()) != "payload" {
+		t.Fatalf("expected revalidated cached response, got %d: %q", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+	if atomic.LoadInt32(&notModified) != 1 {
+		t.Fatalf("handler should get the stored validator")
+	}
+
+	ctx = testCacheRequest(app, "/etag")
+	if got := string(ctx.Response.Header.Peek(XCacheHeader)); got != cacheHit {
+		t.Fatalf("revalidated response should be fresh again, got %q", got)
+	}
+}
+
+func TestCacheEntryEncoding(t *testing.T) {
+	now := time.Now()
+	e := &cacheEntry{
+		storedAt:   now,
+		expiresAt:  now.Add(time.Minute),
+		statusCode: fasthttp.StatusGone,
+		headers:    [][2][]byte{{[]byte("Content-Type"), []byte("text/plain")}, {[]byte("X-Empty"), nil}},
+		body:       []byte("body"),
+
+		staleIfError: time.Hour,
+	}
+	got, err := unmarshalCacheEntry(e.marshal())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !got.expiresAt.Equal(e.expiresAt) || got.statusCode != e.statusCode || got.staleIfError != time.Hour || string(got.body) != "body" ||
+		string(got.header("content-type")) != "text/plain" || len(got.headers) != 2 {
+		t.Fatalf("entry mismatch: %+v", got)
+	}
+
+	if _, err := unmarshalCacheEntry(e.marshal()[:10]); err == nil {
+		t.Fatalf("truncated record should be rejected")
+	}
+	if _, err := unmarshalCacheEntry(marshalVary([]string{"Accept"})); err == nil {
+		t.Fatalf("vary record should not be decoded as an entry")
+	}
+}
+
+func TestCacheCoalescing(t *testing.T) {
+	app := New()
+	var calls int32
+	app.GET("/slow", app.Cache(func(ctx *Context) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		ctx.WriteString("slow")
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ctx := testCacheRequest(app, "/slow"); string(ctx.Response.Body()) != "slow" {
+				t.Errorf("unexpected body %q", ctx.Response.Body())
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("expected the handler to run once, got %d", n)
+	}
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	app := New()
+	var calls int32
+	refreshed := make(chan struct{}, 1)
+	app.GET("/swr", app.Cache(func(ctx *Context) {
+		n := atomic.AddInt32(&calls, 1)
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=1, stale-while-revalidate=60")
+		fmt.Fprintf(ctx, "v%d", n)
+		if n > 1 {
+			refreshed <- struct{}{}
+		}
+	}))
+
+	testCacheRequest(app, "/swr")
+	time.Sleep(1100 * time.Millisecond)
+
+	ctx := testCacheRequest(app, "/swr")
+	if got := string(ctx.Response.Header.Peek(XCacheHeader)); got != cacheStale || string(ctx.Response.Body()) != "v1" {
+		t.Fatalf("expected stale v1, got %s %q", got, ctx.Response.Body())
+	}
+
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatalf("stale response was not refreshed in the background")
+	}
+	// the refreshed response is stored right after the handler returns
+	time.Sleep(10 * time.Millisecond)
+
+	ctx = testCacheRequest(app, "/swr")
+	if got := string(ctx.Response.Header.Peek(XCacheHeader)); got != cacheHit || string(ctx.Response.Body()) != "v2" {
+		t.Fatalf("expected refreshed v2, got %s %q", got, ctx.Response.Body())
+	}
+}
+
+func TestCacheStaleWhileRevalidateNotModified(t *testing.T) {
+	app := New()
+	var calls int32
+	revalidated := make(chan struct{}, 1)
+	app.GET("/swr", app.Cache(func(ctx *Context) {
+		n := atomic.AddInt32(&calls, 1)
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=1, stale-while-revalidate=60")
+		ctx.Response.Header.Set(fasthttp.HeaderETag, `"v1"`)
+		ctx.Response.Header.Set("X-Revision", fmt.Sprintf("r%d", n))
+		if string(ctx.Request.Header.Peek(fasthttp.HeaderIfNoneMatch)) == `"v1"` {
+			ctx.SetStatusCode(fasthttp.StatusNotModified)
+			revalidated <- struct{}{}
+			return
+		}
+		ctx.WriteString("payload")
+	}))
+
+	testCacheRequest(app, "/swr")
+	time.Sleep(1100 * time.Millisecond)
+
+	ctx := testCacheRequest(app, "/swr")
+	if got := string(ctx.Response.Header.Peek(XCacheHeader)); got != cacheStale || string(ctx.Response.Body()) != "payload" {
+		t.Fatalf("expected stale payload, got %s %q", got, ctx.Response.Body())
+	}
+	if got := string(ctx.Response.Header.Peek("X-Revision")); got != "r1" {
+		t.Fatalf("stale response should keep its headers, got %q", got)
+	}
+
+	select {
+	case <-revalidated:
+	case <-time.After(time.Second):
+		t.Fatalf("stale response was not revalidated in the background")
+	}
+	// the revalidated response is stored right after the handler returns
+	time.Sleep(10 * time.Millisecond)
+
+	ctx = testCacheRequest(app, "/swr")
+	if got := string(ctx.Response.Header.Peek(XCacheHeader)); got != cacheHit || string(ctx.Response.Body()) != "payload" {
+		t.Fatalf("expected revalidated payload, got %s %q", got, ctx.Response.Body())
+	}
+	if got := string(ctx.Response.Header.Peek("X-Revision")); got != "r2" {
+		t.Fatalf("revalidated response should get headers of the 304 response, got %q", got)
+	}
+}
+
+func TestCacheStaleIfError(t *testing.T) {
+	app := New()
+	var failing int32
+	opts := NewCacheOptions()
+	opts.TTL = time.Second
+	opts.StaleIfError = time.Minute
+	app.GET("/sie", app.Cache(func(ctx *Context) {
+		if atomic.LoadInt32(&failing) == 1 {
+			ctx.SetStatusCode(fasthttp.StatusBadGateway)
+			ctx.WriteString("upstream is down")
+			return
+		}
+		ctx.WriteString("ok")
+	}, opts))
+	app.GET("/strict", app.Cache(func(ctx *Context) {
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=1, must-revalidate")
+		if atomic.LoadInt32(&failing) == 1 {
+			ctx.SetStatusCode(fasthttp.StatusBadGateway)
+			return
+		}
+		ctx.WriteString("ok")
+	}, opts))
+
+	testCacheRequest(app, "/sie")
+	testCacheRequest(app, "/strict")
+	atomic.StoreInt32(&failing, 1)
+	time.Sleep(1100 * time.Millisecond)
+
+	ctx := testCacheRequest(app, "/sie")
+	if ctx.Response.StatusCode() != fasthttp.StatusOK || string(ctx.Response.Body()) != "ok" ||
+		string(ctx.Response.Header.Peek(XCacheHeader)) != cacheStale {
+		t.Fatalf("expected stale response on error, got %d %q", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+
+	ctx = testCacheRequest(app, "/strict")
+	if ctx.Response.StatusCode() != fasthttp.StatusBadGateway {
+		t.Fatalf("must-revalidate response should not be served stale, got %d", ctx.Response.StatusCode())
+	}
+}
+
+func TestCacheHardTTL(t *testing.T) {
+	opts := NewCacheOptions()
+	opts.StaleWhileRevalidate = time.Hour
+	opts.HardTTL = time.Minute
+	e := &cacheEntry{}
+
+	cc := parseCacheControl([]byte("max-age=50, stale-if-error=600"))
+	swr, sie := opts.stalePeriods(cc, 50*time.Second)
+	e.staleWhileRevalidate, e.staleIfError = swr, sie
+	if swr != 10*time.Second || sie != 10*time.Second {
+		t.Fatalf("stale periods should be limited by HardTTL, got %s and %s", swr, sie)
+	}
+	if r := opts.retention(e, 50*time.Second); r != time.Minute {
+		t.Fatalf("expected HardTTL retention, got %s", r)
+	}
+
+	opts.HardTTL = 0
+	swr, sie = opts.stalePeriods(cc, 50*time.Second)
+	e.staleWhileRevalidate, e.staleIfError = swr, sie
+	if swr != time.Hour || sie != 10*time.Minute {
+		t.Fatalf("unexpected stale periods %s and %s", swr, sie)
+	}
+	if r := opts.retention(e, 50*time.Second); r != time.Hour+50*time.Second {
+		t.Fatalf("unexpected retention %s", r)
+	}
+}
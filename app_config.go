@@ -0,0 +1,291 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gramework/gramework/config"
+)
+
+type (
+	// Config is the typed configuration of core App settings.
+	// Load it with App.LoadConfig, or embed it into your own
+	// configuration struct to load both at once.
+	Config struct {
+		Bind               []string       `config:"bind" usage:"addresses to listen, e.g. :80 or unix:/run/app.sock"`
+		LogLevel           string         `config:"log-level" usage:"log level: debug, info, warn, error or fatal"`
+		MaxRequestBodySize int            `config:"max-request-body-size" usage:"max request body size in bytes"`
+		TLS                ConfigTLS      `config:"tls"`
+		Firewall           ConfigFirewall `config:"firewall"`
+		Cookie             ConfigCookie   `config:"cookie"`
+		Cache              ConfigCache    `config:"cache"`
+	}
+
+	// ConfigTLS is the TLS part of Config
+	ConfigTLS struct {
+		Bind             string        `config:"bind" usage:"address to serve TLS on"`
+		CertFile         string        `config:"cert-file" usage:"TLS certificate file"`
+		KeyFile          string        `config:"key-file" usage:"TLS key file"`
+		ReloadInterval   time.Duration `config:"reload-interval" usage:"certificate files reload interval"`
+		MinVersion       string        `config:"min-version" usage:"min TLS version: 1.0, 1.1, 1.2 or 1.3"`
+		CipherSuites     []string      `config:"cipher-suites" usage:"enabled cipher suites, e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"`
+		ClientCAFile     []string      `config:"client-ca-file" usage:"CA files to verify client certificates (mTLS)"`
+		ClientAuth       string        `config:"client-auth" usage:"client certificate policy: require or verify-if-given"`
+		Emails           []string      `config:"emails" usage:"ACME account emails"`
+		ACMEDirectoryURL string        `config:"acme-directory-url" usage:"ACME directory URL"`
+		ACMEHosts        []string      `config:"acme-hosts" usage:"hosts allowed for ACME certificates"`
+		ACMECachePath    string        `config:"acme-cache-path" usage:"ACME certificates cache directory"`
+	}
+
+	// ConfigFirewall is the firewall part of Config
+	ConfigFirewall struct {
+		Enabled      bool          `config:"enabled" usage:"enable firewall"`
+		MaxReqPerMin int64         `config:"max-req-per-min" usage:"max requests per minute from one IP"`
+		BlockTimeout time.Duration `config:"block-timeout" usage:"IP block timeout"`
+	}
+
+	// ConfigCookie is the cookie part of Config
+	ConfigCookie struct {
+		Domain string        `config:"domain" usage:"cookie domain"`
+		Path   string        `config:"path" usage:"cookie path"`
+		Expire time.Duration `config:"expire" usage:"cookie expiration"`
+	}
+
+	// ConfigCache is the App.Cache part of Config
+	ConfigCache struct {
+		Size                 int           `config:"size" usage:"shared cache size in bytes"`
+		TTL                  time.Duration `config:"ttl" usage:"default cache TTL"`
+		HardTTL              time.Duration `config:"hard-ttl" usage:"default cache hard TTL, including the stale period"`
+		StaleWhileRevalidate time.Duration `config:"stale-while-revalidate" usage:"default period to serve stale cached responses while they are refreshed"`
+		StaleIfError         time.Duration `config:"stale-if-error" usage:"default period to serve stale cached responses if the handler fails"`
+	}
+)
+
+// appConfig is promoted to structs embedding Config
+func (c *Config) appConfig() *Config {
+	return c
+}
+
+// DefaultConfigEnvPrefix is the default environment variables prefix used by App.LoadConfig
+const DefaultConfigEnvPrefix = "GRAMEWORK"
+
+// LoadConfig loads dst from defaults, files, environment variables and
+// command-line flags, see package config. By default, environment variables
+// with DefaultConfigEnvPrefix prefix and flag.CommandLine are used.
+// Flags registered with App.AddFlag, e.g. "bind", set config keys
+// with the same name.
+//
+// If dst is a *Config or embeds Config, it is applied to the app with ApplyConfig.
+func (app *App) LoadConfig(dst interface{}, opts ...config.Option) error {
+	if !flagsDisabled && !app.flagsRegistered {
+		app.RegFlags()
+	}
+
+	opts = append([]config.Option{config.EnvPrefix(DefaultConfigEnvPrefix)}, opts...)
+	if err := config.Load(dst, opts...); err != nil {
+		return err
+	}
+
+	if cfg, ok := dst.(interface{ appConfig() *Config }); ok {
+		return app.ApplyConfig(cfg.appConfig())
+	}
+
+	return nil
+}
+
+// ApplyConfig configures the app. Zero values keep current settings.
+func (app *App) ApplyConfig(cfg *Config) error {
+	if len(cfg.LogLevel) > 0 {
+		lvl, err := ParseLogLevel(cfg.LogLevel)
+		if err != nil {
+			return fmt.Errorf("config: log-level: %s", err)
+		}
+		app.SetLogLevel(lvl)
+	}
+
+	if cfg.MaxRequestBodySize > 0 {
+		OptMaxRequestBodySize(cfg.MaxRequestBodySize)(app)
+	}
+
+	if err := app.applyTLSConfig(&cfg.TLS); err != nil {
+		return err
+	}
+
+	if cfg.Firewall.Enabled {
+		app.EnableFirewall = true
+	}
+	if cfg.Firewall.MaxReqPerMin > 0 {
+		app.Settings.Firewall.MaxReqPerMin = cfg.Firewall.MaxReqPerMin
+		atomic.StoreInt64(app.firewall.MaxReqPerMin, cfg.Firewall.MaxReqPerMin)
+	}
+	if cfg.Firewall.BlockTimeout > 0 {
+		seconds := int64(cfg.Firewall.BlockTimeout / time.Second)
+		app.Settings.Firewall.BlockTimeout = seconds
+		atomic.StoreInt64(app.firewall.BlockTimeout, seconds)
+	}
+
+	if len(cfg.Cookie.Domain) > 0 {
+		app.SetCookieDomain(cfg.Cookie.Domain)
+	}
+	if len(cfg.Cookie.Path) > 0 {
+		app.SetCookiePath(cfg.Cookie.Path)
+	}
+	if cfg.Cookie.Expire > 0 {
+		app.SetCookieExpire(cfg.Cookie.Expire)
+	}
+
+	if cfg.Cache.Size > 0 {
+		app.SetCacheSize(cfg.Cache.Size)
+	}
+	if cfg.Cache.TTL > 0 || cfg.Cache.HardTTL > 0 || cfg.Cache.StaleWhileRevalidate > 0 || cfg.Cache.StaleIfError > 0 {
+		opts := NewCacheOptions()
+		if app.DefaultCacheOptions != nil {
+			copied := *app.DefaultCacheOptions
+			opts = &copied
+		}
+		if cfg.Cache.TTL > 0 {
+			opts.TTL = cfg.Cache.TTL
+		}
+		if cfg.Cache.HardTTL > 0 {
+			opts.HardTTL = cfg.Cache.HardTTL
+		}
+		if cfg.Cache.StaleWhileRevalidate > 0 {
+			opts.StaleWhileRevalidate = cfg.Cache.StaleWhileRevalidate
+		}
+		if cfg.Cache.StaleIfError > 0 {
+			opts.StaleIfError = cfg.Cache.StaleIfError
+		}
+		app.DefaultCacheOptions = opts
+	}
+
+	return nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func (app *App) applyTLSConfig(cfg *ConfigTLS) error {
+	if len(cfg.MinVersion) > 0 {
+		version, ok := tlsVersions[strings.TrimPrefix(cfg.MinVersion, "TLS")]
+		if !ok {
+			return fmt.Errorf("config: tls.min-version: unknown TLS version %q", cfg.MinVersion)
+		}
+		OptTLSMinVersion(version)(app)
+	}
+
+	if len(cfg.CipherSuites) > 0 {
+		suites := make([]uint16, 0, len(cfg.CipherSuites))
+		for _, name := range cfg.CipherSuites {
+			id, ok := cipherSuiteID(name)
+			if !ok {
+				return fmt.Errorf("config: tls.cipher-suites: unknown cipher suite %q", name)
+			}
+			suites = append(suites, id)
+		}
+		OptTLSCipherSuites(suites...)(app)
+	}
+
+	if cfg.ReloadInterval > 0 {
+		OptTLSReloadInterval(cfg.ReloadInterval)(app)
+	}
+
+	if len(cfg.ClientCAFile) > 0 {
+		pool, err := LoadCertPool(cfg.ClientCAFile...)
+		if err != nil {
+			return fmt.Errorf("config: tls.client-ca-file: %s", err)
+		}
+		authType := tls.RequireAndVerifyClientCert
+		switch cfg.ClientAuth {
+		case "", "require":
+		case "verify-if-given":
+			authType = tls.VerifyClientCertIfGiven
+		default:
+			return fmt.Errorf("config: tls.client-auth: unknown policy %q", cfg.ClientAuth)
+		}
+		OptTLSClientAuth(authType, pool)(app)
+	}
+
+	if len(cfg.Emails) > 0 {
+		app.TLSEmails = cfg.Emails
+	}
+	if len(cfg.ACMEDirectoryURL) > 0 {
+		OptACMEDirectoryURL(cfg.ACMEDirectoryURL)(app)
+	}
+	if len(cfg.ACMEHosts) > 0 {
+		OptACMEHosts(cfg.ACMEHosts...)(app)
+	}
+	if len(cfg.Bind) > 0 {
+		OptTLSBind(cfg.Bind)(app)
+	}
+
+	return nil
+}
+
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+
+	return 0, false
+}
+
+// ServeConfig serves HTTP on cfg.Bind addresses and TLS on cfg.TLS.Bind:
+// with the certificate files if set, or with ACME otherwise. Call ApplyConfig
+// or LoadConfig first. Errors are reported as *ServeError to the returned
+// channel, that is closed when all servers stop.
+func (app *App) ServeConfig(cfg *Config) <-chan error {
+	var (
+		binds  []string
+		serves []func() error
+	)
+	for _, addr := range cfg.Bind {
+		addr := addr
+		binds = append(binds, addr)
+		serves = append(serves, func() error {
+			return app.ListenAndServe(addr)
+		})
+	}
+
+	if tlsCfg := cfg.TLS; len(tlsCfg.Bind) > 0 {
+		binds = append(binds, tlsCfg.Bind)
+		serves = append(serves, func() error {
+			if len(tlsCfg.CertFile) > 0 {
+				return app.ListenAndServeTLS(tlsCfg.Bind, tlsCfg.CertFile, tlsCfg.KeyFile)
+			}
+			cachePath := getCachePath()
+			if len(tlsCfg.ACMECachePath) > 0 {
+				cachePath = tlsCfg.ACMECachePath
+			}
+			return app.ListenAndServeAutoTLS(tlsCfg.Bind, cachePath)
+		})
+		if len(tlsCfg.CertFile) == 0 {
+			app.mountACMEChallenge()
+		}
+	}
+
+	return serveMulti(binds, serves)
+}
@@ -0,0 +1,159 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"crypto/tls"
+	"flag"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/gramework/gramework/config"
+	"github.com/valyala/fasthttp"
+)
+
+func TestAppLoadConfig(t *testing.T) {
+	type appConfig struct {
+		Config
+		DatabaseURL string `config:"database-url"`
+	}
+
+	env := map[string]string{
+		"GRAMEWORK_COOKIE_DOMAIN":          "example.com",
+		"GRAMEWORK_FIREWALL_ENABLED":       "true",
+		"GRAMEWORK_FIREWALL_BLOCK_TIMEOUT": "2m",
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+
+	logger := &log.Logger{Handler: Logger.Handler, Level: log.InfoLevel}
+	app := New(OptUseCustomLogger(logger))
+
+	cfg := &appConfig{}
+	err := app.LoadConfig(cfg,
+		config.LookupEnv(func(key string) (string, bool) {
+			v, ok := env[key]
+			return v, ok
+		}),
+		config.FlagSet(fs, []string{
+			"-bind", ":8080,unix:/tmp/app.sock",
+			"-log-level", "debug",
+			"-max-request-body-size", "1024",
+			"-tls.min-version", "1.2",
+			"-tls.cipher-suites", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+			"-cache.ttl", "1m",
+			"-cache.stale-if-error", "1h",
+			"-database-url", "postgres://localhost",
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !reflect.DeepEqual(cfg.Bind, []string{":8080", "unix:/tmp/app.sock"}) || cfg.DatabaseURL != "postgres://localhost" {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	if logger.Level != log.DebugLevel {
+		t.Errorf("log level was not applied")
+	}
+	if app.serverBase.MaxRequestBodySize != 1024 {
+		t.Errorf("max request body size was not applied")
+	}
+	if app.tlsMinVersion != tls.VersionTLS12 ||
+		!reflect.DeepEqual(app.tlsCipherSuites, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}) {
+		t.Errorf("TLS settings were not applied")
+	}
+	if app.DefaultCacheOptions == nil || app.DefaultCacheOptions.TTL != time.Minute ||
+		app.DefaultCacheOptions.StaleIfError != time.Hour {
+		t.Errorf("cache TTL was not applied")
+	}
+	if app.cookieDomain != "example.com" {
+		t.Errorf("cookie domain was not applied")
+	}
+	if !app.EnableFirewall || atomic.LoadInt64(app.firewall.BlockTimeout) != 120 {
+		t.Errorf("firewall settings were not applied")
+	}
+}
+
+func TestAppLoadConfigAddFlag(t *testing.T) {
+	app := New()
+	app.RegFlags()
+	if err := flag.Set("bind", "127.0.0.1:1234"); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{}
+	if err := app.LoadConfig(cfg, config.EnvPrefix("")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(cfg.Bind, []string{"127.0.0.1:1234"}) {
+		t.Fatalf("bind flag should flow into config, got %v", cfg.Bind)
+	}
+}
+
+func TestAppServeConfigBind(t *testing.T) {
+	// the fresh command line registers the bind flag with its ":80" default
+	commandLine := flag.CommandLine
+	flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+	_ = flag.CommandLine.Parse(nil)
+	defer func() {
+		flag.CommandLine = commandLine
+	}()
+
+	app := New()
+	app.GET("/", "config")
+	cfg := &Config{}
+	if err := app.LoadConfig(cfg, config.EnvPrefix(""), config.FlagSet(flag.CommandLine, []string{})); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	addr := testFreeAddr(t)
+	cfg.Bind = []string{addr}
+	errCh := app.ServeConfig(cfg)
+
+	var (
+		code int
+		body []byte
+		err  error
+	)
+	for i := 0; i < 50; i++ {
+		code, body, err = fasthttp.Get(nil, "http://"+addr+"/")
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil || code != fasthttp.StatusOK || string(body) != "config" {
+		t.Fatalf("expected the app to listen on cfg.Bind, got %d %q %v", code, body, err)
+	}
+
+	if err = app.Shutdown(); err != nil {
+		t.Fatalf("unexpected shutdown error: %s", err)
+	}
+	if err, ok := <-errCh; ok {
+		t.Fatalf("unexpected serve error: %v", err)
+	}
+}
+
+func TestAppApplyConfigErrors(t *testing.T) {
+	for _, cfg := range []*Config{
+		{LogLevel: "verbose"},
+		{TLS: ConfigTLS{MinVersion: "2.0"}},
+		{TLS: ConfigTLS{CipherSuites: []string{"UNKNOWN"}}},
+		{TLS: ConfigTLS{ClientCAFile: []string{"missing.pem"}}},
+	} {
+		if err := New().ApplyConfig(cfg); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
@@ -32,5 +32,7 @@ func (app *App) copyServer() *fasthttp.Server {
 		DisableHeaderNamesNormalizing: app.serverBase.DisableHeaderNamesNormalizing,
 		Logger:                        app.serverBase.Logger,
 		KeepHijackedConns:             app.serverBase.KeepHijackedConns,
+		HeaderReceived:                app.headerReceived(app.serverBase.HeaderReceived),
+		ErrorHandler:                  serverErrorHandler(app.serverBase.ErrorHandler),
 	}
 }
@@ -0,0 +1,162 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+	"github.com/valyala/fasthttp"
+)
+
+// DefaultMaxDecompressedBodySize is the default max size of decompressed request bodies
+const DefaultMaxDecompressedBodySize = 16 << 20
+
+// supportedRequestEncodings is sent in Accept-Encoding of 415 Unsupported Media Type responses
+const supportedRequestEncodings = "gzip, deflate, br, zstd"
+
+var (
+	errUnsupportedRequestEncoding = errors.New("unsupported request content encoding")
+	errDecompressedBodyTooLarge   = errors.New("decompressed request body too large")
+)
+
+// DecompressionOption configures request decompression, see App.UseRequestDecompression
+type DecompressionOption func(*decompressionConfig)
+
+type decompressionConfig struct {
+	maxSize int
+}
+
+// DecompressionMaxSize sets the max size of decompressed request bodies,
+// DefaultMaxDecompressedBodySize is used by default.
+// Routes may override it with RequestLimits.MaxDecompressedSize, see App.Limit.
+func DecompressionMaxSize(size int) DecompressionOption {
+	return func(c *decompressionConfig) {
+		if size > 0 {
+			c.maxSize = size
+		}
+	}
+}
+
+// UseRequestDecompression registers the middleware, that transparently decompresses request bodies
+// with gzip, deflate, br and zstd Content-Encoding, so handlers and other middlewares get plain bodies.
+//
+// Decompression stops as soon as the body exceeds the max size, and the request gets
+// 413 Request Entity Too Large, so small compressed bodies can't exhaust the memory.
+// Requests with unsupported encodings get 415 Unsupported Media Type and malformed ones get 400 Bad Request.
+func (app *App) UseRequestDecompression(opts ...DecompressionOption) error {
+	c := &decompressionConfig{
+		maxSize: DefaultMaxDecompressedBodySize,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return app.UsePre(c.middleware)
+}
+
+func (c *decompressionConfig) middleware(ctx *Context) {
+	contentEncoding := ctx.Request.Header.ContentEncoding()
+	if len(contentEncoding) == 0 {
+		return
+	}
+
+	maxSize := c.maxSize
+	// the same host as in App.headerReceived, see Router.Limit
+	host := string(ctx.RequestCtx.Host())
+	if limits, found := ctx.App.requestLimitsFor(host, string(ctx.Method()), string(ctx.Path())); found && limits.MaxDecompressedSize > 0 {
+		maxSize = limits.MaxDecompressedSize
+	}
+
+	// encodings are listed in the order they were applied
+	body := ctx.Request.Body()
+	encodings := strings.Split(string(contentEncoding), ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		decoded, err := decompressBody(strings.ToLower(strings.TrimSpace(encodings[i])), body, maxSize)
+		if err != nil {
+			switch err {
+			case errUnsupportedRequestEncoding:
+				ctx.Error(fasthttp.StatusMessage(fasthttp.StatusUnsupportedMediaType), fasthttp.StatusUnsupportedMediaType)
+				ctx.Response.Header.Set(fasthttp.HeaderAcceptEncoding, supportedRequestEncodings)
+			case errDecompressedBodyTooLarge:
+				ctx.Error(fasthttp.StatusMessage(fasthttp.StatusRequestEntityTooLarge), fasthttp.StatusRequestEntityTooLarge)
+			default:
+				ctx.BadRequest()
+			}
+			ctx.MWKill()
+			return
+		}
+		body = decoded
+	}
+
+	ctx.Request.SetBodyRaw(body)
+	ctx.Request.Header.Del(fasthttp.HeaderContentEncoding)
+	ctx.Request.Header.SetContentLength(len(body))
+}
+
+func decompressBody(encoding string, body []byte, maxSize int) ([]byte, error) {
+	var r io.Reader
+	switch encoding {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case "deflate":
+		// deflate is zlib-wrapped, but some clients send raw deflate streams
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err == zlib.ErrHeader {
+			r = flate.NewReader(bytes.NewReader(body))
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	case "zstd":
+		d, err := zstd.NewReader(bytes.NewReader(body),
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderLowmem(true),
+			zstd.WithDecoderMaxMemory(uint64(maxSize)),
+		)
+		if err != nil {
+			return nil, err
+		}
+		defer d.Close()
+		r = d
+	default:
+		return nil, errUnsupportedRequestEncoding
+	}
+
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(io.LimitReader(r, int64(maxSize)+1))
+	if n > int64(maxSize) || err == zstd.ErrWindowSizeExceeded || err == zstd.ErrDecoderSizeExceeded {
+		return nil, errDecompressedBodyTooLarge
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zstd"
+	"github.com/valyala/fasthttp"
+)
+
+func TestRequestDecompression(t *testing.T) {
+	app := New()
+	if err := app.UseRequestDecompression(DecompressionMaxSize(8 << 10)); err != nil {
+		t.Fatal(err)
+	}
+	handler := func(ctx *Context) {
+		ctx.Response.Header.SetBytesV("X-Content-Encoding", ctx.Request.Header.ContentEncoding())
+		ctx.WriteString(BytesToString(ctx.PostBody()))
+	}
+	app.POST("/", handler)
+	app.POST("/big", handler)
+	app.Limit(MethodPOST, "/big", RequestLimits{MaxDecompressedSize: 64 << 10})
+	c := testServeLimits(t, app)
+
+	plain := []byte(strings.Repeat("gramework decompresses requests. ", 100))
+	zstdEncoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rawDeflate bytes.Buffer
+	fw, _ := flate.NewWriter(&rawDeflate, flate.DefaultCompression)
+	_, _ = fw.Write(plain)
+	_ = fw.Close()
+
+	bodies := map[string][]byte{
+		"gzip":          fasthttp.AppendGzipBytes(nil, plain),
+		"x-gzip":        fasthttp.AppendGzipBytes(nil, plain),
+		"deflate":       fasthttp.AppendDeflateBytes(nil, plain),
+		"br":            fasthttp.AppendBrotliBytes(nil, plain),
+		"zstd":          zstdEncoder.EncodeAll(plain, nil),
+		"identity":      plain,
+		"gzip, br":      fasthttp.AppendBrotliBytes(nil, fasthttp.AppendGzipBytes(nil, plain)),
+		"raw deflate":   rawDeflate.Bytes(),
+		"GZIP":          fasthttp.AppendGzipBytes(nil, plain),
+		"deflate, zstd": zstdEncoder.EncodeAll(fasthttp.AppendDeflateBytes(nil, plain), nil),
+	}
+	for encoding, body := range bodies {
+		header := encoding
+		if encoding == "raw deflate" {
+			header = "deflate"
+		}
+		res := testPostBody(t, c, "/", body, fasthttp.HeaderContentEncoding, header)
+		if res.StatusCode() != fasthttp.StatusOK || !bytes.Equal(res.Body(), plain) {
+			t.Errorf("%s: unexpected response %d %q", encoding, res.StatusCode(), res.Body())
+		}
+		if len(res.Header.Peek("X-Content-Encoding")) > 0 {
+			t.Errorf("%s: Content-Encoding should be removed from the decompressed request", encoding)
+		}
+	}
+
+	res := testPostBody(t, c, "/", plain, fasthttp.HeaderContentEncoding, "lzma")
+	if res.StatusCode() != fasthttp.StatusUnsupportedMediaType || len(res.Header.Peek(fasthttp.HeaderAcceptEncoding)) == 0 {
+		t.Errorf("expected 415 with Accept-Encoding for the unsupported encoding, got %d", res.StatusCode())
+	}
+
+	res = testPostBody(t, c, "/", plain, fasthttp.HeaderContentEncoding, "gzip")
+	if res.StatusCode() != fasthttp.StatusBadRequest {
+		t.Errorf("expected 400 for the malformed body, got %d", res.StatusCode())
+	}
+
+	bomb := bytes.Repeat([]byte{0}, 32<<10)
+	for encoding, body := range map[string][]byte{
+		"gzip": fasthttp.AppendGzipBytes(nil, bomb),
+		"br":   fasthttp.AppendBrotliBytes(nil, bomb),
+		"zstd": zstdEncoder.EncodeAll(bomb, nil),
+	} {
+		res = testPostBody(t, c, "/", body, fasthttp.HeaderContentEncoding, encoding)
+		if res.StatusCode() != fasthttp.StatusRequestEntityTooLarge {
+			t.Errorf("%s: expected 413 for the body greater than the decompressed limit, got %d", encoding, res.StatusCode())
+		}
+		res = testPostBody(t, c, "/big", body, fasthttp.HeaderContentEncoding, encoding)
+		if res.StatusCode() != fasthttp.StatusOK || len(res.Body()) != len(bomb) {
+			t.Errorf("%s: expected the route limit to allow the body, got %d", encoding, res.StatusCode())
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"bytes"
+	"encoding/hex"
+	"hash"
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ETagOption configures the ETag middleware, see App.UseETag
+type ETagOption func(*etagConfig)
+
+type etagConfig struct {
+	weak    bool
+	newHash func() hash.Hash
+}
+
+// ETagWeak makes the ETag middleware generate weak ETags, e.g. W/"5-a1b2c3".
+// Use them if the response body may change in semantically insignificant ways,
+// e.g. if it is compressed later.
+func ETagWeak() ETagOption {
+	return func(c *etagConfig) {
+		c.weak = true
+	}
+}
+
+// ETagHash sets the hash function, that the ETag middleware uses to hash response bodies.
+// 64-bit FNV-1a is used by default.
+func ETagHash(newHash func() hash.Hash) ETagOption {
+	return func(c *etagConfig) {
+		if newHash != nil {
+			c.newHash = newHash
+		}
+	}
+}
+
+// UseETag registers the middleware, that sets the ETag header of successful GET and HEAD
+// responses to the hash of their bodies, unless the handler set it, and answers conditional
+// requests with 304 Not Modified or 412 Precondition Failed. Streamed bodies are skipped.
+//
+// Responses, stored by App.Cache, get their ETag before they are stored,
+// so cached responses keep it and are revalidated with it.
+func (app *App) UseETag(opts ...ETagOption) error {
+	c := &etagConfig{
+		newHash: func() hash.Hash {
+			return fnv.New64a()
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	app.etag = c
+	return app.UseAfterRequest(c.middleware)
+}
+
+func (c *etagConfig) middleware(ctx *Context) {
+	if !ctx.IsGet() && !ctx.IsHead() || ctx.Response.StatusCode() != fasthttp.StatusOK {
+		return
+	}
+
+	c.set(ctx)
+	etag := ctx.Response.Header.Peek(fasthttp.HeaderETag)
+	lastModified := ctx.Response.Header.Peek(fasthttp.HeaderLastModified)
+	if len(etag) == 0 && len(lastModified) == 0 {
+		return
+	}
+	ctx.applyPreconditions(evalPreconditions(&ctx.Request, etag, lastModified))
+}
+
+// set sets the ETag of the response, if it has no ETag yet
+func (c *etagConfig) set(ctx *Context) {
+	resp := &ctx.Response
+	if len(resp.Header.Peek(fasthttp.HeaderETag)) > 0 || resp.IsBodyStream() {
+		return
+	}
+
+	body := resp.Body()
+	h := c.newHash()
+	_, _ = h.Write(body)
+
+	etag := make([]byte, 0, 64)
+	if c.weak {
+		etag = append(etag, "W/"...)
+	}
+	etag = append(etag, '"')
+	etag = strconv.AppendInt(etag, int64(len(body)), 16)
+	etag = append(etag, '-')
+	etag = append(etag, hex.EncodeToString(h.Sum(nil))...)
+	etag = append(etag, '"')
+	resp.Header.SetBytesV(fasthttp.HeaderETag, etag)
+}
+
+// CheckPreconditions evaluates conditional request headers: If-Match, If-Unmodified-Since,
+// If-None-Match and If-Modified-Since against the current ETag and the last modification time
+// of the resource. Pass an empty etag or zero lastModified if the resource has none of them.
+// The etag is quoted if it is not quoted yet.
+//
+// CheckPreconditions returns true if the handler should process the request. Otherwise the response
+// is already set to 304 Not Modified for GET and HEAD requests or to 412 Precondition Failed,
+// e.g. on PUT with If-Match of the outdated ETag. Call it before doing expensive work:
+//
+//	if !ctx.CheckPreconditions(doc.Version, doc.UpdatedAt) {
+//		return
+//	}
+//
+// For GET and HEAD requests the ETag and Last-Modified response headers are set as well.
+func (ctx *Context) CheckPreconditions(etag string, lastModified time.Time) bool {
+	var etagBytes, lastModifiedBytes []byte
+	if etag != "" {
+		etagBytes = quoteETag(etag)
+	}
+	if !lastModified.IsZero() {
+		lastModifiedBytes = fasthttp.AppendHTTPDate(nil, lastModified)
+	}
+
+	if ctx.IsGet() || ctx.IsHead() {
+		if etagBytes != nil {
+			ctx.Response.Header.SetBytesV(fasthttp.HeaderETag, etagBytes)
+		}
+		if lastModifiedBytes != nil {
+			ctx.Response.Header.SetBytesV(fasthttp.HeaderLastModified, lastModifiedBytes)
+		}
+	}
+
+	return ctx.applyPreconditions(evalPreconditions(&ctx.Request, etagBytes, lastModifiedBytes))
+}
+
+// applyPreconditions sets the response to 304 or 412 status code,
+// returned by evalPreconditions, and returns false in that case
+func (ctx *Context) applyPreconditions(statusCode int) bool {
+	switch statusCode {
+	case fasthttp.StatusNotModified:
+		ctx.Response.SetStatusCode(fasthttp.StatusNotModified)
+		ctx.Response.ResetBody()
+		return false
+	case fasthttp.StatusPreconditionFailed:
+		ctx.Response.SetStatusCode(fasthttp.StatusPreconditionFailed)
+		ctx.Response.SetBodyString(fasthttp.StatusMessage(fasthttp.StatusPreconditionFailed))
+		return false
+	}
+	return true
+}
+
+// evalPreconditions evaluates conditional request headers in the order of RFC 9110, 13.2.2,
+// and returns 304 Not Modified, 412 Precondition Failed, or 0 if the request should be processed
+func evalPreconditions(req *fasthttp.Request, etag, lastModified []byte) int {
+	if ifMatch := req.Header.Peek(fasthttp.HeaderIfMatch); len(ifMatch) > 0 {
+		if !etagMatchStrong(ifMatch, etag) {
+			return fasthttp.StatusPreconditionFailed
+		}
+	} else if ius := req.Header.Peek(fasthttp.HeaderIfUnmodifiedSince); len(ius) > 0 && len(lastModified) > 0 {
+		if modifiedSince(lastModified, ius) {
+			return fasthttp.StatusPreconditionFailed
+		}
+	}
+
+	isGetOrHead := req.Header.IsGet() || req.Header.IsHead()
+	if ifNoneMatch := req.Header.Peek(fasthttp.HeaderIfNoneMatch); len(ifNoneMatch) > 0 {
+		if etagMatch(ifNoneMatch, etag) {
+			if isGetOrHead {
+				return fasthttp.StatusNotModified
+			}
+			return fasthttp.StatusPreconditionFailed
+		}
+	} else if ims := req.Header.Peek(fasthttp.HeaderIfModifiedSince); isGetOrHead && len(ims) > 0 && len(lastModified) > 0 {
+		if !modifiedSince(lastModified, ims) {
+			return fasthttp.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+// modifiedSince reports if the lastModified date is after the since date.
+// Invalid dates are considered as modified.
+func modifiedSince(lastModified, since []byte) bool {
+	s, err := fasthttp.ParseHTTPDate(since)
+	if err != nil {
+		return true
+	}
+	m, err := fasthttp.ParseHTTPDate(lastModified)
+	return err != nil || m.After(s)
+}
+
+// etagMatch reports if the If-None-Match header value matches the etag using weak comparison.
+// "*" matches any current representation, even without ETag, see RFC 9110, 13.1.2.
+func etagMatch(ifNoneMatch, etag []byte) bool {
+	etag = bytes.TrimPrefix(etag, []byte("W/"))
+	for _, candidate := range bytes.Split(ifNoneMatch, []byte(",")) {
+		candidate = bytes.TrimSpace(candidate)
+		if string(candidate) == "*" || len(etag) > 0 && bytes.Equal(bytes.TrimPrefix(candidate, []byte("W/")), etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatchStrong reports if the If-Match header value matches the etag using strong comparison:
+// weak ETags never match. "*" matches any current representation, even without ETag
+// or with the weak one, see RFC 9110, 13.1.1.
+func etagMatchStrong(ifMatch, etag []byte) bool {
+	strong := len(etag) > 0 && !bytes.HasPrefix(etag, []byte("W/"))
+	for _, candidate := range bytes.Split(ifMatch, []byte(",")) {
+		candidate = bytes.TrimSpace(candidate)
+		if string(candidate) == "*" || strong && bytes.Equal(candidate, etag) {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteETag(etag string) []byte {
+	if etag[len(etag)-1] == '"' && (etag[0] == '"' || len(etag) > 2 && etag[:3] == `W/"`) {
+		return []byte(etag)
+	}
+	return []byte(`"` + etag + `"`)
+}
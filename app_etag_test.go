@@ -0,0 +1,198 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func testConditionalRequest(app *App, method, uri string, headers ...string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	for i := 0; i+1 < len(headers); i += 2 {
+		ctx.Request.Header.Set(headers[i], headers[i+1])
+	}
+	app.handler()(ctx)
+	return ctx
+}
+
+func TestETagMiddleware(t *testing.T) {
+	app := New()
+	if err := app.UseETag(); err != nil {
+		t.Fatal(err)
+	}
+	app.GET("/", func(ctx *Context) {
+		ctx.WriteString("hello")
+	})
+	app.GET("/custom", func(ctx *Context) {
+		ctx.Response.Header.Set(fasthttp.HeaderETag, `"v1"`)
+		ctx.WriteString("hello")
+	})
+	app.GET("/missing", func(ctx *Context) {
+		ctx.NotFound()
+	})
+
+	ctx := testConditionalRequest(app, fasthttp.MethodGet, "/")
+	etag := string(ctx.Response.Header.Peek(fasthttp.HeaderETag))
+	if !strings.HasPrefix(etag, `"5-`) || !strings.HasSuffix(etag, `"`) {
+		t.Fatalf("unexpected ETag %q", etag)
+	}
+
+	ctx = testConditionalRequest(app, fasthttp.MethodGet, "/", fasthttp.HeaderIfNoneMatch, `"other", W/`+etag)
+	if ctx.Response.StatusCode() != fasthttp.StatusNotModified || len(ctx.Response.Body()) > 0 {
+		t.Fatalf("expected 304, got %d: %q", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+	ctx = testConditionalRequest(app, fasthttp.MethodGet, "/", fasthttp.HeaderIfMatch, `"other"`)
+	if ctx.Response.StatusCode() != fasthttp.StatusPreconditionFailed {
+		t.Fatalf("expected 412, got %d", ctx.Response.StatusCode())
+	}
+
+	ctx = testConditionalRequest(app, fasthttp.MethodGet, "/custom", fasthttp.HeaderIfNoneMatch, `"v1"`)
+	if ctx.Response.StatusCode() != fasthttp.StatusNotModified {
+		t.Fatalf("handler ETag should be kept and evaluated, got %d", ctx.Response.StatusCode())
+	}
+
+	ctx = testConditionalRequest(app, fasthttp.MethodGet, "/missing")
+	if len(ctx.Response.Header.Peek(fasthttp.HeaderETag)) > 0 {
+		t.Fatalf("error responses should not get an ETag")
+	}
+
+	weak := New()
+	if err := weak.UseETag(ETagWeak()); err != nil {
+		t.Fatal(err)
+	}
+	weak.GET("/", func(ctx *Context) {
+		ctx.WriteString("hello")
+	})
+	ctx = testConditionalRequest(weak, fasthttp.MethodGet, "/")
+	if got := string(ctx.Response.Header.Peek(fasthttp.HeaderETag)); got != "W/"+etag {
+		t.Fatalf("expected weak ETag W/%s, got %q", etag, got)
+	}
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	app := New()
+	var updates int32
+	lastModified := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	handler := func(ctx *Context) {
+		if !ctx.CheckPreconditions("v2", lastModified) {
+			return
+		}
+		if ctx.IsPut() {
+			atomic.AddInt32(&updates, 1)
+		}
+		ctx.WriteString("doc")
+	}
+	app.GET("/doc", handler)
+	app.PUT("/doc", handler)
+
+	cases := []struct {
+		method  string
+		headers []string
+		status  int
+	}{
+		{fasthttp.MethodGet, nil, fasthttp.StatusOK},
+		{fasthttp.MethodGet, []string{fasthttp.HeaderIfNoneMatch, `"v2"`}, fasthttp.StatusNotModified},
+		{fasthttp.MethodGet, []string{fasthttp.HeaderIfNoneMatch, `"v1"`}, fasthttp.StatusOK},
+		{fasthttp.MethodGet, []string{fasthttp.HeaderIfModifiedSince, "Wed, 01 Jan 2020 00:00:00 GMT"}, fasthttp.StatusNotModified},
+		{fasthttp.MethodGet, []string{fasthttp.HeaderIfModifiedSince, "Tue, 31 Dec 2019 00:00:00 GMT"}, fasthttp.StatusOK},
+		// If-None-Match takes precedence over If-Modified-Since
+		{fasthttp.MethodGet, []string{fasthttp.HeaderIfNoneMatch, `"v1"`, fasthttp.HeaderIfModifiedSince, "Wed, 01 Jan 2020 00:00:00 GMT"}, fasthttp.StatusOK},
+		{fasthttp.MethodPut, []string{fasthttp.HeaderIfMatch, `"v1"`}, fasthttp.StatusPreconditionFailed},
+		{fasthttp.MethodPut, []string{fasthttp.HeaderIfMatch, `"v2"`}, fasthttp.StatusOK},
+		{fasthttp.MethodPut, []string{fasthttp.HeaderIfMatch, `W/"v2"`}, fasthttp.StatusPreconditionFailed},
+		{fasthttp.MethodPut, []string{fasthttp.HeaderIfNoneMatch, "*"}, fasthttp.StatusPreconditionFailed},
+		{fasthttp.MethodPut, []string{fasthttp.HeaderIfUnmodifiedSince, "Tue, 31 Dec 2019 00:00:00 GMT"}, fasthttp.StatusPreconditionFailed},
+		{fasthttp.MethodPut, []string{fasthttp.HeaderIfUnmodifiedSince, "Wed, 01 Jan 2020 00:00:00 GMT"}, fasthttp.StatusOK},
+	}
+	for _, c := range cases {
+		ctx := testConditionalRequest(app, c.method, "/doc", c.headers...)
+		if ctx.Response.StatusCode() != c.status {
+			t.Errorf("%s %v: expected %d, got %d", c.method, c.headers, c.status, ctx.Response.StatusCode())
+		}
+	}
+	if n := atomic.LoadInt32(&updates); n != 2 {
+		t.Fatalf("expected 2 updates, got %d", n)
+	}
+
+	// "*" matches any current representation, even without ETag or with the weak one
+	app.PUT("/unversioned", func(ctx *Context) {
+		if ctx.CheckPreconditions("", lastModified) {
+			ctx.WriteString("doc")
+		}
+	})
+	app.PUT("/weak", func(ctx *Context) {
+		if ctx.CheckPreconditions(`W/"v2"`, lastModified) {
+			ctx.WriteString("doc")
+		}
+	})
+	for _, c := range []struct {
+		path    string
+		headers []string
+		status  int
+	}{
+		{"/unversioned", []string{fasthttp.HeaderIfMatch, "*"}, fasthttp.StatusOK},
+		{"/unversioned", []string{fasthttp.HeaderIfMatch, `"v2"`}, fasthttp.StatusPreconditionFailed},
+		{"/unversioned", []string{fasthttp.HeaderIfNoneMatch, "*"}, fasthttp.StatusPreconditionFailed},
+		{"/weak", []string{fasthttp.HeaderIfMatch, "*"}, fasthttp.StatusOK},
+		{"/weak", []string{fasthttp.HeaderIfMatch, `W/"v2"`}, fasthttp.StatusPreconditionFailed},
+	} {
+		ctx := testConditionalRequest(app, fasthttp.MethodPut, c.path, c.headers...)
+		if ctx.Response.StatusCode() != c.status {
+			t.Errorf("PUT %s %v: expected %d, got %d", c.path, c.headers, c.status, ctx.Response.StatusCode())
+		}
+	}
+
+	ctx := testConditionalRequest(app, fasthttp.MethodGet, "/doc")
+	if string(ctx.Response.Header.Peek(fasthttp.HeaderETag)) != `"v2"` ||
+		string(ctx.Response.Header.Peek(fasthttp.HeaderLastModified)) != "Wed, 01 Jan 2020 00:00:00 GMT" {
+		t.Fatalf("validators are not set: %s", ctx.Response.Header.String())
+	}
+}
+
+func TestETagCache(t *testing.T) {
+	app := New()
+	if err := app.UseETag(); err != nil {
+		t.Fatal(err)
+	}
+	var calls int32
+	app.GET("/", app.Cache(func(ctx *Context) {
+		atomic.AddInt32(&calls, 1)
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "max-age=1")
+		ctx.WriteString("same body")
+	}))
+
+	ctx := testConditionalRequest(app, fasthttp.MethodGet, "/")
+	etag := string(ctx.Response.Header.Peek(fasthttp.HeaderETag))
+	if etag == "" {
+		t.Fatalf("ETag is not set")
+	}
+
+	ctx = testConditionalRequest(app, fasthttp.MethodGet, "/")
+	if string(ctx.Response.Header.Peek(XCacheHeader)) != cacheHit || string(ctx.Response.Header.Peek(fasthttp.HeaderETag)) != etag {
+		t.Fatalf("cached response should keep the ETag, got %q", ctx.Response.Header.Peek(fasthttp.HeaderETag))
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	ctx = testConditionalRequest(app, fasthttp.MethodGet, "/")
+	if got := string(ctx.Response.Header.Peek(XCacheHeader)); got != cacheRevalidated {
+		t.Fatalf("unchanged response should be revalidated with the ETag, got %q", got)
+	}
+	if string(ctx.Response.Body()) != "same body" || atomic.LoadInt32(&calls) != 2 {
+		t.Fatalf("unexpected revalidated response %q after %d calls", ctx.Response.Body(), calls)
+	}
+}
@@ -40,14 +40,19 @@ func (app *App) handler() func(*fasthttp.RequestCtx) {
 			ctx.requestID = uuid.New().String()
 		}
 
-		tracer := ctx.Logger.
+		tracer := app.internalLog.
 			WithFields(log.Fields{
-				"package":  "gramework",
 				"method":   BytesToString(ctx.Method()),
 				"path":     BytesToString(ctx.Path()),
 				xRequestID: ctx.requestID,
 			})
 
+		if app.tracer != nil {
+			span := app.startRequestSpan(ctx)
+			// registered before Recv, so the span gets the panic status
+			defer endRequestSpan(span, fhctx)
+		}
+
 		if app.defaultRouter.router.PanicHandler != nil || !app.NoDefaultPanicHandler {
 			// unfortunately, we can't get rid of that defer
 			defer app.defaultRouter.router.Recv(ctx, tracer)
@@ -58,12 +63,15 @@ func (app *App) handler() func(*fasthttp.RequestCtx) {
 
 		ctx.loadCookies()
 		app.preMiddlewaresMu.RLock()
+		phase := ctx.startPhaseSpan("pre middlewares", len(app.preMiddlewares))
 		for k := range app.preMiddlewares {
 			app.preMiddlewares[k](ctx)
 		}
 
 		app.preMiddlewaresMu.RUnlock()
+		phase.End()
 		if ctx.middlewareKilledReq {
+			app.runMiddlewaresAfterRequest(ctx)
 			ctx.saveCookies()
 			tracer.
 				WithField("status", ctx.Response.StatusCode()).
@@ -73,6 +81,7 @@ func (app *App) handler() func(*fasthttp.RequestCtx) {
 		}
 		ctx.middlewaresShouldStopProcessing = false
 		app.middlewaresMu.RLock()
+		phase = ctx.startPhaseSpan("middlewares", len(app.middlewares))
 		for k := range app.middlewares {
 			app.middlewares[k](ctx)
 			if ctx.middlewaresShouldStopProcessing {
@@ -81,7 +90,9 @@ func (app *App) handler() func(*fasthttp.RequestCtx) {
 		}
 
 		app.middlewaresMu.RUnlock()
+		phase.End()
 		if ctx.middlewareKilledReq {
+			app.runMiddlewaresAfterRequest(ctx)
 			ctx.saveCookies()
 			tracer.
 				WithField("status", ctx.Response.StatusCode()).
@@ -91,9 +102,11 @@ func (app *App) handler() func(*fasthttp.RequestCtx) {
 		}
 		if len(app.domains) > 0 {
 			app.domainListLock.RLock()
-			if app.domains[string(ctx.URI().Host())] != nil {
+			host := string(ctx.Host())
+			if app.domains[host] != nil {
 				app.domainListLock.RUnlock()
-				app.domains[string(ctx.URI().Host())].handler(ctx)
+				ctx.domain = host
+				app.domains[host].handler(ctx)
 				app.runMiddlewaresAfterRequest(ctx)
 				ctx.saveCookies()
 				tracer.
@@ -126,7 +139,9 @@ func (app *App) handler() func(*fasthttp.RequestCtx) {
 }
 
 func (app *App) runMiddlewaresAfterRequest(ctx *Context) {
+	ctx.afterRequestStarted = true
 	app.middlewaresAfterRequestMu.RLock()
+	phase := ctx.startPhaseSpan("after request middlewares", len(app.middlewaresAfterRequest))
 	for k := range app.middlewaresAfterRequest {
 		app.middlewaresAfterRequest[k](ctx)
 		if ctx.middlewaresShouldStopProcessing {
@@ -135,4 +150,5 @@ func (app *App) runMiddlewaresAfterRequest(ctx *Context) {
 	}
 
 	app.middlewaresAfterRequestMu.RUnlock()
+	phase.End()
 }
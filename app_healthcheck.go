@@ -24,6 +24,8 @@ func (app *App) HealthHandler(ctx *Context) {
 }
 
 // Health registers HealthHandler on /internal/health
+// and HealthStatusHandler on /internal/health/checks
 func (app *App) Health() {
 	app.GET("/internal/health", app.HealthHandler)
+	app.GET("/internal/health/checks", app.HealthStatusHandler)
 }
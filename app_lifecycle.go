@@ -0,0 +1,235 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// DefaultShutdownTimeout is the default time to drain in-flight requests
+	DefaultShutdownTimeout = 30 * time.Second
+	// DefaultUpgradeTimeout is the default time to wait for the upgraded process to serve inherited listeners
+	DefaultUpgradeTimeout = 30 * time.Second
+)
+
+// LifecycleHook is a function that will be called on app start or shutdown.
+// Shutdown hooks receive a context that will be canceled when the shutdown
+// timeout expires.
+type LifecycleHook func(ctx context.Context) error
+
+// OnStart registers a hook that will be called by Run before the app
+// starts serving. Hooks are called in the order they were registered.
+// If any hook fails, Run returns its error without serving.
+func (app *App) OnStart(hook LifecycleHook) *App {
+	app.lifecycleMu.Lock()
+	app.onStart = append(app.onStart, hook)
+	app.lifecycleMu.Unlock()
+	return app
+}
+
+// OnShutdown registers a hook that will be called by Run after the app
+// servers are drained, e.g. to close database connections or flush logs.
+// Hooks are called in the order they were registered, even if some of them fail.
+func (app *App) OnShutdown(hook LifecycleHook) *App {
+	app.lifecycleMu.Lock()
+	app.onShutdown = append(app.onShutdown, hook)
+	app.lifecycleMu.Unlock()
+	return app
+}
+
+// IsReady reports if the app is ready to accept new requests.
+// The app is marked as ready as soon as any of its servers starts accepting
+// connections, e.g. with ListenAndServe or Serve, and as not ready
+// as soon as the graceful shutdown in Run begins.
+func (app *App) IsReady() bool {
+	return atomic.LoadInt32(&app.ready) == 1
+}
+
+// SetReady sets app readiness
+func (app *App) SetReady(ready bool) {
+	if ready {
+		atomic.StoreInt32(&app.ready, 1)
+		return
+	}
+	atomic.StoreInt32(&app.ready, 0)
+}
+
+// ReadyHandler serves 200 OK if the app is ready, and 503 Service Unavailable otherwise
+func (app *App) ReadyHandler(ctx *Context) {
+	if app.IsReady() {
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		ctx.SetBodyString(fasthttp.StatusMessage(fasthttp.StatusOK))
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+	ctx.SetBodyString(fasthttp.StatusMessage(fasthttp.StatusServiceUnavailable))
+}
+
+// OptShutdownTimeout sets the max time Run waits for in-flight requests
+// and shutdown hooks to complete. Zero means no timeout.
+func OptShutdownTimeout(d time.Duration) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.shutdownTimeout = d
+	}
+}
+
+// OptPreStopDelay sets the time Run waits after marking the app as not ready
+// and before draining the servers, so load balancers can stop routing
+// new requests to this instance.
+func OptPreStopDelay(d time.Duration) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.preStopDelay = d
+	}
+}
+
+// OptShutdownSignals sets the signals that trigger graceful shutdown in Run.
+// By default, SIGINT and SIGTERM are used.
+func OptShutdownSignals(signals ...os.Signal) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.shutdownSignals = signals
+	}
+}
+
+// OptUpgradeSignal sets the signal that triggers the binary upgrade in Run:
+// the app starts a new process, passes listeners to it and gracefully shuts down.
+// See App.Upgrade. Typically, SIGHUP or SIGUSR2 is used.
+func OptUpgradeSignal(sig os.Signal) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.upgradeSignal = sig
+	}
+}
+
+// OptUpgradeTimeout sets the max time App.Upgrade waits for the new process
+// to serve inherited listeners. If it does not, the new process is killed
+// and the upgrade fails, so the current process keeps serving.
+func OptUpgradeTimeout(d time.Duration) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		if d > 0 {
+			app.upgradeTimeout = d
+		}
+	}
+}
+
+// ListenAndServeGraceful serves HTTP on given addr just like ListenAndServe,
+// and gracefully shuts the app down on SIGINT or SIGTERM. See Run.
+func (app *App) ListenAndServeGraceful(addr ...string) error {
+	return app.Run(context.Background(), addr...)
+}
+
+// Run manages the full app lifecycle:
+//
+//  1. calls OnStart hooks;
+//  2. serves HTTP on given addr (see ListenAndServe), the app is marked as started and ready
+//     once the listener is bound and accepting;
+//  3. waits for ctx cancellation, a shutdown signal, a successful upgrade
+//     (see OptUpgradeSignal) or a server failure;
+//  4. marks the app as not ready and waits for the pre-stop delay;
+//  5. drains in-flight requests within the shutdown timeout;
+//  6. calls OnShutdown hooks.
+//
+// All errors, occurred during the shutdown, are returned as a MultiError.
+func (app *App) Run(ctx context.Context, addr ...string) error {
+	app.lifecycleMu.Lock()
+	onStart := append([]LifecycleHook(nil), app.onStart...)
+	app.lifecycleMu.Unlock()
+
+	for _, hook := range onStart {
+		if err := hook(ctx); err != nil {
+			app.internalLog.WithError(err).Error("start hook failed")
+			return err
+		}
+	}
+
+	signals := app.shutdownSignals
+	if signals == nil {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	if app.upgradeSignal != nil {
+		signals = append(append([]os.Signal(nil), signals...), app.upgradeSignal)
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, signals...)
+	defer signal.Stop(sigCh)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- app.ListenAndServe(addr...)
+	}()
+
+	var errs MultiError
+	for {
+		select {
+		case <-ctx.Done():
+			app.internalLog.Warn("context done, shutting down")
+		case sig := <-sigCh:
+			if app.upgradeSignal != nil && sig == app.upgradeSignal {
+				if _, err := app.Upgrade(); err != nil {
+					app.internalLog.WithError(err).Error("upgrade failed, continue serving")
+					continue
+				}
+				app.internalLog.Warn("upgraded, shutting down")
+				break
+			}
+			app.internalLog.WithField("signal", sig.String()).Warn("signal received, shutting down")
+		case err := <-serveErr:
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		return app.gracefulStop(errs)
+	}
+}
+
+func (app *App) gracefulStop(errs MultiError) error {
+	app.SetReady(false)
+	if app.preStopDelay > 0 {
+		app.internalLog.WithField("delay", app.preStopDelay.String()).Info("waiting before draining servers")
+		time.Sleep(app.preStopDelay)
+	}
+
+	shutdownCtx, cancel := context.Background(), func() {}
+	if app.shutdownTimeout > 0 {
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, app.shutdownTimeout)
+	}
+	defer cancel()
+
+	if err := app.ShutdownContext(shutdownCtx); err != nil {
+		errs = append(errs, err)
+	}
+
+	app.lifecycleMu.Lock()
+	onShutdown := append([]LifecycleHook(nil), app.onShutdown...)
+	app.lifecycleMu.Unlock()
+
+	for _, hook := range onShutdown {
+		if err := hook(shutdownCtx); err != nil {
+			app.internalLog.WithError(err).Error("shutdown hook failed")
+			errs = append(errs, err)
+		}
+	}
+
+	return errs.Err()
+}
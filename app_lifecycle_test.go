@@ -0,0 +1,145 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func testFreeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestAppRunLifecycle(t *testing.T) {
+	var calls []string
+	errHook := errors.New("hook failed")
+
+	app := New(OptShutdownTimeout(time.Second))
+	app.GET("/ready", app.ReadyHandler)
+	app.OnStart(func(ctx context.Context) error {
+		calls = append(calls, "start")
+		return nil
+	})
+	app.OnShutdown(func(ctx context.Context) error {
+		calls = append(calls, "shutdown1")
+		if app.IsReady() {
+			t.Error("app should not be ready during shutdown")
+		}
+		return errHook
+	})
+	app.OnShutdown(func(ctx context.Context) error {
+		calls = append(calls, "shutdown2")
+		return nil
+	})
+
+	addr := testFreeAddr(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- app.Run(ctx, addr)
+	}()
+
+	var (
+		code int
+		err  error
+	)
+	for i := 0; i < 50; i++ {
+		code, _, err = fasthttp.Get(nil, "http://"+addr+"/ready")
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil || code != fasthttp.StatusOK {
+		t.Fatalf("app is not ready: %d %v", code, err)
+	}
+
+	cancel()
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after context cancellation")
+	}
+
+	if err != errHook {
+		t.Errorf("unexpected Run error: %v", err)
+	}
+	if len(calls) != 3 || calls[0] != "start" || calls[1] != "shutdown1" || calls[2] != "shutdown2" {
+		t.Errorf("unexpected hook calls: %v", calls)
+	}
+}
+
+func TestAppRunStartHookFailure(t *testing.T) {
+	errHook := errors.New("start failed")
+	app := New()
+	app.OnStart(func(ctx context.Context) error {
+		return errHook
+	})
+
+	if err := app.Run(context.Background(), testFreeAddr(t)); err != errHook {
+		t.Errorf("unexpected Run error: %v", err)
+	}
+	if app.IsReady() {
+		t.Error("app should not be ready after start failure")
+	}
+}
+
+func TestMultiError(t *testing.T) {
+	var errs MultiError
+	if errs.Err() != nil {
+		t.Error("empty MultiError should be nil")
+	}
+
+	errA, errB := errors.New("a"), errors.New("b")
+	errs = append(errs, errA)
+	if errs.Err() != errA {
+		t.Error("single MultiError should return the only error")
+	}
+
+	errs = append(errs, errB)
+	if err := errs.Err(); err == nil || err.Error() != "a; b" {
+		t.Errorf("unexpected MultiError: %v", err)
+	}
+}
+
+func TestAppRunBindFailure(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	app := New()
+	var readyOnShutdown bool
+	app.OnShutdown(func(ctx context.Context) error {
+		readyOnShutdown = app.IsReady() || app.IsStarted()
+		return nil
+	})
+	if err := app.Run(context.Background(), ln.Addr().String()); err == nil {
+		t.Error("expected Run to fail on the busy address")
+	}
+	if readyOnShutdown || app.IsReady() {
+		t.Error("app should not be ready when its listener failed to bind")
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// EnvListenFDs is the environment variable that contains
+	// the count of listeners, inherited from the parent process
+	EnvListenFDs = "GRAMEWORK_LISTEN_FDS"
+	// EnvListenFDNames is the environment variable that contains
+	// comma-separated bind addresses of the inherited listeners
+	EnvListenFDNames = "GRAMEWORK_LISTEN_FDNAMES"
+	// EnvUpgradeReadyFD is the environment variable that contains the file descriptor
+	// of the pipe, the parent process waits on until all inherited listeners are served
+	EnvUpgradeReadyFD = "GRAMEWORK_UPGRADE_READY_FD"
+
+	// UnixPrefix is the bind address prefix for unix domain sockets,
+	// e.g. "unix:/run/app.sock"
+	UnixPrefix = "unix:"
+
+	// listenFDsStart is the first inherited file descriptor, right after stdio
+	listenFDsStart = 3
+)
+
+type inheritedListener struct {
+	name string
+	ln   net.Listener
+}
+
+var (
+	inheritedOnce      sync.Once
+	inheritedMu        sync.Mutex
+	inheritedListeners []inheritedListener
+	// inheritedUnserved are inherited listeners, that are not served yet
+	inheritedUnserved map[net.Listener]struct{}
+	// upgradeReady is closed after a write as soon as all inherited listeners are served,
+	// see App.Upgrade
+	upgradeReady *os.File
+)
+
+// Listen announces on the local network address, just like net.Listen does.
+// Addresses with the "unix:" prefix are served on unix domain sockets,
+// see OptUnixSocketMode and OptUnixSocketOwner.
+// If the process has inherited a listener for the same address, either from
+// the parent gramework process (see App.Upgrade) or from systemd socket
+// activation (LISTEN_FDS), the inherited listener is returned instead.
+//
+// Use it to create listeners for App.Serve, if you want them to survive
+// the binary upgrade.
+func (app *App) Listen(network, addr string) (net.Listener, error) {
+	inheritedOnce.Do(func() {
+		listeners, ready := loadInheritedListeners(app)
+
+		inheritedMu.Lock()
+		inheritedListeners = listeners
+		inheritedUnserved = make(map[net.Listener]struct{}, len(listeners))
+		for _, inherited := range listeners {
+			inheritedUnserved[inherited.ln] = struct{}{}
+		}
+		upgradeReady = ready
+		inheritedMu.Unlock()
+	})
+
+	if ln := takeInheritedListener(addr); ln != nil {
+		app.internalLog.WithField("bind", addr).Info("using inherited listener")
+		return ln, nil
+	}
+
+	if strings.HasPrefix(addr, UnixPrefix) {
+		return app.listenUnix(strings.TrimPrefix(addr, UnixPrefix))
+	}
+
+	return net.Listen(network, addr)
+}
+
+// listenUnix listens on the unix domain socket and applies socket permissions.
+// Stale socket file, left by a crashed process, is removed.
+func (app *App) listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("listen unix %s: socket is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if app.unixSocketMode != 0 {
+		if err = os.Chmod(path, app.unixSocketMode); err != nil {
+			_ = ln.Close()
+			return nil, err
+		}
+	}
+	if app.unixSocketChown {
+		if err = os.Chown(path, app.unixSocketUID, app.unixSocketGID); err != nil {
+			_ = ln.Close()
+			return nil, err
+		}
+	}
+
+	return ln, nil
+}
+
+// OptUnixSocketMode sets the file mode of unix domain sockets
+// the app listens on, e.g. 0660
+func OptUnixSocketMode(mode os.FileMode) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.unixSocketMode = mode
+	}
+}
+
+// OptUnixSocketOwner sets the owner of unix domain sockets the app listens on.
+// Use -1 to keep uid or gid unchanged.
+func OptUnixSocketOwner(uid, gid int) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.unixSocketUID = uid
+		app.unixSocketGID = gid
+		app.unixSocketChown = true
+	}
+}
+
+func takeInheritedListener(addr string) net.Listener {
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+
+	for i, inherited := range inheritedListeners {
+		if inherited.name == addr || listenerAddrMatches(inherited.ln.Addr(), addr) {
+			inheritedListeners = append(inheritedListeners[:i], inheritedListeners[i+1:]...)
+			return inherited.ln
+		}
+	}
+
+	return nil
+}
+
+// inheritedListenerServed notifies the parent process, that started the upgrade,
+// as soon as all inherited listeners are served
+func inheritedListenerServed(ln net.Listener) {
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+
+	if _, ok := inheritedUnserved[ln]; !ok {
+		return
+	}
+	delete(inheritedUnserved, ln)
+	if len(inheritedUnserved) > 0 || upgradeReady == nil {
+		return
+	}
+
+	_, _ = upgradeReady.Write([]byte{1})
+	_ = upgradeReady.Close()
+	upgradeReady = nil
+}
+
+// listenerAddrMatches reports if the listener address
+// is the same as the bind address, e.g. "[::]:80" and ":80"
+func listenerAddrMatches(lnAddr net.Addr, addr string) bool {
+	switch a := lnAddr.(type) {
+	case *net.TCPAddr:
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || port != strconv.Itoa(a.Port) {
+			return false
+		}
+		if len(host) == 0 {
+			return a.IP == nil || a.IP.IsUnspecified()
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.Equal(a.IP)
+	case *net.UnixAddr:
+		return a.Name == strings.TrimPrefix(addr, UnixPrefix)
+	}
+
+	return false
+}
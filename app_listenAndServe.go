@@ -19,7 +19,7 @@ import (
 
 // ListenAndServe HTTP on given addr.
 // runs flag.Parse() if !flag.Parsed() to support
-// --bind flag.
+// --bind flag, that overrides addr only if it is set.
 func (app *App) ListenAndServe(addr ...string) error {
 	checks()
 	var bind string
@@ -39,7 +39,8 @@ func (app *App) ListenAndServe(addr ...string) error {
 		flag.Parse()
 	}
 
-	if app.Flags.values != nil {
+	// the explicit addr wins over the flag default, e.g. in ServeConfig after LoadConfig
+	if app.Flags.values != nil && (len(addr) == 0 || flagSet("bind")) {
 		if bindFlag, ok := app.Flags.values["bind"]; ok {
 			bind = *bindFlag.Value
 		}
@@ -52,15 +53,14 @@ func (app *App) ListenAndServe(addr ...string) error {
 	l := app.internalLog.WithField("bind", bind)
 	l.Info("Starting HTTP")
 
-	var err error
+	ln, err := app.Listen("tcp4", bind)
+	if err != nil {
+		l.Errorf("ListenAndServe failed: %s", err)
+		return err
+	}
 	srv := app.copyServer()
-	app.runningServersMu.Lock()
-	app.runningServers = append(app.runningServers, runningServerInfo{
-		bind: bind,
-		srv:  srv,
-	})
-	app.runningServersMu.Unlock()
-	if err = srv.ListenAndServe(bind); err != nil {
+	app.addRunningServer(bind, srv, ln)
+	if err = srv.Serve(app.wrapListener(ln)); err != nil {
 		l.Errorf("ListenAndServe failed: %s", err)
 	}
 
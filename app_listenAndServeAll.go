@@ -13,24 +13,40 @@ package gramework
 import "fmt"
 
 // ListenAndServeAll serves HTTP and HTTPS automatically.
-// HTTPS is served on :443.
+// HTTPS is served on :443, app.TLSPort or the address set by OptTLSBind.
 // If it can't serve http or https, it logs an error and
 // exit the server with app.Logger.Fatalf().
+// Use ListenAndServeAllErrors to handle errors yourself.
 func (app *App) ListenAndServeAll(httpAddr ...string) {
-	go func() {
-		bindAddr := ":443"
-		if app.TLSPort != 0 {
-			bindAddr = fmt.Sprintf(":%d", app.TLSPort)
-		}
-		err := app.ListenAndServeAutoTLS(bindAddr)
-		app.internalLog.Fatalf("can't serve tls: %s", err)
-	}()
-
-	if err := app.ListenAndServe(httpAddr...); err != nil {
-		app.internalLog.Fatalf("can't serve http: %s", err)
+	for err := range app.ListenAndServeAllErrors(httpAddr...) {
+		app.internalLog.Fatalf("can't serve: %s", err)
 	}
 }
 
+// ListenAndServeAllErrors serves HTTP and HTTPS automatically, just like
+// ListenAndServeAll, but instead of exiting the process reports errors
+// as *ServeError to the returned channel. The channel is closed when
+// both servers stop.
+//
+// ACME HTTP-01 challenges are served on the HTTP server, see ACMEChallengeHandler.
+func (app *App) ListenAndServeAllErrors(httpAddr ...string) <-chan error {
+	app.mountACMEChallenge()
+	tlsBind := app.tlsBindAddr()
+	httpBind := ""
+	if len(httpAddr) > 0 {
+		httpBind = httpAddr[0]
+	}
+
+	return serveMulti([]string{tlsBind, httpBind}, []func() error{
+		func() error {
+			return app.ListenAndServeAutoTLS(tlsBind)
+		},
+		func() error {
+			return app.ListenAndServe(httpAddr...)
+		},
+	})
+}
+
 // ListenAndServeAllDev serves HTTP and HTTPS automatically
 // with localhost HTTPS support via self-signed certs.
 // HTTPS is served on :443.
@@ -40,3 +56,24 @@ func (app *App) ListenAndServeAll(httpAddr ...string) {
 func (app *App) ListenAndServeAllDev(httpAddr ...string) {
 	app.ListenAndServeAll(httpAddr...)
 }
+
+// OptTLSBind sets the address ListenAndServeAll serves HTTPS on,
+// e.g. "127.0.0.1:8443" or "unix:/run/app-tls.sock".
+// It takes precedence over app.TLSPort.
+func OptTLSBind(addr string) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.tlsBind = addr
+	}
+}
+
+func (app *App) tlsBindAddr() string {
+	if len(app.tlsBind) > 0 {
+		return app.tlsBind
+	}
+	if app.TLSPort != 0 {
+		return fmt.Sprintf(":%d", app.TLSPort)
+	}
+
+	return ":443"
+}
@@ -13,12 +13,10 @@ package gramework
 import (
 	"crypto/tls"
 	"fmt"
-	"math/rand"
 	"net"
 	"runtime"
-	"time"
 
-	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/crypto/acme"
 )
 
 func getDefaultTLSConfig() *tls.Config {
@@ -37,18 +35,27 @@ func getCachePath(dev ...bool) string {
 	return p
 }
 
-// ListenAndServeAutoTLS serves TLS requests
+// ListenAndServeAutoTLS serves TLS requests with certificates obtained via ACME,
+// by default from Let's Encrypt. Both TLS-ALPN-01 and HTTP-01 challenges
+// are supported, see ACMEChallengeHandler. Use OptACMEDirectoryURL,
+// OptACMEHosts, OptACMEHostPolicy, OptACMECache and OptACMEEvents to
+// configure the ACME client.
 func (app *App) ListenAndServeAutoTLS(addr string, cachePath ...string) error {
 	if len(app.TLSEmails) == 0 {
 		return ErrTLSNoEmails
 	}
 
-	addr, err := normalizeTLSAddr(addr)
+	tlsConfig, err := app.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	addr, err = normalizeTLSAddr(addr)
 	if err != nil {
 		app.internalLog.Errorf("Bad address %q: %s", addr, err)
 	}
 
-	ln, err := net.Listen("tcp", addr)
+	ln, err := app.Listen("tcp", addr)
 	if err != nil {
 		app.internalLog.Errorf("Can't serve %q: %s", addr, err)
 		return err
@@ -58,25 +65,22 @@ func (app *App) ListenAndServeAutoTLS(addr string, cachePath ...string) error {
 	if len(cachePath) > 0 {
 		letscache = cachePath[0]
 	}
+	m := app.acmeManager(letscache)
 
-	s := rand.NewSource(time.Now().Unix())
-	r := rand.New(s)
-	m := autocert.Manager{
-		Prompt: autocert.AcceptTOS,
-		Email:  app.TLSEmails[r.Intn(len(app.TLSEmails))],
-	}
-
-	if letscache != "" {
-		m.Cache = autocert.DirCache(letscache)
-	}
-
-	tlsConfig := getDefaultTLSConfig()
+	tlsConfig.NextProtos = append(tlsConfig.NextProtos, "http/1.1", acme.ALPNProto)
 	tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (cert *tls.Certificate, err error) {
 		if len(hello.ServerName) == 0 || hello.ServerName == localhost {
 			hello.ServerName = localhost
 			cert, err = selfSignedCertificate(hello)
 		} else {
 			cert, err = m.GetCertificate(hello)
+			if err != nil {
+				app.acmeEvent(ACMEEvent{
+					Type:   ACMECertificateFailed,
+					Domain: hello.ServerName,
+					Err:    err,
+				})
+			}
 		}
 
 		if err != nil {
@@ -85,25 +89,29 @@ func (app *App) ListenAndServeAutoTLS(addr string, cachePath ...string) error {
 
 		return cert, err
 	}
+	skipACMEClientAuth(tlsConfig)
+
+	return app.serveTLS(addr, ln, tlsConfig)
+}
 
-	tlsLn := tls.NewListener(ln, tlsConfig)
-	checks()
-
-	l := app.internalLog.WithField("bind", addr)
-	l.Info("Starting HTTPS")
-
-	srv := app.copyServer()
-	app.runningServersMu.Lock()
-	app.runningServers = append(app.runningServers, runningServerInfo{
-		bind: addr,
-		srv:  srv,
-	})
-	app.runningServersMu.Unlock()
-	if err = srv.Serve(tlsLn); err != nil {
-		app.internalLog.Errorf("Can't serve: %s", err)
+// skipACMEClientAuth makes the config to not request client certificates
+// in TLS-ALPN-01 challenge handshakes, since ACME validators have no client certificates
+func skipACMEClientAuth(tlsConfig *tls.Config) {
+	if tlsConfig.ClientAuth == tls.NoClientCert {
+		return
 	}
 
-	return err
+	acmeConfig := tlsConfig.Clone()
+	acmeConfig.ClientAuth = tls.NoClientCert
+	acmeConfig.ClientCAs = nil
+	acmeConfig.NextProtos = []string{acme.ALPNProto}
+	tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		// validators offer the acme-tls/1 protocol only, see RFC 8737, section 3
+		if len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto {
+			return acmeConfig, nil
+		}
+		return nil, nil
+	}
 }
 
 // ListenAndServeAutoTLSDev serves non-production grade TLS requests. Supports localhost.localdomain.
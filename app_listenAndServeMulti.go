@@ -0,0 +1,93 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"net"
+	"sync"
+)
+
+// ServeError is an error occurred while serving on the bind address
+type ServeError struct {
+	Bind string
+	Err  error
+}
+
+// Error implements error interface
+func (e *ServeError) Error() string {
+	return e.Bind + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *ServeError) Unwrap() error {
+	return e.Err
+}
+
+// ListenAndServeMulti serves HTTP on all given addresses concurrently.
+// Addresses with the "unix:" prefix are served on unix domain sockets.
+//
+// Errors of all servers are reported as *ServeError to the returned channel,
+// that is closed when all servers stop.
+func (app *App) ListenAndServeMulti(addrs ...string) <-chan error {
+	serves := make([]func() error, 0, len(addrs))
+	binds := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		addr := addr
+		serves = append(serves, func() error {
+			return app.ListenAndServe(addr)
+		})
+		binds = append(binds, addr)
+	}
+
+	return serveMulti(binds, serves)
+}
+
+// ServeMulti serves HTTP on all given listeners concurrently.
+//
+// Errors of all servers are reported as *ServeError to the returned channel,
+// that is closed when all servers stop.
+func (app *App) ServeMulti(lns ...net.Listener) <-chan error {
+	serves := make([]func() error, 0, len(lns))
+	binds := make([]string, 0, len(lns))
+	for _, ln := range lns {
+		ln := ln
+		serves = append(serves, func() error {
+			return app.Serve(ln)
+		})
+		binds = append(binds, ln.Addr().String())
+	}
+
+	return serveMulti(binds, serves)
+}
+
+func serveMulti(binds []string, serves []func() error) <-chan error {
+	errCh := make(chan error, len(serves))
+	wg := &sync.WaitGroup{}
+	for i, serve := range serves {
+		wg.Add(1)
+		go func(bind string, serve func() error) {
+			defer wg.Done()
+			if err := serve(); err != nil {
+				errCh <- &ServeError{
+					Bind: bind,
+					Err:  err,
+				}
+			}
+		}(binds[i], serve)
+	}
+
+	go func() {
+		wg.Wait()
+		close(errCh)
+	}()
+
+	return errCh
+}
@@ -0,0 +1,110 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestAppListenAndServeMulti(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets are not tested on windows")
+	}
+
+	const text = "multi"
+	app := New(OptUnixSocketMode(0600))
+	app.GET("/", text)
+
+	sock := filepath.Join(t.TempDir(), "app.sock")
+	tcpAddr := testFreeAddr(t)
+	errCh := app.ListenAndServeMulti(UnixPrefix+sock, tcpAddr)
+
+	unixClient := &fasthttp.Client{
+		Dial: func(string) (net.Conn, error) {
+			return net.Dial("unix", sock)
+		},
+	}
+
+	var (
+		code int
+		body []byte
+		err  error
+	)
+	for i := 0; i < 50; i++ {
+		code, body, err = unixClient.Get(nil, "http://unix/")
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil || code != fasthttp.StatusOK || string(body) != text {
+		t.Fatalf("unexpected unix socket response: %d %q %v", code, body, err)
+	}
+
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatalf("could not stat socket: %s", err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0600 {
+		t.Errorf("unexpected socket mode: %o", mode)
+	}
+
+	code, body, err = fasthttp.Get(nil, "http://"+tcpAddr+"/")
+	if err != nil || code != fasthttp.StatusOK || string(body) != text {
+		t.Fatalf("unexpected tcp response: %d %q %v", code, body, err)
+	}
+
+	if err = app.Shutdown(); err != nil {
+		t.Fatalf("unexpected shutdown error: %s", err)
+	}
+
+	select {
+	case err, ok := <-errCh:
+		if ok {
+			t.Fatalf("unexpected serve error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("error channel was not closed after shutdown")
+	}
+
+	if _, err = os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("socket file should be removed after shutdown: %v", err)
+	}
+}
+
+func TestAppListenAndServeMultiError(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	app := New()
+	errCh := app.ListenAndServeMulti(ln.Addr().String())
+
+	select {
+	case err := <-errCh:
+		serveErr := &ServeError{}
+		if !errors.As(err, &serveErr) || serveErr.Bind != ln.Addr().String() {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected bind error")
+	}
+}
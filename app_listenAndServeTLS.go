@@ -0,0 +1,85 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// ListenAndServeTLS serves TLS requests using the certificate and key files.
+// Certificates added with AddTLSCertificate are selected by SNI, the given one
+// is used by default. Both certFile and keyFile may be empty if there are
+// added certificates.
+//
+// Certificate files are watched for changes and reloaded without restart,
+// see OptTLSReloadInterval.
+func (app *App) ListenAndServeTLS(addr, certFile, keyFile string) error {
+	if len(certFile) > 0 || len(keyFile) > 0 {
+		if err := app.tlsCerts.add(certFile, keyFile, true); err != nil {
+			app.internalLog.Errorf("Can't load certificate %q: %s", certFile, err)
+			return err
+		}
+	}
+	if app.tlsCerts.len() == 0 {
+		return ErrTLSNoCertificates
+	}
+
+	app.domainListLock.RLock()
+	for domain := range app.domains {
+		if !app.tlsCerts.covers(domain) {
+			app.internalLog.WithField("domain", domain).Warn("no certificate for the domain, the default one will be used")
+		}
+	}
+	app.domainListLock.RUnlock()
+
+	tlsConfig, err := app.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	addr, err = normalizeTLSAddr(addr)
+	if err != nil {
+		app.internalLog.Errorf("Bad address %q: %s", addr, err)
+	}
+
+	ln, err := app.Listen("tcp", addr)
+	if err != nil {
+		app.internalLog.Errorf("Can't serve %q: %s", addr, err)
+		return err
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go app.tlsCerts.watch(app, app.tlsReloadInterval, stop)
+
+	tlsConfig.GetCertificate = app.tlsCerts.getCertificate
+
+	return app.serveTLS(addr, ln, tlsConfig)
+}
+
+// serveTLS serves TLS requests on the listener
+func (app *App) serveTLS(addr string, ln net.Listener, tlsConfig *tls.Config) error {
+	tlsLn := tls.NewListener(app.wrapListener(ln), tlsConfig)
+	checks()
+
+	l := app.internalLog.WithField("bind", addr)
+	l.Info("Starting HTTPS")
+
+	srv := app.copyServer()
+	app.addRunningServer(addr, srv, ln)
+	err := srv.Serve(tlsLn)
+	if err != nil {
+		app.internalLog.Errorf("Can't serve: %s", err)
+	}
+
+	return err
+}
@@ -0,0 +1,74 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenerAddrMatches(t *testing.T) {
+	cases := []struct {
+		lnAddr net.Addr
+		addr   string
+		expect bool
+	}{
+		{&net.TCPAddr{IP: net.IPv6zero, Port: 80}, ":80", true},
+		{&net.TCPAddr{IP: net.IPv4zero, Port: 80}, "0.0.0.0:80", true},
+		{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80}, "127.0.0.1:80", true},
+		{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80}, ":80", false},
+		{&net.TCPAddr{IP: net.IPv6zero, Port: 80}, ":8080", false},
+		{&net.TCPAddr{IP: net.IPv6zero, Port: 80}, "localhost", false},
+		{&net.UnixAddr{Name: "/run/app.sock", Net: "unix"}, "unix:/run/app.sock", true},
+		{&net.UnixAddr{Name: "/run/app.sock", Net: "unix"}, "/run/app.sock", true},
+		{&net.UnixAddr{Name: "/run/app.sock", Net: "unix"}, "/run/other.sock", false},
+	}
+
+	for _, c := range cases {
+		if got := listenerAddrMatches(c.lnAddr, c.addr); got != c.expect {
+			t.Errorf("listenerAddrMatches(%q, %q): expected %v, got %v", c.lnAddr, c.addr, c.expect, got)
+		}
+	}
+}
+
+func TestAppListenInherited(t *testing.T) {
+	inheritedOnce.Do(func() {})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+	defer ln.Close()
+
+	inheritedMu.Lock()
+	inheritedListeners = append(inheritedListeners, inheritedListener{name: "api", ln: ln})
+	inheritedMu.Unlock()
+
+	app := New()
+	got, err := app.Listen("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got != ln {
+		t.Fatalf("expected inherited listener to be used")
+	}
+
+	// inherited listener can be taken only once
+	got, err = app.Listen("tcp", ln.Addr().String())
+	if err == nil {
+		got.Close()
+		t.Fatalf("expected address to be busy")
+	}
+
+	if taken := takeInheritedListener("api"); taken != nil {
+		t.Fatalf("expected listener to be already taken")
+	}
+}
@@ -0,0 +1,322 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/valyala/fasthttp"
+)
+
+// Probe is a set of Kubernetes-style health probes
+type Probe uint8
+
+const (
+	// ProbeLiveness reports if the app is alive and should not be restarted.
+	// Liveness checks should not depend on external services.
+	ProbeLiveness Probe = 1 << iota
+	// ProbeReadiness reports if the app can serve requests
+	ProbeReadiness
+	// ProbeStartup reports if the app has finished starting
+	ProbeStartup
+)
+
+const (
+	// DefaultHealthCheckTimeout is the default timeout of a single health check
+	DefaultHealthCheckTimeout = 5 * time.Second
+
+	// DefaultHealthCheckProbes are the probes health checks are included into by default
+	DefaultHealthCheckProbes = ProbeReadiness | ProbeStartup
+)
+
+var (
+	errNotReady   = errors.New("app is not ready")
+	errNotStarted = errors.New("app has not started")
+)
+
+type (
+	// HealthCheck checks a dependency, e.g. a database connection.
+	// The context is canceled when the check timeout expires.
+	HealthCheck func(ctx context.Context) error
+
+	// HealthCheckOption configures a health check, see App.AddHealthCheck
+	HealthCheckOption func(*healthCheck)
+
+	healthCheck struct {
+		name        string
+		check       HealthCheck
+		timeout     time.Duration
+		cacheTTL    time.Duration
+		nonCritical bool
+		probes      Probe
+
+		historySize   int
+		flapThreshold int
+
+		mu        sync.Mutex
+		lastErr   error
+		checkedAt time.Time
+		status    HealthCheckStatus
+		// running is closed when the check in progress completes,
+		// concurrent runs wait for it and share its result
+		running chan struct{}
+	}
+
+	healthCheckResult struct {
+		name        string
+		err         error
+		nonCritical bool
+	}
+)
+
+// HealthCheckTimeout sets the check timeout, DefaultHealthCheckTimeout is used by default.
+// A check, that did not return in time, fails.
+func HealthCheckTimeout(d time.Duration) HealthCheckOption {
+	return func(c *healthCheck) {
+		if d > 0 {
+			c.timeout = d
+		}
+	}
+}
+
+// HealthCheckCacheTTL sets the time the check result is reused for,
+// so frequent probes do not overload the dependency. By default, results are not cached.
+func HealthCheckCacheTTL(d time.Duration) HealthCheckOption {
+	return func(c *healthCheck) {
+		c.cacheTTL = d
+	}
+}
+
+// HealthCheckNonCritical marks the check as non-critical: its failures
+// are reported in the verbose output, but do not fail the probe
+func HealthCheckNonCritical() HealthCheckOption {
+	return func(c *healthCheck) {
+		c.nonCritical = true
+	}
+}
+
+// HealthCheckProbes sets the probes the check is included into,
+// e.g. ProbeLiveness|ProbeReadiness. DefaultHealthCheckProbes are used by default.
+func HealthCheckProbes(probes Probe) HealthCheckOption {
+	return func(c *healthCheck) {
+		c.probes = probes
+	}
+}
+
+// AddHealthCheck registers the named check, that will be run by the health probes,
+// see App.Probes. A check with the same name is replaced.
+// Concurrent probes do not run the check twice: they wait for the running check and share its result.
+func (app *App) AddHealthCheck(name string, check HealthCheck, opts ...HealthCheckOption) *App {
+	c := &healthCheck{
+		name:          name,
+		check:         check,
+		timeout:       DefaultHealthCheckTimeout,
+		probes:        DefaultHealthCheckProbes,
+		historySize:   DefaultHealthCheckHistorySize,
+		flapThreshold: DefaultHealthCheckFlapThreshold,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.status = HealthCheckStatus{
+		Name:     name,
+		Critical: !c.nonCritical,
+		Status:   HealthStatusUnknown,
+	}
+
+	app.healthMu.Lock()
+	defer app.healthMu.Unlock()
+	for i := range app.healthChecks {
+		if app.healthChecks[i].name == name {
+			app.healthChecks[i] = c
+			return app
+		}
+	}
+	app.healthChecks = append(app.healthChecks, c)
+	return app
+}
+
+// IsStarted reports if the app has finished starting.
+// The app is marked as started as soon as any of its servers starts accepting connections,
+// e.g. with ListenAndServe or Serve. Run starts serving after OnStart hooks succeeded.
+func (app *App) IsStarted() bool {
+	return atomic.LoadInt32(&app.started) == 1
+}
+
+// SetStarted sets if the app has finished starting
+func (app *App) SetStarted(started bool) {
+	if started {
+		atomic.StoreInt32(&app.started, 1)
+		return
+	}
+	atomic.StoreInt32(&app.started, 0)
+}
+
+// Probes registers health probes handlers on /livez, /readyz and /startupz
+func (app *App) Probes() {
+	app.GET("/livez", app.ProbeHandler(ProbeLiveness))
+	app.GET("/readyz", app.ProbeHandler(ProbeReadiness))
+	app.GET("/startupz", app.ProbeHandler(ProbeStartup))
+}
+
+// ProbeHandler returns the handler, that runs health checks registered for the probe
+// concurrently and serves 200 OK if all critical checks passed, and 503 Service Unavailable otherwise.
+// Readiness probe also fails if the app is not ready, e.g. during graceful shutdown,
+// and startup probe fails until the app is started, see App.IsReady and App.IsStarted.
+//
+// Failed probes and probes requested with ?verbose list every check result.
+func (app *App) ProbeHandler(probe Probe) func(*Context) {
+	name := probe.String()
+	return func(ctx *Context) {
+		results := app.runHealthChecks(probe)
+		if probe&ProbeReadiness != 0 && !app.IsReady() {
+			results = append(results, healthCheckResult{name: "ready", err: errNotReady})
+		}
+		if probe&ProbeStartup != 0 && !app.IsStarted() {
+			results = append(results, healthCheckResult{name: "started", err: errNotStarted})
+		}
+
+		failed := false
+		for _, r := range results {
+			if r.err != nil && !r.nonCritical {
+				failed = true
+				break
+			}
+		}
+
+		ctx.Response.Header.Set("Cache-Control", "no-store")
+		ctx.SetContentType(plainCT)
+		if failed {
+			ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		}
+		if !failed && !ctx.QueryArgs().Has("verbose") {
+			ctx.SetBodyString("ok")
+			return
+		}
+
+		var b strings.Builder
+		for _, r := range results {
+			switch {
+			case r.err == nil:
+				fmt.Fprintf(&b, "[+]%s ok\n", r.name)
+			case r.nonCritical:
+				fmt.Fprintf(&b, "[!]%s failed (non-critical): %s\n", r.name, r.err)
+			default:
+				fmt.Fprintf(&b, "[-]%s failed: %s\n", r.name, r.err)
+			}
+		}
+		if failed {
+			fmt.Fprintf(&b, "%s check failed\n", name)
+		} else {
+			fmt.Fprintf(&b, "%s check passed\n", name)
+		}
+		ctx.SetBodyString(b.String())
+	}
+}
+
+// String returns the probe endpoint name, e.g. "readyz"
+func (p Probe) String() string {
+	var names []string
+	if p&ProbeLiveness != 0 {
+		names = append(names, "livez")
+	}
+	if p&ProbeReadiness != 0 {
+		names = append(names, "readyz")
+	}
+	if p&ProbeStartup != 0 {
+		names = append(names, "startupz")
+	}
+	return strings.Join(names, "|")
+}
+
+func (app *App) runHealthChecks(probe Probe) []healthCheckResult {
+	app.healthMu.RLock()
+	checks := make([]*healthCheck, 0, len(app.healthChecks))
+	for _, c := range app.healthChecks {
+		if c.probes&probe != 0 {
+			checks = append(checks, c)
+		}
+	}
+	app.healthMu.RUnlock()
+
+	results := make([]healthCheckResult, len(checks))
+	var wg sync.WaitGroup
+	wg.Add(len(checks))
+	for i, c := range checks {
+		go func(i int, c *healthCheck) {
+			defer wg.Done()
+			results[i] = healthCheckResult{
+				name:        c.name,
+				err:         c.run(app.internalLog),
+				nonCritical: c.nonCritical,
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func (c *healthCheck) run(logger *log.Entry) error {
+	c.mu.Lock()
+	if c.cacheTTL > 0 && !c.checkedAt.IsZero() && time.Since(c.checkedAt) < c.cacheTTL {
+		err := c.lastErr
+		c.mu.Unlock()
+		return err
+	}
+	if running := c.running; running != nil {
+		c.mu.Unlock()
+		<-running
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.lastErr
+	}
+	running := make(chan struct{})
+	c.running = running
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	res := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				res <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		res <- c.check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-res:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", c.timeout)
+	}
+
+	c.mu.Lock()
+	c.lastErr = err
+	c.checkedAt = time.Now()
+	c.record(logger, start, c.checkedAt.Sub(start), err)
+	c.running = nil
+	c.mu.Unlock()
+	close(running)
+	return err
+}
@@ -0,0 +1,179 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"time"
+
+	"github.com/apex/log"
+)
+
+const (
+	// DefaultHealthCheckHistorySize is the default count of recent results kept per health check
+	DefaultHealthCheckHistorySize = 10
+	// DefaultHealthCheckFlapThreshold is the default count of ok/failed transitions
+	// in the history, that marks the check as flapping
+	DefaultHealthCheckFlapThreshold = 4
+
+	// HealthStatusUnknown is the status of a health check, that has not run yet
+	HealthStatusUnknown = "unknown"
+	// HealthStatusOK is the status of a passing health check
+	HealthStatusOK = "ok"
+	// HealthStatusFailing is the status of a failing health check
+	HealthStatusFailing = "failing"
+	// HealthStatusFlapping is the status of a health check,
+	// that switches between ok and failed too often
+	HealthStatusFlapping = "flapping"
+)
+
+type (
+	// HealthCheckRecord is a single health check result
+	HealthCheckRecord struct {
+		Time    time.Time     `json:"time"`
+		Latency time.Duration `json:"latency_ns"`
+		Error   string        `json:"error,omitempty"`
+	}
+
+	// HealthCheckStatus is the state and recent history of a health check,
+	// see App.HealthStatus
+	HealthCheckStatus struct {
+		Name     string `json:"name"`
+		Critical bool   `json:"critical"`
+		// Status is one of HealthStatusUnknown, HealthStatusOK,
+		// HealthStatusFailing or HealthStatusFlapping
+		Status   string `json:"status"`
+		Flapping bool   `json:"flapping"`
+
+		LastCheckedAt       time.Time     `json:"last_checked_at"`
+		LastLatency         time.Duration `json:"last_latency_ns"`
+		LastSuccessAt       time.Time     `json:"last_success_at"`
+		LastFailureAt       time.Time     `json:"last_failure_at"`
+		LastFailure         string        `json:"last_failure,omitempty"`
+		ConsecutiveFailures int           `json:"consecutive_failures"`
+		Runs                uint64        `json:"runs"`
+		Failures            uint64        `json:"failures"`
+
+		// History contains recent results, oldest first
+		History []HealthCheckRecord `json:"history"`
+	}
+)
+
+// HealthCheckHistorySize sets the count of recent results kept for the check,
+// DefaultHealthCheckHistorySize is used by default. Cached results are not recorded.
+func HealthCheckHistorySize(size int) HealthCheckOption {
+	return func(c *healthCheck) {
+		if size > 0 {
+			c.historySize = size
+		}
+	}
+}
+
+// HealthCheckFlapThreshold sets the count of ok/failed transitions in the history,
+// that marks the check as flapping. DefaultHealthCheckFlapThreshold is used by default.
+func HealthCheckFlapThreshold(transitions int) HealthCheckOption {
+	return func(c *healthCheck) {
+		if transitions > 0 {
+			c.flapThreshold = transitions
+		}
+	}
+}
+
+// HealthStatus returns the state and recent history of registered health checks
+// in the order they were registered
+func (app *App) HealthStatus() []HealthCheckStatus {
+	app.healthMu.RLock()
+	checks := append([]*healthCheck(nil), app.healthChecks...)
+	app.healthMu.RUnlock()
+
+	statuses := make([]HealthCheckStatus, 0, len(checks))
+	for _, c := range checks {
+		c.mu.Lock()
+		status := c.status
+		status.History = append([]HealthCheckRecord(nil), c.status.History...)
+		c.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// HealthStatusHandler serves App.HealthStatus as JSON
+func (app *App) HealthStatusHandler(ctx *Context) {
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	if err := ctx.JSON(app.HealthStatus()); err != nil {
+		ctx.Err500(err)
+	}
+}
+
+// record updates the check status. Check state changes are logged.
+func (c *healthCheck) record(logger *log.Entry, at time.Time, latency time.Duration, err error) {
+	s := &c.status
+	rec := HealthCheckRecord{
+		Time:    at,
+		Latency: latency,
+	}
+	prevStatus := s.Status
+
+	s.Runs++
+	s.LastCheckedAt = at
+	s.LastLatency = latency
+	if err != nil {
+		rec.Error = err.Error()
+		s.Failures++
+		s.ConsecutiveFailures++
+		s.LastFailureAt = at
+		s.LastFailure = rec.Error
+	} else {
+		s.ConsecutiveFailures = 0
+		s.LastSuccessAt = at
+	}
+
+	if len(s.History) >= c.historySize {
+		copy(s.History, s.History[len(s.History)-c.historySize+1:])
+		s.History = s.History[:c.historySize-1]
+	}
+	s.History = append(s.History, rec)
+
+	transitions := 0
+	for i := 1; i < len(s.History); i++ {
+		if (s.History[i].Error == "") != (s.History[i-1].Error == "") {
+			transitions++
+		}
+	}
+	s.Flapping = transitions >= c.flapThreshold
+
+	switch {
+	case s.Flapping:
+		s.Status = HealthStatusFlapping
+	case err != nil:
+		s.Status = HealthStatusFailing
+	default:
+		s.Status = HealthStatusOK
+	}
+
+	if logger == nil || s.Status == prevStatus || (prevStatus == HealthStatusUnknown && s.Status == HealthStatusOK) {
+		return
+	}
+	entry := logger.WithFields(log.Fields{
+		"check":  c.name,
+		"status": s.Status,
+	})
+	if err != nil {
+		entry = entry.WithError(err)
+	}
+	switch {
+	case s.Status == HealthStatusOK:
+		entry.Info("health check recovered")
+	case c.nonCritical:
+		entry.Info("non-critical health check state changed")
+	default:
+		entry.Warn("health check state changed")
+	}
+}
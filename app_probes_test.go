@@ -0,0 +1,263 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func testProbe(t *testing.T, app *App, uri string) (int, string) {
+	t.Helper()
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI(uri)
+	app.handler()(ctx)
+	return ctx.Response.StatusCode(), string(ctx.Response.Body())
+}
+
+func TestProbes(t *testing.T) {
+	app := New()
+	app.Probes()
+
+	dbDown := int32(1)
+	app.AddHealthCheck("ping", func(context.Context) error {
+		return nil
+	}, HealthCheckProbes(ProbeLiveness|ProbeReadiness))
+	app.AddHealthCheck("db", func(context.Context) error {
+		if atomic.LoadInt32(&dbDown) == 1 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	app.AddHealthCheck("search", func(context.Context) error {
+		return errors.New("index is stale")
+	}, HealthCheckNonCritical())
+
+	code, body := testProbe(t, app, "/livez")
+	if code != fasthttp.StatusOK || body != "ok" {
+		t.Fatalf("unexpected livez response %d: %q", code, body)
+	}
+
+	code, body = testProbe(t, app, "/readyz")
+	if code != fasthttp.StatusServiceUnavailable {
+		t.Fatalf("readyz should fail, got %d: %q", code, body)
+	}
+	for _, line := range []string{
+		"[+]ping ok",
+		"[-]db failed: connection refused",
+		"[!]search failed (non-critical): index is stale",
+		"[-]ready failed: app is not ready",
+		"readyz check failed",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("readyz response should contain %q: %q", line, body)
+		}
+	}
+
+	atomic.StoreInt32(&dbDown, 0)
+	app.SetReady(true)
+	if code, body = testProbe(t, app, "/readyz"); code != fasthttp.StatusOK || body != "ok" {
+		t.Fatalf("unexpected readyz response %d: %q", code, body)
+	}
+	code, body = testProbe(t, app, "/readyz?verbose")
+	if code != fasthttp.StatusOK || !strings.Contains(body, "[!]search failed") || !strings.Contains(body, "readyz check passed") {
+		t.Fatalf("unexpected verbose readyz response %d: %q", code, body)
+	}
+
+	code, body = testProbe(t, app, "/startupz")
+	if code != fasthttp.StatusServiceUnavailable || !strings.Contains(body, "[-]started failed") {
+		t.Fatalf("startupz should fail until the app is started, got %d: %q", code, body)
+	}
+	app.SetStarted(true)
+	if code, body = testProbe(t, app, "/startupz"); code != fasthttp.StatusOK {
+		t.Fatalf("unexpected startupz response %d: %q", code, body)
+	}
+}
+
+func TestProbesListenAndServe(t *testing.T) {
+	app := New()
+	app.Probes()
+	if app.IsReady() || app.IsStarted() {
+		t.Fatalf("app should not be ready or started before it serves")
+	}
+
+	go func() {
+		_ = app.ListenAndServe("127.0.0.1:0")
+	}()
+	defer app.Shutdown()
+
+	var addr string
+	for deadline := time.Now().Add(5 * time.Second); addr == "" && time.Now().Before(deadline); {
+		app.runningServersMu.Lock()
+		if len(app.runningServers) > 0 {
+			addr = app.runningServers[0].ln.Addr().String()
+		}
+		app.runningServersMu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	if addr == "" {
+		t.Fatalf("server has not started")
+	}
+
+	for _, probe := range []string{"/readyz", "/startupz"} {
+		code, body, err := fasthttp.Get(nil, "http://"+addr+probe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != fasthttp.StatusOK {
+			t.Errorf("%s should pass once the app serves, got %d: %q", probe, code, body)
+		}
+	}
+}
+
+func TestHealthCheckTimeoutAndCache(t *testing.T) {
+	app := New()
+	app.SetReady(true)
+	app.Probes()
+
+	var calls int32
+	app.AddHealthCheck("cached", func(context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}, HealthCheckCacheTTL(time.Hour))
+	app.AddHealthCheck("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	}, HealthCheckTimeout(10*time.Millisecond))
+
+	for i := 0; i < 3; i++ {
+		code, body := testProbe(t, app, "/readyz")
+		if code != fasthttp.StatusServiceUnavailable || !strings.Contains(body, "[-]slow failed: timed out after 10ms") {
+			t.Fatalf("slow check should time out, got %d: %q", code, body)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("cached check should run once, ran %d times", n)
+	}
+
+	app.AddHealthCheck("slow", func(context.Context) error {
+		panic("boom")
+	})
+	if code, body := testProbe(t, app, "/readyz"); code != fasthttp.StatusServiceUnavailable || !strings.Contains(body, "[-]slow failed: panic: boom") {
+		t.Fatalf("replaced check should fail with panic, got %d: %q", code, body)
+	}
+}
+
+func TestHealthCheckConcurrentRuns(t *testing.T) {
+	app := New()
+	app.SetReady(true)
+	app.Probes()
+
+	var calls int32
+	started, release := make(chan struct{}), make(chan struct{})
+	app.AddHealthCheck("db", func(context.Context) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return nil
+	}, HealthCheckTimeout(5*time.Second))
+
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			code, _ := testProbe(t, app, "/readyz")
+			codes <- code
+		}()
+	}
+	<-started
+
+	// the status is available while the check is running
+	statusRead := make(chan struct{})
+	go func() {
+		app.HealthStatus()
+		close(statusRead)
+	}()
+	select {
+	case <-statusRead:
+	case <-time.After(time.Second):
+		t.Fatal("health status is blocked by the running check")
+	}
+
+	// let the second probe join the running check
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	for i := 0; i < 2; i++ {
+		if code := <-codes; code != fasthttp.StatusOK {
+			t.Errorf("expected 200, got %d", code)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("concurrent runs should share the running check, ran %d times", n)
+	}
+}
+
+func TestHealthCheckHistory(t *testing.T) {
+	app := New()
+	app.SetReady(true)
+	app.Probes()
+
+	var fail int32
+	app.AddHealthCheck("db", func(context.Context) error {
+		if atomic.LoadInt32(&fail) == 1 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}, HealthCheckHistorySize(5), HealthCheckFlapThreshold(3))
+
+	if s := app.HealthStatus(); len(s) != 1 || s[0].Status != HealthStatusUnknown || s[0].Runs != 0 {
+		t.Fatalf("unexpected initial status: %+v", s)
+	}
+
+	for _, f := range []int32{0, 1, 1, 0, 1, 0, 0} {
+		atomic.StoreInt32(&fail, f)
+		testProbe(t, app, "/readyz")
+	}
+
+	s := app.HealthStatus()[0]
+	if s.Runs != 7 || s.Failures != 3 || s.ConsecutiveFailures != 0 {
+		t.Fatalf("unexpected counters: %+v", s)
+	}
+	if len(s.History) != 5 || s.History[0].Error != "connection refused" || s.History[4].Error != "" {
+		t.Fatalf("unexpected history: %+v", s.History)
+	}
+	if !s.Flapping || s.Status != HealthStatusFlapping || s.LastFailure != "connection refused" {
+		t.Fatalf("check should be flapping: %+v", s)
+	}
+	if s.LastFailureAt.After(s.LastSuccessAt) || s.Critical != true {
+		t.Fatalf("unexpected last results: %+v", s)
+	}
+
+	atomic.StoreInt32(&fail, 1)
+	for i := 0; i < 5; i++ {
+		testProbe(t, app, "/readyz")
+	}
+	s = app.HealthStatus()[0]
+	if s.Flapping || s.Status != HealthStatusFailing || s.ConsecutiveFailures != 5 {
+		t.Fatalf("check should be failing: %+v", s)
+	}
+
+	code, body := testProbe(t, app, "/internal/health/checks")
+	if code != 404 {
+		t.Fatalf("status handler should not be registered by Probes, got %d: %s", code, body)
+	}
+	app.Health()
+	if code, body = testProbe(t, app, "/internal/health/checks"); code != fasthttp.StatusOK || !strings.Contains(body, `"status":"failing"`) {
+		t.Fatalf("unexpected status response %d: %s", code, body)
+	}
+}
@@ -60,6 +60,7 @@ func (app *App) protectionMiddleware(handler func(*Context)) func(ctx *Context)
 	return func(ctx *Context) {
 		if ctx.IsBlacklisted() {
 			// force closing of the connection ASAP
+			ctx.SetStatusCode(forbiddenCode)
 			ctx.Hijack(nilHijackHandler)
 			return
 		}
@@ -215,6 +216,10 @@ func (ctx *Context) IsWhitelisted() (isWhitelisted bool) {
 	if ctx.RemoteIP().IsLoopback() {
 		return true
 	}
+	if ctx.App.trustedIP == nil {
+		// Gramework Protection is not enabled
+		return false
+	}
 	ctx.App.trustedIP.mu.RLock()
 	_, isWhitelisted = ctx.App.trustedIP.list[ctx.remoteIPHash()]
 	ctx.App.trustedIP.mu.RUnlock()
@@ -229,7 +234,7 @@ func (ctx *Context) IsWhitelisted() (isWhitelisted bool) {
 // Context.Whitelist(), Context.Blacklist(), Context.Suspect(), Context.HackAttemptDetected(),
 // Context.SuspectsHackAttempts()
 func (ctx *Context) IsBlacklisted() (isBlacklisted bool) {
-	if ctx.IsWhitelisted() {
+	if ctx.App.untrustedIP == nil || ctx.IsWhitelisted() {
 		return false
 	}
 	ctx.App.untrustedIP.mu.RLock()
@@ -246,7 +251,7 @@ func (ctx *Context) IsBlacklisted() (isBlacklisted bool) {
 // Context.Whitelist(), Context.Blacklist(), Context.Suspect(), Context.HackAttemptDetected(),
 // Context.SuspectsHackAttempts()
 func (ctx *Context) IsSuspect() (isSuspect bool) {
-	if ctx.IsWhitelisted() {
+	if ctx.App.suspectedIP == nil || ctx.IsWhitelisted() {
 		return false
 	}
 	ctx.App.suspectedIP.mu.RLock()
@@ -307,28 +312,48 @@ func (ctx *Context) remoteIPHash() string {
 
 // HackAttemptDetected adds given ip to Gramework Protection suspectedIP list.
 // Use it when you detected app-level hack attempt from current client.
+// The client is blacklisted after App.MaxHackAttempts() attempts.
 //
 // See also App.Protect(), App.Whitelist(), App.Untrust(), App.Suspect(), App.MaxHackAttempts(),
 // App.Blacklist(), Context.IsWhitelisted(), Context.IsBlacklisted(), Context.IsSuspect(),
 // Context.Whitelist(), Context.Suspect(), Context.Blacklist(),
 // Context.SuspectsHackAttempts()
 func (ctx *Context) HackAttemptDetected() {
-	if ctx.IsWhitelisted() {
+	if ctx.App.suspectedIP == nil || ctx.IsWhitelisted() {
 		return
 	}
 	ipHash := ctx.remoteIPHash()
 
 	ctx.App.suspectedIP.mu.Lock()
-	if s, ok := ctx.App.suspectedIP.list[ipHash]; !ok || s == nil {
-		ctx.App.suspectedIP.list[ipHash] = &suspect{
-			hackAttempts: 1,
-		}
-		ctx.App.suspectedIP.mu.Unlock()
-		return
+	s, ok := ctx.App.suspectedIP.list[ipHash]
+	if !ok || s == nil {
+		s = &suspect{}
+		ctx.App.suspectedIP.list[ipHash] = s
 	}
 	// release lock ASAP
 	ctx.App.suspectedIP.mu.Unlock()
-	atomic.AddInt32(&ctx.App.suspectedIP.list[ipHash].hackAttempts, 1)
+
+	attempts := atomic.AddInt32(&s.hackAttempts, 1)
+	if maxAttempts := atomic.LoadInt32(ctx.App.maxHackAttempts); maxAttempts > 0 && attempts >= maxAttempts {
+		ctx.Blacklist()
+	}
+}
+
+// IsAuthorized reports if the client is allowed to access a protected endpoint:
+// Gramework Protection whitelisted clients (including loopback ones, see App.Whitelist)
+// are allowed if trustWhitelisted is set, other clients must pass any of authorize functions.
+//
+// See also App.Protect(), App.Whitelist(), Context.IsWhitelisted(), Context.HackAttemptDetected()
+func (ctx *Context) IsAuthorized(trustWhitelisted bool, authorize ...func(ctx *Context) bool) bool {
+	if trustWhitelisted && ctx.IsWhitelisted() {
+		return true
+	}
+	for _, auth := range authorize {
+		if auth != nil && auth(ctx) {
+			return true
+		}
+	}
+	return false
 }
 
 // SuspectsHackAttempts returns hack attempts detected with Gramework Protection
@@ -340,12 +365,12 @@ func (ctx *Context) HackAttemptDetected() {
 // Context.Whitelist(), Context.Suspect(), Context.Blacklist(),
 // Context.HackAttemptDetected()
 func (ctx *Context) SuspectsHackAttempts() (attempts int32) {
-	if ctx.IsWhitelisted() {
+	if ctx.App.suspectedIP == nil || ctx.IsWhitelisted() {
 		return zero
 	}
 	ctx.App.suspectedIP.mu.RLock()
 	if s, ok := ctx.App.suspectedIP.list[ctx.remoteIPHash()]; ok && s != nil {
-		attempts = s.hackAttempts
+		attempts = atomic.LoadInt32(&s.hackAttempts)
 	}
 	ctx.App.suspectedIP.mu.RUnlock()
 	return
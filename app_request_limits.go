@@ -0,0 +1,219 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// RequestLimits are request body limits of routes, see App.Limit and SubRouter.LimitAll.
+// Zero fields keep the server-wide settings, e.g. OptMaxRequestBodySize.
+type RequestLimits struct {
+	// MaxBodySize is the max request body size in bytes. Requests with greater Content-Length
+	// get 413 Request Entity Too Large before their body is read.
+	MaxBodySize int
+	// ReadTimeout is the max duration of reading the request body after its headers
+	ReadTimeout time.Duration
+	// MaxDecompressedSize is the max size of the decompressed request body,
+	// see App.UseRequestDecompression
+	MaxDecompressedSize int
+}
+
+type requestLimitRule struct {
+	host     string
+	method   string
+	segments []string
+	prefix   bool
+	limits   RequestLimits
+}
+
+// Limit sets body limits of requests to the route, e.g. to allow big bodies on ingestion endpoints only:
+//
+//	app.Limit(gramework.MethodPOST, "/ingest", gramework.RequestLimits{MaxBodySize: 256 << 20})
+//
+// Empty method matches any method.
+func (app *App) Limit(method, route string, limits RequestLimits) *App {
+	app.defaultRouter.Limit(method, route, limits)
+	return app
+}
+
+// Limit sets body limits of requests to the route. Empty method matches any method.
+//
+// Limits of domain routers (see App.Domain) match the host of the request as received,
+// including the port, even if the app runs behind a proxy, that reports another host (see App.Behind):
+// body limits are applied before the request body is read, when the proxy can't be verified yet.
+func (r *Router) Limit(method, route string, limits RequestLimits) *Router {
+	r.app.addRequestLimits(requestLimitRule{
+		host:     r.domain,
+		method:   method,
+		segments: routeSegments(route),
+		limits:   limits,
+	})
+	return r
+}
+
+// Limit sets body limits of requests to the route, relative to the SubRouter prefix.
+// Empty method matches any method.
+func (r *SubRouter) Limit(method, route string, limits RequestLimits) *SubRouter {
+	root := r.root()
+	root.app.addRequestLimits(requestLimitRule{
+		host:     root.domain,
+		method:   method,
+		segments: routeSegments(r.prefixedRoute(route)),
+		limits:   limits,
+	})
+	return r
+}
+
+// LimitAll sets body limits of all requests to routes with the SubRouter prefix.
+// Limits of routes, set with Limit, and of nested SubRouters take precedence.
+func (r *SubRouter) LimitAll(limits RequestLimits) *SubRouter {
+	root := r.root()
+	root.app.addRequestLimits(requestLimitRule{
+		host:     root.domain,
+		segments: routeSegments(r.prefix),
+		prefix:   true,
+		limits:   limits,
+	})
+	return r
+}
+
+// root returns the Router the SubRouter registers routes in
+func (r *SubRouter) root() *Router {
+	switch parent := r.parent.(type) {
+	case *SubRouter:
+		return parent.root()
+	case *Router:
+		return parent
+	}
+	return nil
+}
+
+func (app *App) addRequestLimits(rule requestLimitRule) {
+	app.requestLimitsMu.Lock()
+	app.requestLimits = append(app.requestLimits, rule)
+	app.requestLimitsMu.Unlock()
+}
+
+// requestLimitsFor returns limits of the most specific rule, that matches the request:
+// route rules take precedence over prefix rules, longer prefixes take precedence over shorter ones.
+// The host must be normalized the same way as for the domain dispatch, see fasthttp.URI.Host.
+func (app *App) requestLimitsFor(host, method, path string) (limits RequestLimits, found bool) {
+	if len(app.requestLimits) == 0 {
+		return limits, false
+	}
+
+	app.domainListLock.RLock()
+	if app.domains[host] == nil {
+		host = ""
+	}
+	app.domainListLock.RUnlock()
+
+	app.requestLimitsMu.RLock()
+	defer app.requestLimitsMu.RUnlock()
+
+	segments := routeSegments(path)
+	best := -1
+	for _, rule := range app.requestLimits {
+		if rule.host != host || rule.method != "" && rule.method != method || !rule.match(segments) {
+			continue
+		}
+		score := len(rule.segments)
+		if !rule.prefix {
+			score += len(segments) + 1
+		}
+		if score > best {
+			limits, best = rule.limits, score
+		}
+	}
+	return limits, best >= 0
+}
+
+// match reports if the path segments match the route or prefix segments.
+// :name matches any segment, *name matches the rest of the path.
+func (rule *requestLimitRule) match(segments []string) bool {
+	for i, s := range rule.segments {
+		if strings.HasPrefix(s, "*") {
+			return true
+		}
+		if i >= len(segments) || !strings.HasPrefix(s, ":") && s != segments[i] {
+			return false
+		}
+	}
+	return rule.prefix || len(segments) == len(rule.segments)
+}
+
+func routeSegments(route string) []string {
+	route = strings.Trim(route, "/")
+	if route == "" {
+		return nil
+	}
+	return strings.Split(route, "/")
+}
+
+// headerReceived applies route limits to the request before its body is read, see fasthttp.Server.HeaderReceived.
+// Domain limits match the host of the request URI, see Router.Limit.
+func (app *App) headerReceived(next func(*fasthttp.RequestHeader) fasthttp.RequestConfig) func(*fasthttp.RequestHeader) fasthttp.RequestConfig {
+	return func(header *fasthttp.RequestHeader) (cfg fasthttp.RequestConfig) {
+		if next != nil {
+			cfg = next(header)
+		}
+		if len(app.requestLimits) == 0 {
+			return cfg
+		}
+
+		// the same URI as fasthttp.RequestCtx.URI, that the domain dispatch uses
+		uri := fasthttp.AcquireURI()
+		defer fasthttp.ReleaseURI(uri)
+		if err := uri.Parse(header.Host(), header.RequestURI()); err != nil {
+			return cfg
+		}
+		limits, found := app.requestLimitsFor(string(uri.Host()), string(header.Method()), string(uri.Path()))
+		if !found {
+			return cfg
+		}
+		if limits.MaxBodySize > 0 {
+			cfg.MaxRequestBodySize = limits.MaxBodySize
+		}
+		if limits.ReadTimeout > 0 {
+			cfg.ReadTimeout = limits.ReadTimeout
+		}
+		return cfg
+	}
+}
+
+// serverErrorHandler answers requests, that the server failed to read, with 413 Request Entity Too Large
+// if their body exceeds the limit, unless the server has its own error handler
+func serverErrorHandler(next func(*fasthttp.RequestCtx, error)) func(*fasthttp.RequestCtx, error) {
+	if next != nil {
+		return next
+	}
+	return func(ctx *fasthttp.RequestCtx, err error) {
+		if err == fasthttp.ErrBodyTooLarge {
+			ctx.Error(fasthttp.StatusMessage(fasthttp.StatusRequestEntityTooLarge), fasthttp.StatusRequestEntityTooLarge)
+			return
+		}
+		// the same as the fasthttp default error handler
+		if _, ok := err.(*fasthttp.ErrSmallBuffer); ok {
+			ctx.Error("Too big request header", fasthttp.StatusRequestHeaderFieldsTooLarge)
+			return
+		}
+		if netErr, ok := err.(*net.OpError); ok && netErr.Timeout() {
+			ctx.Error("Request timeout", fasthttp.StatusRequestTimeout)
+			return
+		}
+		ctx.Error("Error when parsing request", fasthttp.StatusBadRequest)
+	}
+}
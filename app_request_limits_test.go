@@ -0,0 +1,163 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func testServeLimits(t *testing.T, app *App) *fasthttp.Client {
+	t.Helper()
+	ln := fasthttputil.NewInmemoryListener()
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	go func() {
+		_ = app.Serve(ln)
+	}()
+	return &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+}
+
+func testPostBody(t *testing.T, c *fasthttp.Client, uri string, body []byte, headers ...string) *fasthttp.Response {
+	t.Helper()
+	req, res := testBuildReqRes(POST, "http://test.request"+uri)
+	defer fasthttp.ReleaseRequest(req)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	req.UseHostHeader = true
+	req.SetBody(body)
+	if err := c.Do(req, res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestRequestLimitsFor(t *testing.T) {
+	app := New()
+	app.Limit(MethodPOST, "/upload/:id", RequestLimits{MaxBodySize: 1})
+	app.Limit("", "/files/*path", RequestLimits{MaxBodySize: 2})
+	ingest := app.Sub("/ingest").LimitAll(RequestLimits{MaxBodySize: 3})
+	ingest.Sub("/bulk").LimitAll(RequestLimits{MaxBodySize: 4})
+	ingest.Limit(MethodPUT, "/events", RequestLimits{MaxBodySize: 5})
+	app.Domain("example.com").Limit(MethodPOST, "/upload/:id", RequestLimits{MaxBodySize: 6})
+
+	cases := []struct {
+		host, method, path string
+		expected           int
+	}{
+		{"", MethodPOST, "/upload/42", 1},
+		{"", MethodPOST, "/upload/42/", 1},
+		{"", MethodGET, "/upload/42", 0},
+		{"", MethodPOST, "/upload", 0},
+		{"", MethodPOST, "/upload/42/more", 0},
+		{"", MethodDELETE, "/files/a/b/c", 2},
+		{"", MethodPOST, "/ingest", 3},
+		{"", MethodPOST, "/ingest/logs", 3},
+		{"", MethodPOST, "/ingest/bulk/logs", 4},
+		{"", MethodPUT, "/ingest/events", 5},
+		{"", MethodPOST, "/ingest/events", 3},
+		{"", MethodPOST, "/ingestion", 0},
+		{"example.com", MethodPOST, "/upload/42", 6},
+		// the domain dispatch keeps the port, so the request is not served by the domain router
+		{"example.com:8080", MethodPOST, "/upload/42", 1},
+		{"example.com", MethodPOST, "/ingest", 0},
+	}
+	for _, c := range cases {
+		limits, found := app.requestLimitsFor(c.host, c.method, c.path)
+		if limits.MaxBodySize != c.expected || found != (c.expected > 0) {
+			t.Errorf("%s %s%s: expected limit %d, got %d", c.method, c.host, c.path, c.expected, limits.MaxBodySize)
+		}
+	}
+}
+
+func TestRequestLimits(t *testing.T) {
+	app := New(OptMaxRequestBodySize(1024))
+	handler := func(ctx *Context) {
+		ctx.WriteString(BytesToString(ctx.PostBody()))
+	}
+	app.POST("/api", handler)
+	app.Sub("/ingest").
+		LimitAll(RequestLimits{MaxBodySize: 16 << 10}).
+		POST("/events", handler).
+		Limit(MethodPOST, "/small", RequestLimits{MaxBodySize: 16}).
+		POST("/small", handler)
+	c := testServeLimits(t, app)
+
+	body := []byte(strings.Repeat("x", 4096))
+	if res := testPostBody(t, c, "/api", body); res.StatusCode() != fasthttp.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for the body greater than the server limit, got %d", res.StatusCode())
+	}
+	if res := testPostBody(t, c, "/ingest/events", body); res.StatusCode() != fasthttp.StatusOK || len(res.Body()) != len(body) {
+		t.Errorf("expected the body to be accepted by the SubRouter limit, got %d", res.StatusCode())
+	}
+	if res := testPostBody(t, c, "/ingest/small", body[:17]); res.StatusCode() != fasthttp.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for the body greater than the route limit, got %d", res.StatusCode())
+	}
+	if res := testPostBody(t, c, "/ingest/small", body[:16]); res.StatusCode() != fasthttp.StatusOK {
+		t.Errorf("expected the body within the route limit to be accepted, got %d", res.StatusCode())
+	}
+}
+
+// testForwardedHost is a Behind, that trusts X-Forwarded-Host of any client
+type testForwardedHost struct{}
+
+func (testForwardedHost) RemoteIP(ctx *Context) net.IP     { return ctx.RequestCtx.RemoteIP() }
+func (testForwardedHost) RemoteAddr(ctx *Context) net.Addr { return ctx.RequestCtx.RemoteAddr() }
+func (testForwardedHost) Scheme(ctx *Context) string       { return "" }
+func (testForwardedHost) Port(ctx *Context) int            { return 0 }
+func (testForwardedHost) Host(ctx *Context) string {
+	return string(ctx.Request.Header.Peek("X-Forwarded-Host"))
+}
+
+func TestRequestLimitsBehind(t *testing.T) {
+	app := New(OptMaxRequestBodySize(1024))
+	app.Behind(testForwardedHost{})
+	if err := app.UseRequestDecompression(DecompressionMaxSize(1024)); err != nil {
+		t.Fatal(err)
+	}
+	app.Domain("example.com").
+		POST("/upload", func(ctx *Context) {
+			ctx.WriteString(BytesToString(ctx.PostBody()))
+		}).
+		Limit(MethodPOST, "/upload", RequestLimits{MaxBodySize: 16 << 10, MaxDecompressedSize: 16 << 10})
+	c := testServeLimits(t, app)
+
+	body := []byte(strings.Repeat("x", 4096))
+	gzipped := fasthttp.AppendGzipBytes(nil, body)
+	if res := testPostBody(t, c, "/upload", body, "Host", "example.com"); res.StatusCode() != fasthttp.StatusOK || len(res.Body()) != len(body) {
+		t.Errorf("expected the domain limit to allow the body, got %d", res.StatusCode())
+	}
+	if res := testPostBody(t, c, "/upload", gzipped, "Host", "example.com", fasthttp.HeaderContentEncoding, "gzip"); res.StatusCode() != fasthttp.StatusOK || len(res.Body()) != len(body) {
+		t.Errorf("expected the domain limit to allow the decompressed body, got %d", res.StatusCode())
+	}
+
+	// the request is routed by the forwarded host, but domain limits match the Host header
+	forwarded := []string{"Host", "upstream.local", "X-Forwarded-Host", "example.com"}
+	if res := testPostBody(t, c, "/upload", body[:512], forwarded...); res.StatusCode() != fasthttp.StatusOK || len(res.Body()) != 512 {
+		t.Errorf("expected the request to be routed by the forwarded host, got %d", res.StatusCode())
+	}
+	if res := testPostBody(t, c, "/upload", body, forwarded...); res.StatusCode() != fasthttp.StatusRequestEntityTooLarge {
+		t.Errorf("expected the server limit for the forwarded host, got %d", res.StatusCode())
+	}
+	if res := testPostBody(t, c, "/upload", gzipped, append(forwarded, fasthttp.HeaderContentEncoding, "gzip")...); res.StatusCode() != fasthttp.StatusRequestEntityTooLarge {
+		t.Errorf("expected the default decompressed limit for the forwarded host, got %d", res.StatusCode())
+	}
+}
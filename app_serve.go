@@ -12,21 +12,44 @@ package gramework
 
 import (
 	"net"
+
+	"github.com/valyala/fasthttp"
 )
 
 // Serve app on given listener
 func (app *App) Serve(ln net.Listener) error {
 	var err error
 	srv := app.copyServer()
+	app.addRunningServer(ln.Addr().String(), srv, ln)
+	if err = srv.Serve(app.wrapListener(ln)); err != nil {
+		app.internalLog.Errorf("ListenAndServe failed: %s", err)
+	}
+
+	return err
+}
+
+// addRunningServer registers the server, that is about to accept connections on the bound listener,
+// and marks the app as started and ready, see App.IsStarted and App.IsReady.
+// If the listener is inherited from the parent process, the parent is notified, see App.Upgrade.
+func (app *App) addRunningServer(bind string, srv *fasthttp.Server, ln net.Listener) {
 	app.runningServersMu.Lock()
 	app.runningServers = append(app.runningServers, runningServerInfo{
-		bind: ln.Addr().String(),
+		bind: bind,
 		srv:  srv,
+		ln:   ln,
 	})
 	app.runningServersMu.Unlock()
-	if err = srv.Serve(ln); err != nil {
-		app.internalLog.Errorf("ListenAndServe failed: %s", err)
+
+	app.SetStarted(true)
+	app.SetReady(true)
+	inheritedListenerServed(ln)
+}
+
+// wrapListener applies listener wrappers, registered with OptListenerWrapper
+func (app *App) wrapListener(ln net.Listener) net.Listener {
+	for _, wrapper := range app.listenerWrappers {
+		ln = wrapper(ln)
 	}
 
-	return err
+	return ln
 }
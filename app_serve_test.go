@@ -90,3 +90,46 @@ func TestAppServe(t *testing.T) {
 		}
 	}
 }
+
+type testCountingListener struct {
+	net.Listener
+	accepted int
+}
+
+func (l *testCountingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted++
+	}
+	return c, err
+}
+
+func TestAppServeListenerWrapper(t *testing.T) {
+	var wrapped *testCountingListener
+	app := New(OptListenerWrapper(func(ln net.Listener) net.Listener {
+		wrapped = &testCountingListener{Listener: ln}
+		return wrapped
+	}))
+	app.GET("/", "ok")
+
+	ln := fasthttputil.NewInmemoryListener()
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+
+	go func() {
+		_ = app.Serve(ln)
+	}()
+
+	req, res := testBuildReqRes(GET, "http://test.request")
+	if err := c.Do(req, res); err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+
+	if wrapped == nil || wrapped.accepted != 1 {
+		t.Errorf("listener wrapper was not applied")
+	}
+}
@@ -1,28 +1,66 @@
 package gramework
 
+import (
+	"context"
+	"sync"
+)
+
 // Shutdown gracefully shuts down application servers
 func (app *App) Shutdown() (err error) {
+	return app.ShutdownContext(context.Background())
+}
+
+// ShutdownContext gracefully shuts down application servers, waiting
+// for in-flight requests to complete until ctx is done.
+// Servers that were not drained in time are kept in the running list.
+func (app *App) ShutdownContext(ctx context.Context) error {
 	app.runningServersMu.Lock()
 	// this is not a hot path, we can freely use defer here
 	defer app.runningServersMu.Unlock()
 
-	newRunningList := []runningServerInfo{}
+	var (
+		errs           MultiError
+		errsMu         sync.Mutex
+		wg             sync.WaitGroup
+		newRunningList = []runningServerInfo{}
+	)
 	for _, info := range app.runningServers {
 		app.internalLog.WithField("bind", info.bind).Warn("shutting down server")
-		err = info.srv.Shutdown()
-		if err != nil {
-			app.internalLog.WithError(err).Error("could not shutdown server")
+
+		done := make(chan error, 1)
+		go func(info runningServerInfo) {
+			done <- info.srv.Shutdown()
+		}(info)
+
+		wg.Add(1)
+		go func(info runningServerInfo) {
+			defer wg.Done()
+
+			var err error
+			select {
+			case err = <-done:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			if err == nil {
+				return
+			}
+
+			app.internalLog.WithError(err).WithField("bind", info.bind).Error("could not shutdown server")
+			errsMu.Lock()
+			errs = append(errs, err)
 			newRunningList = append(newRunningList, info)
-			continue
-		}
+			errsMu.Unlock()
+		}(info)
 	}
+	wg.Wait()
 
 	app.runningServers = newRunningList
 
-	if err == nil {
+	if len(errs) == 0 {
 		app.internalLog.Warn("application servers shutted down successfully")
-		return
+		return nil
 	}
-	app.internalLog.WithError(err).WithField("stillRunning", len(app.runningServers)).Warn("could not stop servers")
-	return
+	app.internalLog.WithError(errs).WithField("stillRunning", len(app.runningServers)).Warn("could not stop servers")
+	return errs.Err()
 }
@@ -0,0 +1,252 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+//go:build !windows
+// +build !windows
+
+package gramework
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	envSystemdListenPID     = "LISTEN_PID"
+	envSystemdListenFDs     = "LISTEN_FDS"
+	envSystemdListenFDNames = "LISTEN_FDNAMES"
+)
+
+// ErrNoListenersToUpgrade occurs when App.Upgrade called, but the app serves nothing
+var ErrNoListenersToUpgrade = errors.New("upgrade: no listeners to pass to the new process")
+
+// Upgrade starts a new process of the current executable with the same
+// arguments, and passes all listeners of the running servers to it.
+// The new process picks them up in ListenAndServe, ListenAndServeAutoTLS
+// and App.Listen, so it starts accepting connections immediately.
+//
+// Upgrade returns as soon as the new process serves all inherited listeners.
+// If it exits or does not serve them within the upgrade timeout (see OptUpgradeTimeout),
+// the new process is killed and an error is returned, so the current process should keep serving.
+//
+// After a successful upgrade, the current process should drain its
+// servers with Shutdown or ShutdownContext. Run does it automatically
+// when the signal set by OptUpgradeSignal is received.
+func (app *App) Upgrade() (*os.Process, error) {
+	app.runningServersMu.Lock()
+	var (
+		files   []*os.File
+		names   []string
+		unixLns []*net.UnixListener
+	)
+	for _, info := range app.runningServers {
+		if info.ln == nil {
+			continue
+		}
+		if unixLn, ok := info.ln.(*net.UnixListener); ok {
+			unixLns = append(unixLns, unixLn)
+		}
+		filer, ok := info.ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			continue
+		}
+		f, err := filer.File()
+		if err != nil {
+			app.runningServersMu.Unlock()
+			closeFiles(files)
+			return nil, fmt.Errorf("upgrade: could not get listener file for %q: %s", info.bind, err)
+		}
+		files = append(files, f)
+		names = append(names, info.bind)
+	}
+	app.runningServersMu.Unlock()
+	defer closeFiles(files)
+
+	if len(files) == 0 {
+		return nil, ErrNoListenersToUpgrade
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	// the new process writes to the pipe as soon as it serves all inherited listeners
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer ready.Close()
+
+	env := make([]string, 0, len(os.Environ())+3)
+	for _, kv := range os.Environ() {
+		switch strings.SplitN(kv, "=", 2)[0] {
+		case EnvListenFDs, EnvListenFDNames, EnvUpgradeReadyFD, envSystemdListenPID, envSystemdListenFDs, envSystemdListenFDNames:
+			continue
+		}
+		env = append(env, kv)
+	}
+	env = append(env,
+		EnvListenFDs+"="+strconv.Itoa(len(files)),
+		EnvListenFDNames+"="+strings.Join(names, ","),
+		EnvUpgradeReadyFD+"="+strconv.Itoa(listenFDsStart+len(files)),
+	)
+
+	process, err := os.StartProcess(exe, os.Args, &os.ProcAttr{
+		Dir:   wd,
+		Env:   env,
+		Files: append(append([]*os.File{os.Stdin, os.Stdout, os.Stderr}, files...), readyW),
+	})
+	// the pipe must be closed on our side, so the read fails as soon as the new process exits
+	_ = readyW.Close()
+	// passing files sets their descriptors to blocking mode, and the listeners share it,
+	// so the running servers could not be shut down
+	for _, f := range files {
+		_ = syscall.SetNonblock(int(f.Fd()), true)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	app.internalLog.
+		WithField("pid", process.Pid).
+		WithField("listeners", len(files)).
+		Warn("new process started, listeners passed")
+
+	if err = waitUpgradeReady(ready, app.upgradeTimeout); err != nil {
+		_ = process.Kill()
+		_, _ = process.Wait()
+		return nil, fmt.Errorf("upgrade: new process %d is not ready: %s", process.Pid, err)
+	}
+
+	// the new process serves the same socket files, so they must survive our shutdown
+	for _, unixLn := range unixLns {
+		unixLn.SetUnlinkOnClose(false)
+	}
+
+	return process, nil
+}
+
+// waitUpgradeReady waits until the new process notifies that it serves all inherited listeners
+func waitUpgradeReady(ready *os.File, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = DefaultUpgradeTimeout
+	}
+	if err := ready.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	_, err := ready.Read(make([]byte, 1))
+	switch {
+	case err == io.EOF:
+		return errors.New("process exited before serving inherited listeners")
+	case os.IsTimeout(err):
+		return fmt.Errorf("inherited listeners are not served within %s", timeout)
+	}
+	return err
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		_ = f.Close()
+	}
+}
+
+// loadInheritedListeners loads listeners passed by the parent gramework
+// process or by systemd socket activation, and the pipe the parent process
+// waits on until they are served
+func loadInheritedListeners(app *App) ([]inheritedListener, *os.File) {
+	count, names, readyFD, err := inheritedListenersEnv()
+	if err != nil {
+		app.internalLog.WithError(err).Error("could not parse inherited listeners")
+		return nil, nil
+	}
+
+	var ready *os.File
+	if readyFD >= listenFDsStart+count {
+		// the pipe must not leak to processes we start
+		syscall.CloseOnExec(readyFD)
+		ready = os.NewFile(uintptr(readyFD), "upgrade-ready")
+	}
+
+	listeners := make([]inheritedListener, 0, count)
+	for i := 0; i < count; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		ln, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			app.internalLog.WithError(err).WithField("fd", listenFDsStart+i).Error("could not use inherited listener")
+			continue
+		}
+
+		listeners = append(listeners, inheritedListener{
+			name: name,
+			ln:   ln,
+		})
+	}
+
+	return listeners, ready
+}
+
+// inheritedListenersEnv parses and clears the environment variables, that describe
+// listeners passed by the parent gramework process or by systemd socket activation.
+// readyFD is -1, unless the listeners are passed by App.Upgrade.
+func inheritedListenersEnv() (count int, names []string, readyFD int, err error) {
+	readyFD = -1
+	if raw := os.Getenv(EnvListenFDs); len(raw) > 0 {
+		names = strings.Split(os.Getenv(EnvListenFDNames), ",")
+		rawReadyFD := os.Getenv(EnvUpgradeReadyFD)
+		_ = os.Unsetenv(EnvListenFDs)
+		_ = os.Unsetenv(EnvListenFDNames)
+		_ = os.Unsetenv(EnvUpgradeReadyFD)
+
+		if count, err = strconv.Atoi(raw); err != nil {
+			return 0, nil, -1, err
+		}
+		if len(rawReadyFD) > 0 {
+			if readyFD, err = strconv.Atoi(rawReadyFD); err != nil {
+				return 0, nil, -1, err
+			}
+		}
+	} else if raw := os.Getenv(envSystemdListenFDs); len(raw) > 0 {
+		if os.Getenv(envSystemdListenPID) != strconv.Itoa(os.Getpid()) {
+			return 0, nil, -1, nil
+		}
+		names = strings.Split(os.Getenv(envSystemdListenFDNames), ":")
+		_ = os.Unsetenv(envSystemdListenPID)
+		_ = os.Unsetenv(envSystemdListenFDs)
+		_ = os.Unsetenv(envSystemdListenFDNames)
+
+		if count, err = strconv.Atoi(raw); err != nil {
+			return 0, nil, -1, err
+		}
+	}
+	if count < 0 {
+		return 0, nil, -1, fmt.Errorf("invalid inherited listeners count %d", count)
+	}
+
+	return count, names, readyFD, nil
+}
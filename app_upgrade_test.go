@@ -0,0 +1,211 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+//go:build !windows
+// +build !windows
+
+package gramework
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// envTestUpgradeChild makes the test binary act as the upgraded process, see TestAppUpgrade
+const envTestUpgradeChild = "GRAMEWORK_TEST_UPGRADE_CHILD"
+
+func TestInheritedListenersEnv(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	cases := []struct {
+		name    string
+		env     map[string]string
+		count   int
+		names   []string
+		readyFD int
+		err     bool
+	}{
+		{"none", nil, 0, nil, -1, false},
+		{"gramework", map[string]string{
+			EnvListenFDs:      "2",
+			EnvListenFDNames:  ":80,unix:/run/app.sock",
+			EnvUpgradeReadyFD: "5",
+		}, 2, []string{":80", "unix:/run/app.sock"}, 5, false},
+		{"gramework without readiness pipe", map[string]string{
+			EnvListenFDs:     "1",
+			EnvListenFDNames: ":80",
+		}, 1, []string{":80"}, -1, false},
+		{"gramework invalid count", map[string]string{EnvListenFDs: "two"}, 0, nil, -1, true},
+		{"gramework negative count", map[string]string{EnvListenFDs: "-1"}, 0, nil, -1, true},
+		{"gramework invalid readiness pipe", map[string]string{EnvListenFDs: "1", EnvUpgradeReadyFD: "pipe"}, 0, nil, -1, true},
+		{"systemd", map[string]string{
+			envSystemdListenPID:     pid,
+			envSystemdListenFDs:     "2",
+			envSystemdListenFDNames: "http:https",
+		}, 2, []string{"http", "https"}, -1, false},
+		{"systemd for another process", map[string]string{
+			envSystemdListenPID: "1",
+			envSystemdListenFDs: "2",
+		}, 0, nil, -1, false},
+		{"systemd invalid count", map[string]string{
+			envSystemdListenPID: pid,
+			envSystemdListenFDs: "many",
+		}, 0, nil, -1, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for _, key := range []string{EnvListenFDs, EnvListenFDNames, EnvUpgradeReadyFD, envSystemdListenPID, envSystemdListenFDs, envSystemdListenFDNames} {
+				t.Setenv(key, c.env[key])
+			}
+
+			count, names, readyFD, err := inheritedListenersEnv()
+			if (err != nil) != c.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if count != c.count || readyFD != c.readyFD || len(names) != len(c.names) {
+				t.Fatalf("expected %d listeners %q with readiness pipe %d, got %d %q %d", c.count, c.names, c.readyFD, count, names, readyFD)
+			}
+			for i := range names {
+				if names[i] != c.names[i] {
+					t.Fatalf("expected names %q, got %q", c.names, names)
+				}
+			}
+			if c.count > 0 && (os.Getenv(EnvListenFDs) != "" || os.Getenv(envSystemdListenFDs) != "") {
+				t.Error("expected the environment to be cleared")
+			}
+		})
+	}
+}
+
+func TestAppUpgrade(t *testing.T) {
+	if mode := os.Getenv(envTestUpgradeChild); len(mode) > 0 {
+		testUpgradeChild(mode)
+		return
+	}
+
+	app := New()
+	app.GET("/", "parent")
+	ln, err := app.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = app.Serve(ln)
+	}()
+	defer app.Shutdown()
+	addr := ln.Addr().String()
+	for i := 0; i < 50 && !app.IsReady(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the new process must run this test only
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestAppUpgrade$"}
+	defer func() {
+		os.Args = args
+	}()
+
+	for mode, timeout := range map[string]time.Duration{"exit": 10 * time.Second, "hang": 500 * time.Millisecond} {
+		t.Setenv(envTestUpgradeChild, mode)
+		app.upgradeTimeout = timeout
+		started := time.Now()
+		if process, err := app.Upgrade(); err == nil {
+			_ = process.Kill()
+			t.Fatalf("%s: expected the upgrade to fail", mode)
+		}
+		if mode == "exit" && time.Since(started) >= timeout {
+			t.Errorf("%s: the upgrade should fail without waiting for the timeout", mode)
+		}
+		if code, body, err := fasthttp.Get(nil, "http://"+addr); err != nil || code != fasthttp.StatusOK || string(body) != "parent" {
+			t.Fatalf("%s: expected the parent process to keep serving, got %d %q %v", mode, code, body, err)
+		}
+	}
+
+	t.Setenv(envTestUpgradeChild, "serve")
+	app.upgradeTimeout = 10 * time.Second
+	process, err := app.Upgrade()
+	if err != nil {
+		t.Fatalf("unexpected upgrade error: %s", err)
+	}
+	defer func() {
+		_ = process.Kill()
+		_, _ = process.Wait()
+	}()
+
+	// the parent process stops accepting, so the new one must serve all requests
+	if err = app.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		code, body, err := fasthttp.Get(nil, "http://"+addr)
+		if err != nil || code != fasthttp.StatusOK || string(body) != "child" {
+			t.Fatalf("expected the new process to serve, got %d %q %v", code, body, err)
+		}
+	}
+}
+
+func TestAppUpgradeFailureUnixSocket(t *testing.T) {
+	app := New()
+	sock := filepath.Join(t.TempDir(), "app.sock")
+	ln, err := app.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = app.Serve(ln)
+	}()
+	for i := 0; i < 50 && !app.IsReady(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestAppUpgrade$"}
+	defer func() {
+		os.Args = args
+	}()
+	t.Setenv(envTestUpgradeChild, "exit")
+	if process, err := app.Upgrade(); err == nil {
+		_ = process.Kill()
+		t.Fatal("expected the upgrade to fail")
+	}
+
+	// the parent process keeps serving the socket, so it removes the socket file on shutdown
+	if err = app.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("socket file should be removed after shutdown: %v", err)
+	}
+}
+
+// testUpgradeChild acts as the new process, started by App.Upgrade
+func testUpgradeChild(mode string) {
+	switch mode {
+	case "exit":
+		os.Exit(0)
+	case "hang":
+		time.Sleep(time.Minute)
+		os.Exit(0)
+	}
+
+	app := New()
+	app.GET("/", "child")
+	go func() {
+		_ = app.ListenAndServe(os.Getenv(EnvListenFDNames))
+		os.Exit(1)
+	}()
+	time.Sleep(time.Minute)
+	os.Exit(0)
+}
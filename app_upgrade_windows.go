@@ -0,0 +1,28 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrNoListenersToUpgrade occurs when App.Upgrade called, but the app serves nothing
+var ErrNoListenersToUpgrade = errors.New("upgrade: no listeners to pass to the new process")
+
+// Upgrade is not supported on Windows
+func (app *App) Upgrade() (*os.Process, error) {
+	return nil, errors.New("upgrade: listener inheritance is not supported on windows")
+}
+
+func loadInheritedListeners(app *App) ([]inheritedListener, *os.File) {
+	return nil, nil
+}
@@ -2,6 +2,7 @@ package gramework
 
 import (
 	"net"
+	"strconv"
 )
 
 // Behind is an interface that allow you to parse provider-dependent
@@ -32,6 +33,84 @@ func (ctx *Context) RemoteAddr() net.Addr {
 	return ctx.RequestCtx.RemoteAddr()
 }
 
+// BehindForwarded is an optional interface that a Behind implementation
+// may satisfy to resolve the scheme, host and port of the original request,
+// e.g. from X-Forwarded-Proto/X-Forwarded-Host or Forwarded headers.
+//
+// Empty scheme or host and zero port mean that the value is unknown
+// and the one from the connection should be used instead.
+type BehindForwarded interface {
+	Scheme(ctx *Context) string
+	Host(ctx *Context) string
+	Port(ctx *Context) int
+}
+
+// Scheme returns the scheme of the original request, "http" or "https".
+// If the app runs behind a proxy that implements BehindForwarded,
+// the scheme reported by the proxy is returned.
+func (ctx *Context) Scheme() string {
+	if fwd, ok := ctx.behindForwarded(); ok {
+		if scheme := fwd.Scheme(ctx); len(scheme) > 0 {
+			return scheme
+		}
+	}
+
+	if ctx.RequestCtx.IsTLS() {
+		return https
+	}
+	return httpScheme
+}
+
+// IsTLS returns true if the original request was sent over TLS,
+// either directly or to a TLS-terminating proxy.
+func (ctx *Context) IsTLS() bool {
+	return ctx.Scheme() == https
+}
+
+// Host returns the requested host of the original request.
+// If the app runs behind a proxy that implements BehindForwarded,
+// the host reported by the proxy is returned.
+func (ctx *Context) Host() []byte {
+	if fwd, ok := ctx.behindForwarded(); ok {
+		if host := fwd.Host(ctx); len(host) > 0 {
+			return []byte(host)
+		}
+	}
+
+	return ctx.RequestCtx.Host()
+}
+
+// Port returns the port of the original request.
+// If no port reported by the proxy nor present in the host,
+// the default port of the scheme is returned.
+func (ctx *Context) Port() int {
+	if fwd, ok := ctx.behindForwarded(); ok {
+		if port := fwd.Port(ctx); port > 0 {
+			return port
+		}
+	}
+
+	if _, port, err := net.SplitHostPort(string(ctx.Host())); err == nil {
+		if p, err := strconv.Atoi(port); err == nil && p > 0 {
+			return p
+		}
+	}
+
+	if ctx.IsTLS() {
+		return 443
+	}
+	return 80
+}
+
+func (ctx *Context) behindForwarded() (BehindForwarded, bool) {
+	if ctx.App == nil {
+		return nil, false
+	}
+
+	fwd, ok := ctx.App.behind.(BehindForwarded)
+	return fwd, ok
+}
+
 type internalBehindActivationHook interface {
 	OnAppActivation(*App)
 }
@@ -0,0 +1,234 @@
+// Package cloudflare provides a gramework.Behind implementation
+// developed for Gramework.
+// This is not an official Cloudflare-supported implementation.
+// If you having any issues with this package, please
+// consider to contact Gramework support first.
+//
+// Cloudflare is a trademark of Cloudflare, Inc.
+//
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+package cloudflare
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gramework/gramework"
+	"github.com/gramework/gramework/behind/internal/trustedproxy"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// DefaultCloudflareIPHeader is the header Cloudflare puts the client IP in
+	DefaultCloudflareIPHeader = "CF-Connecting-IP"
+	// ForwardedProtoHeader is the header Cloudflare puts the client scheme in
+	ForwardedProtoHeader = "X-Forwarded-Proto"
+
+	// IPsV4URL is the official list of Cloudflare IPv4 ranges
+	IPsV4URL = "https://www.cloudflare.com/ips-v4"
+	// IPsV6URL is the official list of Cloudflare IPv6 ranges
+	IPsV6URL = "https://www.cloudflare.com/ips-v6"
+)
+
+// DefaultCIDRs is a snapshot of the published Cloudflare ranges.
+// Use FetchCIDRBlocks to load the fresh list on startup.
+var DefaultCIDRs = []string{
+	"173.245.48.0/20",
+	"103.21.244.0/22",
+	"103.22.200.0/22",
+	"103.31.4.0/22",
+	"141.101.64.0/18",
+	"108.162.192.0/18",
+	"190.93.240.0/20",
+	"188.114.96.0/20",
+	"197.234.240.0/22",
+	"198.41.128.0/17",
+	"162.158.0.0/15",
+	"104.16.0.0/13",
+	"104.24.0.0/14",
+	"172.64.0.0/13",
+	"131.0.72.0/22",
+	"2400:cb00::/32",
+	"2606:4700::/32",
+	"2803:f800::/32",
+	"2405:b500::/32",
+	"2405:8100::/32",
+	"2a06:98c0::/29",
+	"2c0f:f248::/32",
+}
+
+// Option configures the Unwrapper
+type Option func(u *Unwrapper)
+
+// Unwrapper resolves the client address using CF-Connecting-IP header,
+// if the request came from the Cloudflare network.
+type Unwrapper struct {
+	ipHeader     string
+	trustedCIDR  []*net.IPNet
+	disableCache bool
+
+	matcher *trustedproxy.Matcher
+}
+
+// New creates an unwrapper, optimized for Cloudflare network.
+// If no CIDRBlocks option is given, DefaultCIDRs are used.
+func New(opts ...Option) *Unwrapper {
+	u := &Unwrapper{
+		ipHeader: DefaultCloudflareIPHeader,
+	}
+
+	for _, opt := range opts {
+		opt(u)
+	}
+
+	if u.trustedCIDR == nil {
+		u.trustedCIDR, _ = trustedproxy.ParseCIDRs(DefaultCIDRs...)
+	}
+	u.matcher = trustedproxy.NewMatcher(u.trustedCIDR, u.disableCache)
+
+	return u
+}
+
+// OnAppActivation logs the unwrapper configuration
+func (u *Unwrapper) OnAppActivation(app *gramework.App) {
+	log := app.Logger.WithField("package", "gramework/behind/cloudflare")
+	log.Info("Gramework is running behind Cloudflare.")
+	log = log.WithField("CIDRs", u.matcher.Len())
+	if u.matcher.CacheEnabled() {
+		log = log.WithField("cache", "enabled")
+	} else {
+		log = log.WithField("cache", "disabled")
+	}
+	log.Info("Activated")
+}
+
+// DisableCache disables Cloudflare CIDRs lookup cache
+func DisableCache() Option {
+	return func(u *Unwrapper) {
+		u.disableCache = true
+	}
+}
+
+// CIDRBlocks replaces the default Cloudflare CIDR blocks
+func CIDRBlocks(blocks []*net.IPNet) Option {
+	return func(u *Unwrapper) {
+		u.trustedCIDR = blocks
+	}
+}
+
+// IPHeader sets the header that contains the client IP
+func IPHeader(name string) Option {
+	return func(u *Unwrapper) {
+		u.ipHeader = name
+	}
+}
+
+// ParseCIDRBlocks parses a newline-separated list of CIDR blocks,
+// in the format of https://www.cloudflare.com/ips-v4.
+// Empty lines and lines starting with # are skipped.
+func ParseCIDRBlocks(list []byte) ([]*net.IPNet, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(list))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return trustedproxy.ParseCIDRs(lines...)
+}
+
+// FetchCIDRBlocks downloads and parses CIDR block lists.
+// If no urls given, IPsV4URL and IPsV6URL are used.
+func FetchCIDRBlocks(timeout time.Duration, urls ...string) ([]*net.IPNet, error) {
+	if len(urls) == 0 {
+		urls = []string{IPsV4URL, IPsV6URL}
+	}
+
+	var blocks []*net.IPNet
+	for _, url := range urls {
+		statusCode, body, err := fasthttp.GetTimeout(nil, url, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if statusCode != fasthttp.StatusOK {
+			return nil, fmt.Errorf("cloudflare: unexpected status code %d for %s", statusCode, url)
+		}
+
+		parsed, err := ParseCIDRBlocks(body)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, parsed...)
+	}
+
+	return blocks, nil
+}
+
+// RemoteIP returns the client IP
+func (u *Unwrapper) RemoteIP(ctx *gramework.Context) net.IP {
+	rIP := ctx.RequestCtx.RemoteIP()
+	if !u.matcher.Contains(rIP) {
+		return rIP
+	}
+
+	ip := net.ParseIP(string(ctx.Request.Header.Peek(u.ipHeader)))
+	if len(ip) == 0 {
+		return rIP
+	}
+
+	return ip
+}
+
+// RemoteAddr returns the client address
+func (u *Unwrapper) RemoteAddr(ctx *gramework.Context) net.Addr {
+	orig := ctx.RequestCtx.RemoteAddr()
+	rIP := ctx.RequestCtx.RemoteIP()
+	ip := u.RemoteIP(ctx)
+	if ip == nil || ip.Equal(rIP) {
+		return orig
+	}
+
+	return trustedproxy.Addr(orig, ip)
+}
+
+// Scheme returns the scheme of the request to Cloudflare edge
+func (u *Unwrapper) Scheme(ctx *gramework.Context) string {
+	if !u.matcher.Contains(ctx.RequestCtx.RemoteIP()) {
+		return ""
+	}
+
+	proto := strings.ToLower(trustedproxy.LastValue(ctx.Request.Header.Peek(ForwardedProtoHeader)))
+	if proto != "http" && proto != "https" {
+		return ""
+	}
+
+	return proto
+}
+
+// Host returns an empty string, since Cloudflare keeps the original Host header
+func (u *Unwrapper) Host(ctx *gramework.Context) string {
+	return ""
+}
+
+// Port returns zero, since Cloudflare does not report the original port
+func (u *Unwrapper) Port(ctx *gramework.Context) int {
+	return 0
+}
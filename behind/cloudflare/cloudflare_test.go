@@ -0,0 +1,61 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package cloudflare
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gramework/gramework"
+	"github.com/valyala/fasthttp"
+)
+
+const ipsList = `173.245.48.0/20
+# comment
+
+2400:cb00::/32
+`
+
+func TestParseCIDRBlocks(t *testing.T) {
+	blocks, err := ParseCIDRBlocks([]byte(ipsList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("unexpected blocks count: %d", len(blocks))
+	}
+	if blocks[0].String() != "173.245.48.0/20" || blocks[1].String() != "2400:cb00::/32" {
+		t.Errorf("unexpected blocks: %v", blocks)
+	}
+
+	if _, err = ParseCIDRBlocks([]byte("not a cidr/8")); err == nil {
+		t.Error("expected error on invalid list")
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	u := New()
+
+	req := &fasthttp.Request{}
+	req.Header.Set(DefaultCloudflareIPHeader, "203.0.113.5")
+	fhctx := &fasthttp.RequestCtx{}
+	ctx := &gramework.Context{RequestCtx: fhctx}
+
+	fhctx.Init(req, &net.TCPAddr{IP: net.ParseIP("173.245.48.10")}, nil)
+	if ip := u.RemoteIP(ctx); ip.String() != "203.0.113.5" {
+		t.Errorf("unexpected RemoteIP: %q", ip)
+	}
+
+	fhctx.Init(req, &net.TCPAddr{IP: net.ParseIP("198.51.100.7")}, nil)
+	if ip := u.RemoteIP(ctx); ip.String() != "198.51.100.7" {
+		t.Errorf("unexpected RemoteIP for non-Cloudflare peer: %q", ip)
+	}
+}
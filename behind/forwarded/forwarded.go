@@ -0,0 +1,190 @@
+// Package forwarded provides a gramework.Behind implementation
+// for proxies that use the standard Forwarded header (RFC 7239).
+//
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+package forwarded
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gramework/gramework"
+	"github.com/gramework/gramework/behind/internal/trustedproxy"
+)
+
+// HeaderName is the RFC 7239 header name
+const HeaderName = "Forwarded"
+
+// Option configures the Unwrapper
+type Option func(u *Unwrapper)
+
+// Unwrapper resolves the client address using the Forwarded header,
+// sent by trusted proxies.
+//
+// Elements are walked right-to-left: each element which "for" node
+// belongs to the trusted CIDR blocks is skipped, and the first untrusted
+// node is the client. The header is ignored completely if the connection
+// peer is not trusted.
+//
+// Scheme and host are taken from the same element as the client address,
+// i.e. from the element added by the outermost trusted proxy.
+type Unwrapper struct {
+	trustedCIDR  []*net.IPNet
+	disableCache bool
+
+	matcher *trustedproxy.Matcher
+}
+
+// New creates an unwrapper for RFC 7239-compatible proxies
+func New(opts ...Option) *Unwrapper {
+	u := &Unwrapper{}
+
+	for _, opt := range opts {
+		opt(u)
+	}
+
+	u.matcher = trustedproxy.NewMatcher(u.trustedCIDR, u.disableCache)
+
+	return u
+}
+
+// OnAppActivation logs the unwrapper configuration
+func (u *Unwrapper) OnAppActivation(app *gramework.App) {
+	log := app.Logger.WithField("package", "gramework/behind/forwarded")
+	log.Info("Gramework is running behind RFC 7239-compatible proxy.")
+	log = log.WithField("CIDRs", u.matcher.Len())
+	if u.matcher.CacheEnabled() {
+		log = log.WithField("cache", "enabled")
+	} else {
+		log = log.WithField("cache", "disabled")
+	}
+	log.Info("Activated")
+}
+
+// DisableCache disables trusted proxies lookup cache
+func DisableCache() Option {
+	return func(u *Unwrapper) {
+		u.disableCache = true
+	}
+}
+
+// CIDRBlocks sets trusted proxies CIDR blocks
+func CIDRBlocks(blocks []*net.IPNet) Option {
+	return func(u *Unwrapper) {
+		u.trustedCIDR = append(u.trustedCIDR, blocks...)
+	}
+}
+
+// TrustedProxies parses and sets trusted proxies CIDR blocks or IPs.
+// It panics if any of the given values is invalid.
+func TrustedProxies(cidrs ...string) Option {
+	blocks, err := trustedproxy.ParseCIDRs(cidrs...)
+	if err != nil {
+		panic(err)
+	}
+
+	return CIDRBlocks(blocks)
+}
+
+// Elements returns parsed Forwarded header elements of the request.
+// Malformed header is treated as absent.
+func Elements(ctx *gramework.Context) []Element {
+	var values []string
+	ctx.Request.Header.VisitAll(func(key, value []byte) {
+		if strings.EqualFold(string(key), HeaderName) {
+			values = append(values, string(value))
+		}
+	})
+	if len(values) == 0 {
+		return nil
+	}
+
+	elements, err := Parse(strings.Join(values, ","))
+	if err != nil {
+		return nil
+	}
+
+	return elements
+}
+
+// RemoteIP returns the client IP
+func (u *Unwrapper) RemoteIP(ctx *gramework.Context) net.IP {
+	rIP := ctx.RequestCtx.RemoteIP()
+	el, ok := u.clientElement(ctx)
+	if !ok {
+		return rIP
+	}
+
+	return el.ForIP()
+}
+
+// Scheme returns the original request scheme, if set by a trusted proxy
+func (u *Unwrapper) Scheme(ctx *gramework.Context) string {
+	el, ok := u.clientElement(ctx)
+	if !ok || (el.Proto != "http" && el.Proto != "https") {
+		return ""
+	}
+
+	return el.Proto
+}
+
+// Host returns the original request host, if set by a trusted proxy
+func (u *Unwrapper) Host(ctx *gramework.Context) string {
+	el, ok := u.clientElement(ctx)
+	if !ok {
+		return ""
+	}
+
+	return el.Host
+}
+
+// Port returns the original request port, if set by a trusted proxy
+func (u *Unwrapper) Port(ctx *gramework.Context) int {
+	return trustedproxy.HostPort(u.Host(ctx))
+}
+
+// clientElement returns the element that describes the client request.
+// It returns false if the request came directly from the client.
+func (u *Unwrapper) clientElement(ctx *gramework.Context) (Element, bool) {
+	rIP := ctx.RequestCtx.RemoteIP()
+	if !u.matcher.Contains(rIP) {
+		return Element{}, false
+	}
+
+	elements := Elements(ctx)
+	if len(elements) == 0 {
+		return Element{}, false
+	}
+
+	hops := make([]net.IP, 0, len(elements))
+	for _, el := range elements {
+		hops = append(hops, el.ForIP())
+	}
+
+	idx := u.matcher.ClientIndex(rIP, hops)
+	if idx < 0 {
+		return Element{}, false
+	}
+
+	return elements[idx], true
+}
+
+// RemoteAddr returns the client address
+func (u *Unwrapper) RemoteAddr(ctx *gramework.Context) net.Addr {
+	orig := ctx.RequestCtx.RemoteAddr()
+	rIP := ctx.RequestCtx.RemoteIP()
+	ip := u.RemoteIP(ctx)
+	if ip == nil || ip.Equal(rIP) {
+		return orig
+	}
+
+	return trustedproxy.Addr(orig, ip)
+}
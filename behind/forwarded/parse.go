@@ -0,0 +1,159 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package forwarded
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/gramework/gramework/behind/internal/trustedproxy"
+)
+
+// ErrMalformed returned when the Forwarded header value does not follow RFC 7239
+var ErrMalformed = errors.New("malformed Forwarded header")
+
+// Element is a single forwarded-element of the Forwarded header,
+// e.g. `for=192.0.2.60;proto=http;by=203.0.113.43`.
+// Values are unquoted, parameter names are case-insensitive.
+type Element struct {
+	For   string
+	By    string
+	Host  string
+	Proto string
+}
+
+// ForIP returns the IP of the "for" node, or nil if the node
+// is "unknown", obfuscated or can not be parsed.
+func (e Element) ForIP() net.IP {
+	return NodeIP(e.For)
+}
+
+// NodeIP parses a RFC 7239 node, e.g. `192.0.2.43`, `192.0.2.43:47011`
+// or `[2001:db8:cafe::17]:4711`. It returns nil for "unknown"
+// and obfuscated identifiers.
+func NodeIP(node string) net.IP {
+	if len(node) == 0 || strings.EqualFold(node, "unknown") || node[0] == '_' {
+		return nil
+	}
+
+	return trustedproxy.ParseIP(node)
+}
+
+// Parse parses the Forwarded header value into the list of elements.
+// Multiple header values should be joined with a comma before parsing.
+func Parse(value string) ([]Element, error) {
+	var (
+		elements []Element
+		current  Element
+		pos      int
+	)
+
+	for {
+		pos = skipSpaces(value, pos)
+		if pos >= len(value) {
+			if current == (Element{}) {
+				return elements, nil
+			}
+			return append(elements, current), nil
+		}
+
+		nameEnd := pos
+		for nameEnd < len(value) && isTokenChar(value[nameEnd]) {
+			nameEnd++
+		}
+		if nameEnd == pos || nameEnd >= len(value) || value[nameEnd] != '=' {
+			return nil, ErrMalformed
+		}
+		name := value[pos:nameEnd]
+		pos = nameEnd + 1
+
+		var paramValue string
+		if pos < len(value) && value[pos] == '"' {
+			var (
+				b       strings.Builder
+				escaped bool
+				closed  bool
+			)
+			pos++
+			for ; pos < len(value); pos++ {
+				c := value[pos]
+				if escaped {
+					b.WriteByte(c)
+					escaped = false
+					continue
+				}
+				if c == '\\' {
+					escaped = true
+					continue
+				}
+				if c == '"' {
+					closed = true
+					pos++
+					break
+				}
+				b.WriteByte(c)
+			}
+			if !closed {
+				return nil, ErrMalformed
+			}
+			paramValue = b.String()
+		} else {
+			valueEnd := pos
+			for valueEnd < len(value) && isTokenChar(value[valueEnd]) {
+				valueEnd++
+			}
+			paramValue = value[pos:valueEnd]
+			pos = valueEnd
+		}
+
+		switch strings.ToLower(name) {
+		case "for":
+			current.For = paramValue
+		case "by":
+			current.By = paramValue
+		case "host":
+			current.Host = paramValue
+		case "proto":
+			current.Proto = strings.ToLower(paramValue)
+		}
+
+		pos = skipSpaces(value, pos)
+		if pos >= len(value) {
+			continue
+		}
+		switch value[pos] {
+		case ';':
+			pos++
+		case ',':
+			elements = append(elements, current)
+			current = Element{}
+			pos++
+		default:
+			return nil, ErrMalformed
+		}
+	}
+}
+
+func skipSpaces(s string, pos int) int {
+	for pos < len(s) && (s[pos] == ' ' || s[pos] == '\t') {
+		pos++
+	}
+	return pos
+}
+
+// isTokenChar reports if c is a RFC 7230 tchar
+func isTokenChar(c byte) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
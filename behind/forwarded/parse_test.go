@@ -0,0 +1,128 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package forwarded
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/gramework/gramework"
+	"github.com/valyala/fasthttp"
+)
+
+func TestParse(t *testing.T) {
+	type tcase struct {
+		value    string
+		expected []Element
+		err      bool
+	}
+	cases := []tcase{
+		{
+			value:    "for=192.0.2.60;proto=HTTP;by=203.0.113.43",
+			expected: []Element{{For: "192.0.2.60", Proto: "http", By: "203.0.113.43"}},
+		},
+		{
+			value: `For="[2001:db8:cafe::17]:4711", for=192.0.2.43;host=example.com`,
+			expected: []Element{
+				{For: "[2001:db8:cafe::17]:4711"},
+				{For: "192.0.2.43", Host: "example.com"},
+			},
+		},
+		{
+			value:    `for="_hidden\"x"`,
+			expected: []Element{{For: `_hidden"x`}},
+		},
+		{
+			value: "",
+		},
+		{
+			value: "for",
+			err:   true,
+		},
+		{
+			value: `for="192.0.2.1`,
+			err:   true,
+		},
+		{
+			value: "for=192.0.2.1 by=1.1.1.1",
+			err:   true,
+		},
+	}
+
+	for _, tc := range cases {
+		elements, err := Parse(tc.value)
+		if (err != nil) != tc.err {
+			t.Errorf("%q: unexpected error: %v", tc.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(elements, tc.expected) {
+			t.Errorf("%q: unexpected elements: %#v", tc.value, elements)
+		}
+	}
+}
+
+func TestNodeIP(t *testing.T) {
+	cases := map[string]string{
+		"192.0.2.43":               "192.0.2.43",
+		"192.0.2.43:47011":         "192.0.2.43",
+		"[2001:db8:cafe::17]:4711": "2001:db8:cafe::17",
+		"[2001:db8:cafe::17]":      "2001:db8:cafe::17",
+		"unknown":                  "<nil>",
+		"_gazonk":                  "<nil>",
+	}
+
+	for node, expected := range cases {
+		if ip := NodeIP(node); ip.String() != expected {
+			t.Errorf("%q: unexpected ip %q, expected %q", node, ip, expected)
+		}
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	u := New(TrustedProxies("10.0.0.0/8"))
+
+	req := &fasthttp.Request{}
+	req.Header.Add("Forwarded", "for=1.1.1.1")
+	req.Header.Add("Forwarded", `for=203.0.113.5;proto=https, for="10.1.1.1:8080"`)
+	fhctx := &fasthttp.RequestCtx{}
+	fhctx.Init(req, &net.TCPAddr{IP: net.ParseIP("10.0.0.1")}, nil)
+	ctx := &gramework.Context{RequestCtx: fhctx}
+
+	if ip := u.RemoteIP(ctx); ip.String() != "203.0.113.5" {
+		t.Errorf("unexpected RemoteIP: %q", ip)
+	}
+
+	fhctx.Init(req, &net.TCPAddr{IP: net.ParseIP("198.51.100.7")}, nil)
+	if ip := u.RemoteIP(ctx); ip.String() != "198.51.100.7" {
+		t.Errorf("unexpected RemoteIP for untrusted peer: %q", ip)
+	}
+}
+
+func TestForwardedInfo(t *testing.T) {
+	u := New(TrustedProxies("10.0.0.0/8"))
+
+	req := &fasthttp.Request{}
+	req.Header.Add("Forwarded", `for=203.0.113.5;proto=https;host="example.com:8443", for=10.1.1.1;proto=http;host=internal`)
+	fhctx := &fasthttp.RequestCtx{}
+	fhctx.Init(req, &net.TCPAddr{IP: net.ParseIP("10.0.0.1")}, nil)
+	ctx := &gramework.Context{RequestCtx: fhctx}
+
+	if scheme := u.Scheme(ctx); scheme != "https" {
+		t.Errorf("unexpected Scheme: %q", scheme)
+	}
+	if host := u.Host(ctx); host != "example.com:8443" {
+		t.Errorf("unexpected Host: %q", host)
+	}
+	if port := u.Port(ctx); port != 8443 {
+		t.Errorf("unexpected Port: %d", port)
+	}
+}
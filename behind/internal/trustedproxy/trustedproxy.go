@@ -0,0 +1,238 @@
+// Package trustedproxy provides the trusted proxy matching and hop walking
+// shared by the generic gramework.Behind implementations.
+//
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+package trustedproxy
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/VictoriaMetrics/fastcache"
+)
+
+const cacheSize = 32 * 1024 * 1024
+
+// Matcher checks if an IP belongs to the list of trusted CIDR blocks.
+// Lookup results are cached the same way behind/akamai does.
+type Matcher struct {
+	blocks []*net.IPNet
+
+	initCache    sync.Once
+	disableCache bool
+	cache        *fastcache.Cache
+}
+
+// NewMatcher creates a matcher for given blocks
+func NewMatcher(blocks []*net.IPNet, disableCache bool) *Matcher {
+	return &Matcher{
+		blocks:       blocks,
+		disableCache: disableCache,
+	}
+}
+
+// Len returns count of the trusted blocks
+func (m *Matcher) Len() int {
+	return len(m.blocks)
+}
+
+// CacheEnabled reports if lookup cache is enabled
+func (m *Matcher) CacheEnabled() bool {
+	return !m.disableCache
+}
+
+// Contains reports if ip is in any of the trusted blocks
+func (m *Matcher) Contains(ip net.IP) bool {
+	if len(ip) == 0 {
+		return false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	allowed, found := m.cacheRead(ip)
+	if found {
+		return allowed
+	}
+
+	allowed = false
+	for _, cidr := range m.blocks {
+		if cidr.Contains(ip) {
+			allowed = true
+			break
+		}
+	}
+
+	if allowed {
+		m.cacheWrite(ip, []byte{1})
+	} else {
+		m.cacheWrite(ip, []byte{0})
+	}
+
+	return allowed
+}
+
+func (m *Matcher) initCacheFunc() {
+	if m.disableCache {
+		return
+	}
+
+	m.cache = fastcache.New(cacheSize)
+}
+
+func (m *Matcher) cacheWrite(key net.IP, value []byte) {
+	m.initCache.Do(m.initCacheFunc)
+	if m.cache != nil {
+		m.cache.Set(key, value)
+	}
+}
+
+func (m *Matcher) cacheRead(key net.IP) (allowed, ok bool) {
+	if m.cache != nil {
+		result := m.cache.Get(nil, key)
+		nonnil := len(result) > 0
+		return nonnil && result[0] == 1, nonnil
+	}
+
+	return false, false
+}
+
+// ClientIP walks hops from right to left, starting with the connection peer.
+// Every hop that is trusted is skipped, and the first untrusted one is
+// returned as the client address. If the walk reaches a hop that can not be
+// parsed (nil), the last valid hop is returned. If all hops are trusted,
+// the leftmost one is returned.
+func (m *Matcher) ClientIP(peer net.IP, hops []net.IP) net.IP {
+	idx := m.ClientIndex(peer, hops)
+	if idx < 0 {
+		return peer
+	}
+
+	return hops[idx]
+}
+
+// ClientIndex returns the index of the hop ClientIP resolves to,
+// or -1 if it resolves to the connection peer.
+func (m *Matcher) ClientIndex(peer net.IP, hops []net.IP) int {
+	if !m.Contains(peer) {
+		return -1
+	}
+
+	prev := -1
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := hops[i]
+		if ip == nil {
+			return prev
+		}
+		if !m.Contains(ip) {
+			return i
+		}
+		prev = i
+	}
+
+	return prev
+}
+
+// LastValue returns the rightmost non-empty value of a comma-separated
+// header, i.e. the one set by the nearest proxy.
+func LastValue(value []byte) string {
+	for len(value) > 0 {
+		i := bytes.LastIndexByte(value, ',')
+		v := bytes.TrimSpace(value[i+1:])
+		if len(v) > 0 {
+			return string(v)
+		}
+		if i < 0 {
+			break
+		}
+		value = value[:i]
+	}
+
+	return ""
+}
+
+// HostPort returns the port of host, if any
+func HostPort(host string) int {
+	if _, port, err := net.SplitHostPort(host); err == nil {
+		p, err := strconv.Atoi(port)
+		if err == nil && p > 0 && p < 65536 {
+			return p
+		}
+	}
+
+	return 0
+}
+
+// ParseCIDRs parses a list of CIDR blocks. Plain IP addresses
+// are accepted too and converted to a single-address block.
+func ParseCIDRs(list ...string) ([]*net.IPNet, error) {
+	blocks := make([]*net.IPNet, 0, len(list))
+	for _, raw := range list {
+		raw = strings.TrimSpace(raw)
+		if len(raw) == 0 {
+			continue
+		}
+		if !strings.Contains(raw, "/") {
+			ip := net.ParseIP(raw)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: raw}
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+				bits = 32
+			}
+			blocks = append(blocks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, block, err := net.ParseCIDR(raw)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+
+	return blocks, nil
+}
+
+// ParseIP parses an IP that may include a port or IPv6 brackets,
+// e.g. "192.0.2.1", "192.0.2.1:8080", "[2001:db8::1]:443" or "2001:db8::1".
+func ParseIP(raw string) net.IP {
+	raw = strings.TrimSpace(raw)
+	if len(raw) == 0 {
+		return nil
+	}
+	if ip := net.ParseIP(raw); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(raw); err == nil {
+		return net.ParseIP(host)
+	}
+	if strings.HasPrefix(raw, "[") && strings.HasSuffix(raw, "]") {
+		return net.ParseIP(raw[1 : len(raw)-1])
+	}
+
+	return nil
+}
+
+// Addr replaces the connection address with the resolved client IP,
+// preserving the network of the original address.
+func Addr(orig net.Addr, ip net.IP) net.Addr {
+	if orig != nil && orig.Network() == "tcp" {
+		return &net.TCPAddr{IP: ip}
+	}
+
+	return &net.IPAddr{IP: ip}
+}
@@ -0,0 +1,79 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package proxyproto
+
+import (
+	"net"
+
+	"github.com/gramework/gramework"
+)
+
+// Unwrapper is an optional gramework.Behind implementation, that reports
+// the scheme and host of the original connection using the PP2_TYPE_SSL
+// and PP2_TYPE_AUTHORITY vectors of the PROXY header.
+// Client address is already resolved by the Listener, so Unwrapper
+// returns it as is.
+type Unwrapper struct{}
+
+// New creates an unwrapper for TLS-terminating TCP proxies
+func New() *Unwrapper {
+	return &Unwrapper{}
+}
+
+// OnAppActivation logs the unwrapper activation
+func (u *Unwrapper) OnAppActivation(app *gramework.App) {
+	log := app.Logger.WithField("package", "gramework/behind/proxyproto")
+	log.Info("Gramework is running behind PROXY protocol-compatible proxy.")
+	log.Info("Activated")
+}
+
+// RemoteIP returns the client IP
+func (u *Unwrapper) RemoteIP(ctx *gramework.Context) net.IP {
+	return ctx.RequestCtx.RemoteIP()
+}
+
+// RemoteAddr returns the client address
+func (u *Unwrapper) RemoteAddr(ctx *gramework.Context) net.Addr {
+	return ctx.RequestCtx.RemoteAddr()
+}
+
+// Scheme returns the scheme of the client connection to the proxy.
+// It returns an empty string if the proxy does not send the SSL vector.
+func (u *Unwrapper) Scheme(ctx *gramework.Context) string {
+	ssl, ok := FromContext(ctx).SSL()
+	if !ok {
+		return ""
+	}
+
+	if ssl.IsTLS() {
+		return "https"
+	}
+	return "http"
+}
+
+// Host returns the host name the client used, if sent by the proxy
+func (u *Unwrapper) Host(ctx *gramework.Context) string {
+	return FromContext(ctx).Authority()
+}
+
+// Port returns the original destination port
+func (u *Unwrapper) Port(ctx *gramework.Context) int {
+	h := FromContext(ctx)
+	if h == nil {
+		return 0
+	}
+
+	if addr, ok := h.DestinationAddr.(*net.TCPAddr); ok {
+		return addr.Port
+	}
+
+	return 0
+}
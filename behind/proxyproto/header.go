@@ -0,0 +1,269 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrNoHeader returned when a required PROXY header is missing
+	ErrNoHeader = errors.New("proxyproto: PROXY header is missing")
+	// ErrMalformedHeader returned when the PROXY header can not be parsed
+	ErrMalformedHeader = errors.New("proxyproto: malformed PROXY header")
+	// ErrUnsupportedVersion returned when the PROXY header has unknown version
+	ErrUnsupportedVersion = errors.New("proxyproto: unsupported PROXY protocol version")
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	// v1MaxLength is the max length of the v1 header, including CRLF
+	v1MaxLength = 107
+
+	v2HeaderLength = 16
+	v2Version      = 0x20
+
+	v2AddrLengthIPv4 = 12
+	v2AddrLengthIPv6 = 36
+	v2AddrLengthUnix = 216
+)
+
+// Command is the PROXY protocol command
+type Command byte
+
+const (
+	// CommandLocal means that the connection was established by the proxy itself,
+	// e.g. for health checks. Addresses should be ignored.
+	CommandLocal Command = 0x0
+	// CommandProxy means that the connection was established on behalf of another node
+	CommandProxy Command = 0x1
+)
+
+// Header is a parsed PROXY protocol header
+type Header struct {
+	// Version is 1 or 2
+	Version int
+	// Command is always CommandProxy for version 1 headers,
+	// except for the UNKNOWN protocol.
+	Command Command
+	// SourceAddr is the original client address.
+	// It is nil for LOCAL command and unknown protocols.
+	SourceAddr net.Addr
+	// DestinationAddr is the original destination address.
+	// It is nil for LOCAL command and unknown protocols.
+	DestinationAddr net.Addr
+	// TLVs is the list of version 2 Type-Length-Value vectors
+	TLVs []TLV
+}
+
+// readHeader reads the PROXY header from r, if any.
+// It returns nil header and nil error if there's no header.
+func readHeader(r *bufio.Reader) (*Header, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch first[0] {
+	case v1Prefix[0]:
+		prefix, err := r.Peek(len(v1Prefix))
+		if err != nil || !bytes.Equal(prefix, v1Prefix) {
+			return nil, err
+		}
+		return readV1(r)
+	case v2Signature[0]:
+		sig, err := r.Peek(len(v2Signature))
+		if err != nil || !bytes.Equal(sig, v2Signature) {
+			return nil, err
+		}
+		return readV2(r)
+	}
+
+	return nil, nil
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	line := make([]byte, 0, v1MaxLength)
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+		if len(line) >= v1MaxLength {
+			return nil, ErrMalformedHeader
+		}
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, ErrMalformedHeader
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 {
+		return nil, ErrMalformedHeader
+	}
+
+	h := &Header{
+		Version: 1,
+		Command: CommandProxy,
+	}
+
+	switch fields[1] {
+	case "UNKNOWN":
+		h.Command = CommandLocal
+		return h, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, ErrMalformedHeader
+	}
+
+	if len(fields) != 6 {
+		return nil, ErrMalformedHeader
+	}
+
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	if srcIP == nil || dstIP == nil {
+		return nil, ErrMalformedHeader
+	}
+	if (fields[1] == "TCP4") != (srcIP.To4() != nil && dstIP.To4() != nil) {
+		return nil, ErrMalformedHeader
+	}
+
+	srcPort, err := parsePort(fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dstPort, err := parsePort(fields[5])
+	if err != nil {
+		return nil, err
+	}
+
+	h.SourceAddr = &net.TCPAddr{IP: srcIP, Port: srcPort}
+	h.DestinationAddr = &net.TCPAddr{IP: dstIP, Port: dstPort}
+
+	return h, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 0 || port > 65535 || (len(s) > 1 && s[0] == '0') {
+		return 0, ErrMalformedHeader
+	}
+
+	return port, nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	var fixed [v2HeaderLength]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, err
+	}
+
+	if fixed[12]&0xF0 != v2Version {
+		return nil, ErrUnsupportedVersion
+	}
+
+	h := &Header{
+		Version: 2,
+		Command: Command(fixed[12] & 0x0F),
+	}
+	if h.Command != CommandLocal && h.Command != CommandProxy {
+		return nil, ErrMalformedHeader
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	// the receiver must discard the whole block for LOCAL command
+	if h.Command == CommandLocal {
+		return h, nil
+	}
+
+	family, transport := fixed[13]>>4, fixed[13]&0x0F
+	addrLength := 0
+	switch family {
+	case 0x0: // AF_UNSPEC
+	case 0x1: // AF_INET
+		addrLength = v2AddrLengthIPv4
+	case 0x2: // AF_INET6
+		addrLength = v2AddrLengthIPv6
+	case 0x3: // AF_UNIX
+		addrLength = v2AddrLengthUnix
+	default:
+		return nil, ErrMalformedHeader
+	}
+	if len(payload) < addrLength {
+		return nil, ErrMalformedHeader
+	}
+
+	if addrLength > 0 {
+		h.SourceAddr, h.DestinationAddr = parseV2Addrs(family, transport, payload[:addrLength])
+	}
+
+	tlvs, err := parseTLVs(payload[addrLength:])
+	if err != nil {
+		return nil, err
+	}
+	h.TLVs = tlvs
+
+	return h, nil
+}
+
+func parseV2Addrs(family, transport byte, b []byte) (src, dst net.Addr) {
+	switch family {
+	case 0x1, 0x2:
+		ipLength := net.IPv4len
+		if family == 0x2 {
+			ipLength = net.IPv6len
+		}
+		srcIP := net.IP(append([]byte(nil), b[:ipLength]...))
+		dstIP := net.IP(append([]byte(nil), b[ipLength:2*ipLength]...))
+		srcPort := int(binary.BigEndian.Uint16(b[2*ipLength:]))
+		dstPort := int(binary.BigEndian.Uint16(b[2*ipLength+2:]))
+		if transport == 0x2 {
+			return &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}
+		}
+		return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}
+	case 0x3:
+		network := "unix"
+		if transport == 0x2 {
+			network = "unixgram"
+		}
+		return &net.UnixAddr{Name: unixPath(b[:108]), Net: network},
+			&net.UnixAddr{Name: unixPath(b[108:216]), Net: network}
+	}
+
+	return nil, nil
+}
+
+func unixPath(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+
+	return string(b)
+}
@@ -0,0 +1,285 @@
+// Package proxyproto provides a PROXY protocol v1/v2 listener
+// for gramework apps running behind TCP load balancers,
+// e.g. HAProxy or AWS Network Load Balancer.
+//
+// Use it with gramework.OptListenerWrapper:
+//
+//     app := gramework.New(gramework.OptListenerWrapper(
+//         proxyproto.Wrap(proxyproto.TrustedProxies("10.0.0.0/8")),
+//     ))
+//
+// Only headers of connections from trusted proxies are parsed,
+// so without TrustedProxies or TrustAll the listener ignores headers.
+//
+// With the listener enabled, ctx.RemoteAddr(), ctx.RemoteIP()
+// and the firewall see the original client address.
+//
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+package proxyproto
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gramework/gramework"
+	"github.com/gramework/gramework/behind/internal/trustedproxy"
+)
+
+// DefaultReadHeaderTimeout is the default timeout for reading the PROXY header
+const DefaultReadHeaderTimeout = 10 * time.Second
+
+// bufferSize fits any v1 header and most of v2 headers
+const bufferSize = 256
+
+// Option configures the Listener
+type Option func(l *Listener)
+
+// Listener wraps a net.Listener and parses the PROXY protocol header
+// of connections, accepted from trusted sources. Connections from
+// untrusted sources are passed through untouched, so their clients
+// can't spoof the address with a forged header.
+//
+// The header is parsed lazily on the first Read, RemoteAddr or LocalAddr
+// call, so slow proxies do not block the accept loop.
+type Listener struct {
+	net.Listener
+
+	trustedCIDR       []*net.IPNet
+	trustAll          bool
+	required          bool
+	readHeaderTimeout time.Duration
+
+	matcher *trustedproxy.Matcher
+}
+
+// NewListener wraps ln with the PROXY protocol parser.
+// If no trusted proxies configured, no sources are trusted and headers
+// are never parsed: use TrustedProxies, CIDRBlocks or TrustAll.
+func NewListener(ln net.Listener, opts ...Option) *Listener {
+	l := &Listener{
+		Listener:          ln,
+		readHeaderTimeout: DefaultReadHeaderTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	l.matcher = trustedproxy.NewMatcher(l.trustedCIDR, false)
+
+	return l
+}
+
+// Wrap returns a listener wrapper for gramework.OptListenerWrapper
+func Wrap(opts ...Option) func(net.Listener) net.Listener {
+	return func(ln net.Listener) net.Listener {
+		return NewListener(ln, opts...)
+	}
+}
+
+// CIDRBlocks sets trusted proxies CIDR blocks
+func CIDRBlocks(blocks []*net.IPNet) Option {
+	return func(l *Listener) {
+		l.trustedCIDR = append(l.trustedCIDR, blocks...)
+	}
+}
+
+// TrustedProxies parses and sets trusted proxies CIDR blocks or IPs.
+// It panics if any of the given values is invalid.
+func TrustedProxies(cidrs ...string) Option {
+	blocks, err := trustedproxy.ParseCIDRs(cidrs...)
+	if err != nil {
+		panic(err)
+	}
+
+	return CIDRBlocks(blocks)
+}
+
+// TrustAll trusts the PROXY header of connections from any source.
+// Use it only if the listener is not reachable by clients directly,
+// otherwise any client can spoof its address.
+func TrustAll() Option {
+	return func(l *Listener) {
+		l.trustAll = true
+	}
+}
+
+// Required makes the PROXY header mandatory for trusted sources.
+// Connections without the header will be rejected with ErrNoHeader.
+func Required() Option {
+	return func(l *Listener) {
+		l.required = true
+	}
+}
+
+// ReadHeaderTimeout sets the timeout for reading the PROXY header.
+// Zero disables the timeout.
+func ReadHeaderTimeout(d time.Duration) Option {
+	return func(l *Listener) {
+		l.readHeaderTimeout = d
+	}
+}
+
+// Accept waits for and returns the next connection
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !l.trustAll && !l.matcher.Contains(addrIP(c.RemoteAddr())) {
+		return c, nil
+	}
+
+	return &Conn{
+		Conn:              c,
+		required:          l.required,
+		readHeaderTimeout: l.readHeaderTimeout,
+	}, nil
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+
+	return nil
+}
+
+// Conn is a connection with the PROXY protocol header
+type Conn struct {
+	net.Conn
+
+	required          bool
+	readHeaderTimeout time.Duration
+
+	once      sync.Once
+	br        *bufio.Reader
+	header    *Header
+	headerErr error
+
+	deadlineMu   sync.Mutex
+	readDeadline time.Time
+}
+
+// Header returns the parsed PROXY header. It returns nil header and nil error
+// if the connection has no header.
+func (c *Conn) Header() (*Header, error) {
+	c.once.Do(c.readHeader)
+	return c.header, c.headerErr
+}
+
+func (c *Conn) readHeader() {
+	if c.readHeaderTimeout > 0 {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.readHeaderTimeout))
+	}
+
+	c.br = bufio.NewReaderSize(c.Conn, bufferSize)
+	c.header, c.headerErr = readHeader(c.br)
+	if c.headerErr == nil && c.header == nil && c.required {
+		c.headerErr = ErrNoHeader
+	}
+
+	if c.readHeaderTimeout > 0 {
+		c.deadlineMu.Lock()
+		_ = c.Conn.SetReadDeadline(c.readDeadline)
+		c.deadlineMu.Unlock()
+	}
+}
+
+// Read reads data from the connection, skipping the PROXY header
+func (c *Conn) Read(b []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+
+	if c.br != nil {
+		if c.br.Buffered() > 0 {
+			return c.br.Read(b)
+		}
+		c.br = nil
+	}
+
+	return c.Conn.Read(b)
+}
+
+// RemoteAddr returns the original client address, if the connection has
+// the PROXY header, or the connection remote address otherwise
+func (c *Conn) RemoteAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h != nil && h.SourceAddr != nil {
+		return h.SourceAddr
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the original destination address, if the connection has
+// the PROXY header, or the connection local address otherwise
+func (c *Conn) LocalAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h != nil && h.DestinationAddr != nil {
+		return h.DestinationAddr
+	}
+
+	return c.Conn.LocalAddr()
+}
+
+// ProxyAddr returns the address of the proxy itself
+func (c *Conn) ProxyAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	c.readDeadline = t
+	c.deadlineMu.Unlock()
+
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	c.readDeadline = t
+	c.deadlineMu.Unlock()
+
+	return c.Conn.SetReadDeadline(t)
+}
+
+// FromConn returns the PROXY header of the connection, if any.
+// TLS connections are unwrapped automatically.
+func FromConn(c net.Conn) *Header {
+	for c != nil {
+		switch conn := c.(type) {
+		case *Conn:
+			h, _ := conn.Header()
+			return h
+		case interface{ NetConn() net.Conn }:
+			c = conn.NetConn()
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// FromContext returns the PROXY header of the request connection, if any
+func FromContext(ctx *gramework.Context) *Header {
+	return FromConn(ctx.Conn())
+}
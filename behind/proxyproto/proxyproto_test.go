@@ -0,0 +1,201 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func v2Header(cmd Command, addrs []byte, tlvs ...TLV) []byte {
+	var payload []byte
+	payload = append(payload, addrs...)
+	for _, tlv := range tlvs {
+		payload = append(payload, byte(tlv.Type), byte(len(tlv.Value)>>8), byte(len(tlv.Value)))
+		payload = append(payload, tlv.Value...)
+	}
+
+	b := append([]byte(nil), v2Signature...)
+	b = append(b, v2Version|byte(cmd), 0x11, 0, 0)
+	binary.BigEndian.PutUint16(b[14:], uint16(len(payload)))
+	return append(b, payload...)
+}
+
+func ipv4Addrs(src, dst string, srcPort, dstPort uint16) []byte {
+	b := append([]byte(nil), net.ParseIP(src).To4()...)
+	b = append(b, net.ParseIP(dst).To4()...)
+	b = append(b, byte(srcPort>>8), byte(srcPort), byte(dstPort>>8), byte(dstPort))
+	return b
+}
+
+// dial sends data to the listener and returns the accepted connection
+func dial(t *testing.T, ln net.Listener, data []byte) net.Conn {
+	t.Helper()
+	go func() {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		_, _ = c.Write(data)
+		time.Sleep(100 * time.Millisecond)
+		c.Close()
+	}()
+
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func listen(t *testing.T, opts ...Option) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return NewListener(ln, opts...)
+}
+
+func readAll(t *testing.T, c net.Conn) string {
+	t.Helper()
+	b, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestV1(t *testing.T) {
+	ln := listen(t, TrustedProxies("127.0.0.1"))
+	c := dial(t, ln, []byte("PROXY TCP4 203.0.113.5 192.0.2.1 56324 443\r\nGET / HTTP/1.1\r\n\r\n"))
+	defer c.Close()
+
+	if addr := c.RemoteAddr().String(); addr != "203.0.113.5:56324" {
+		t.Errorf("unexpected RemoteAddr: %q", addr)
+	}
+	if addr := c.LocalAddr().String(); addr != "192.0.2.1:443" {
+		t.Errorf("unexpected LocalAddr: %q", addr)
+	}
+	if body := readAll(t, c); body != "GET / HTTP/1.1\r\n\r\n" {
+		t.Errorf("unexpected payload: %q", body)
+	}
+}
+
+func TestV2(t *testing.T) {
+	ssl := []byte{SSLClientSSL, 0, 0, 0, 0, byte(TLVTypeSSLVersion), 0, 7}
+	ssl = append(ssl, "TLSv1.3"...)
+	header := v2Header(CommandProxy, ipv4Addrs("203.0.113.5", "192.0.2.1", 56324, 443),
+		TLV{Type: TLVTypeAuthority, Value: []byte("example.com")},
+		TLV{Type: TLVTypeSSL, Value: ssl},
+	)
+
+	ln := listen(t, TrustAll())
+	c := dial(t, ln, append(header, "payload"...))
+	defer c.Close()
+
+	if addr := c.RemoteAddr().String(); addr != "203.0.113.5:56324" {
+		t.Errorf("unexpected RemoteAddr: %q", addr)
+	}
+
+	h := FromConn(c)
+	if h == nil || h.Version != 2 {
+		t.Fatalf("unexpected header: %#v", h)
+	}
+	if h.Authority() != "example.com" {
+		t.Errorf("unexpected authority: %q", h.Authority())
+	}
+	info, ok := h.SSL()
+	if !ok || !info.IsTLS() || info.Version != "TLSv1.3" {
+		t.Errorf("unexpected SSL info: %#v", info)
+	}
+	if body := readAll(t, c); body != "payload" {
+		t.Errorf("unexpected payload: %q", body)
+	}
+}
+
+func TestV2Local(t *testing.T) {
+	ln := listen(t, TrustedProxies("127.0.0.1"))
+	c := dial(t, ln, append(v2Header(CommandLocal, nil), "payload"...))
+	defer c.Close()
+
+	if addr := c.RemoteAddr().(*net.TCPAddr); !addr.IP.IsLoopback() {
+		t.Errorf("unexpected RemoteAddr for LOCAL command: %q", addr)
+	}
+	if body := readAll(t, c); body != "payload" {
+		t.Errorf("unexpected payload: %q", body)
+	}
+}
+
+func TestNoHeader(t *testing.T) {
+	ln := listen(t, TrustedProxies("127.0.0.1"))
+	c := dial(t, ln, []byte("GET / HTTP/1.1\r\n\r\n"))
+	defer c.Close()
+
+	if body := readAll(t, c); body != "GET / HTTP/1.1\r\n\r\n" {
+		t.Errorf("unexpected payload: %q", body)
+	}
+
+	ln = listen(t, TrustedProxies("127.0.0.1"), Required())
+	c = dial(t, ln, []byte("GET / HTTP/1.1\r\n\r\n"))
+	defer c.Close()
+
+	if _, err := c.Read(make([]byte, 16)); err != ErrNoHeader {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestUntrustedSource(t *testing.T) {
+	payload := []byte("PROXY TCP4 203.0.113.5 192.0.2.1 56324 443\r\n")
+	for name, opts := range map[string][]Option{
+		"other proxies":       {TrustedProxies("10.0.0.0/8")},
+		"no trusted proxies":  nil,
+		"required, untrusted": {Required()},
+	} {
+		ln := listen(t, opts...)
+		c := dial(t, ln, payload)
+
+		if FromConn(c) != nil {
+			t.Errorf("%s: header should not be parsed for untrusted source", name)
+		}
+		if ip := addrIP(c.RemoteAddr()); !ip.IsLoopback() {
+			t.Errorf("%s: untrusted source should not override the address, got %s", name, ip)
+		}
+		if body := readAll(t, c); !bytes.Equal([]byte(body), payload) {
+			t.Errorf("%s: unexpected payload: %q", name, body)
+		}
+		c.Close()
+	}
+}
+
+func TestMalformedV1(t *testing.T) {
+	cases := []string{
+		"PROXY TCP4 203.0.113.5 192.0.2.1 56324\r\n",
+		"PROXY TCP4 2001:db8::1 192.0.2.1 56324 443\r\n",
+		"PROXY TCP4 203.0.113.5 192.0.2.1 56324 443\n",
+		"PROXY TCP5 203.0.113.5 192.0.2.1 56324 443\r\n",
+		"PROXY TCP4 203.0.113.5 192.0.2.1 56324 99999\r\n",
+	}
+
+	for _, tc := range cases {
+		ln := listen(t, TrustedProxies("127.0.0.1"))
+		c := dial(t, ln, []byte(tc))
+		if _, err := c.(*Conn).Header(); err == nil {
+			t.Errorf("%q: expected error", tc)
+		}
+		c.Close()
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package proxyproto
+
+import (
+	"encoding/binary"
+)
+
+// TLVType is the type of the PROXY protocol v2 Type-Length-Value vector
+type TLVType byte
+
+// Registered TLV types
+const (
+	TLVTypeALPN          TLVType = 0x01
+	TLVTypeAuthority     TLVType = 0x02
+	TLVTypeCRC32C        TLVType = 0x03
+	TLVTypeNoop          TLVType = 0x04
+	TLVTypeUniqueID      TLVType = 0x05
+	TLVTypeSSL           TLVType = 0x20
+	TLVTypeSSLVersion    TLVType = 0x21
+	TLVTypeSSLCN         TLVType = 0x22
+	TLVTypeSSLCipher     TLVType = 0x23
+	TLVTypeSSLSigAlg     TLVType = 0x24
+	TLVTypeSSLKeyAlg     TLVType = 0x25
+	TLVTypeNetNamespace  TLVType = 0x30
+	TLVTypeCustomMin     TLVType = 0xE0
+	TLVTypeCustomMax     TLVType = 0xEF
+	TLVTypeExperimentMin TLVType = 0xF0
+	TLVTypeExperimentMax TLVType = 0xF7
+)
+
+// SSL client flags, see SSLInfo.Client
+const (
+	SSLClientSSL      byte = 0x01
+	SSLClientCertConn byte = 0x02
+	SSLClientCertSess byte = 0x04
+)
+
+// TLV is a PROXY protocol v2 Type-Length-Value vector
+type TLV struct {
+	Type  TLVType
+	Value []byte
+}
+
+// SSLInfo is the parsed PP2_TYPE_SSL vector
+type SSLInfo struct {
+	// Client is a bit field of SSLClient* flags
+	Client byte
+	// Verify is zero if the client presented a certificate
+	// and it was successfully verified
+	Verify uint32
+	// Version is the TLS version, e.g. "TLSv1.3"
+	Version string
+	// CN is the Common Name of the client certificate
+	CN string
+	// Cipher is the cipher name, e.g. "ECDHE-RSA-AES128-GCM-SHA256"
+	Cipher string
+	// SigAlg is the algorithm used to sign the client certificate
+	SigAlg string
+	// KeyAlg is the algorithm used to generate the client certificate key
+	KeyAlg string
+}
+
+// IsTLS reports if the client connected to the proxy over TLS
+func (s *SSLInfo) IsTLS() bool {
+	return s.Client&SSLClientSSL != 0
+}
+
+// ClientCertVerified reports if the client presented a certificate
+// and it was successfully verified by the proxy
+func (s *SSLInfo) ClientCertVerified() bool {
+	return s.IsTLS() && s.Client&(SSLClientCertConn|SSLClientCertSess) != 0 && s.Verify == 0
+}
+
+func parseTLVs(b []byte) ([]TLV, error) {
+	var tlvs []TLV
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, ErrMalformedHeader
+		}
+		length := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+length {
+			return nil, ErrMalformedHeader
+		}
+		tlvs = append(tlvs, TLV{
+			Type:  TLVType(b[0]),
+			Value: b[3 : 3+length],
+		})
+		b = b[3+length:]
+	}
+
+	return tlvs, nil
+}
+
+// TLV returns the value of the first vector of given type
+func (h *Header) TLV(t TLVType) ([]byte, bool) {
+	if h == nil {
+		return nil, false
+	}
+
+	for _, tlv := range h.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+// Authority returns the host name the client used (SNI), if sent by the proxy
+func (h *Header) Authority() string {
+	v, _ := h.TLV(TLVTypeAuthority)
+	return string(v)
+}
+
+// ALPN returns the negotiated application protocol, if sent by the proxy
+func (h *Header) ALPN() string {
+	v, _ := h.TLV(TLVTypeALPN)
+	return string(v)
+}
+
+// UniqueID returns the connection unique ID, if sent by the proxy
+func (h *Header) UniqueID() []byte {
+	v, _ := h.TLV(TLVTypeUniqueID)
+	return v
+}
+
+// SSL returns the parsed SSL vector, if sent by the proxy
+func (h *Header) SSL() (*SSLInfo, bool) {
+	v, ok := h.TLV(TLVTypeSSL)
+	if !ok || len(v) < 5 {
+		return nil, false
+	}
+
+	info := &SSLInfo{
+		Client: v[0],
+		Verify: binary.BigEndian.Uint32(v[1:5]),
+	}
+
+	subs, err := parseTLVs(v[5:])
+	if err != nil {
+		return nil, false
+	}
+	for _, sub := range subs {
+		switch sub.Type {
+		case TLVTypeSSLVersion:
+			info.Version = string(sub.Value)
+		case TLVTypeSSLCN:
+			info.CN = string(sub.Value)
+		case TLVTypeSSLCipher:
+			info.Cipher = string(sub.Value)
+		case TLVTypeSSLSigAlg:
+			info.SigAlg = string(sub.Value)
+		case TLVTypeSSLKeyAlg:
+			info.KeyAlg = string(sub.Value)
+		}
+	}
+
+	return info, true
+}
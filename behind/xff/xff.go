@@ -0,0 +1,245 @@
+// Package xff provides a gramework.Behind implementation
+// for proxies that use X-Forwarded-For and X-Real-IP headers,
+// e.g. nginx, HAProxy, Traefik or most of the cloud load balancers.
+//
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+package xff
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gramework/gramework"
+	"github.com/gramework/gramework/behind/internal/trustedproxy"
+)
+
+const (
+	// DefaultForwardedForHeader is the default header that contains the proxy chain
+	DefaultForwardedForHeader = "X-Forwarded-For"
+	// DefaultRealIPHeader is the default header that is used when
+	// the proxy chain header is not present
+	DefaultRealIPHeader = "X-Real-IP"
+	// DefaultForwardedProtoHeader is the default header that contains the original scheme
+	DefaultForwardedProtoHeader = "X-Forwarded-Proto"
+	// DefaultForwardedHostHeader is the default header that contains the original host
+	DefaultForwardedHostHeader = "X-Forwarded-Host"
+	// DefaultForwardedPortHeader is the default header that contains the original port
+	DefaultForwardedPortHeader = "X-Forwarded-Port"
+)
+
+// Option configures the Unwrapper
+type Option func(u *Unwrapper)
+
+// Unwrapper resolves the client address using X-Forwarded-For
+// and X-Real-IP headers, sent by trusted proxies.
+//
+// Hops are walked right-to-left: each hop that belongs to the trusted
+// CIDR blocks is skipped, and the first untrusted hop is the client.
+// Headers are ignored completely if the connection peer is not trusted.
+//
+// Scheme, host and port are resolved from X-Forwarded-Proto,
+// X-Forwarded-Host and X-Forwarded-Port headers. If a header contains
+// a list, the rightmost value, set by the nearest proxy, is used.
+type Unwrapper struct {
+	forwardedForHeader   string
+	realIPHeader         string
+	forwardedProtoHeader string
+	forwardedHostHeader  string
+	forwardedPortHeader  string
+	trustedCIDR          []*net.IPNet
+	disableCache         bool
+
+	matcher *trustedproxy.Matcher
+}
+
+// New creates an unwrapper for X-Forwarded-For-compatible proxies
+func New(opts ...Option) *Unwrapper {
+	u := &Unwrapper{
+		forwardedForHeader:   DefaultForwardedForHeader,
+		realIPHeader:         DefaultRealIPHeader,
+		forwardedProtoHeader: DefaultForwardedProtoHeader,
+		forwardedHostHeader:  DefaultForwardedHostHeader,
+		forwardedPortHeader:  DefaultForwardedPortHeader,
+	}
+
+	for _, opt := range opts {
+		opt(u)
+	}
+
+	u.matcher = trustedproxy.NewMatcher(u.trustedCIDR, u.disableCache)
+
+	return u
+}
+
+// OnAppActivation logs the unwrapper configuration
+func (u *Unwrapper) OnAppActivation(app *gramework.App) {
+	log := app.Logger.WithField("package", "gramework/behind/xff")
+	log.Info("Gramework is running behind X-Forwarded-For-compatible proxy.")
+	log = log.WithField("CIDRs", u.matcher.Len())
+	if u.matcher.CacheEnabled() {
+		log = log.WithField("cache", "enabled")
+	} else {
+		log = log.WithField("cache", "disabled")
+	}
+	log.Info("Activated")
+}
+
+// DisableCache disables trusted proxies lookup cache
+func DisableCache() Option {
+	return func(u *Unwrapper) {
+		u.disableCache = true
+	}
+}
+
+// CIDRBlocks sets trusted proxies CIDR blocks
+func CIDRBlocks(blocks []*net.IPNet) Option {
+	return func(u *Unwrapper) {
+		u.trustedCIDR = append(u.trustedCIDR, blocks...)
+	}
+}
+
+// TrustedProxies parses and sets trusted proxies CIDR blocks or IPs.
+// It panics if any of the given values is invalid.
+func TrustedProxies(cidrs ...string) Option {
+	blocks, err := trustedproxy.ParseCIDRs(cidrs...)
+	if err != nil {
+		panic(err)
+	}
+
+	return CIDRBlocks(blocks)
+}
+
+// ForwardedForHeader sets the header that contains the proxy chain.
+// Empty name disables the proxy chain lookup.
+func ForwardedForHeader(name string) Option {
+	return func(u *Unwrapper) {
+		u.forwardedForHeader = name
+	}
+}
+
+// RealIPHeader sets the header that is used when the proxy chain
+// header is not present. Empty name disables the lookup.
+func RealIPHeader(name string) Option {
+	return func(u *Unwrapper) {
+		u.realIPHeader = name
+	}
+}
+
+// ForwardedProtoHeader sets the header that contains the original scheme.
+// Empty name disables the lookup.
+func ForwardedProtoHeader(name string) Option {
+	return func(u *Unwrapper) {
+		u.forwardedProtoHeader = name
+	}
+}
+
+// ForwardedHostHeader sets the header that contains the original host.
+// Empty name disables the lookup.
+func ForwardedHostHeader(name string) Option {
+	return func(u *Unwrapper) {
+		u.forwardedHostHeader = name
+	}
+}
+
+// ForwardedPortHeader sets the header that contains the original port.
+// Empty name disables the lookup.
+func ForwardedPortHeader(name string) Option {
+	return func(u *Unwrapper) {
+		u.forwardedPortHeader = name
+	}
+}
+
+// RemoteIP returns the client IP
+func (u *Unwrapper) RemoteIP(ctx *gramework.Context) net.IP {
+	rIP := ctx.RequestCtx.RemoteIP()
+	if !u.matcher.Contains(rIP) {
+		return rIP
+	}
+
+	hops := u.hops(ctx)
+	if len(hops) == 0 {
+		if len(u.realIPHeader) > 0 {
+			if ip := trustedproxy.ParseIP(string(ctx.Request.Header.Peek(u.realIPHeader))); ip != nil {
+				return ip
+			}
+		}
+		return rIP
+	}
+
+	return u.matcher.ClientIP(rIP, hops)
+}
+
+// RemoteAddr returns the client address
+func (u *Unwrapper) RemoteAddr(ctx *gramework.Context) net.Addr {
+	orig := ctx.RequestCtx.RemoteAddr()
+	rIP := ctx.RequestCtx.RemoteIP()
+	ip := u.RemoteIP(ctx)
+	if ip == nil || ip.Equal(rIP) {
+		return orig
+	}
+
+	return trustedproxy.Addr(orig, ip)
+}
+
+func (u *Unwrapper) hops(ctx *gramework.Context) (hops []net.IP) {
+	if len(u.forwardedForHeader) == 0 {
+		return nil
+	}
+
+	ctx.Request.Header.VisitAll(func(key, value []byte) {
+		if !bytes.EqualFold(key, []byte(u.forwardedForHeader)) {
+			return
+		}
+		for _, hop := range bytes.Split(value, []byte{','}) {
+			hop = bytes.TrimSpace(hop)
+			if len(hop) == 0 {
+				continue
+			}
+			hops = append(hops, trustedproxy.ParseIP(string(hop)))
+		}
+	})
+
+	return hops
+}
+
+// Scheme returns the original request scheme, if set by a trusted proxy
+func (u *Unwrapper) Scheme(ctx *gramework.Context) string {
+	proto := strings.ToLower(u.trustedValue(ctx, u.forwardedProtoHeader))
+	if proto != "http" && proto != "https" {
+		return ""
+	}
+
+	return proto
+}
+
+// Host returns the original request host, if set by a trusted proxy
+func (u *Unwrapper) Host(ctx *gramework.Context) string {
+	return u.trustedValue(ctx, u.forwardedHostHeader)
+}
+
+// Port returns the original request port, if set by a trusted proxy
+func (u *Unwrapper) Port(ctx *gramework.Context) int {
+	if port, err := strconv.Atoi(u.trustedValue(ctx, u.forwardedPortHeader)); err == nil && port > 0 && port < 65536 {
+		return port
+	}
+
+	return trustedproxy.HostPort(u.Host(ctx))
+}
+
+func (u *Unwrapper) trustedValue(ctx *gramework.Context, header string) string {
+	if len(header) == 0 || !u.matcher.Contains(ctx.RequestCtx.RemoteIP()) {
+		return ""
+	}
+
+	return trustedproxy.LastValue(ctx.Request.Header.Peek(header))
+}
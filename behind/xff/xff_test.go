@@ -0,0 +1,145 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package xff
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gramework/gramework"
+	"github.com/valyala/fasthttp"
+)
+
+func newCtx(peer string, headers map[string][]string) *gramework.Context {
+	req := &fasthttp.Request{}
+	for k, values := range headers {
+		for _, v := range values {
+			req.Header.Add(k, v)
+		}
+	}
+	fhctx := &fasthttp.RequestCtx{}
+	fhctx.Init(req, &net.TCPAddr{IP: net.ParseIP(peer), Port: 4242}, nil)
+	return &gramework.Context{RequestCtx: fhctx}
+}
+
+func TestRemoteIP(t *testing.T) {
+	u := New(TrustedProxies("10.0.0.0/8", "192.0.2.1"))
+
+	type tcase struct {
+		name     string
+		peer     string
+		headers  map[string][]string
+		expected string
+	}
+	cases := []tcase{
+		{
+			name:     "untrusted peer",
+			peer:     "198.51.100.7",
+			headers:  map[string][]string{"X-Forwarded-For": {"203.0.113.5"}},
+			expected: "198.51.100.7",
+		},
+		{
+			name:     "single hop",
+			peer:     "10.0.0.1",
+			headers:  map[string][]string{"X-Forwarded-For": {"203.0.113.5"}},
+			expected: "203.0.113.5",
+		},
+		{
+			name:     "spoofed leftmost hop",
+			peer:     "10.0.0.1",
+			headers:  map[string][]string{"X-Forwarded-For": {"1.1.1.1, 203.0.113.5, 192.0.2.1"}},
+			expected: "203.0.113.5",
+		},
+		{
+			name:     "multiple headers",
+			peer:     "10.0.0.1",
+			headers:  map[string][]string{"X-Forwarded-For": {"203.0.113.5", "10.1.1.1"}},
+			expected: "203.0.113.5",
+		},
+		{
+			name:     "all hops trusted",
+			peer:     "10.0.0.1",
+			headers:  map[string][]string{"X-Forwarded-For": {"10.2.2.2, 10.1.1.1"}},
+			expected: "10.2.2.2",
+		},
+		{
+			name:     "invalid hop",
+			peer:     "10.0.0.1",
+			headers:  map[string][]string{"X-Forwarded-For": {"203.0.113.5, garbage, 10.1.1.1"}},
+			expected: "10.1.1.1",
+		},
+		{
+			name:     "real ip fallback",
+			peer:     "10.0.0.1",
+			headers:  map[string][]string{"X-Real-IP": {"203.0.113.9"}},
+			expected: "203.0.113.9",
+		},
+		{
+			name:     "ipv6 hop with port",
+			peer:     "10.0.0.1",
+			headers:  map[string][]string{"X-Forwarded-For": {"[2001:db8::1]:443"}},
+			expected: "2001:db8::1",
+		},
+	}
+
+	for _, tc := range cases {
+		ctx := newCtx(tc.peer, tc.headers)
+		if ip := u.RemoteIP(ctx); ip.String() != tc.expected {
+			t.Errorf("%s: unexpected RemoteIP: got %q, expected %q", tc.name, ip, tc.expected)
+		}
+		// repeat to check the cached lookup path
+		if ip := u.RemoteIP(ctx); ip.String() != tc.expected {
+			t.Errorf("%s: unexpected cached RemoteIP: got %q, expected %q", tc.name, ip, tc.expected)
+		}
+	}
+}
+
+func TestRemoteAddr(t *testing.T) {
+	u := New(TrustedProxies("10.0.0.0/8"), DisableCache())
+
+	ctx := newCtx("10.0.0.1", map[string][]string{"X-Forwarded-For": {"203.0.113.5"}})
+	addr, ok := u.RemoteAddr(ctx).(*net.TCPAddr)
+	if !ok || !addr.IP.Equal(net.ParseIP("203.0.113.5")) {
+		t.Errorf("unexpected RemoteAddr: %v", u.RemoteAddr(ctx))
+	}
+
+	ctx = newCtx("198.51.100.7", map[string][]string{"X-Forwarded-For": {"203.0.113.5"}})
+	if addr := u.RemoteAddr(ctx); addr.String() != "198.51.100.7:4242" {
+		t.Errorf("unexpected RemoteAddr for untrusted peer: %v", addr)
+	}
+}
+
+func TestForwardedInfo(t *testing.T) {
+	u := New(TrustedProxies("10.0.0.0/8"))
+
+	ctx := newCtx("10.0.0.1", map[string][]string{
+		"X-Forwarded-Proto": {"http, HTTPS"},
+		"X-Forwarded-Host":  {"example.com"},
+		"X-Forwarded-Port":  {"8443"},
+	})
+	if scheme := u.Scheme(ctx); scheme != "https" {
+		t.Errorf("unexpected Scheme: %q", scheme)
+	}
+	if host := u.Host(ctx); host != "example.com" {
+		t.Errorf("unexpected Host: %q", host)
+	}
+	if port := u.Port(ctx); port != 8443 {
+		t.Errorf("unexpected Port: %d", port)
+	}
+
+	ctx = newCtx("198.51.100.7", map[string][]string{
+		"X-Forwarded-Proto": {"https"},
+		"X-Forwarded-Host":  {"example.com"},
+	})
+	if scheme, host := u.Scheme(ctx), u.Host(ctx); scheme != "" || host != "" {
+		t.Errorf("untrusted peer headers should be ignored, got %q %q", scheme, host)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"net"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+type testForwardedBehind struct {
+	scheme string
+	host   string
+	port   int
+}
+
+func (b *testForwardedBehind) RemoteIP(ctx *Context) net.IP     { return ctx.RequestCtx.RemoteIP() }
+func (b *testForwardedBehind) RemoteAddr(ctx *Context) net.Addr { return ctx.RequestCtx.RemoteAddr() }
+func (b *testForwardedBehind) Scheme(ctx *Context) string       { return b.scheme }
+func (b *testForwardedBehind) Host(ctx *Context) string         { return b.host }
+func (b *testForwardedBehind) Port(ctx *Context) int            { return b.port }
+
+func testBehindCtx(app *App, host string) *Context {
+	req := &fasthttp.Request{}
+	req.SetRequestURI("/path?a=b")
+	req.Header.SetHost(host)
+	fhctx := &fasthttp.RequestCtx{}
+	fhctx.Init(req, nil, nil)
+	return &Context{RequestCtx: fhctx, App: app}
+}
+
+func TestContextForwardedInfo(t *testing.T) {
+	app := New()
+	ctx := testBehindCtx(app, "internal:8080")
+
+	if ctx.Scheme() != "http" || ctx.IsTLS() {
+		t.Errorf("unexpected scheme without proxy: %q", ctx.Scheme())
+	}
+	if string(ctx.Host()) != "internal:8080" || ctx.Port() != 8080 {
+		t.Errorf("unexpected host without proxy: %q:%d", ctx.Host(), ctx.Port())
+	}
+
+	app.Behind(&testForwardedBehind{scheme: "https", host: "example.com"})
+	if ctx.Scheme() != "https" || !ctx.IsTLS() {
+		t.Errorf("unexpected scheme behind proxy: %q", ctx.Scheme())
+	}
+	if string(ctx.Host()) != "example.com" || ctx.Port() != 443 {
+		t.Errorf("unexpected host behind proxy: %q:%d", ctx.Host(), ctx.Port())
+	}
+
+	app.Behind(&testForwardedBehind{scheme: "https", host: "example.com", port: 8443})
+	if ctx.Port() != 8443 {
+		t.Errorf("unexpected port behind proxy: %d", ctx.Port())
+	}
+}
+
+func TestToTLSBehindProxy(t *testing.T) {
+	app := New()
+	app.Behind(&testForwardedBehind{host: "example.com"})
+	ctx := testBehindCtx(app, "internal:8080")
+
+	ctx.ToTLS()
+	if loc := string(ctx.Response.Header.Peek("Location")); loc != "https://example.com/path?a=b" {
+		t.Errorf("unexpected redirect location: %q", loc)
+	}
+}
+
+func TestHTTPSRouterBehindProxy(t *testing.T) {
+	app := New()
+	app.HTTPS().GET("/path", "https")
+	app.HTTP().GET("/path", "http")
+
+	ctx := testBehindCtx(app, "example.com")
+	app.defaultRouter.handler(ctx)
+	if body := string(ctx.Response.Body()); body != "http" {
+		t.Errorf("unexpected response without proxy: %q", body)
+	}
+
+	app.Behind(&testForwardedBehind{scheme: "https"})
+	ctx = testBehindCtx(app, "example.com")
+	app.defaultRouter.handler(ctx)
+	if body := string(ctx.Response.Body()); body != "https" {
+		t.Errorf("unexpected response behind TLS-terminating proxy: %q", body)
+	}
+}
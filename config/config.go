@@ -0,0 +1,382 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+// Package config loads a typed configuration struct from defaults,
+// YAML/JSON/TOML files, environment variables and command-line flags,
+// in that precedence, and validates it.
+//
+// Each struct field has a key, set by the `config` tag or derived from
+// the field name, e.g. MaxBodySize becomes "max-body-size". Keys of nested
+// structs are joined with dots: "tls.cert-file". The same key is used
+// in files, as a flag name ("-tls.cert-file") and as an environment variable
+// with the prefix ("APP_TLS_CERT_FILE").
+//
+// Supported tags:
+//
+//	config:"name"      overrides the key, "-" skips the field
+//	default:"value"    the value used when the field is zero
+//	usage:"text"       the flag description
+//	validate:"required" fails the loading if the field is zero
+//
+// Embedded structs are flattened. Structs implementing Validator are
+// validated after loading.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Validator is implemented by configuration structs with custom validation
+type Validator interface {
+	Validate() error
+}
+
+// Option configures the Loader
+type Option func(*Loader)
+
+// Loader loads configuration structs
+type Loader struct {
+	files     []configFile
+	envPrefix string
+	lookupEnv func(string) (string, bool)
+	flagSet   *flag.FlagSet
+	args      []string
+	noFlags   bool
+}
+
+type configFile struct {
+	path     string
+	optional bool
+}
+
+// ErrNotStructPointer occurs when Load called with a value,
+// that is not a pointer to a struct
+var ErrNotStructPointer = errors.New("config: destination must be a pointer to a struct")
+
+// FieldError describes an error of loading the field
+type FieldError struct {
+	Key    string
+	Source string
+	Err    error
+}
+
+// Error implements error interface
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("config: %s: %s: %s", e.Source, e.Key, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// File loads configuration from the file. The format is detected
+// by the extension: .yaml, .yml, .json or .toml.
+func File(path string) Option {
+	return func(l *Loader) {
+		l.files = append(l.files, configFile{path: path})
+	}
+}
+
+// OptionalFile loads configuration from the file, if it exists. See File.
+func OptionalFile(path string) Option {
+	return func(l *Loader) {
+		l.files = append(l.files, configFile{path: path, optional: true})
+	}
+}
+
+// EnvPrefix enables loading from environment variables with the prefix,
+// e.g. with "APP" prefix the "tls.cert-file" key is loaded from APP_TLS_CERT_FILE.
+// An empty prefix disables environment loading.
+func EnvPrefix(prefix string) Option {
+	return func(l *Loader) {
+		l.envPrefix = prefix
+	}
+}
+
+// LookupEnv replaces os.LookupEnv, e.g. for tests
+func LookupEnv(lookup func(string) (string, bool)) Option {
+	return func(l *Loader) {
+		l.lookupEnv = lookup
+	}
+}
+
+// FlagSet sets the flag set and arguments to parse.
+// By default, flag.CommandLine and os.Args[1:] are used.
+// If the flag set is already parsed, e.g. with flag.Parse(), the arguments
+// are parsed again, so config flags are loaded too.
+func FlagSet(fs *flag.FlagSet, args []string) Option {
+	return func(l *Loader) {
+		l.flagSet = fs
+		l.args = args
+	}
+}
+
+// NoFlags disables loading from command-line flags
+func NoFlags() Option {
+	return func(l *Loader) {
+		l.noFlags = true
+	}
+}
+
+// New returns a new Loader
+func New(opts ...Option) *Loader {
+	l := &Loader{
+		lookupEnv: os.LookupEnv,
+		flagSet:   flag.CommandLine,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.args == nil && l.flagSet == flag.CommandLine && len(os.Args) > 0 {
+		l.args = os.Args[1:]
+	}
+
+	return l
+}
+
+// Load loads the configuration into dst, which must be a pointer to a struct.
+// Values already set in dst are used as defaults.
+func Load(dst interface{}, opts ...Option) error {
+	return New(opts...).Load(dst)
+}
+
+// Load loads the configuration into dst, which must be a pointer to a struct.
+// Values already set in dst are used as defaults.
+func (l *Loader) Load(dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return ErrNotStructPointer
+	}
+
+	fields := collectFields(v.Elem(), nil)
+
+	for _, f := range fields {
+		if len(f.defaultValue) > 0 && f.value.IsZero() {
+			if err := setString(f.value, f.defaultValue); err != nil {
+				return &FieldError{Key: f.key, Source: "default", Err: err}
+			}
+		}
+	}
+
+	for _, file := range l.files {
+		if err := l.loadFile(fields, file); err != nil {
+			return err
+		}
+	}
+
+	if len(l.envPrefix) > 0 {
+		for _, f := range fields {
+			raw, ok := l.lookupEnv(f.envName(l.envPrefix))
+			if !ok {
+				continue
+			}
+			if err := setString(f.value, raw); err != nil {
+				return &FieldError{Key: f.key, Source: "env " + f.envName(l.envPrefix), Err: err}
+			}
+		}
+	}
+
+	if !l.noFlags && l.flagSet != nil {
+		if err := l.loadFlags(fields); err != nil {
+			return err
+		}
+	}
+
+	return validate(v.Elem(), fields)
+}
+
+func (l *Loader) loadFile(fields []*field, file configFile) error {
+	data, err := os.ReadFile(file.path)
+	if err != nil {
+		if file.optional && os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("config: %s", err)
+	}
+
+	values, err := decodeFile(file.path, data)
+	if err != nil {
+		return fmt.Errorf("config: %s: %s", file.path, err)
+	}
+
+	for _, f := range fields {
+		raw, ok := lookupPath(values, f.path)
+		if !ok {
+			continue
+		}
+		if err = setRaw(f.value, raw); err != nil {
+			return &FieldError{Key: f.key, Source: file.path, Err: err}
+		}
+	}
+
+	return nil
+}
+
+func (l *Loader) loadFlags(fields []*field) error {
+	byKey := make(map[string]*field, len(fields))
+	for _, f := range fields {
+		byKey[f.key] = f
+	}
+
+	fs := l.flagSet
+	if fs.Parsed() {
+		// e.g. the app has called flag.Parse() before: values of its flags are applied,
+		// and the arguments are parsed again to load config flags too
+		if err := applyFlags(fs, byKey); err != nil {
+			return err
+		}
+		fs = parsedFlagSetCopy(fs)
+	}
+	for _, f := range fields {
+		if fs.Lookup(f.key) != nil {
+			// already defined, e.g. with gramework's App.AddFlag
+			continue
+		}
+		fs.Var(&flagValue{
+			value:  valueString(f.value),
+			isBool: f.value.Kind() == reflect.Bool,
+		}, f.key, f.usage)
+	}
+
+	if err := fs.Parse(l.args); err != nil {
+		return err
+	}
+
+	return applyFlags(fs, byKey)
+}
+
+// applyFlags sets fields to values of flags, set in the parsed flag set
+func applyFlags(fs *flag.FlagSet, byKey map[string]*field) error {
+	var err error
+	fs.Visit(func(fl *flag.Flag) {
+		f, ok := byKey[fl.Name]
+		if !ok || err != nil {
+			return
+		}
+		if setErr := setString(f.value, fl.Value.String()); setErr != nil {
+			err = &FieldError{Key: f.key, Source: "flag", Err: setErr}
+		}
+	})
+
+	return err
+}
+
+// parsedFlagSetCopy returns a new flag set with the same flags, e.g. when the app
+// has already called flag.Parse(), so the arguments can be parsed again for config flags
+// without changing the values of the app's flags
+func parsedFlagSetCopy(parsed *flag.FlagSet) *flag.FlagSet {
+	fs := flag.NewFlagSet(parsed.Name(), flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	parsed.VisitAll(func(fl *flag.Flag) {
+		boolFlag, ok := fl.Value.(interface{ IsBoolFlag() bool })
+		fs.Var(&flagValue{
+			value:  fl.DefValue,
+			isBool: ok && boolFlag.IsBoolFlag(),
+		}, fl.Name, fl.Usage)
+	})
+
+	return fs
+}
+
+// flagValue holds the raw flag value until it is applied to the field
+type flagValue struct {
+	value  string
+	isBool bool
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return v.value
+}
+
+func (v *flagValue) Set(s string) error {
+	v.value = s
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
+func validate(root reflect.Value, fields []*field) error {
+	for _, f := range fields {
+		if f.required && f.value.IsZero() {
+			return &FieldError{Key: f.key, Source: "validate", Err: errors.New("required")}
+		}
+	}
+
+	return validateStruct(root)
+}
+
+// validateStruct calls Validate of the struct and its nested structs
+func validateStruct(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		fv := v.Field(i)
+		sf := t.Field(i)
+		if (sf.PkgPath != "" && !sf.Anonymous) || fv.Kind() != reflect.Struct {
+			continue
+		}
+		if err := validateStruct(fv); err != nil {
+			return err
+		}
+	}
+
+	if v.CanAddr() && v.Addr().CanInterface() {
+		if validator, ok := v.Addr().Interface().(Validator); ok {
+			return validator.Validate()
+		}
+	}
+
+	return nil
+}
+
+// Keys returns all configuration keys of the struct, e.g. for documentation
+func Keys(dst interface{}) []string {
+	v := reflect.ValueOf(dst)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	if !v.CanAddr() {
+		ptr := reflect.New(v.Type())
+		ptr.Elem().Set(v)
+		v = ptr.Elem()
+	}
+
+	fields := collectFields(v, nil)
+	keys := make([]string, 0, len(fields))
+	for _, f := range fields {
+		keys = append(keys, f.key)
+	}
+
+	return keys
+}
+
+// EnvName returns the environment variable name for the key with the prefix
+func EnvName(prefix, key string) string {
+	name := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+	if len(prefix) == 0 {
+		return name
+	}
+
+	return strings.ToUpper(prefix) + "_" + name
+}
@@ -0,0 +1,175 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package config
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testDB struct {
+	DSN      string        `validate:"required"`
+	MaxConns int           `default:"10"`
+	Timeout  time.Duration `default:"5s"`
+}
+
+type testEmbedded struct {
+	Debug bool
+}
+
+type testConfig struct {
+	testEmbedded
+	Name     string `config:"app-name" default:"app"`
+	Bind     []string
+	Port     uint16
+	Ratio    float64
+	DB       testDB `config:"db"`
+	Skipped  string `config:"-"`
+	HTTPHost string
+}
+
+func (c *testConfig) Validate() error {
+	if c.Port == 1 {
+		return errors.New("port 1 is not allowed")
+	}
+	return nil
+}
+
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKeys(t *testing.T) {
+	expected := []string{"debug", "app-name", "bind", "port", "ratio", "db.dsn", "db.max-conns", "db.timeout", "http-host"}
+	if keys := Keys(testConfig{}); !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	if name := EnvName("app", "db.max-conns"); name != "APP_DB_MAX_CONNS" {
+		t.Fatalf("unexpected env name: %q", name)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": "app-name: from-file\nport: 8080\nbind: [':80', ':81']\ndb:\n  dsn: postgres://file\n  max_conns: 20\n",
+		"config.json": `{"app-name": "from-file", "port": 8080, "bind": [":80", ":81"], "db": {"dsn": "postgres://file", "maxConns": 20}}`,
+		"config.toml": "app-name = \"from-file\"\nport = 8080\nbind = [\":80\", \":81\"]\n[db]\ndsn = \"postgres://file\"\nmax-conns = 20\n",
+	}
+
+	for name, data := range files {
+		t.Run(name, func(t *testing.T) {
+			env := map[string]string{
+				"APP_PORT":       "9090",
+				"APP_DB_TIMEOUT": "1m",
+				"APP_RATIO":      "0.5",
+			}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.String("http-host", "", "defined outside of config")
+
+			cfg := &testConfig{Skipped: "keep"}
+			err := Load(cfg,
+				File(writeFile(t, name, data)),
+				OptionalFile(filepath.Join(t.TempDir(), "missing.yaml")),
+				EnvPrefix("APP"),
+				LookupEnv(func(key string) (string, bool) {
+					v, ok := env[key]
+					return v, ok
+				}),
+				FlagSet(fs, []string{"-port", "7070", "-debug", "-db.max-conns=30", "-http-host", "example.com"}),
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			expected := &testConfig{
+				testEmbedded: testEmbedded{Debug: true},
+				Name:         "from-file",
+				Bind:         []string{":80", ":81"},
+				Port:         7070,
+				Ratio:        0.5,
+				DB: testDB{
+					DSN:      "postgres://file",
+					MaxConns: 30,
+					Timeout:  time.Minute,
+				},
+				Skipped:  "keep",
+				HTTPHost: "example.com",
+			}
+			if !reflect.DeepEqual(cfg, expected) {
+				t.Fatalf("unexpected config:\n%+v\nexpected:\n%+v", cfg, expected)
+			}
+		})
+	}
+}
+
+func TestLoadParsedFlagSet(t *testing.T) {
+	args := []string{"-verbose", "-port", "7070", "-http-host", "example.com"}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	verbose := fs.Bool("verbose", false, "defined outside of config")
+	// the app has parsed the flags before loading the config
+	// and ignored the error of unknown config flags
+	_ = fs.Parse(args)
+
+	cfg := &testConfig{DB: testDB{DSN: "postgres://default"}}
+	if err := Load(cfg, FlagSet(fs, args)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.Port != 7070 || cfg.HTTPHost != "example.com" {
+		t.Fatalf("config flags of the parsed flag set are not loaded: %+v", cfg)
+	}
+	if !*verbose || fs.Lookup("port") != nil {
+		t.Fatalf("the parsed flag set should not be changed")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	noFlags := NoFlags()
+
+	if err := Load(testConfig{}, noFlags); err != ErrNotStructPointer {
+		t.Errorf("expected ErrNotStructPointer, got %v", err)
+	}
+
+	err := Load(&testConfig{}, noFlags)
+	fieldErr := &FieldError{}
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "db.dsn" {
+		t.Errorf("expected required field error, got %v", err)
+	}
+
+	err = Load(&testConfig{Port: 1, DB: testDB{DSN: "x"}}, noFlags)
+	if err == nil || err.Error() != "port 1 is not allowed" {
+		t.Errorf("expected custom validation error, got %v", err)
+	}
+
+	err = Load(&testConfig{}, noFlags, File(writeFile(t, "bad.yaml", "port: not-a-number\n")))
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "port" {
+		t.Errorf("expected port parse error, got %v", err)
+	}
+
+	if err = Load(&testConfig{}, noFlags, File(writeFile(t, "config.ini", "port=1"))); err == nil {
+		t.Errorf("expected unsupported format error")
+	}
+
+	if err = Load(&testConfig{}, noFlags, File(filepath.Join(t.TempDir(), "missing.yaml"))); err == nil {
+		t.Errorf("expected missing file error")
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package config
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+type field struct {
+	path         []string
+	key          string
+	value        reflect.Value
+	defaultValue string
+	usage        string
+	required     bool
+}
+
+func (f *field) envName(prefix string) string {
+	return EnvName(prefix, f.key)
+}
+
+// collectFields returns all leaf fields of the struct
+func collectFields(v reflect.Value, path []string) []*field {
+	var fields []*field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" && !(sf.Anonymous && sf.Type.Kind() == reflect.Struct) {
+			continue
+		}
+		name := sf.Tag.Get("config")
+		if name == "-" {
+			continue
+		}
+		fv := v.Field(i)
+
+		if isNested(fv.Type()) {
+			if sf.Anonymous && len(name) == 0 {
+				fields = append(fields, collectFields(fv, path)...)
+				continue
+			}
+			if len(name) == 0 {
+				name = keyName(sf.Name)
+			}
+			fields = append(fields, collectFields(fv, appendPath(path, name))...)
+			continue
+		}
+
+		if len(name) == 0 {
+			name = keyName(sf.Name)
+		}
+		fieldPath := appendPath(path, name)
+		fields = append(fields, &field{
+			path:         fieldPath,
+			key:          strings.Join(fieldPath, "."),
+			value:        fv,
+			defaultValue: sf.Tag.Get("default"),
+			usage:        sf.Tag.Get("usage"),
+			required:     sf.Tag.Get("validate") == "required",
+		})
+	}
+
+	return fields
+}
+
+func appendPath(path []string, name string) []string {
+	return append(append([]string(nil), path...), name)
+}
+
+func isNested(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && !reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+// keyName converts the field name to the key, e.g. MaxBodySize to max-body-size
+func keyName(name string) string {
+	runes := []rune(name)
+	b := strings.Builder{}
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			prevLower := i > 0 && !unicode.IsUpper(runes[i-1])
+			nextLower := i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1])
+			if prevLower || nextLower {
+				b.WriteByte('-')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// normalizeKey makes file keys comparison case- and separator-insensitive
+func normalizeKey(key string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(key))
+}
+
+// lookupPath finds the value in decoded file
+func lookupPath(values map[string]interface{}, path []string) (interface{}, bool) {
+	var current interface{} = values
+	for _, name := range path {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = lookupKey(m, name)
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+func lookupKey(m map[string]interface{}, name string) (interface{}, bool) {
+	if v, ok := m[name]; ok {
+		return v, true
+	}
+	normalized := normalizeKey(name)
+	for k, v := range m {
+		if normalizeKey(k) == normalized {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
+
+// setRaw sets the value decoded from a file
+func setRaw(v reflect.Value, raw interface{}) error {
+	switch r := raw.(type) {
+	case nil:
+		return nil
+	case string:
+		return setString(v, r)
+	case []interface{}:
+		if v.Kind() != reflect.Slice {
+			return fmt.Errorf("can't set list to %s", v.Type())
+		}
+		slice := reflect.MakeSlice(v.Type(), len(r), len(r))
+		for i, item := range r {
+			if err := setRaw(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	case map[string]interface{}:
+		return fmt.Errorf("can't set map to %s", v.Type())
+	case time.Time:
+		if v.Type() == reflect.TypeOf(r) {
+			v.Set(reflect.ValueOf(r))
+			return nil
+		}
+		return setString(v, r.Format(time.RFC3339Nano))
+	}
+
+	return setString(v, fmt.Sprint(raw))
+}
+
+// setString parses s into the value
+func setString(v reflect.Value, s string) error {
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var parts []string
+		if len(strings.TrimSpace(s)) > 0 {
+			parts = strings.Split(s, ",")
+		}
+		slice := reflect.MakeSlice(v.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := setString(slice.Index(i), strings.TrimSpace(part)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case reflect.Ptr:
+		ptr := reflect.New(v.Type().Elem())
+		if err := setString(ptr.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(ptr)
+	default:
+		return errors.New("unsupported type " + v.Type().String())
+	}
+
+	return nil
+}
+
+// valueString formats the value for flag defaults
+func valueString(v reflect.Value) string {
+	if v.CanAddr() && v.Addr().CanInterface() {
+		if stringer, ok := v.Addr().Interface().(fmt.Stringer); ok {
+			return stringer.String()
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Slice:
+		parts := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			parts = append(parts, valueString(v.Index(i)))
+		}
+		return strings.Join(parts, ",")
+	case reflect.Ptr:
+		if v.IsNil() {
+			return ""
+		}
+		return valueString(v.Elem())
+	}
+
+	return fmt.Sprint(v.Interface())
+}
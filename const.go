@@ -49,6 +49,7 @@ const (
 	hOrigin                           = "Origin"
 	htmlCT                            = "text/html; charset=utf8"
 	https                             = "https"
+	httpScheme                        = "http"
 	jsonCT                            = "application/json;charset=utf8"
 	jsonCTshort                       = "application/json"
 	delimiterCTParams                 = ";"
@@ -65,5 +66,7 @@ const (
 	zero                              = 0
 	// ContextKey defines where in context.Context will be stored gramework.Context for current request
 	ContextKey contextKey = "gramework:request:ctx"
-	// plainCT                        = "text/plain"
+	// loggerContextKey defines where in context.Context will be stored the request logger
+	loggerContextKey contextKey = "gramework:request:logger"
+	plainCT                     = "text/plain; charset=utf-8"
 )
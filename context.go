@@ -18,6 +18,7 @@ import (
 
 	"github.com/microcosm-cc/bluemonday"
 	"github.com/pquerna/ffjson/ffjson"
+	"github.com/valyala/fasthttp"
 
 	"github.com/gocarina/gocsv"
 )
@@ -84,14 +85,23 @@ func (ctx *Context) ContentType() string {
 
 // ToContext returns context.Context with gramework.Context stored
 // in context values as a pointer (see gramework.ContextKey to receive and use this value).
+// The request logger and span are stored too, see LoggerFromContext and SpanFromContext.
 //
 // By default this func will extend context.Background(), if parentCtx is not provided.
 func (ctx *Context) ToContext(parentCtx ...context.Context) context.Context {
+	parent := context.Background()
 	if len(parentCtx) > 0 {
-		return context.WithValue(parentCtx[0], ContextKey, ctx)
+		parent = parentCtx[0]
 	}
 
-	return context.WithValue(context.Background(), ContextKey, ctx)
+	c := context.WithValue(parent, ContextKey, ctx)
+	if ctx.Logger != nil {
+		c = ContextWithLogger(c, ctx.Logger)
+	}
+	if ctx.span != nil {
+		c = ContextWithSpan(c, ctx.span)
+	}
+	return c
 }
 
 // RouteArg returns an argument value as a string or empty string
@@ -168,7 +178,10 @@ func (ctx *Context) RouteArgErr(argName string) (string, error) {
 
 // ToTLS redirects user to HTTPS scheme
 func (ctx *Context) ToTLS() {
-	u := ctx.URI()
+	u := fasthttp.AcquireURI()
+	defer fasthttp.ReleaseURI(u)
+	ctx.URI().CopyTo(u)
+	u.SetHostBytes(ctx.Host())
 	u.SetScheme(https)
 	ctx.Redirect(u.String(), redirectCode)
 }
@@ -220,3 +233,15 @@ func (ctx *Context) jsonErrorLog(v interface{}) {
 func (ctx *Context) RequestID() string {
 	return ctx.requestID
 }
+
+// RouteTemplate returns the registered route, that matched the request,
+// e.g. "/users/:id". It is empty if no route matched, e.g. for 404 responses.
+func (ctx *Context) RouteTemplate() string {
+	return ctx.routeTemplate
+}
+
+// Domain returns the domain of app.Domain() router, that served the request.
+// It is empty if the request was served by the default router.
+func (ctx *Context) Domain() string {
+	return ctx.domain
+}
@@ -0,0 +1,188 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// SPIFFEScheme is the URI scheme of SPIFFE IDs
+const SPIFFEScheme = "spiffe"
+
+// PeerIdentity is the identity of the client, authenticated with a TLS certificate
+type PeerIdentity struct {
+	Subject        pkix.Name
+	CommonName     string
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+	// SPIFFEID is the first SPIFFE URI SAN, e.g. "spiffe://example.org/ns/prod/sa/billing"
+	SPIFFEID string
+}
+
+// NewPeerIdentity returns the identity described by the certificate
+func NewPeerIdentity(cert *x509.Certificate) *PeerIdentity {
+	if cert == nil {
+		return nil
+	}
+
+	identity := &PeerIdentity{
+		Subject:        cert.Subject,
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		IPAddresses:    cert.IPAddresses,
+		URIs:           cert.URIs,
+	}
+	for _, uri := range cert.URIs {
+		if uri.Scheme == SPIFFEScheme {
+			identity.SPIFFEID = uri.String()
+			break
+		}
+	}
+
+	return identity
+}
+
+// SPIFFETrustDomain returns the trust domain of the SPIFFE ID, e.g. "example.org"
+func (id *PeerIdentity) SPIFFETrustDomain() string {
+	for _, uri := range id.URIs {
+		if uri.Scheme == SPIFFEScheme {
+			return uri.Host
+		}
+	}
+
+	return ""
+}
+
+// ClientCertificate returns the client certificate, verified during
+// the TLS handshake, or nil if the client did not provide one.
+// See OptTLSClientAuth.
+func (ctx *Context) ClientCertificate() *x509.Certificate {
+	state := ctx.TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return state.VerifiedChains[0][0]
+}
+
+// PeerIdentity returns the identity of the client, authenticated with
+// a verified TLS certificate, or nil if the client is not authenticated.
+func (ctx *Context) PeerIdentity() *PeerIdentity {
+	return NewPeerIdentity(ctx.ClientCertificate())
+}
+
+// PeerAuthorizer reports if the client with given identity is allowed
+type PeerAuthorizer func(id *PeerIdentity) bool
+
+// AllowSPIFFEIDs allows clients with any of the SPIFFE IDs
+func AllowSPIFFEIDs(ids ...string) PeerAuthorizer {
+	return func(id *PeerIdentity) bool {
+		return containsString(ids, id.SPIFFEID)
+	}
+}
+
+// AllowSPIFFETrustDomains allows clients with SPIFFE IDs from any of the trust domains
+func AllowSPIFFETrustDomains(domains ...string) PeerAuthorizer {
+	return func(id *PeerIdentity) bool {
+		return containsString(domains, id.SPIFFETrustDomain())
+	}
+}
+
+// AllowCommonNames allows clients with any of the subject common names
+func AllowCommonNames(names ...string) PeerAuthorizer {
+	return func(id *PeerIdentity) bool {
+		return containsString(names, id.CommonName)
+	}
+}
+
+// AllowDNSNames allows clients with any of the DNS SANs
+func AllowDNSNames(names ...string) PeerAuthorizer {
+	return func(id *PeerIdentity) bool {
+		for _, name := range id.DNSNames {
+			if containsString(names, strings.ToLower(name)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func containsString(list []string, s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorizePeer checks the client identity and sends 403 Forbidden
+// if the client is not allowed
+func authorizePeer(ctx *Context, allow []PeerAuthorizer) bool {
+	id := ctx.PeerIdentity()
+	if id == nil {
+		ctx.Logger.Debug("no verified client certificate provided")
+		ctx.Forbidden()
+		return false
+	}
+
+	for _, authorizer := range allow {
+		if authorizer(id) {
+			return true
+		}
+	}
+
+	ctx.Logger.
+		WithField("subject", id.Subject.String()).
+		WithField("spiffeID", id.SPIFFEID).
+		Warn("client is not authorized")
+	ctx.Forbidden()
+	return false
+}
+
+// AuthorizePeer wraps the handler, so it is called only for clients
+// with a verified TLS certificate, allowed by any of the authorizers.
+// Other clients receive 403 Forbidden.
+//
+// Example:
+//
+//	app.GET("/billing", gramework.AuthorizePeer(billingHandler,
+//		gramework.AllowSPIFFEIDs("spiffe://example.org/ns/prod/sa/orders"),
+//	))
+func AuthorizePeer(handler func(*Context), allow ...PeerAuthorizer) func(*Context) {
+	return func(ctx *Context) {
+		if authorizePeer(ctx, allow) {
+			handler(ctx)
+		}
+	}
+}
+
+// PeerAuthMiddleware returns a middleware for app.Use, that allows only
+// clients with a verified TLS certificate, allowed by any of the authorizers.
+// Other clients receive 403 Forbidden.
+func PeerAuthMiddleware(allow ...PeerAuthorizer) func(*Context) {
+	return func(ctx *Context) {
+		if !authorizePeer(ctx, allow) {
+			ctx.MWKill()
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// testClientCert returns a self-signed client certificate with the SPIFFE ID
+// and writes it to the file, that can be used as a client CA
+func testClientCert(t *testing.T, dir, cn, spiffeID string) (tls.Certificate, string) {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri, err := url.Parse(spiffeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		URIs:                  []*url.URL{uri},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caFile := filepath.Join(dir, cn+"-ca.crt")
+	if err = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  priv,
+	}, caFile
+}
+
+func TestPeerIdentityMTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := testWriteCert(t, dir, "server", "localhost")
+	orders, ordersCA := testClientCert(t, dir, "orders", "spiffe://example.org/ns/prod/sa/orders")
+	stranger, strangerCA := testClientCert(t, dir, "stranger", "spiffe://evil.org/sa/stranger")
+
+	pool, err := LoadCertPool(ordersCA, strangerCA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadCertPool(certFile + ".missing"); err == nil {
+		t.Fatalf("expected error for missing CA file")
+	}
+
+	app := New(OptTLSClientAuth(tls.VerifyClientCertIfGiven, pool))
+	app.GET("/whoami", func(ctx *Context) {
+		id := ctx.PeerIdentity()
+		if id == nil {
+			ctx.WriteString("anonymous")
+			return
+		}
+		ctx.WriteString(id.CommonName + " " + id.SPIFFEID + " " + id.SPIFFETrustDomain())
+	})
+	app.GET("/billing", AuthorizePeer(func(ctx *Context) {
+		ctx.WriteString("billing")
+	}, AllowSPIFFETrustDomains("example.org")))
+
+	addr := testFreeAddr(t)
+	done := make(chan error, 1)
+	go func() {
+		done <- app.ListenAndServeTLS(addr, certFile, keyFile)
+	}()
+	defer func() {
+		_ = app.Shutdown()
+		<-done
+	}()
+
+	get := func(path string, certs ...tls.Certificate) (int, string) {
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+					Certificates:       certs,
+				},
+			},
+		}
+		var (
+			resp *http.Response
+			err  error
+		)
+		for i := 0; i < 50; i++ {
+			resp, err = client.Get("https://" + addr + path)
+			if err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("request failed: %s", err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	cases := []struct {
+		path   string
+		certs  []tls.Certificate
+		code   int
+		expect string
+	}{
+		{"/whoami", nil, 200, "anonymous"},
+		{"/whoami", []tls.Certificate{orders}, 200, "orders spiffe://example.org/ns/prod/sa/orders example.org"},
+		{"/billing", nil, 403, ""},
+		{"/billing", []tls.Certificate{stranger}, 403, ""},
+		{"/billing", []tls.Certificate{orders}, 200, "billing"},
+	}
+	for _, c := range cases {
+		code, body := get(c.path, c.certs...)
+		if code != c.code || (len(c.expect) > 0 && body != c.expect) {
+			t.Errorf("%s with %d certs: unexpected response %d %q", c.path, len(c.certs), code, body)
+		}
+	}
+}
+
+func TestTLSClientAuthConfig(t *testing.T) {
+	cfg, err := New(OptTLSClientAuth(tls.RequireAnyClientCert, nil)).tlsConfig()
+	if err != nil || cfg.ClientAuth != tls.RequireAnyClientCert {
+		t.Fatalf("client auth should be applied without client CAs, got %v: %v", cfg, err)
+	}
+
+	for _, authType := range []tls.ClientAuthType{tls.VerifyClientCertIfGiven, tls.RequireAndVerifyClientCert} {
+		if _, err = New(OptTLSClientAuth(authType, nil)).tlsConfig(); err != ErrTLSNoClientCAs {
+			t.Errorf("%v: expected ErrTLSNoClientCAs, got %v", authType, err)
+		}
+	}
+
+	cfg, err = New(OptTLSClientAuth(tls.RequireAndVerifyClientCert, x509.NewCertPool())).tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.NextProtos = []string{"http/1.1", acme.ALPNProto}
+	skipACMEClientAuth(cfg)
+
+	acmeConfig, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{SupportedProtos: []string{acme.ALPNProto}})
+	if err != nil || acmeConfig == nil || acmeConfig.ClientAuth != tls.NoClientCert {
+		t.Fatalf("TLS-ALPN-01 handshakes should not request client certificates, got %v: %v", acmeConfig, err)
+	}
+	clientConfig, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{SupportedProtos: []string{"http/1.1", acme.ALPNProto}})
+	if err != nil || clientConfig != nil {
+		t.Fatalf("other handshakes should use the client auth config, got %v: %v", clientConfig, err)
+	}
+}
+
+func TestPeerAuthorizers(t *testing.T) {
+	uri, _ := url.Parse("spiffe://example.org/sa/api")
+	id := NewPeerIdentity(&x509.Certificate{
+		Subject:  pkix.Name{CommonName: "api"},
+		DNSNames: []string{"API.internal"},
+		URIs:     []*url.URL{uri},
+	})
+
+	cases := []struct {
+		name   string
+		allow  PeerAuthorizer
+		expect bool
+	}{
+		{"spiffe id", AllowSPIFFEIDs("spiffe://example.org/sa/api"), true},
+		{"other spiffe id", AllowSPIFFEIDs("spiffe://example.org/sa/web"), false},
+		{"trust domain", AllowSPIFFETrustDomains("example.org"), true},
+		{"common name", AllowCommonNames("api"), true},
+		{"dns name", AllowDNSNames("api.internal"), true},
+		{"other dns name", AllowDNSNames("web.internal"), false},
+	}
+	for _, c := range cases {
+		if got := c.allow(id); got != c.expect {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expect, got)
+		}
+	}
+
+	if NewPeerIdentity(nil) != nil {
+		t.Errorf("expected nil identity for nil certificate")
+	}
+}
@@ -14,13 +14,24 @@ import (
 	"github.com/valyala/fasthttp"
 )
 
-// Proxy request to given url
+// Proxy request to given url.
+// If the request is traced, the proxied request continues the trace.
 func (ctx *Context) Proxy(url string) (err error) {
 	proxyReq := fasthttp.AcquireRequest()
 	ctx.Request.CopyTo(proxyReq)
 	proxyReq.SetRequestURI(url)
 
+	span := ctx.span.StartChild(string(proxyReq.Header.Method()), SpanKindClient)
+	span.SetAttribute(SpanAttrURLFull, url)
+	InjectTraceContext(&proxyReq.Header, span.Context())
+
 	err = fasthttp.Do(proxyReq, &ctx.Response)
+	if err != nil {
+		span.RecordError(err)
+	} else {
+		span.SetHTTPStatus(ctx.Response.StatusCode())
+	}
+	span.End()
 
 	fasthttp.ReleaseRequest(proxyReq)
 	return
@@ -0,0 +1,114 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"context"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// TraceIDLogField is the request logger field that contains the trace ID
+	TraceIDLogField = "trace_id"
+
+	// spanContextKey defines where in context.Context will be stored the current span
+	spanContextKey contextKey = "gramework:request:span"
+)
+
+// OptTracer enables request tracing: every request gets a server span,
+// that continues the trace from W3C traceparent and tracestate headers,
+// with child spans for middleware phases. The tracer is shut down
+// with the app, see App.OnShutdown.
+func OptTracer(t *Tracer) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.tracer = t
+		app.OnShutdown(t.Shutdown)
+	}
+}
+
+// Tracer returns the app tracer, or nil if the tracing is disabled
+func (app *App) Tracer() *Tracer {
+	return app.tracer
+}
+
+// Span returns the request span, or nil if the tracing is disabled.
+// It's safe to call Span methods on nil.
+func (ctx *Context) Span() *Span {
+	return ctx.span
+}
+
+// StartSpan starts a child span of the request span,
+// e.g. to trace a database query. Don't forget to End it.
+func (ctx *Context) StartSpan(name string) *Span {
+	return ctx.span.StartChild(name, SpanKindInternal)
+}
+
+// ContextWithSpan returns a copy of parent with the span stored in it,
+// see SpanFromContext
+func ContextWithSpan(parent context.Context, span *Span) context.Context {
+	return context.WithValue(parent, spanContextKey, span)
+}
+
+// SpanFromContext returns the span stored by Context.ToContext or ContextWithSpan,
+// or nil if there's no span
+func SpanFromContext(c context.Context) *Span {
+	if c == nil {
+		return nil
+	}
+
+	span, _ := c.Value(spanContextKey).(*Span)
+	return span
+}
+
+// startRequestSpan starts the server span of the request
+func (app *App) startRequestSpan(ctx *Context) *Span {
+	parent, _ := ExtractTraceContext(&ctx.Request.Header)
+	method := string(ctx.Method())
+	span := app.tracer.StartSpan(parent, method, SpanKindServer)
+	span.SetAttribute(SpanAttrHTTPMethod, method)
+	span.SetAttribute(SpanAttrURLPath, string(ctx.Path()))
+	span.SetAttribute(SpanAttrRequestID, ctx.requestID)
+	if ip := ctx.RemoteIP(); ip != nil {
+		span.SetAttribute(SpanAttrClientAddress, ip.String())
+	}
+
+	ctx.span = span
+	ctx.Logger = ctx.Logger.WithField(TraceIDLogField, span.Context().TraceID.String())
+	return span
+}
+
+// endRequestSpan ends the server span of the request. It doesn't use
+// the gramework context, because the context may be already released.
+func endRequestSpan(span *Span, fhctx *fasthttp.RequestCtx) {
+	span.SetHTTPStatus(fhctx.Response.StatusCode())
+	span.End()
+}
+
+// setSpanRoute names the request span after the route template
+func (ctx *Context) setSpanRoute(route string) {
+	if ctx.span == nil {
+		return
+	}
+
+	ctx.span.SetName(string(ctx.Method()) + " " + route)
+	ctx.span.SetAttribute(SpanAttrHTTPRoute, route)
+}
+
+// startPhaseSpan starts the middleware phase span, if the phase has any middlewares
+func (ctx *Context) startPhaseSpan(name string, count int) *Span {
+	if count == 0 {
+		return nil
+	}
+
+	return ctx.span.StartChild(name, SpanKindInternal)
+}
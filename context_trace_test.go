@@ -0,0 +1,134 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func testTracedApp(t *testing.T) (*App, *InMemoryExporter) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter)
+	t.Cleanup(func() {
+		_ = tracer.Shutdown(context.Background())
+	})
+	return New(OptTracer(tracer)), exporter
+}
+
+func testFlushSpans(t *testing.T, app *App, exporter *InMemoryExporter) map[string]SpanData {
+	if err := app.Tracer().Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	spans := make(map[string]SpanData)
+	for _, s := range exporter.Spans() {
+		spans[s.Name] = s
+	}
+	exporter.Reset()
+	return spans
+}
+
+func TestRequestSpan(t *testing.T) {
+	app, exporter := testTracedApp(t)
+	if err := app.UsePre(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.UseAfterRequest(func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	var fromContext *Span
+	app.GET("/users/:id", func(ctx *Context) {
+		fromContext = SpanFromContext(ctx.ToContext())
+		ctx.StartSpan("db query").End()
+	})
+	app.GET("/panic", func() {
+		panic("test panic")
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/users/42")
+	ctx.Request.Header.Set(TraceParentHeader, testTraceParent)
+	app.handler()(ctx)
+
+	spans := testFlushSpans(t, app, exporter)
+	server, ok := spans["GET /users/:id"]
+	if !ok {
+		t.Fatalf("server span was not exported: %+v", spans)
+	}
+	if server.Kind != SpanKindServer || server.SpanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" ||
+		server.ParentSpanID.String() != "00f067aa0ba902b7" {
+		t.Fatalf("server span should continue the trace: %+v", server)
+	}
+	if fromContext == nil || fromContext.Context() != server.SpanContext {
+		t.Fatalf("ToContext should propagate the request span")
+	}
+	attrs := make(map[string]interface{})
+	for _, a := range server.Attributes {
+		attrs[a.Key] = a.Value
+	}
+	if attrs[SpanAttrHTTPRoute] != "/users/:id" || attrs[SpanAttrHTTPStatusCode] != 200 || attrs[SpanAttrURLPath] != "/users/42" {
+		t.Fatalf("unexpected server span attributes: %v", attrs)
+	}
+	for _, name := range []string{"pre middlewares", "after request middlewares", "db query"} {
+		if s, ok := spans[name]; !ok || s.ParentSpanID != server.SpanContext.SpanID {
+			t.Errorf("span %q is not a child of the server span: %+v", name, s)
+		}
+	}
+	if _, ok := spans["middlewares"]; ok {
+		t.Errorf("empty middleware phase should not be traced")
+	}
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/panic")
+	app.handler()(ctx)
+
+	spans = testFlushSpans(t, app, exporter)
+	if s := spans["GET /panic"]; s.Status != SpanStatusError || s.StatusMessage != "panic: test panic" {
+		t.Fatalf("panicked request should have error status: %+v", s)
+	}
+}
+
+func TestProxyTraceContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 1)
+	go func() {
+		_ = fasthttp.Serve(ln, func(ctx *fasthttp.RequestCtx) {
+			received <- string(ctx.Request.Header.Peek(TraceParentHeader))
+		})
+	}()
+	defer ln.Close()
+
+	app, exporter := testTracedApp(t)
+	app.GET("/proxy", func(ctx *Context) error {
+		return ctx.Proxy("http://" + ln.Addr().String() + "/")
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/proxy")
+	ctx.Request.Header.Set(TraceParentHeader, testTraceParent)
+	app.handler()(ctx)
+
+	spans := testFlushSpans(t, app, exporter)
+	client, ok := spans["GET"]
+	if !ok || client.Kind != SpanKindClient {
+		t.Fatalf("client span was not exported: %+v", spans)
+	}
+	if got := <-received; got != client.SpanContext.TraceParent() {
+		t.Fatalf("proxied request has traceparent %q, expected %q", got, client.SpanContext.TraceParent())
+	}
+}
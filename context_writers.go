@@ -87,7 +87,7 @@ func (ctx *Context) CORS(domains ...string) *Context {
 	} else if headerOrigin := ctx.Request.Header.Peek(hOrigin); len(headerOrigin) > 0 {
 		origins = append(origins, string(headerOrigin))
 	} else {
-		origins = append(origins, string(ctx.Request.URI().Host()))
+		origins = append(origins, string(ctx.Host()))
 	}
 
 	ctx.Response.Header.Set(corsAccessControlAllowOrigin, strings.Join(origins, " "))
@@ -12,6 +12,7 @@ package gramework
 
 import (
 	"errors"
+	"strings"
 )
 
 var (
@@ -24,3 +25,30 @@ var (
 	// ErrInvalidGQLRequest used in DecodeGQL
 	ErrInvalidGQLRequest = errors.New("invalid gql request")
 )
+
+// MultiError is a list of errors, occurred during a single operation,
+// e.g. while running shutdown hooks
+type MultiError []error
+
+// Error implements error interface
+func (e MultiError) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Err returns nil if the list is empty, the only error if there's one,
+// or the list itself otherwise
+func (e MultiError) Err() error {
+	switch len(e) {
+	case 0:
+		return nil
+	case 1:
+		return e[0]
+	default:
+		return e
+	}
+}
@@ -7,6 +7,7 @@
 package gramework
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/apex/log"
@@ -284,12 +285,17 @@ func (r *router) Recv(ctx *Context, tracer *log.Entry) {
 		} else {
 			DefaultPanicHandler(ctx, rcv)
 		}
+		ctx.span.SetStatus(SpanStatusError, fmt.Sprintf("panic: %v", rcv))
 		if tracer != nil {
 			tracer.WithFields(log.Fields{
 				"reason": rcv,
 				"code":   ctx.Response.StatusCode(),
 			}).Error("request caused panic")
 		}
+		if ctx.App != nil && !ctx.afterRequestStarted {
+			// let the after request middlewares, e.g. access log, see the response
+			ctx.App.runMiddlewaresAfterRequest(ctx)
+		}
 	}
 }
 
@@ -1,7 +1,9 @@
 module github.com/gramework/gramework
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/VictoriaMetrics/fastcache v1.12.0
+	github.com/andybalholm/brotli v1.0.4
 	github.com/apex/log v1.9.0
 	github.com/cloudfoundry/gosigar v1.3.4
 	github.com/fasthttp/websocket v1.4.3
@@ -10,6 +12,7 @@ require (
 	github.com/gramework/utils v0.0.0-20190202181041-3c30a162ea26
 	github.com/graph-gophers/graphql-go v1.4.0
 	github.com/kirillDanshin/go-accept-headers v0.0.0-20130320203746-c78f304b1b09
+	github.com/klauspost/compress v1.15.11
 	github.com/microcosm-cc/bluemonday v1.0.20
 	github.com/pkg/errors v0.9.1
 	github.com/pquerna/ffjson v0.0.0-20190930134022-aa0246cd15f7
@@ -18,10 +21,10 @@ require (
 	github.com/valyala/bytebufferpool v1.0.0
 	github.com/valyala/fasthttp v1.40.0
 	golang.org/x/crypto v0.0.0-20220926161630-eccd6366d1be
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/andybalholm/brotli v1.0.4 // indirect
 	github.com/aymerick/douceur v0.2.0 // indirect
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
@@ -30,7 +33,6 @@ require (
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/golang/snappy v0.0.4 // indirect
 	github.com/gorilla/css v1.0.0 // indirect
-	github.com/klauspost/compress v1.15.11 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.16 // indirect
 	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
@@ -43,7 +45,6 @@ require (
 	golang.org/x/sys v0.0.0-20220928140112-f11e5e49a4ec // indirect
 	golang.org/x/text v0.3.7 // indirect
 	google.golang.org/protobuf v1.26.0 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20200605160147-a5ece683394c // indirect
 )
 
 go 1.18
@@ -2,22 +2,34 @@ package healthchecks
 
 // Register both ping and healthcheck endpoints
 func Register(r interface{}, collectors ...func() (statKey string, stats interface{})) error {
-	return doReg(r, collectors, true, true)
+	return doReg(r, DefaultThresholds(), collectors, true, true)
 }
 
 // RegisterPing registers ping endpoint
 func RegisterPing(r interface{}) error {
-	return doReg(r, nil, true, false)
+	return doReg(r, DefaultThresholds(), nil, true, false)
 }
 
-// RegisterHealthcheck registers healthcheck endpoint
+// RegisterHealthcheck registers healthcheck endpoint.
+// The response also contains the status and history of checks
+// registered with App.AddHealthCheck.
 func RegisterHealthcheck(r interface{}, collectors ...func() (statKey string, stats interface{})) error {
-	return doReg(r, collectors, false, true)
+	return doReg(r, DefaultThresholds(), collectors, false, true)
+}
+
+// RegisterHealthcheckWithThresholds registers healthcheck endpoint with custom resource alert thresholds
+func RegisterHealthcheckWithThresholds(r interface{}, th Thresholds, collectors ...func() (statKey string, stats interface{})) error {
+	return doReg(r, th, collectors, false, true)
 }
 
 // ServeHealthcheck serves healthcheck
 func ServeHealthcheck(collectors ...func() (statKey string, stats interface{})) func() interface{} {
+	return ServeHealthcheckWithThresholds(DefaultThresholds(), collectors...)
+}
+
+// ServeHealthcheckWithThresholds serves healthcheck with custom resource alert thresholds
+func ServeHealthcheckWithThresholds(th Thresholds, collectors ...func() (statKey string, stats interface{})) func() interface{} {
 	return func() interface{} {
-		return check(collectors...)
+		return check(th, collectors...)
 	}
 }
@@ -2,8 +2,8 @@ package healthchecks
 
 import (
 	"errors"
-	"runtime"
 	"strings"
+	"sync"
 
 	sigar "github.com/cloudfoundry/gosigar"
 	"github.com/gramework/gramework"
@@ -11,16 +11,24 @@ import (
 )
 
 type hc struct {
-	CPUClock string  `json:"cpu_clock"`
-	RAM      ramJSON `json:"ram_usage"`
-	Swap     ramJSON `json:"swap_usage"`
+	Status string `json:"status"`
+
+	CPUClock  string  `json:"cpu_clock"`
+	CPUUsage  float64 `json:"cpu_usage_percent"`
+	CPUStatus string  `json:"cpu_alert_status,omitempty"`
+
+	RAM        ramJSON `json:"ram_usage"`
+	RAMStatus  string  `json:"ram_alert_status,omitempty"`
+	Swap       ramJSON `json:"swap_usage"`
+	SwapStatus string  `json:"swap_alert_status,omitempty"`
 
 	LA         laJSON `json:"load_average"`
 	LoadStatus string `json:"load_alert_status"`
 
 	Uptime string `json:"uptime"`
 
-	Custom map[string]interface{} `json:"custom_metrics,omitempty"`
+	Checks []gramework.HealthCheckStatus `json:"checks,omitempty"`
+	Custom map[string]interface{}        `json:"custom_metrics,omitempty"`
 }
 
 type laJSON struct {
@@ -32,12 +40,19 @@ type laJSON struct {
 type ramJSON struct {
 	Used  string `json:"used"`
 	Total string `json:"total"`
+
+	usedPercent float64
 }
 
 type sigarWrapper struct {
 	sigar.ConcreteSigar
 }
 
+var (
+	lastCPUMu sync.Mutex
+	lastCPU   sigar.Cpu
+)
+
 func (s sigarWrapper) swap() ramJSON {
 	swap, err := s.GetSwap()
 	if err != nil {
@@ -45,8 +60,9 @@ func (s sigarWrapper) swap() ramJSON {
 		_ = err
 	}
 	return ramJSON{
-		Used:  gfmt.Si(swap.Used),
-		Total: gfmt.Si(swap.Total),
+		Used:        gfmt.Si(swap.Used),
+		Total:       gfmt.Si(swap.Total),
+		usedPercent: percent(swap.Used, swap.Total),
 	}
 }
 func (s sigarWrapper) ram() ramJSON {
@@ -56,12 +72,36 @@ func (s sigarWrapper) ram() ramJSON {
 		_ = err
 	}
 	return ramJSON{
-		Used:  gfmt.Si(mem.Used),
-		Total: gfmt.Si(mem.Total),
+		Used:        gfmt.Si(mem.Used),
+		Total:       gfmt.Si(mem.Total),
+		usedPercent: percent(mem.Used, mem.Total),
+	}
+}
+
+// cpuUsage returns CPU usage percent since the previous call
+// or since boot on the first call
+func (s sigarWrapper) cpuUsage() (float64, error) {
+	cpu := sigar.Cpu{}
+	if err := cpu.Get(); err != nil {
+		return 0, err
 	}
+
+	lastCPUMu.Lock()
+	delta := cpu.Delta(lastCPU)
+	lastCPU = cpu
+	lastCPUMu.Unlock()
+
+	return 100 - percent(delta.Idle+delta.Wait, delta.Total()), nil
+}
+
+func percent(used, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(used) / float64(total) * 100
 }
 
-func doReg(r interface{}, collectors []func() (statKey string, stats interface{}), registerPing, registerHC bool) error {
+func doReg(r interface{}, th Thresholds, collectors []func() (statKey string, stats interface{}), registerPing, registerHC bool) error {
 	app, isApp := r.(*gramework.App)
 	sr, isSr := r.(*gramework.SubRouter)
 	if !isApp && !isSr {
@@ -76,43 +116,53 @@ func doReg(r interface{}, collectors []func() (statKey string, stats interface{}
 		sr.GET("/ping", "pong")
 	}
 	if registerHC {
-		sr.GET("/healthcheck", ServeHealthcheck(collectors...))
+		sr.GET("/healthcheck", func(ctx *gramework.Context) interface{} {
+			currentCheck := check(th, collectors...)
+			currentCheck.Checks = ctx.App.HealthStatus()
+			return currentCheck
+		})
 	}
 
 	return nil
 }
 
-func check(collectors ...func() (statKey string, stats interface{})) interface{} {
+func check(th Thresholds, collectors ...func() (statKey string, stats interface{})) *hc {
 	s := sigarWrapper{sigar.ConcreteSigar{}}
 	currentCheck := &hc{
 		CPUClock:   gfmt.Si(uint64(gramework.TicksPerSecond())),
 		RAM:        s.ram(),
 		Swap:       s.swap(),
-		LoadStatus: "<unknown>",
+		LoadStatus: statusUnknown,
+	}
+	statuses := make([]string, 0, 4)
+
+	if usage, err := s.cpuUsage(); err == nil {
+		currentCheck.CPUUsage = usage
+		currentCheck.CPUStatus = th.CPU.status(usage)
+		statuses = append(statuses, currentCheck.CPUStatus)
 	}
+	currentCheck.RAMStatus = th.RAM.status(currentCheck.RAM.usedPercent)
+	currentCheck.SwapStatus = th.Swap.status(currentCheck.Swap.usedPercent)
+	statuses = append(statuses, currentCheck.RAMStatus, currentCheck.SwapStatus)
+
 	la, err := s.GetLoadAverage()
 	if err != nil {
 		err = la.Get() // retry
 	}
 
 	if err == nil {
-		maxLA := float64(runtime.NumCPU() + 2)
 		currentCheck.LA = laJSON(la)
-		diffOne := maxLA - la.One
-		diffFive := maxLA - la.Five
-		diffFifteen := maxLA - la.Fifteen
-
-		alertTrigger := float64(-3)
-		warnTrigger := float64(0)
-
-		if diffOne < alertTrigger || diffFive < alertTrigger || diffFifteen < alertTrigger {
-			currentCheck.LoadStatus = "alert"
-		} else if diffOne < warnTrigger || diffFive < warnTrigger || diffFifteen < warnTrigger {
-			currentCheck.LoadStatus = "warn"
-		} else {
-			currentCheck.LoadStatus = "ok"
+		currentCheck.LoadStatus = worstStatus(
+			th.Load.status(la.One),
+			th.Load.status(la.Five),
+			th.Load.status(la.Fifteen),
+		)
+		if currentCheck.LoadStatus == "" {
+			currentCheck.LoadStatus = statusUnknown
 		}
 	}
+	statuses = append(statuses, currentCheck.LoadStatus)
+	currentCheck.Status = worstStatus(statuses...)
 
 	uptime := sigar.Uptime{}
 	err = uptime.Get()
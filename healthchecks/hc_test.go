@@ -0,0 +1,58 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package healthchecks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/gramework/gramework"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestRegisterHealthcheck(t *testing.T) {
+	app := gramework.New()
+	app.AddHealthCheck("db", func(context.Context) error {
+		return errors.New("connection refused")
+	})
+	if err := RegisterHealthcheckWithThresholds(app.Sub("/internal"), DefaultThresholds()); err != nil {
+		t.Fatal(err)
+	}
+	app.Probes()
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go func() {
+		_ = app.Serve(ln)
+	}()
+	c := &fasthttp.Client{
+		Dial: func(string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+
+	if _, _, err := c.Get(nil, "http://localhost/readyz"); err != nil {
+		t.Fatal(err)
+	}
+	code, body, err := c.Get(nil, "http://localhost/internal/healthcheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`"load_alert_status"`, `"name":"db"`, `"last_failure":"connection refused"`} {
+		if code != fasthttp.StatusOK || !strings.Contains(string(body), s) {
+			t.Errorf("healthcheck response should contain %s, got %d: %s", s, code, body)
+		}
+	}
+}
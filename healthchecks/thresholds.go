@@ -0,0 +1,83 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package healthchecks
+
+import "runtime"
+
+const (
+	statusUnknown = "<unknown>"
+	statusOK      = "ok"
+	statusWarn    = "warn"
+	statusAlert   = "alert"
+)
+
+// Threshold sets the values, above which the resource status is "warn" or "alert".
+// Zero value disables the threshold.
+type Threshold struct {
+	Warn  float64
+	Alert float64
+}
+
+// Thresholds configures resource alerts of the healthcheck endpoint
+type Thresholds struct {
+	// CPU usage in percent since the previous healthcheck
+	CPU Threshold
+	// RAM usage in percent
+	RAM Threshold
+	// Swap usage in percent
+	Swap Threshold
+	// Load average, checked for all of 1, 5 and 15 minutes
+	Load Threshold
+}
+
+// DefaultThresholds returns thresholds used by Register and RegisterHealthcheck:
+// load average warns above NumCPU()+2 and alerts above NumCPU()+5,
+// CPU, RAM and swap thresholds are disabled
+func DefaultThresholds() Thresholds {
+	maxLA := float64(runtime.NumCPU() + 2)
+	return Thresholds{
+		Load: Threshold{
+			Warn:  maxLA,
+			Alert: maxLA + 3,
+		},
+	}
+}
+
+func (t Threshold) status(value float64) string {
+	switch {
+	case t.Warn == 0 && t.Alert == 0:
+		return ""
+	case t.Alert > 0 && value > t.Alert:
+		return statusAlert
+	case t.Warn > 0 && value > t.Warn:
+		return statusWarn
+	default:
+		return statusOK
+	}
+}
+
+var statusRanks = map[string]int{
+	statusUnknown: 1,
+	statusOK:      2,
+	statusWarn:    3,
+	statusAlert:   4,
+}
+
+// worstStatus returns the most severe of statuses, ignoring disabled ones
+func worstStatus(statuses ...string) string {
+	worst := ""
+	for _, s := range statuses {
+		if statusRanks[s] > statusRanks[worst] {
+			worst = s
+		}
+	}
+	return worst
+}
@@ -0,0 +1,53 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package healthchecks
+
+import "testing"
+
+func TestThresholds(t *testing.T) {
+	th := Threshold{Warn: 80, Alert: 95}
+	for value, expected := range map[float64]string{
+		10: statusOK,
+		80: statusOK,
+		81: statusWarn,
+		96: statusAlert,
+	} {
+		if s := th.status(value); s != expected {
+			t.Errorf("status(%v) = %q, expected %q", value, s, expected)
+		}
+	}
+
+	if s := (Threshold{}).status(100); s != "" {
+		t.Errorf("disabled threshold should not report a status, got %q", s)
+	}
+	if s := worstStatus("", statusOK, statusUnknown, statusWarn, statusOK); s != statusWarn {
+		t.Errorf("unexpected worst status %q", s)
+	}
+	if s := worstStatus("", ""); s != "" {
+		t.Errorf("unexpected worst status of disabled thresholds %q", s)
+	}
+}
+
+func TestCheckThresholds(t *testing.T) {
+	c := check(Thresholds{
+		RAM:  Threshold{Warn: 0.001, Alert: 1000},
+		Load: Threshold{Warn: 1000},
+	})
+	if c.RAMStatus != statusWarn || c.SwapStatus != "" {
+		t.Errorf("unexpected RAM and swap statuses: %q, %q", c.RAMStatus, c.SwapStatus)
+	}
+	if c.LoadStatus != statusOK && c.LoadStatus != statusUnknown {
+		t.Errorf("unexpected load status %q", c.LoadStatus)
+	}
+	if c.Status != statusWarn {
+		t.Errorf("unexpected overall status %q", c.Status)
+	}
+}
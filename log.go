@@ -20,8 +20,6 @@ import (
 	"github.com/valyala/fasthttp"
 )
 
-var enableDebug = true
-
 var currentEnvironment *int32
 
 // Environment defines which environment gramework application runs in.
@@ -77,7 +75,8 @@ func isEnvEquals(rawEnv string) bool {
 	return strings.HasPrefix(grameEnv, rawEnv) || strings.HasPrefix(env, rawEnv)
 }
 
-// SetEnv sets gramework's environment
+// SetEnv sets gramework's environment.
+// It doesn't change the level of existing loggers, see OptLogLevel.
 func SetEnv(e Environment) {
 	if e != DEV && e != STAGE && e != PROD {
 		internalLog.Warn("could not set unknown environment value, ignoring")
@@ -89,13 +88,6 @@ func SetEnv(e Environment) {
 			WithField("newEnv", e).
 			Warn("Setting a new environment")
 	}
-	if e == PROD {
-		Logger.Level = log.InfoLevel
-		enableDebug = false
-	} else {
-		enableDebug = true
-		Logger.Level = log.DebugLevel
-	}
 	atomic.StoreInt32(currentEnvironment, int32(e))
 }
 
@@ -113,12 +105,22 @@ type FastHTTPLoggerAdapter struct {
 	fasthttp.Logger
 }
 
-// Logger handles default logger
+// Logger handles default logger.
+// Its level is debug, or info if the app runs in PROD environment
+// according to the GRAMEWORK_ENV or ENV environment variables.
 var Logger = &log.Logger{
-	Level:   log.InfoLevel,
+	Level:   defaultLogLevel(),
 	Handler: cli.New(os.Stdout),
 }
 
+func defaultLogLevel() log.Level {
+	if isEnvEquals("prod") {
+		return log.InfoLevel
+	}
+
+	return log.DebugLevel
+}
+
 // Errorf logs an error using default logger
 func Errorf(msg string, v ...interface{}) {
 	Logger.Errorf(msg, v...)
@@ -137,18 +139,12 @@ func (l *FastHTTPLoggerAdapter) Printf(msg string, v ...interface{}) {
 	l.apexLogger.Debugf(msg, v...)
 }
 
-var internalLog = func() *log.Entry {
-	Logger.Level = log.DebugLevel
-	if !enableDebug {
-		Logger.Level = log.InfoLevel
-	}
-
-	return Logger.WithField("package", "gramework")
-}()
+var internalLog = Logger.WithField(PackageLogField, internalPackage)
 
-// SetInternalLogger allows to change internal log used for handler registration logs etc.
+// SetInternalLogger allows to change internal log used by package-level functions,
+// e.g. SetEnv. Apps use their own loggers, see App.PackageLogger.
 func SetInternalLogger(log log.Interface) {
 	if log != nil {
-		internalLog = log.WithField("package", "gramework")
+		internalLog = log.WithField(PackageLogField, internalPackage)
 	}
 }
@@ -0,0 +1,329 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/apex/log"
+)
+
+// LogLevel is a logging backend independent log level.
+// Values are the same as log/slog levels use.
+type LogLevel int
+
+const (
+	// LevelDebug is the debug log level
+	LevelDebug LogLevel = -4
+	// LevelInfo is the info log level
+	LevelInfo LogLevel = 0
+	// LevelWarn is the warning log level
+	LevelWarn LogLevel = 4
+	// LevelError is the error log level
+	LevelError LogLevel = 8
+	// LevelFatal is the fatal log level
+	LevelFatal LogLevel = 12
+)
+
+const (
+	// PackageLogField is the log field that contains the name of
+	// the gramework package that produced the log, e.g. "gramework" or "mw/accesslog"
+	PackageLogField = "package"
+	// RouteLogField is the log field that contains the route template
+	// of the request, see Context.RouteTemplate
+	RouteLogField = "route"
+
+	// internalPackage is the package name used by gramework internals
+	internalPackage = "gramework"
+)
+
+var logLevelNames = map[string]LogLevel{
+	"debug":   LevelDebug,
+	"info":    LevelInfo,
+	"warn":    LevelWarn,
+	"warning": LevelWarn,
+	"error":   LevelError,
+	"fatal":   LevelFatal,
+}
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	case LevelFatal:
+		return "fatal"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// ParseLogLevel parses a log level name: debug, info, warn, error or fatal
+func ParseLogLevel(s string) (LogLevel, error) {
+	lvl, ok := logLevelNames[strings.ToLower(strings.TrimSpace(s))]
+	if !ok {
+		return LevelInfo, fmt.Errorf("unknown log level %q", s)
+	}
+
+	return lvl, nil
+}
+
+func (l LogLevel) apex() log.Level {
+	switch {
+	case l <= LevelDebug:
+		return log.DebugLevel
+	case l <= LevelInfo:
+		return log.InfoLevel
+	case l <= LevelWarn:
+		return log.WarnLevel
+	case l <= LevelError:
+		return log.ErrorLevel
+	default:
+		return log.FatalLevel
+	}
+}
+
+func logLevelFromApex(l log.Level) LogLevel {
+	switch l {
+	case log.DebugLevel:
+		return LevelDebug
+	case log.InfoLevel:
+		return LevelInfo
+	case log.WarnLevel:
+		return LevelWarn
+	case log.ErrorLevel:
+		return LevelError
+	default:
+		return LevelFatal
+	}
+}
+
+// LogField is a structured log field
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// LogSink is a structured logging backend. Use it to send gramework and
+// app logs to log/slog (see NewSlogSink), zap, zerolog or any other logger,
+// e.g. with zerolog:
+//
+//	sink := gramework.LogSinkFunc(func(level gramework.LogLevel, msg string, fields []gramework.LogField) {
+//		e := zl.WithLevel(zerolog.Level(level/4 + 1))
+//		for _, f := range fields {
+//			e = e.Interface(f.Key, f.Value)
+//		}
+//		e.Msg(msg)
+//	})
+//	app := gramework.New(gramework.OptLogSink(sink))
+//
+// Log fields are sorted by key.
+type LogSink interface {
+	// Enabled reports if the sink handles records of the level
+	Enabled(level LogLevel) bool
+	// Log writes the record
+	Log(level LogLevel, msg string, fields []LogField)
+}
+
+// LogSinkFunc is a LogSink, that handles records of any level
+type LogSinkFunc func(level LogLevel, msg string, fields []LogField)
+
+// Enabled always returns true
+func (f LogSinkFunc) Enabled(LogLevel) bool {
+	return true
+}
+
+// Log calls f
+func (f LogSinkFunc) Log(level LogLevel, msg string, fields []LogField) {
+	f(level, msg, fields)
+}
+
+// NewSinkLogger returns a logger, that writes to the sink.
+// It may be used anywhere gramework expects a logger,
+// e.g. in OptUseCustomLogger or SetInternalLogger.
+func NewSinkLogger(sink LogSink) *log.Logger {
+	return &log.Logger{
+		Handler: &sinkHandler{sink: sink},
+		Level:   log.DebugLevel,
+	}
+}
+
+type sinkHandler struct {
+	sink LogSink
+}
+
+func (h *sinkHandler) HandleLog(e *log.Entry) error {
+	level := logLevelFromApex(e.Level)
+	if !h.sink.Enabled(level) {
+		return nil
+	}
+
+	fields := make([]LogField, 0, len(e.Fields))
+	for _, name := range e.Fields.Names() {
+		fields = append(fields, LogField{Key: name, Value: e.Fields.Get(name)})
+	}
+
+	h.sink.Log(level, e.Message, fields)
+	return nil
+}
+
+// interfaceHandler passes entries to any logger,
+// so the level may be filtered before the logger does it
+type interfaceHandler struct {
+	logger log.Interface
+}
+
+func (h interfaceHandler) HandleLog(e *log.Entry) error {
+	l := h.logger.WithFields(e.Fields)
+	switch e.Level {
+	case log.DebugLevel:
+		l.Debug(e.Message)
+	case log.InfoLevel:
+		l.Info(e.Message)
+	case log.WarnLevel:
+		l.Warn(e.Message)
+	case log.ErrorLevel:
+		l.Error(e.Message)
+	default:
+		l.Fatal(e.Message)
+	}
+	return nil
+}
+
+// handlerOf returns the handler that writes to the logger without level filtering
+func handlerOf(logger log.Interface) log.Handler {
+	if l, ok := logger.(*log.Logger); ok && l.Handler != nil {
+		return l.Handler
+	}
+
+	return interfaceHandler{logger: logger}
+}
+
+// OptLogSink makes the app log to the sink instead of the default logger
+func OptLogSink(sink LogSink) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.Logger = NewSinkLogger(sink)
+	}
+}
+
+// OptLogLevel sets the app logger level. It doesn't change the level
+// of the default gramework.Logger or of other apps.
+func OptLogLevel(level LogLevel) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.logLevel = &level
+	}
+}
+
+// OptPackageLogLevel sets the log level for the gramework package,
+// e.g. "gramework" for the framework internals or "mw/accesslog".
+// By default, packages log with the app logger level.
+func OptPackageLogLevel(pkg string, level LogLevel) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		if app.packageLogLevels == nil {
+			app.packageLogLevels = make(map[string]LogLevel)
+		}
+		app.packageLogLevels[pkg] = level
+	}
+}
+
+// SetLogLevel sets the app logger level. If the app uses the default
+// gramework.Logger, the app gets its own copy of it, so the default
+// logger and other apps are not affected.
+// It should be called before the app starts serving.
+func (app *App) SetLogLevel(level LogLevel) {
+	switch l := app.Logger.(type) {
+	case *log.Logger:
+		if l == Logger {
+			app.Logger = &log.Logger{
+				Handler: Logger.Handler,
+				Level:   level.apex(),
+			}
+		} else {
+			l.Level = level.apex()
+		}
+	default:
+		app.Logger = &log.Logger{
+			Handler: interfaceHandler{logger: l},
+			Level:   level.apex(),
+		}
+	}
+
+	app.initLoggers()
+}
+
+// PackageLogger returns the app logger for the gramework package, e.g. "mw/xhostname".
+// Records have the PackageLogField field and the package log level,
+// see OptPackageLogLevel.
+func (app *App) PackageLogger(pkg string) *log.Entry {
+	level, ok := app.packageLogLevels[pkg]
+	if !ok {
+		if l, ok := app.Logger.(*log.Logger); ok {
+			return l.WithField(PackageLogField, pkg)
+		}
+		return (&log.Logger{
+			Handler: interfaceHandler{logger: app.Logger},
+			Level:   log.DebugLevel,
+		}).WithField(PackageLogField, pkg)
+	}
+
+	return (&log.Logger{
+		Handler: handlerOf(app.Logger),
+		Level:   level.apex(),
+	}).WithField(PackageLogField, pkg)
+}
+
+// initLoggers updates loggers derived from the app logger
+func (app *App) initLoggers() {
+	app.internalLog = app.PackageLogger(internalPackage)
+	if app.serverBase != nil {
+		app.serverBase.Logger = NewFastHTTPLoggerAdapter(&app.Logger)
+	}
+}
+
+// AddLogField adds the field to the request logger, e.g. the user ID.
+// The field is also present in loggers received with LoggerFromContext.
+func (ctx *Context) AddLogField(key string, value interface{}) {
+	ctx.Logger = ctx.Logger.WithField(key, value)
+}
+
+// AddLogFields adds the fields to the request logger
+func (ctx *Context) AddLogFields(fields log.Fields) {
+	ctx.Logger = ctx.Logger.WithFields(fields)
+}
+
+// ContextWithLogger returns a copy of parent with the logger stored in it,
+// see LoggerFromContext
+func ContextWithLogger(parent context.Context, logger log.Interface) context.Context {
+	return context.WithValue(parent, loggerContextKey, logger)
+}
+
+// LoggerFromContext returns the logger stored in the context by Context.ToContext
+// or ContextWithLogger, with all the request fields. If there's no logger,
+// the default gramework.Logger is returned.
+func LoggerFromContext(c context.Context) log.Interface {
+	if c != nil {
+		if logger, ok := c.Value(loggerContextKey).(log.Interface); ok && logger != nil {
+			return logger
+		}
+	}
+
+	return Logger
+}
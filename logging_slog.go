@@ -0,0 +1,45 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+//go:build go1.21
+// +build go1.21
+
+package gramework
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+type slogSink struct {
+	handler slog.Handler
+}
+
+// NewSlogSink returns a LogSink, that writes to the log/slog handler, e.g.
+//
+//	app := gramework.New(gramework.OptLogSink(gramework.NewSlogSink(slog.NewJSONHandler(os.Stdout, nil))))
+func NewSlogSink(handler slog.Handler) LogSink {
+	return &slogSink{
+		handler: handler,
+	}
+}
+
+func (s *slogSink) Enabled(level LogLevel) bool {
+	return s.handler.Enabled(context.Background(), slog.Level(level))
+}
+
+func (s *slogSink) Log(level LogLevel, msg string, fields []LogField) {
+	r := slog.NewRecord(time.Now(), slog.Level(level), msg, 0)
+	for _, f := range fields {
+		r.AddAttrs(slog.Any(f.Key, f.Value))
+	}
+	_ = s.handler.Handle(context.Background(), r)
+}
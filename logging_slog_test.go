@@ -0,0 +1,38 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+//go:build go1.21
+// +build go1.21
+
+package gramework
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestSlogSink(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})
+	app := New(OptLogSink(NewSlogSink(handler)))
+
+	app.Logger.Debug("filtered")
+	app.Logger.WithField("user", "alice").Warn("hello")
+
+	var rec map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("expected exactly one JSON record, got %q: %s", buf.String(), err)
+	}
+	if rec["msg"] != "hello" || rec["level"] != "WARN" || rec["user"] != "alice" {
+		t.Fatalf("unexpected record: %v", rec)
+	}
+}
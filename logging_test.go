@@ -0,0 +1,178 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/valyala/fasthttp"
+)
+
+type testLogRecord struct {
+	level  LogLevel
+	msg    string
+	fields map[string]interface{}
+}
+
+type testLogSink struct {
+	mu      sync.Mutex
+	records []testLogRecord
+}
+
+func (s *testLogSink) Enabled(level LogLevel) bool {
+	return true
+}
+
+func (s *testLogSink) Log(level LogLevel, msg string, fields []LogField) {
+	rec := testLogRecord{level: level, msg: msg, fields: make(map[string]interface{})}
+	for i, f := range fields {
+		if i > 0 && fields[i-1].Key > f.Key {
+			panic("log fields are not sorted")
+		}
+		rec.fields[f.Key] = f.Value
+	}
+	s.mu.Lock()
+	s.records = append(s.records, rec)
+	s.mu.Unlock()
+}
+
+func (s *testLogSink) find(msg string) *testLogRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.records {
+		if s.records[i].msg == msg {
+			return &s.records[i]
+		}
+	}
+	return nil
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for name, expected := range map[string]LogLevel{
+		"debug":   LevelDebug,
+		"INFO":    LevelInfo,
+		"warning": LevelWarn,
+		" error ": LevelError,
+		"fatal":   LevelFatal,
+	} {
+		lvl, err := ParseLogLevel(name)
+		if err != nil || lvl != expected {
+			t.Errorf("ParseLogLevel(%q) = %v, %v; expected %v", name, lvl, err, expected)
+		}
+	}
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Errorf("expected error for unknown level")
+	}
+}
+
+func TestLogSinkRequestFields(t *testing.T) {
+	sink := &testLogSink{}
+	app := New(OptLogSink(sink))
+
+	var propagated log.Interface
+	app.GET("/users/:id", func(ctx *Context) {
+		ctx.AddLogField("user", "alice")
+		c := ctx.ToContext()
+		propagated = LoggerFromContext(c)
+		propagated.Info("handled")
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/users/42")
+	ctx.Request.Header.Set(xRequestID, "req-1")
+	app.handler()(ctx)
+
+	rec := sink.find("handled")
+	if rec == nil {
+		t.Fatalf("record was not logged to the sink")
+	}
+	if rec.level != LevelInfo {
+		t.Errorf("unexpected level: %v", rec.level)
+	}
+	expected := map[string]interface{}{
+		xRequestID:    "req-1",
+		RouteLogField: "/users/:id",
+		"user":        "alice",
+	}
+	for k, v := range expected {
+		if rec.fields[k] != v {
+			t.Errorf("field %q = %v, expected %v", k, rec.fields[k], v)
+		}
+	}
+
+	if rec := sink.find("request processed"); rec == nil || rec.fields[PackageLogField] != internalPackage {
+		t.Errorf("internal request trace was not logged with the package field: %+v", rec)
+	}
+}
+
+func TestLoggerFromContextFallback(t *testing.T) {
+	if LoggerFromContext(context.Background()) != Logger {
+		t.Errorf("expected default logger")
+	}
+
+	logger := NewSinkLogger(&testLogSink{})
+	if LoggerFromContext(ContextWithLogger(context.Background(), logger)) != logger {
+		t.Errorf("expected stored logger")
+	}
+}
+
+func TestLogLevelNoGlobalMutation(t *testing.T) {
+	prevLevel := Logger.Level
+	prevEnv := atomic.LoadInt32(currentEnvironment)
+	defer atomic.StoreInt32(currentEnvironment, prevEnv)
+
+	app := New(OptLogLevel(LevelError))
+	if Logger.Level != prevLevel {
+		t.Fatalf("OptLogLevel changed the default logger level")
+	}
+	if l, ok := app.Logger.(*log.Logger); !ok || l.Level != log.ErrorLevel {
+		t.Fatalf("app logger level was not set: %#v", app.Logger)
+	}
+	if New().Logger != Logger {
+		t.Fatalf("other apps should use the default logger")
+	}
+
+	SetEnv(PROD)
+	if Logger.Level != prevLevel {
+		t.Fatalf("SetEnv changed the default logger level")
+	}
+}
+
+func TestPackageLogLevel(t *testing.T) {
+	sink := &testLogSink{}
+	app := New(
+		OptLogSink(sink),
+		OptLogLevel(LevelDebug),
+		OptPackageLogLevel(internalPackage, LevelError),
+	)
+
+	app.internalLog.Info("internal info")
+	app.internalLog.Error("internal error")
+	app.PackageLogger("mw/test").Debug("package debug")
+	app.Logger.Debug("app debug")
+
+	if sink.find("internal info") != nil {
+		t.Errorf("internal info should be filtered by the package level")
+	}
+	if rec := sink.find("internal error"); rec == nil || rec.fields[PackageLogField] != internalPackage {
+		t.Errorf("internal error was not logged: %+v", rec)
+	}
+	if rec := sink.find("package debug"); rec == nil || rec.fields[PackageLogField] != "mw/test" {
+		t.Errorf("package without level should use the app level: %+v", rec)
+	}
+	if sink.find("app debug") == nil {
+		t.Errorf("app debug was not logged")
+	}
+}
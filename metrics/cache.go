@@ -0,0 +1,74 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package metrics
+
+import (
+	"github.com/gramework/gramework"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type cacheCollector struct {
+	app *gramework.App
+
+	requests  *prometheus.Desc
+	evictions *prometheus.Desc
+	purged    *prometheus.Desc
+	entries   *prometheus.Desc
+	bytes     *prometheus.Desc
+	maxBytes  *prometheus.Desc
+}
+
+// RegisterCache exports App.Cache statistics: requests by result
+// (hit, stale, revalidated, miss and bypass), evictions, purges
+// and the shared storage size, see App.CacheStats.
+func (m *Middleware) RegisterCache(app *gramework.App) error {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(name, help, labels, m.constLabels)
+	}
+
+	return m.registerer.Register(&cacheCollector{
+		app:       app,
+		requests:  desc("gramework_cache_requests_total", "Total count of App.Cache requests by result", "result"),
+		evictions: desc("gramework_cache_evictions_total", "Total count of cached responses, evicted before they expired"),
+		purged:    desc("gramework_cache_purged_total", "Total count of cache records, removed with App.PurgeCache"),
+		entries:   desc("gramework_cache_entries", "Count of records in the shared cache storage"),
+		bytes:     desc("gramework_cache_bytes", "Size of the shared cache storage in bytes"),
+		maxBytes:  desc("gramework_cache_max_bytes", "Max size of the shared cache storage in bytes"),
+	})
+}
+
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.requests
+	ch <- c.evictions
+	ch <- c.purged
+	ch <- c.entries
+	ch <- c.bytes
+	ch <- c.maxBytes
+}
+
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.app.CacheStats()
+
+	for result, value := range map[string]uint64{
+		"hit":         s.Hits,
+		"stale":       s.StaleHits,
+		"revalidated": s.Revalidations,
+		"miss":        s.Misses,
+		"bypass":      s.Bypasses,
+	} {
+		ch <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(value), result)
+	}
+	ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(s.Evictions))
+	ch <- prometheus.MustNewConstMetric(c.purged, prometheus.CounterValue, float64(s.Purged))
+	ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(s.Entries))
+	ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(s.BytesSize))
+	ch <- prometheus.MustNewConstMetric(c.maxBytes, prometheus.GaugeValue, float64(s.MaxBytesSize))
+}
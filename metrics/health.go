@@ -0,0 +1,80 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/gramework/gramework"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type healthCollector struct {
+	app *gramework.App
+
+	up                  *prometheus.Desc
+	flapping            *prometheus.Desc
+	latency             *prometheus.Desc
+	consecutiveFailures *prometheus.Desc
+	runs                *prometheus.Desc
+	failures            *prometheus.Desc
+}
+
+// RegisterHealthChecks exports the state of the app health checks,
+// registered with App.AddHealthCheck. Checks, that have not run yet, are skipped.
+func (m *Middleware) RegisterHealthChecks(app *gramework.App) error {
+	labels := []string{"check", "critical"}
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(name, help, labels, m.constLabels)
+	}
+
+	return m.registerer.Register(&healthCollector{
+		app:                 app,
+		up:                  desc("gramework_health_check_up", "Whether the last health check run passed (1) or failed (0)"),
+		flapping:            desc("gramework_health_check_flapping", "Whether the health check switches between ok and failed too often"),
+		latency:             desc("gramework_health_check_latency_seconds", "Duration of the last health check run in seconds"),
+		consecutiveFailures: desc("gramework_health_check_consecutive_failures", "Count of health check failures in a row"),
+		runs:                desc("gramework_health_check_runs_total", "Total count of health check runs"),
+		failures:            desc("gramework_health_check_failures_total", "Total count of failed health check runs"),
+	})
+}
+
+func (c *healthCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.up
+	ch <- c.flapping
+	ch <- c.latency
+	ch <- c.consecutiveFailures
+	ch <- c.runs
+	ch <- c.failures
+}
+
+func (c *healthCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.app.HealthStatus() {
+		if s.Runs == 0 {
+			continue
+		}
+		labels := []string{s.Name, strconv.FormatBool(s.Critical)}
+
+		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, boolValue(s.ConsecutiveFailures == 0), labels...)
+		ch <- prometheus.MustNewConstMetric(c.flapping, prometheus.GaugeValue, boolValue(s.Flapping), labels...)
+		ch <- prometheus.MustNewConstMetric(c.latency, prometheus.GaugeValue, s.LastLatency.Seconds(), labels...)
+		ch <- prometheus.MustNewConstMetric(c.consecutiveFailures, prometheus.GaugeValue, float64(s.ConsecutiveFailures), labels...)
+		ch <- prometheus.MustNewConstMetric(c.runs, prometheus.CounterValue, float64(s.Runs), labels...)
+		ch <- prometheus.MustNewConstMetric(c.failures, prometheus.CounterValue, float64(s.Failures), labels...)
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -1,113 +1,291 @@
 package metrics
 
 import (
-	"bytes"
+	"net"
+	"net/http"
 	"os"
 	"strconv"
-	"time"
 
 	"github.com/gramework/gramework"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
 )
 
 // Middleware handles metrics data
 type Middleware struct {
 	httpReqCounter *prometheus.CounterVec
 	reqDuration    *prometheus.HistogramVec
+	reqSize        *prometheus.HistogramVec
+	respSize       *prometheus.HistogramVec
+	inFlight       prometheus.Gauge
+
+	serviceName string
+	path        string
+	buckets     []float64
+	sizeBuckets []float64
+	registerer  prometheus.Registerer
+	gatherer    prometheus.Gatherer
+	constLabels prometheus.Labels
 }
 
+// Option configures the Middleware
+type Option func(*Middleware)
+
 const (
 	typeHTTPS = "https"
 	typeHTTP  = "http"
 
-	millisecond = float64(time.Millisecond)
-
 	uvKey = "gramework.metrics.startTime"
+
+	// DefaultPath is the default metrics path
+	DefaultPath = "/metrics"
+
+	// UnmatchedRoute is the route label value for requests,
+	// that matched no route, e.g. 404 responses
+	UnmatchedRoute = "unmatched"
 )
 
-var metricsPath = []byte("/metrics")
+// DefaultSizeBuckets are the default request and response size buckets, from 100B to 100MB
+var DefaultSizeBuckets = prometheus.ExponentialBuckets(100, 10, 7)
+
+// WithServiceName sets the service const label. By default, os.Args[0] is used.
+func WithServiceName(name string) Option {
+	return func(m *Middleware) {
+		m.serviceName = name
+	}
+}
+
+// WithPath sets the metrics path served by Handler and ListenAndServe
+func WithPath(path string) Option {
+	return func(m *Middleware) {
+		m.path = path
+	}
+}
+
+// WithBuckets sets the request duration buckets in seconds.
+// By default, prometheus.DefBuckets are used.
+func WithBuckets(buckets []float64) Option {
+	return func(m *Middleware) {
+		m.buckets = buckets
+	}
+}
 
-// Register the middlewares
+// WithSizeBuckets sets the request and response size buckets in bytes
+func WithSizeBuckets(buckets []float64) Option {
+	return func(m *Middleware) {
+		m.sizeBuckets = buckets
+	}
+}
+
+// WithRegisterer sets the registerer for the metrics instead of
+// prometheus.DefaultRegisterer. If it is a prometheus.Gatherer too,
+// e.g. *prometheus.Registry, it is also used by Handler.
+func WithRegisterer(reg prometheus.Registerer) Option {
+	return func(m *Middleware) {
+		m.registerer = reg
+		if g, ok := reg.(prometheus.Gatherer); ok && m.gatherer == nil {
+			m.gatherer = g
+		}
+	}
+}
+
+// WithGatherer sets the gatherer used by Handler instead of prometheus.DefaultGatherer
+func WithGatherer(g prometheus.Gatherer) Option {
+	return func(m *Middleware) {
+		m.gatherer = g
+	}
+}
+
+// Register the middlewares. Metrics are not served on the app:
+// use New, Setup and ListenAndServe to serve them on a separate port.
 func Register(app *gramework.App, serviceName ...string) error {
-	var m Middleware
-	name := os.Args[0]
+	var opts []Option
 	if len(serviceName) > 0 {
-		name = serviceName[0]
+		opts = append(opts, WithServiceName(serviceName[0]))
 	}
 
-	hostname, err := os.Hostname()
+	m, err := New(opts...)
 	if err != nil {
 		return err
 	}
 
+	return m.Setup(app)
+}
+
+// New creates and registers the collectors
+func New(opts ...Option) (*Middleware, error) {
+	m := &Middleware{
+		serviceName: os.Args[0],
+		path:        DefaultPath,
+		buckets:     prometheus.DefBuckets,
+		sizeBuckets: DefaultSizeBuckets,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.registerer == nil {
+		m.registerer = prometheus.DefaultRegisterer
+	}
+	if m.gatherer == nil {
+		m.gatherer = prometheus.DefaultGatherer
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	constLabels := prometheus.Labels{
+		"service": m.serviceName,
+		"node":    hostname,
+	}
+	m.constLabels = constLabels
+
 	m.httpReqCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "gramework_http_requests_total",
-			Help: "Total count of HTTP requests processed, partitioned by code, method, path and type (HTTP/HTTPS)",
-			ConstLabels: prometheus.Labels{
-				"service": name,
-				"node":    hostname,
-			},
+			Name:        "gramework_http_requests_total",
+			Help:        "Total count of HTTP requests processed, partitioned by code, method, route, domain and type (HTTP/HTTPS)",
+			ConstLabels: constLabels,
 		},
-		[]string{"code", "method", "path", "type"},
+		[]string{"code", "method", "route", "domain", "type"},
 	)
-	if err = prometheus.Register(m.httpReqCounter); err != nil {
-		return err
-	}
-
 	m.reqDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
-			Name: "gramework_http_requests_duration_seconds",
-			Help: "Request processing duration, partitioned by code, method, path and type (HTTP/HTTPS)",
-			ConstLabels: prometheus.Labels{
-				"service": name,
-				"node":    hostname,
-			},
+			Name:        "gramework_http_requests_duration_seconds",
+			Help:        "Request processing duration in seconds, partitioned by code, method, route, domain and type (HTTP/HTTPS)",
+			ConstLabels: constLabels,
+			Buckets:     m.buckets,
+		},
+		[]string{"code", "method", "route", "domain", "type"},
+	)
+	m.reqSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:        "gramework_http_request_size_bytes",
+			Help:        "Request body size in bytes, partitioned by method, route, domain and type (HTTP/HTTPS)",
+			ConstLabels: constLabels,
+			Buckets:     m.sizeBuckets,
+		},
+		[]string{"method", "route", "domain", "type"},
+	)
+	m.respSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:        "gramework_http_response_size_bytes",
+			Help:        "Response body size in bytes, partitioned by code, method, route, domain and type (HTTP/HTTPS)",
+			ConstLabels: constLabels,
+			Buckets:     m.sizeBuckets,
+		},
+		[]string{"code", "method", "route", "domain", "type"},
+	)
+	m.inFlight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name:        "gramework_http_requests_in_flight",
+			Help:        "Count of HTTP requests being processed",
+			ConstLabels: constLabels,
 		},
-		[]string{"code", "method", "path", "type"},
 	)
 
-	if err = prometheus.Register(m.reqDuration); err != nil {
-		return err
+	for _, c := range []prometheus.Collector{m.httpReqCounter, m.reqDuration, m.reqSize, m.respSize, m.inFlight} {
+		if err = m.registerer.Register(c); err != nil {
+			return nil, err
+		}
 	}
 
-	app.GET(string(metricsPath), gramework.NewGrameHandler(promhttp.Handler()))
+	return m, nil
+}
 
-	if err = app.UsePre(m.startReq); err != nil {
+// Setup registers the middlewares in the app. Metrics are not served
+// on the app, see Handler and ListenAndServe.
+func (m *Middleware) Setup(app *gramework.App) error {
+	if err := app.UsePre(m.startReq); err != nil {
 		return err
 	}
 
 	return app.UseAfterRequest(m.endReq)
 }
 
-func (m *Middleware) startReq(ctx *gramework.Context) {
-	if bytes.Equal(ctx.Path(), metricsPath) {
-		return
+// Path returns the metrics path
+func (m *Middleware) Path() string {
+	return m.path
+}
+
+// Handler returns the handler that serves metrics from the gatherer
+func (m *Middleware) Handler() func(*gramework.Context) {
+	return gramework.NewGrameHandler(m.httpHandler())
+}
+
+// ListenAndServe serves metrics on the addr at the metrics path,
+// separately from the app
+func (m *Middleware) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
 	}
+
+	return m.Serve(ln)
+}
+
+// Serve serves metrics on the listener at the metrics path,
+// separately from the app
+func (m *Middleware) Serve(ln net.Listener) error {
+	handler := fasthttpadaptor.NewFastHTTPHandler(m.httpHandler())
+	return fasthttp.Serve(ln, func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) != m.path {
+			ctx.NotFound()
+			return
+		}
+		handler(ctx)
+	})
+}
+
+func (m *Middleware) httpHandler() http.Handler {
+	return promhttp.InstrumentMetricHandler(m.registerer, promhttp.HandlerFor(m.gatherer, promhttp.HandlerOpts{}))
+}
+
+func (m *Middleware) startReq(ctx *gramework.Context) {
+	m.inFlight.Inc()
 	ctx.SetUserValue(uvKey, gramework.Nanotime())
 }
 
 func (m *Middleware) endReq(ctx *gramework.Context) {
-	if bytes.Equal(ctx.Path(), metricsPath) {
+	startTime, ok := ctx.UserValue(uvKey).(int64)
+	if !ok {
 		return
 	}
+	m.inFlight.Dec()
 
-	opts := []string{
-		strconv.FormatInt(int64(ctx.Response.StatusCode()), 10),
-		string(ctx.Method()),
-		string(ctx.Path()),
-		typeHTTP,
+	route := ctx.RouteTemplate()
+	if route == m.path && route == string(ctx.Path()) {
+		return
+	}
+	if len(route) == 0 {
+		route = UnmatchedRoute
 	}
 
+	reqType := typeHTTP
 	if ctx.IsTLS() {
-		opts[3] = typeHTTPS
+		reqType = typeHTTPS
 	}
+	code := strconv.Itoa(ctx.Response.StatusCode())
+	method := string(ctx.Method())
+	domain := ctx.Domain()
 
-	m.httpReqCounter.WithLabelValues(opts...).Add(1)
+	m.httpReqCounter.WithLabelValues(code, method, route, domain, reqType).Inc()
+	m.reqDuration.WithLabelValues(code, method, route, domain, reqType).
+		Observe(float64(gramework.Nanotime()-startTime) / 1e9)
+	m.reqSize.WithLabelValues(method, route, domain, reqType).
+		Observe(float64(len(ctx.Request.Body())))
+	m.respSize.WithLabelValues(code, method, route, domain, reqType).
+		Observe(float64(responseSize(&ctx.Response)))
+}
 
-	startTime, _ := ctx.UserValue(uvKey).(int64)
-	duration := float64(gramework.Nanotime()-startTime) / millisecond
+func responseSize(resp *fasthttp.Response) int {
+	if resp.IsBodyStream() {
+		if n := resp.Header.ContentLength(); n > 0 {
+			return n
+		}
+		return 0
+	}
 
-	m.reqDuration.WithLabelValues(opts...).Observe(duration)
+	return len(resp.Body())
 }
@@ -0,0 +1,289 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/gramework/gramework"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func testClient(ln *fasthttputil.InmemoryListener) *fasthttp.Client {
+	return &fasthttp.Client{
+		Dial: func(string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+}
+
+func TestMiddlewareRouteLabels(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(WithRegisterer(reg), WithServiceName("test"), WithBuckets([]float64{0.1, 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := gramework.New()
+	app.HandleUnknownDomains = true
+	if err = m.Setup(app); err != nil {
+		t.Fatal(err)
+	}
+	app.GET("/users/:id", "user")
+	app.Domain("api.example.com").GET("/v1/ping", "pong")
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go func() {
+		_ = app.Serve(ln)
+	}()
+	c := testClient(ln)
+
+	for _, url := range []string{
+		"http://example.com/users/1",
+		"http://example.com/users/2",
+		"http://example.com/unknown/path",
+		"http://api.example.com/v1/ping",
+		"http://example.com/metrics",
+	} {
+		if _, _, err = c.Get(nil, url); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]float64)
+	for _, f := range families {
+		switch f.GetName() {
+		case "gramework_http_requests_total":
+			for _, metric := range f.GetMetric() {
+				labels := make(map[string]string)
+				for _, l := range metric.GetLabel() {
+					labels[l.GetName()] = l.GetValue()
+				}
+				counts[labels["route"]+"@"+labels["domain"]+"#"+labels["code"]] = metric.GetCounter().GetValue()
+			}
+		case "gramework_http_requests_duration_seconds":
+			if b := f.GetMetric()[0].GetHistogram().GetBucket(); len(b) != 2 || b[0].GetUpperBound() != 0.1 {
+				t.Errorf("custom buckets were not applied: %v", b)
+			}
+		case "gramework_http_requests_in_flight":
+			if v := f.GetMetric()[0].GetGauge().GetValue(); v != 0 {
+				t.Errorf("unexpected in-flight requests: %v", v)
+			}
+		}
+	}
+
+	expected := map[string]float64{
+		"/users/:id@#200":              2,
+		UnmatchedRoute + "@#404":       2,
+		"/v1/ping@api.example.com#200": 1,
+	}
+	for k, v := range expected {
+		if counts[k] != v {
+			t.Errorf("requests_total{%s} = %v, expected %v; all: %v", k, counts[k], v, counts)
+		}
+	}
+}
+
+func TestMiddlewareServe(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(WithRegisterer(reg), WithPath("/internal/metrics"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go func() {
+		_ = m.Serve(ln)
+	}()
+	c := testClient(ln)
+
+	code, body, err := c.Get(nil, "http://localhost/internal/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 200 || !strings.Contains(string(body), "gramework_http_requests_in_flight") {
+		t.Fatalf("unexpected metrics response %d: %s", code, body)
+	}
+
+	if code, _, err = c.Get(nil, "http://localhost/metrics"); err != nil || code != 404 {
+		t.Fatalf("only the metrics path should be served, got %d %v", code, err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	app := gramework.New()
+	if err := Register(app, "test"); err != nil {
+		t.Fatal(err)
+	}
+	app.GET("/", "ok")
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go func() {
+		_ = app.Serve(ln)
+	}()
+	c := testClient(ln)
+
+	if code, _, err := c.Get(nil, "http://localhost/"); err != nil || code != 200 {
+		t.Fatalf("unexpected response %d %v", code, err)
+	}
+	if code, _, err := c.Get(nil, "http://localhost"+DefaultPath); err != nil || code != 404 {
+		t.Fatalf("metrics should not be served on the app, got %d %v", code, err)
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(WithRegisterer(reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := gramework.New()
+	app.AddHealthCheck("db", func(context.Context) error {
+		return errors.New("connection refused")
+	})
+	app.AddHealthCheck("idle", func(context.Context) error {
+		return nil
+	})
+	if err = m.RegisterHealthChecks(app); err != nil {
+		t.Fatal(err)
+	}
+	app.SetReady(true)
+	app.GET("/readyz", app.ProbeHandler(gramework.ProbeReadiness))
+	app.AddHealthCheck("cache", func(context.Context) error {
+		return nil
+	}, gramework.HealthCheckProbes(gramework.ProbeLiveness))
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go func() {
+		_ = app.Serve(ln)
+	}()
+	for i := 0; i < 2; i++ {
+		if _, _, err = testClient(ln).Get(nil, "http://example.com/readyz"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, f := range families {
+		if !strings.HasPrefix(f.GetName(), "gramework_health_check_") {
+			continue
+		}
+		for _, metric := range f.GetMetric() {
+			check := ""
+			for _, l := range metric.GetLabel() {
+				if l.GetName() == "check" {
+					check = l.GetValue()
+				}
+			}
+			value := metric.GetGauge().GetValue()
+			if f.GetType().String() == "COUNTER" {
+				value = metric.GetCounter().GetValue()
+			}
+			values[f.GetName()+"{"+check+"}"] = value
+		}
+	}
+
+	expected := map[string]float64{
+		"gramework_health_check_up{db}":                   0,
+		"gramework_health_check_up{idle}":                 1,
+		"gramework_health_check_consecutive_failures{db}": 2,
+		"gramework_health_check_runs_total{db}":           2,
+		"gramework_health_check_failures_total{db}":       2,
+		"gramework_health_check_failures_total{idle}":     0,
+	}
+	for k, v := range expected {
+		if got, ok := values[k]; !ok || got != v {
+			t.Errorf("%s = %v, expected %v; all: %v", k, got, v, values)
+		}
+	}
+	if _, ok := values["gramework_health_check_up{cache}"]; ok {
+		t.Errorf("checks, that have not run yet, should be skipped: %v", values)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(WithRegisterer(reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := gramework.New()
+	if err = m.RegisterCache(app); err != nil {
+		t.Fatal(err)
+	}
+	app.GET("/cached", app.Cache(func(ctx *gramework.Context) {
+		ctx.WriteString("cached")
+	}))
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go func() {
+		_ = app.Serve(ln)
+	}()
+	for i := 0; i < 3; i++ {
+		if _, _, err = testClient(ln).Get(nil, "http://example.com/cached"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app.PurgeCache(gramework.CachePurgePrefix("GET example.com /cached"))
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, f := range families {
+		for _, metric := range f.GetMetric() {
+			name := f.GetName()
+			for _, l := range metric.GetLabel() {
+				if l.GetName() == "result" {
+					name += "{" + l.GetValue() + "}"
+				}
+			}
+			values[name] = metric.GetCounter().GetValue() + metric.GetGauge().GetValue()
+		}
+	}
+
+	expected := map[string]float64{
+		"gramework_cache_requests_total{hit}":  2,
+		"gramework_cache_requests_total{miss}": 1,
+		"gramework_cache_purged_total":         1,
+		"gramework_cache_entries":              0,
+	}
+	for k, v := range expected {
+		if got, ok := values[k]; !ok || got != v {
+			t.Errorf("%s: expected %v, got %v (exported: %v)", k, v, got, ok)
+		}
+	}
+	if values["gramework_cache_max_bytes"] == 0 {
+		t.Errorf("storage size is not exported")
+	}
+}
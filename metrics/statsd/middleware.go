@@ -0,0 +1,122 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package statsd
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gramework/gramework"
+)
+
+const (
+	// MetricRequests is the request counter
+	MetricRequests = "http.requests"
+	// MetricRequestDuration is the request duration timing
+	MetricRequestDuration = "http.request.duration"
+	// MetricRequestSize is the request body size histogram
+	MetricRequestSize = "http.request.size"
+	// MetricResponseSize is the response body size histogram
+	MetricResponseSize = "http.response.size"
+	// MetricInFlight is the gauge of requests being processed
+	MetricInFlight = "http.requests.in_flight"
+
+	// UnmatchedRoute is the route tag value for requests,
+	// that matched no route, e.g. 404 responses
+	UnmatchedRoute = "unmatched"
+
+	typeHTTPS = "https"
+	typeHTTP  = "http"
+
+	uvKey = "gramework.statsd.startTime"
+)
+
+// Register creates a Client and registers its middlewares in the app.
+// The client is closed on app shutdown, see App.OnShutdown.
+func Register(app *gramework.App, addr string, opts ...Option) (*Client, error) {
+	c, err := New(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.Setup(app); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	app.OnShutdown(func(context.Context) error {
+		return c.Close()
+	})
+
+	return c, nil
+}
+
+// Setup registers request metrics middlewares in the app. Requests are
+// tagged by type (http/https), domain, method, route template,
+// status code and status class, e.g. "2xx".
+func (c *Client) Setup(app *gramework.App) error {
+	if err := app.UsePre(c.startReq); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&c.trackInFlight, 1)
+
+	return app.UseAfterRequest(c.endReq)
+}
+
+func (c *Client) startReq(ctx *gramework.Context) {
+	atomic.AddInt64(&c.inFlight, 1)
+	ctx.SetUserValue(uvKey, gramework.Nanotime())
+}
+
+func (c *Client) endReq(ctx *gramework.Context) {
+	startTime, ok := ctx.UserValue(uvKey).(int64)
+	if !ok {
+		return
+	}
+	atomic.AddInt64(&c.inFlight, -1)
+
+	route := ctx.RouteTemplate()
+	if len(route) == 0 {
+		route = UnmatchedRoute
+	}
+	reqType := typeHTTP
+	if ctx.IsTLS() {
+		reqType = typeHTTPS
+	}
+	statusCode := ctx.Response.StatusCode()
+	code := strconv.Itoa(statusCode)
+	class := strconv.Itoa(statusCode/100) + "xx"
+
+	reqTags := []Tag{
+		T("type", reqType),
+		T("domain", ctx.Domain()),
+		T("method", string(ctx.Method())),
+		T("route", route),
+	}
+	tags := append(reqTags[:len(reqTags):len(reqTags)], T("status_class", class), T("code", code))
+
+	c.Count(MetricRequests, 1, tags...)
+	c.Timing(MetricRequestDuration, time.Duration(gramework.Nanotime()-startTime), tags[:len(tags)-1]...)
+	c.Histogram(MetricRequestSize, float64(len(ctx.Request.Body())), reqTags...)
+	c.Histogram(MetricResponseSize, float64(responseSize(ctx)), tags[:len(tags)-1]...)
+}
+
+func responseSize(ctx *gramework.Context) int {
+	if ctx.Response.IsBodyStream() {
+		if n := ctx.Response.Header.ContentLength(); n > 0 {
+			return n
+		}
+		return 0
+	}
+
+	return len(ctx.Response.Body())
+}
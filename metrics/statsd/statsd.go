@@ -0,0 +1,462 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+// Package statsd provides request metrics middleware, that pushes metrics
+// over UDP in StatsD or DogStatsD format.
+package statsd
+
+import (
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Format is the wire format of metrics
+type Format int
+
+const (
+	// FormatStatsD is the plain StatsD format. Tags are not supported by it,
+	// so tag values are appended to metric names, e.g. "http.requests.http.none.GET.users_id.2xx.200"
+	FormatStatsD Format = iota
+	// FormatDogStatsD is the DogStatsD format with tags, e.g. "http.requests:1|c|#method:GET"
+	FormatDogStatsD
+)
+
+const (
+	// DefaultPrefix is the default metric name prefix
+	DefaultPrefix = "gramework."
+	// DefaultFlushInterval is the default aggregation window
+	DefaultFlushInterval = 10 * time.Second
+	// DefaultMaxPacketSize is the default max UDP packet size,
+	// that fits into the common Ethernet MTU
+	DefaultMaxPacketSize = 1432
+	// DefaultQueueSize is the default count of samples waiting for aggregation
+	DefaultQueueSize = 8192
+	// DefaultMaxSamples is the default count of timing and histogram values
+	// of a metric sent per aggregation window
+	DefaultMaxSamples = 128
+)
+
+const (
+	typeCount     = "c"
+	typeGauge     = "g"
+	typeTiming    = "ms"
+	typeHistogram = "h"
+)
+
+// Tag is a metric tag
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// T is a shortcut for Tag{Key: key, Value: value}
+func T(key, value string) Tag {
+	return Tag{Key: key, Value: value}
+}
+
+// Option configures the Client
+type Option func(*Client)
+
+// WithPrefix sets the metric name prefix, DefaultPrefix is used by default
+func WithPrefix(prefix string) Option {
+	return func(c *Client) {
+		c.prefix = prefix
+	}
+}
+
+// WithFormat sets the wire format. FormatStatsD is used by default.
+func WithFormat(format Format) Option {
+	return func(c *Client) {
+		c.format = format
+	}
+}
+
+// WithTags adds tags to all metrics, e.g. T("service", "users").
+// Only FormatDogStatsD sends them.
+func WithTags(tags ...Tag) Option {
+	return func(c *Client) {
+		c.tags = append(c.tags, tags...)
+	}
+}
+
+// WithFlushInterval sets the aggregation window: counters are summed up
+// and sent with other samples once per window
+func WithFlushInterval(d time.Duration) Option {
+	return func(c *Client) {
+		if d > 0 {
+			c.flushInterval = d
+		}
+	}
+}
+
+// WithMaxPacketSize sets the max UDP packet size
+func WithMaxPacketSize(size int) Option {
+	return func(c *Client) {
+		if size > 0 {
+			c.maxPacketSize = size
+		}
+	}
+}
+
+// WithQueueSize sets the count of samples waiting for aggregation.
+// Samples are dropped if the queue is full, see Client.Dropped.
+func WithQueueSize(size int) Option {
+	return func(c *Client) {
+		if size > 0 {
+			c.queueSize = size
+		}
+	}
+}
+
+// WithMaxSamples sets the count of timing and histogram values of a metric
+// sent per aggregation window. If there are more values, a uniform random
+// sample of them is sent with the sample rate, e.g. "|@0.1".
+func WithMaxSamples(n int) Option {
+	return func(c *Client) {
+		if n > 0 {
+			c.maxSamples = n
+		}
+	}
+}
+
+type sample struct {
+	name  string
+	typ   string
+	value float64
+	tags  []Tag
+	// rate is the sample rate of timings and histograms, 0 means all values are sent
+	rate float64
+}
+
+// Client aggregates metrics and sends them over UDP.
+// All methods are safe for concurrent use and never block on network.
+type Client struct {
+	conn          net.Conn
+	prefix        string
+	format        Format
+	tags          []Tag
+	flushInterval time.Duration
+	maxPacketSize int
+	queueSize     int
+	maxSamples    int
+
+	queue     chan sample
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	dropped   uint64
+	sendErrs  uint64
+
+	// inFlight is the count of requests being processed,
+	// sent on flush if the middleware is set up
+	inFlight      int64
+	trackInFlight int32
+}
+
+// New returns a Client, that sends metrics to the UDP addr, e.g. "127.0.0.1:8125"
+func New(addr string, opts ...Option) (*Client, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn:          conn,
+		prefix:        DefaultPrefix,
+		flushInterval: DefaultFlushInterval,
+		maxPacketSize: DefaultMaxPacketSize,
+		queueSize:     DefaultQueueSize,
+		maxSamples:    DefaultMaxSamples,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.queue = make(chan sample, c.queueSize)
+
+	go c.loop()
+	return c, nil
+}
+
+// Count adds the value to the counter
+func (c *Client) Count(name string, value int64, tags ...Tag) {
+	c.enqueue(sample{name: name, typ: typeCount, value: float64(value), tags: tags})
+}
+
+// Gauge sets the gauge value
+func (c *Client) Gauge(name string, value float64, tags ...Tag) {
+	c.enqueue(sample{name: name, typ: typeGauge, value: value, tags: tags})
+}
+
+// Timing records the duration in milliseconds
+func (c *Client) Timing(name string, d time.Duration, tags ...Tag) {
+	c.enqueue(sample{name: name, typ: typeTiming, value: float64(d) / float64(time.Millisecond), tags: tags})
+}
+
+// Histogram records the value. In FormatStatsD, it is sent as a timing.
+func (c *Client) Histogram(name string, value float64, tags ...Tag) {
+	c.enqueue(sample{name: name, typ: typeHistogram, value: value, tags: tags})
+}
+
+// Dropped returns the count of samples dropped because the queue was full
+func (c *Client) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// SendErrors returns the count of packets, that could not be sent
+func (c *Client) SendErrors() uint64 {
+	return atomic.LoadUint64(&c.sendErrs)
+}
+
+// Close sends aggregated metrics and closes the connection
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+	})
+	<-c.done
+
+	return c.conn.Close()
+}
+
+func (c *Client) enqueue(s sample) {
+	select {
+	case c.queue <- s:
+	default:
+		atomic.AddUint64(&c.dropped, 1)
+	}
+}
+
+type aggregate struct {
+	counters   map[string]*sample
+	gauges     map[string]*sample
+	samples    map[string]*reservoir
+	maxSamples int
+	rand       *rand.Rand
+}
+
+// reservoir keeps a uniform random sample of timing or histogram values of a metric
+type reservoir struct {
+	samples []sample
+	seen    int
+}
+
+func (c *Client) loop() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+
+	agg := &aggregate{
+		counters:   make(map[string]*sample),
+		gauges:     make(map[string]*sample),
+		samples:    make(map[string]*reservoir),
+		maxSamples: c.maxSamples,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for {
+		select {
+		case s := <-c.queue:
+			agg.add(s)
+		case <-ticker.C:
+			c.flush(agg)
+		case <-c.stop:
+			c.drain(agg)
+			c.flush(agg)
+			return
+		}
+	}
+}
+
+func (c *Client) drain(agg *aggregate) {
+	for {
+		select {
+		case s := <-c.queue:
+			agg.add(s)
+		default:
+			return
+		}
+	}
+}
+
+func (a *aggregate) add(s sample) {
+	switch s.typ {
+	case typeCount, typeGauge:
+		m := a.counters
+		if s.typ == typeGauge {
+			m = a.gauges
+		}
+		key := sampleKey(&s)
+		if prev, ok := m[key]; ok {
+			if s.typ == typeCount {
+				prev.value += s.value
+			} else {
+				prev.value = s.value
+			}
+			return
+		}
+		m[key] = &s
+	default:
+		key := s.typ + "|" + sampleKey(&s)
+		r, ok := a.samples[key]
+		if !ok {
+			r = &reservoir{}
+			a.samples[key] = r
+		}
+		r.seen++
+		if len(r.samples) < a.maxSamples {
+			r.samples = append(r.samples, s)
+			return
+		}
+		// each of the seen values is kept with the same probability
+		if i := a.rand.Intn(r.seen); i < len(r.samples) {
+			r.samples[i] = s
+		}
+	}
+}
+
+func sampleKey(s *sample) string {
+	var b strings.Builder
+	b.WriteString(s.name)
+	for _, t := range s.tags {
+		b.WriteByte('|')
+		b.WriteString(t.Key)
+		b.WriteByte(':')
+		b.WriteString(t.Value)
+	}
+	return b.String()
+}
+
+func (c *Client) flush(a *aggregate) {
+	packet := make([]byte, 0, c.maxPacketSize)
+	line := make([]byte, 0, 256)
+	write := func(s *sample) {
+		line = c.appendLine(line[:0], s)
+		if len(packet) > 0 && len(packet)+1+len(line) > c.maxPacketSize {
+			c.send(packet)
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+
+	for key, s := range a.counters {
+		write(s)
+		delete(a.counters, key)
+	}
+	// gauges keep their values between windows
+	for _, s := range a.gauges {
+		write(s)
+	}
+	for key, r := range a.samples {
+		for i := range r.samples {
+			if len(r.samples) < r.seen {
+				r.samples[i].rate = float64(len(r.samples)) / float64(r.seen)
+			}
+			write(&r.samples[i])
+		}
+		delete(a.samples, key)
+	}
+	if atomic.LoadInt32(&c.trackInFlight) == 1 {
+		write(&sample{name: MetricInFlight, typ: typeGauge, value: float64(atomic.LoadInt64(&c.inFlight))})
+	}
+
+	if len(packet) > 0 {
+		c.send(packet)
+	}
+}
+
+func (c *Client) send(packet []byte) {
+	if _, err := c.conn.Write(packet); err != nil {
+		atomic.AddUint64(&c.sendErrs, 1)
+	}
+}
+
+func (c *Client) appendLine(b []byte, s *sample) []byte {
+	b = append(b, c.prefix...)
+	b = append(b, s.name...)
+	if c.format == FormatStatsD {
+		for _, t := range s.tags {
+			b = append(b, '.')
+			b = appendSanitizedName(b, t.Value)
+		}
+	}
+	b = append(b, ':')
+	b = strconv.AppendFloat(b, s.value, 'f', -1, 64)
+	b = append(b, '|')
+	if s.typ == typeHistogram && c.format == FormatStatsD {
+		b = append(b, typeTiming...)
+	} else {
+		b = append(b, s.typ...)
+	}
+	if s.rate > 0 {
+		b = append(b, "|@"...)
+		b = strconv.AppendFloat(b, s.rate, 'g', 6, 64)
+	}
+
+	if c.format == FormatDogStatsD && len(c.tags)+len(s.tags) > 0 {
+		b = append(b, "|#"...)
+		for i, t := range append(c.tags[:len(c.tags):len(c.tags)], s.tags...) {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendSanitizedTag(b, t.Key)
+			b = append(b, ':')
+			b = appendSanitizedTag(b, t.Value)
+		}
+	}
+
+	return b
+}
+
+// appendSanitizedName appends the tag value as a metric name segment,
+// e.g. "/users/:id" as "users_id"
+func appendSanitizedName(b []byte, s string) []byte {
+	s = strings.Trim(s, "/")
+	if len(s) == 0 {
+		return append(b, "none"...)
+	}
+
+	underscore := false
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') || ch == '-' {
+			b = append(b, ch)
+			underscore = false
+			continue
+		}
+		if !underscore {
+			b = append(b, '_')
+			underscore = true
+		}
+	}
+	return b
+}
+
+// appendSanitizedTag appends the tag key or value
+// without DogStatsD delimiters
+func appendSanitizedTag(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; ch {
+		case ',', '|', '#', '\n', ' ':
+			b = append(b, '_')
+		default:
+			b = append(b, ch)
+		}
+	}
+	return b
+}
@@ -0,0 +1,172 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package statsd
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gramework/gramework"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func testUDPServer(t *testing.T) (*net.UDPConn, func() []string) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return conn, func() []string {
+		var lines []string
+		buf := make([]byte, 65536)
+		for {
+			_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, err := conn.Read(buf)
+			if err != nil {
+				return lines
+			}
+			lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+		}
+	}
+}
+
+func contains(lines []string, line string) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+func TestClientAggregation(t *testing.T) {
+	srv, read := testUDPServer(t)
+	c, err := New(srv.LocalAddr().String(),
+		WithFormat(FormatDogStatsD),
+		WithPrefix("app."),
+		WithTags(T("service", "users")),
+		WithFlushInterval(time.Hour),
+		WithMaxPacketSize(64),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		c.Count("jobs", 2, T("queue", "mail,urgent"))
+	}
+	c.Gauge("workers", 1)
+	c.Gauge("workers", 5)
+	c.Timing("job", 1500*time.Microsecond)
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := read()
+	for _, expected := range []string{
+		"app.jobs:6|c|#service:users,queue:mail_urgent",
+		"app.workers:5|g|#service:users",
+		"app.job:1.5|ms|#service:users",
+	} {
+		if !contains(lines, expected) {
+			t.Errorf("line %q was not sent: %q", expected, lines)
+		}
+	}
+}
+
+func TestClientSampling(t *testing.T) {
+	srv, read := testUDPServer(t)
+	c, err := New(srv.LocalAddr().String(), WithFlushInterval(time.Hour), WithMaxSamples(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		c.Timing("job", time.Millisecond)
+	}
+	c.Histogram("size", 1)
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := read()
+	sampled := 0
+	for _, l := range lines {
+		if strings.HasPrefix(l, "gramework.job:") {
+			sampled++
+			if l != "gramework.job:1|ms|@0.01" {
+				t.Errorf("unexpected sampled timing: %q", l)
+			}
+		}
+	}
+	if sampled != 10 {
+		t.Errorf("expected 10 sampled timings, got %d", sampled)
+	}
+	if !contains(lines, "gramework.size:1|ms") {
+		t.Errorf("histogram without sampling should be sent without the rate: %q", lines)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	srv, read := testUDPServer(t)
+	app := gramework.New()
+	c, err := Register(app, srv.LocalAddr().String(), WithFlushInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.GET("/users/:id", "user")
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go func() {
+		_ = app.Serve(ln)
+	}()
+	client := &fasthttp.Client{
+		Dial: func(string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	for _, path := range []string{"/users/1", "/users/2", "/unknown"} {
+		if _, _, err = client.Get(nil, "http://example.com"+path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := read()
+	for _, expected := range []string{
+		"gramework.http.requests.http.none.GET.users_id.2xx.200:2|c",
+		"gramework.http.requests.http.none.GET.unmatched.4xx.404:1|c",
+		"gramework.http.requests.in_flight:0|g",
+	} {
+		if !contains(lines, expected) {
+			t.Errorf("line %q was not sent: %q", expected, lines)
+		}
+	}
+
+	timings := 0
+	for _, l := range lines {
+		if strings.HasPrefix(l, "gramework.http.request.duration.http.none.GET.users_id.2xx:") && strings.HasSuffix(l, "|ms") {
+			timings++
+		}
+	}
+	if timings != 2 {
+		t.Errorf("expected 2 duration timings, got %d: %q", timings, lines)
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+// Package accesslog provides access log middleware, that writes one line
+// per request in Common, Combined, JSON or logfmt format.
+package accesslog
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gramework/gramework"
+)
+
+const (
+	// DefaultBufferSize is the default count of lines waiting to be written
+	DefaultBufferSize = 4096
+
+	// DefaultFlushInterval is the default interval of flushing written lines
+	DefaultFlushInterval = time.Second
+)
+
+// Option configures the Logger
+type Option func(*Logger)
+
+// Logger writes access log lines asynchronously
+type Logger struct {
+	format        Format
+	out           io.Writer
+	sampleRate    float64
+	exclude       []string
+	bufferSize    int
+	flushInterval time.Duration
+
+	lines   chan []byte
+	done    chan struct{}
+	closeMu sync.RWMutex
+	closed  bool
+	dropped uint64
+	pool    sync.Pool
+}
+
+// WithFormat sets the line format. FormatCombined is used by default.
+func WithFormat(format Format) Option {
+	return func(l *Logger) {
+		l.format = format
+	}
+}
+
+// WithOutput sets the output. os.Stdout is used by default.
+// If the output implements io.Closer, it is closed by Logger.Close.
+func WithOutput(out io.Writer) Option {
+	return func(l *Logger) {
+		l.out = out
+	}
+}
+
+// WithSampleRate logs only the given fraction of successful requests, e.g. 0.1.
+// Requests with 4xx and 5xx status codes are always logged.
+func WithSampleRate(rate float64) Option {
+	return func(l *Logger) {
+		l.sampleRate = rate
+	}
+}
+
+// WithExclude disables logging of the paths, e.g. "/healthz" or "/metrics".
+// Paths ending with "*" are matched as prefixes.
+func WithExclude(paths ...string) Option {
+	return func(l *Logger) {
+		l.exclude = append(l.exclude, paths...)
+	}
+}
+
+// WithBufferSize sets the count of lines waiting to be written.
+// Lines are dropped if the buffer is full, see Logger.Dropped.
+func WithBufferSize(size int) Option {
+	return func(l *Logger) {
+		l.bufferSize = size
+	}
+}
+
+// WithFlushInterval sets how often written lines are flushed to the output
+func WithFlushInterval(d time.Duration) Option {
+	return func(l *Logger) {
+		l.flushInterval = d
+	}
+}
+
+// New returns a new access Logger and starts its writer
+func New(opts ...Option) *Logger {
+	l := &Logger{
+		format:        FormatCombined,
+		out:           os.Stdout,
+		sampleRate:    1,
+		bufferSize:    DefaultBufferSize,
+		flushInterval: DefaultFlushInterval,
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.bufferSize <= 0 {
+		l.bufferSize = DefaultBufferSize
+	}
+	if l.flushInterval <= 0 {
+		l.flushInterval = DefaultFlushInterval
+	}
+
+	l.lines = make(chan []byte, l.bufferSize)
+	go l.writeLoop()
+
+	return l
+}
+
+// Setup creates an access Logger and registers it in the app as an after request
+// middleware. The Logger is closed on app shutdown, see App.OnShutdown.
+func Setup(app *gramework.App, opts ...Option) (*Logger, error) {
+	l := New(opts...)
+	if err := app.UseAfterRequest(l.Middleware); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+	app.OnShutdown(func(context.Context) error {
+		return l.Close()
+	})
+
+	return l, nil
+}
+
+// Middleware writes the access log line for the request.
+// Register it with App.UseAfterRequest.
+func (l *Logger) Middleware(ctx *gramework.Context) {
+	if !l.shouldLog(ctx) {
+		return
+	}
+
+	l.Log(NewEntry(ctx))
+}
+
+// Log writes the entry asynchronously
+func (l *Logger) Log(e *Entry) {
+	bufPtr, ok := l.pool.Get().(*[]byte)
+	if !ok {
+		buf := make([]byte, 0, 256)
+		bufPtr = &buf
+	}
+	line := e.AppendFormat((*bufPtr)[:0], l.format)
+
+	l.closeMu.RLock()
+	defer l.closeMu.RUnlock()
+	if l.closed {
+		return
+	}
+
+	select {
+	case l.lines <- line:
+	default:
+		atomic.AddUint64(&l.dropped, 1)
+	}
+}
+
+// Dropped returns the count of lines dropped because the buffer was full
+func (l *Logger) Dropped() uint64 {
+	return atomic.LoadUint64(&l.dropped)
+}
+
+// Close flushes pending lines and closes the output, if it is an io.Closer
+func (l *Logger) Close() error {
+	l.closeMu.Lock()
+	if l.closed {
+		l.closeMu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.lines)
+	l.closeMu.Unlock()
+
+	<-l.done
+	if closer, ok := l.out.(io.Closer); ok && l.out != os.Stdout && l.out != os.Stderr {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+func (l *Logger) shouldLog(ctx *gramework.Context) bool {
+	path := string(ctx.Path())
+	for _, excluded := range l.exclude {
+		if strings.HasSuffix(excluded, "*") {
+			if strings.HasPrefix(path, strings.TrimSuffix(excluded, "*")) {
+				return false
+			}
+		} else if path == excluded {
+			return false
+		}
+	}
+
+	if l.sampleRate >= 1 || ctx.Response.StatusCode() >= 400 {
+		return true
+	}
+
+	return rand.Float64() < l.sampleRate
+}
+
+func (l *Logger) writeLoop() {
+	defer close(l.done)
+
+	w := bufio.NewWriter(l.out)
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case line, ok := <-l.lines:
+			if !ok {
+				_ = w.Flush()
+				return
+			}
+			_, _ = w.Write(line)
+			line = line[:0]
+			l.pool.Put(&line)
+			if len(l.lines) == 0 {
+				_ = w.Flush()
+			}
+		case <-ticker.C:
+			_ = w.Flush()
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gramework/gramework"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func testEntry() *Entry {
+	return &Entry{
+		Time:      time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+		RemoteIP:  "127.0.0.1",
+		Host:      "example.com",
+		Method:    "GET",
+		URI:       "/users/42?q=\"x\"",
+		Proto:     "HTTP/1.1",
+		Route:     "/users/:id",
+		Status:    200,
+		BytesIn:   0,
+		BytesOut:  2326,
+		Latency:   1500 * time.Microsecond,
+		UserAgent: "curl/7.0",
+		RequestID: "req-1",
+	}
+}
+
+func TestEntryFormats(t *testing.T) {
+	e := testEntry()
+
+	cases := map[Format]string{
+		FormatCommon:   `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /users/42?q=\"x\" HTTP/1.1" 200 2326` + "\n",
+		FormatCombined: `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /users/42?q=\"x\" HTTP/1.1" 200 2326 "-" "curl/7.0"` + "\n",
+		FormatLogfmt: `time=2000-10-10T13:55:36-07:00 remote_ip=127.0.0.1 host=example.com method=GET uri="/users/42?q=\"x\"" ` +
+			`proto=HTTP/1.1 route=/users/:id status=200 bytes_in=0 bytes_out=2326 latency_ms=1.500 user_agent=curl/7.0 request_id=req-1` + "\n",
+	}
+	for format, expected := range cases {
+		if line := string(e.AppendFormat(nil, format)); line != expected {
+			t.Errorf("format %d:\n got: %s\nwant: %s", format, line, expected)
+		}
+	}
+
+	line := e.AppendFormat(nil, FormatJSON)
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(line, &decoded); err != nil {
+		t.Fatalf("invalid JSON line %q: %s", line, err)
+	}
+	if decoded["route"] != "/users/:id" || decoded["status"] != float64(200) || decoded["latency_ms"] != 1.5 {
+		t.Errorf("unexpected JSON line: %s", line)
+	}
+	if !bytes.HasSuffix(line, []byte("\n")) || bytes.Count(line, []byte("\n")) != 1 {
+		t.Errorf("JSON line must be a single line: %q", line)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	out := &syncBuffer{}
+	app := gramework.New()
+	l, err := Setup(app,
+		WithOutput(out),
+		WithFormat(FormatLogfmt),
+		WithExclude("/healthz", "/metrics*"),
+		WithSampleRate(0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.GET("/users/:id", "user")
+	app.GET("/healthz", "ok")
+	app.GET("/metrics/go", "ok")
+	app.GET("/ok", "ok")
+	app.GET("/fail", func(ctx *gramework.Context) {
+		ctx.SetStatusCode(500)
+	})
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go func() {
+		_ = app.Serve(ln)
+	}()
+	c := &fasthttp.Client{
+		Dial: func(string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+
+	for _, path := range []string{"/users/42", "/healthz", "/metrics/go", "/ok", "/fail"} {
+		if _, _, err = c.Get(nil, "http://example.com"+path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected one line for the failed request, got %q", out.String())
+	}
+	// successful requests are sampled out, but 4xx and 5xx are always logged
+	if !strings.Contains(lines[0], "uri=/fail") || !strings.Contains(lines[0], "status=500") {
+		t.Errorf("unexpected line: %q", lines[0])
+	}
+}
+
+func TestMiddlewareRoute(t *testing.T) {
+	out := &syncBuffer{}
+	app := gramework.New()
+	l, err := Setup(app, WithOutput(out), WithFormat(FormatJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.GET("/users/:id", "user")
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go func() {
+		_ = app.Serve(ln)
+	}()
+	c := &fasthttp.Client{
+		Dial: func(string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI("http://example.com/users/42")
+	req.Header.SetUserAgent("test-agent")
+	req.Header.Set("X-Request-ID", "req-42")
+	if err = c.Do(req, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var e map[string]interface{}
+	if err = json.Unmarshal([]byte(out.String()), &e); err != nil {
+		t.Fatalf("invalid line %q: %s", out.String(), err)
+	}
+	if e["route"] != "/users/:id" || e["uri"] != "/users/42" || e["user_agent"] != "test-agent" ||
+		e["request_id"] != "req-42" || e["bytes_out"] != float64(4) {
+		t.Errorf("unexpected entry: %v", e)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := NewRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err = f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for file, content := range expected {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("%s: expected %q, got %q", file, content, data)
+		}
+	}
+	if _, err = os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("only 2 backups should be kept")
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package accesslog
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gramework/gramework"
+)
+
+// Format is an access log line format
+type Format int
+
+const (
+	// FormatCommon is the Common Log Format
+	FormatCommon Format = iota
+	// FormatCombined is the Combined Log Format
+	FormatCombined
+	// FormatJSON writes JSON lines
+	FormatJSON
+	// FormatLogfmt writes logfmt lines
+	FormatLogfmt
+)
+
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// Entry is an access log entry
+type Entry struct {
+	Time      time.Time     `json:"time"`
+	RemoteIP  string        `json:"remote_ip"`
+	Host      string        `json:"host"`
+	Method    string        `json:"method"`
+	URI       string        `json:"uri"`
+	Proto     string        `json:"proto"`
+	Route     string        `json:"route,omitempty"`
+	Status    int           `json:"status"`
+	BytesIn   int           `json:"bytes_in"`
+	BytesOut  int           `json:"bytes_out"`
+	Latency   time.Duration `json:"-"`
+	UserAgent string        `json:"user_agent,omitempty"`
+	Referer   string        `json:"referer,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+}
+
+// NewEntry returns the access log entry of the processed request
+func NewEntry(ctx *gramework.Context) *Entry {
+	bytesIn := len(ctx.Request.Body())
+	if cl := ctx.Request.Header.ContentLength(); cl > bytesIn {
+		bytesIn = cl
+	}
+	bytesOut := len(ctx.Response.Body())
+	if cl := ctx.Response.Header.ContentLength(); cl > bytesOut {
+		bytesOut = cl
+	}
+
+	proto := "HTTP/1.1"
+	if !ctx.Request.Header.IsHTTP11() {
+		proto = "HTTP/1.0"
+	}
+
+	return &Entry{
+		Time:      ctx.Time(),
+		RemoteIP:  ctx.RemoteIP().String(),
+		Host:      string(ctx.Host()),
+		Method:    string(ctx.Method()),
+		URI:       string(ctx.RequestURI()),
+		Proto:     proto,
+		Route:     ctx.RouteTemplate(),
+		Status:    ctx.Response.StatusCode(),
+		BytesIn:   bytesIn,
+		BytesOut:  bytesOut,
+		Latency:   time.Since(ctx.Time()),
+		UserAgent: string(ctx.UserAgent()),
+		Referer:   string(ctx.Referer()),
+		RequestID: ctx.RequestID(),
+	}
+}
+
+// AppendFormat appends the entry line, including the trailing newline, to b
+func (e *Entry) AppendFormat(b []byte, format Format) []byte {
+	switch format {
+	case FormatJSON:
+		return e.appendJSON(b)
+	case FormatLogfmt:
+		return e.appendLogfmt(b)
+	case FormatCombined:
+		b = e.appendCommon(b)
+		b = append(b, ' ')
+		b = appendQuotedOrDash(b, e.Referer)
+		b = append(b, ' ')
+		b = appendQuotedOrDash(b, e.UserAgent)
+		return append(b, '\n')
+	}
+
+	return append(e.appendCommon(b), '\n')
+}
+
+// appendCommon appends the Common Log Format line without the newline
+func (e *Entry) appendCommon(b []byte) []byte {
+	b = append(b, e.RemoteIP...)
+	b = append(b, " - - ["...)
+	b = e.Time.AppendFormat(b, clfTimeLayout)
+	b = append(b, "] \""...)
+	b = append(b, e.Method...)
+	b = append(b, ' ')
+	b = append(b, escapeQuoted(e.URI)...)
+	b = append(b, ' ')
+	b = append(b, e.Proto...)
+	b = append(b, "\" "...)
+	b = strconv.AppendInt(b, int64(e.Status), 10)
+	b = append(b, ' ')
+	if e.BytesOut == 0 {
+		return append(b, '-')
+	}
+
+	return strconv.AppendInt(b, int64(e.BytesOut), 10)
+}
+
+func (e *Entry) appendJSON(b []byte) []byte {
+	type jsonEntry struct {
+		*Entry
+		LatencyMS float64 `json:"latency_ms"`
+	}
+	line, err := json.Marshal(jsonEntry{
+		Entry:     e,
+		LatencyMS: float64(e.Latency) / float64(time.Millisecond),
+	})
+	if err != nil {
+		return b
+	}
+
+	b = append(b, line...)
+	return append(b, '\n')
+}
+
+func (e *Entry) appendLogfmt(b []byte) []byte {
+	b = appendLogfmtPair(b, "time", e.Time.Format(time.RFC3339Nano))
+	b = appendLogfmtPair(b, "remote_ip", e.RemoteIP)
+	b = appendLogfmtPair(b, "host", e.Host)
+	b = appendLogfmtPair(b, "method", e.Method)
+	b = appendLogfmtPair(b, "uri", e.URI)
+	b = appendLogfmtPair(b, "proto", e.Proto)
+	if len(e.Route) > 0 {
+		b = appendLogfmtPair(b, "route", e.Route)
+	}
+	b = appendLogfmtPair(b, "status", strconv.Itoa(e.Status))
+	b = appendLogfmtPair(b, "bytes_in", strconv.Itoa(e.BytesIn))
+	b = appendLogfmtPair(b, "bytes_out", strconv.Itoa(e.BytesOut))
+	b = appendLogfmtPair(b, "latency_ms", strconv.FormatFloat(float64(e.Latency)/float64(time.Millisecond), 'f', 3, 64))
+	if len(e.UserAgent) > 0 {
+		b = appendLogfmtPair(b, "user_agent", e.UserAgent)
+	}
+	if len(e.Referer) > 0 {
+		b = appendLogfmtPair(b, "referer", e.Referer)
+	}
+	if len(e.RequestID) > 0 {
+		b = appendLogfmtPair(b, "request_id", e.RequestID)
+	}
+
+	b[len(b)-1] = '\n'
+	return b
+}
+
+// appendLogfmtPair appends key=value with a trailing space
+func appendLogfmtPair(b []byte, key, value string) []byte {
+	b = append(b, key...)
+	b = append(b, '=')
+	if len(value) == 0 || strings.ContainsAny(value, " =\"\\\t\r\n") {
+		b = strconv.AppendQuote(b, value)
+	} else {
+		b = append(b, value...)
+	}
+
+	return append(b, ' ')
+}
+
+func appendQuotedOrDash(b []byte, s string) []byte {
+	if len(s) == 0 {
+		return append(b, "\"-\""...)
+	}
+	b = append(b, '"')
+	b = append(b, escapeQuoted(s)...)
+	return append(b, '"')
+}
+
+// escapeQuoted escapes quotes, backslashes and control characters,
+// so the value can't break the line
+func escapeQuoted(s string) string {
+	if !strings.ContainsAny(s, "\"\\\r\n\t") {
+		return s
+	}
+
+	quoted := strconv.Quote(s)
+	return quoted[1 : len(quoted)-1]
+}
@@ -0,0 +1,305 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+// Package compress provides response compression middleware, that negotiates
+// gzip, brotli, deflate and zstd encodings with Accept-Encoding, see Setup.
+package compress
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/gramework/gramework"
+	"github.com/klauspost/compress/zstd"
+	"github.com/valyala/fasthttp"
+)
+
+// Supported content encodings
+const (
+	EncodingBrotli  = "br"
+	EncodingZstd    = "zstd"
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+// DefaultMinSize is the default min size of the response body to compress
+const DefaultMinSize = 1024
+
+// DefaultEncodings are the encodings, enabled by default, in the server preference order
+var DefaultEncodings = []string{EncodingBrotli, EncodingZstd, EncodingGzip, EncodingDeflate}
+
+// DefaultContentTypes are the content types, compressed by default.
+// Types ending with "/" match all subtypes.
+var DefaultContentTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/x-javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/ld+json",
+	"application/manifest+json",
+	"application/wasm",
+	"image/svg+xml",
+	"font/ttf",
+	"font/otf",
+}
+
+// default compression levels
+var defaultLevels = map[string]int{
+	EncodingBrotli:  fasthttp.CompressBrotliDefaultCompression,
+	EncodingZstd:    int(zstd.SpeedDefault),
+	EncodingGzip:    fasthttp.CompressDefaultCompression,
+	EncodingDeflate: fasthttp.CompressDefaultCompression,
+}
+
+// Option configures the Compressor
+type Option func(*Compressor)
+
+// WithEncodings sets enabled encodings in the server preference order,
+// that is used if the client accepts some of them with the same quality.
+// DefaultEncodings are used by default.
+func WithEncodings(encodings ...string) Option {
+	return func(c *Compressor) {
+		c.encodings = encodings
+	}
+}
+
+// WithLevel sets the compression level of the encoding: fasthttp.CompressBrotli* levels for brotli,
+// fasthttp.Compress* levels for gzip and deflate and zstd.EncoderLevel for zstd.
+func WithLevel(encoding string, level int) Option {
+	return func(c *Compressor) {
+		c.levels[encoding] = level
+	}
+}
+
+// WithMinSize sets the min size of the response body to compress, DefaultMinSize is used by default
+func WithMinSize(size int) Option {
+	return func(c *Compressor) {
+		c.minSize = size
+	}
+}
+
+// WithContentTypes sets the content types to compress, DefaultContentTypes are used by default.
+// Types ending with "/" match all subtypes.
+func WithContentTypes(types ...string) Option {
+	return func(c *Compressor) {
+		c.contentTypes = types
+	}
+}
+
+// Compressor compresses responses
+type Compressor struct {
+	encodings    []string
+	levels       map[string]int
+	minSize      int
+	contentTypes []string
+
+	zstd *zstd.Encoder
+}
+
+// New returns a new Compressor
+func New(opts ...Option) (*Compressor, error) {
+	c := &Compressor{
+		encodings:    DefaultEncodings,
+		levels:       make(map[string]int, len(defaultLevels)),
+		minSize:      DefaultMinSize,
+		contentTypes: DefaultContentTypes,
+	}
+	for encoding, level := range defaultLevels {
+		c.levels[encoding] = level
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	for _, encoding := range c.encodings {
+		switch encoding {
+		case EncodingBrotli, EncodingGzip, EncodingDeflate:
+		case EncodingZstd:
+			enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevel(c.levels[EncodingZstd])))
+			if err != nil {
+				return nil, err
+			}
+			c.zstd = enc
+		default:
+			return nil, &UnsupportedEncodingError{Encoding: encoding}
+		}
+	}
+
+	return c, nil
+}
+
+// Setup creates a Compressor and registers it in the app as an after request middleware,
+// so it compresses responses of all handlers. Register it after the ETag middleware,
+// if any: strong ETags of compressed responses are weakened.
+func Setup(app *gramework.App, opts ...Option) (*Compressor, error) {
+	c, err := New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = app.UseAfterRequest(c.Middleware); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// UnsupportedEncodingError is returned by New for unknown encodings
+type UnsupportedEncodingError struct {
+	Encoding string
+}
+
+func (e *UnsupportedEncodingError) Error() string {
+	return "compress: unsupported encoding " + strconv.Quote(e.Encoding)
+}
+
+// Middleware compresses the response with the encoding, negotiated with Accept-Encoding.
+// Responses with streamed bodies, with Content-Encoding, with Cache-Control: no-transform,
+// with content types, that are not allowed, and smaller than min size are not compressed.
+// Register it with App.UseAfterRequest.
+func (c *Compressor) Middleware(ctx *gramework.Context) {
+	resp := &ctx.Response
+	switch status := resp.StatusCode(); {
+	case status < fasthttp.StatusOK,
+		status == fasthttp.StatusNoContent,
+		status == fasthttp.StatusPartialContent,
+		status == fasthttp.StatusNotModified:
+		return
+	}
+	if resp.IsBodyStream() || len(resp.Header.Peek(fasthttp.HeaderContentEncoding)) > 0 ||
+		!c.compressible(resp.Header.ContentType()) ||
+		bytes.Contains(resp.Header.Peek(fasthttp.HeaderCacheControl), []byte("no-transform")) {
+		return
+	}
+
+	// the response depends on Accept-Encoding even if it is not compressed this time
+	addVary(resp)
+
+	body := resp.Body()
+	if len(body) < c.minSize {
+		return
+	}
+	encoding := c.negotiate(ctx.Request.Header.Peek(fasthttp.HeaderAcceptEncoding), nil)
+	if encoding == "" {
+		return
+	}
+
+	compressed := c.compress(encoding, body)
+	if len(compressed) >= len(body) {
+		return
+	}
+	resp.SetBodyRaw(compressed)
+	resp.Header.Set(fasthttp.HeaderContentEncoding, encoding)
+	weakenETag(resp)
+}
+
+func (c *Compressor) compress(encoding string, body []byte) []byte {
+	level := c.levels[encoding]
+	switch encoding {
+	case EncodingBrotli:
+		return fasthttp.AppendBrotliBytesLevel(nil, body, level)
+	case EncodingZstd:
+		return c.zstd.EncodeAll(body, make([]byte, 0, len(body)/2))
+	case EncodingGzip:
+		return fasthttp.AppendGzipBytesLevel(nil, body, level)
+	case EncodingDeflate:
+		return fasthttp.AppendDeflateBytesLevel(nil, body, level)
+	}
+	return body
+}
+
+func (c *Compressor) compressible(contentType []byte) bool {
+	if i := bytes.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = bytes.ToLower(bytes.TrimSpace(contentType))
+	for _, t := range c.contentTypes {
+		if strings.HasSuffix(t, "/") && bytes.HasPrefix(contentType, []byte(t)) || string(contentType) == t {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiate returns the enabled encoding with the highest quality in Accept-Encoding.
+// If available is not nil, only available encodings are considered.
+func (c *Compressor) negotiate(acceptEncoding []byte, available func(encoding string) bool) string {
+	if len(acceptEncoding) == 0 {
+		return ""
+	}
+	qualities := parseAcceptEncoding(acceptEncoding)
+
+	best, bestQ := "", 0.0
+	for _, encoding := range c.encodings {
+		q, ok := qualities[encoding]
+		if !ok {
+			q = qualities["*"]
+		}
+		if q > bestQ && (available == nil || available(encoding)) {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// parseAcceptEncoding returns qualities of listed encodings
+func parseAcceptEncoding(h []byte) map[string]float64 {
+	qualities := make(map[string]float64)
+	for _, item := range strings.Split(string(h), ",") {
+		params := strings.Split(item, ";")
+		encoding := strings.ToLower(strings.TrimSpace(params[0]))
+		if encoding == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") || strings.HasPrefix(param, "Q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil || v < 0 || v > 1 {
+					v = 0
+				}
+				q = v
+			}
+		}
+		if encoding == "x-gzip" {
+			encoding = EncodingGzip
+		}
+		qualities[encoding] = q
+	}
+	return qualities
+}
+
+func addVary(resp *fasthttp.Response) {
+	vary := resp.Header.Peek(fasthttp.HeaderVary)
+	for _, v := range bytes.Split(vary, []byte(",")) {
+		v = bytes.TrimSpace(v)
+		if string(v) == "*" || bytes.EqualFold(v, []byte(fasthttp.HeaderAcceptEncoding)) {
+			return
+		}
+	}
+	if len(vary) == 0 {
+		resp.Header.Set(fasthttp.HeaderVary, fasthttp.HeaderAcceptEncoding)
+		return
+	}
+	resp.Header.Set(fasthttp.HeaderVary, string(vary)+", "+fasthttp.HeaderAcceptEncoding)
+}
+
+// weakenETag makes the strong ETag weak: the compressed representation
+// is not byte-for-byte identical to the one the ETag was generated for
+func weakenETag(resp *fasthttp.Response) {
+	etag := resp.Header.Peek(fasthttp.HeaderETag)
+	if len(etag) > 0 && !bytes.HasPrefix(etag, []byte("W/")) {
+		resp.Header.Set(fasthttp.HeaderETag, "W/"+string(etag))
+	}
+}
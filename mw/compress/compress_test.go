@@ -0,0 +1,246 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package compress
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gramework/gramework"
+	"github.com/klauspost/compress/zstd"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func testClient(ln *fasthttputil.InmemoryListener) *fasthttp.Client {
+	return &fasthttp.Client{
+		Dial: func(string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+}
+
+func testServe(t *testing.T, app *gramework.App) *fasthttputil.InmemoryListener {
+	t.Helper()
+	ln := fasthttputil.NewInmemoryListener()
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	go func() {
+		_ = app.Serve(ln)
+	}()
+	return ln
+}
+
+func testGet(t *testing.T, ln *fasthttputil.InmemoryListener, uri, acceptEncoding string) *fasthttp.Response {
+	t.Helper()
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI("http://example.com" + uri)
+	if acceptEncoding != "" {
+		req.Header.Set(fasthttp.HeaderAcceptEncoding, acceptEncoding)
+	}
+	resp := &fasthttp.Response{}
+	if err := testClient(ln).Do(req, resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func decode(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+	var (
+		out []byte
+		err error
+	)
+	switch encoding {
+	case EncodingBrotli:
+		out, err = fasthttp.AppendUnbrotliBytes(nil, body)
+	case EncodingGzip:
+		out, err = fasthttp.AppendGunzipBytes(nil, body)
+	case EncodingDeflate:
+		out, err = fasthttp.AppendInflateBytes(nil, body)
+	case EncodingZstd:
+		var d *zstd.Decoder
+		if d, err = zstd.NewReader(nil); err == nil {
+			out, err = d.DecodeAll(body, nil)
+			d.Close()
+		}
+	default:
+		return string(body)
+	}
+	if err != nil {
+		t.Fatalf("cannot decode %s body: %s", encoding, err)
+	}
+	return string(out)
+}
+
+func TestNegotiate(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"":                              "",
+		"identity":                      "",
+		"gzip":                          EncodingGzip,
+		"x-gzip":                        EncodingGzip,
+		"gzip, deflate, br":             EncodingBrotli,
+		"gzip;q=1.0, br;q=0.5":          EncodingGzip,
+		"br;q=0, gzip;q=0.1":            EncodingGzip,
+		"*":                             EncodingBrotli,
+		"*;q=0.5, br;q=0, zstd;q=0":     EncodingGzip,
+		"deflate;q=0.9, zstd;q=0.9":     EncodingZstd,
+		"gzip;q=invalid, deflate;q=0.1": EncodingDeflate,
+	}
+	for header, expected := range cases {
+		if got := c.negotiate([]byte(header), nil); got != expected {
+			t.Errorf("%q: expected %q, got %q", header, expected, got)
+		}
+	}
+
+	c, err = New(WithEncodings(EncodingGzip, EncodingBrotli))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.negotiate([]byte("br, gzip"), nil); got != EncodingGzip {
+		t.Errorf("server preference should win on equal quality, got %q", got)
+	}
+
+	if _, err = New(WithEncodings("lzma")); err == nil {
+		t.Errorf("unsupported encoding should be rejected")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	body := strings.Repeat("gramework compresses responses. ", 100)
+
+	app := gramework.New()
+	if err := app.UseETag(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Setup(app, WithLevel(EncodingGzip, fasthttp.CompressBestSpeed)); err != nil {
+		t.Fatal(err)
+	}
+	app.GET("/text", func(ctx *gramework.Context) {
+		ctx.SetContentType("text/plain; charset=utf-8")
+		ctx.WriteString(body)
+	})
+	app.GET("/small", func(ctx *gramework.Context) {
+		ctx.SetContentType("application/json")
+		ctx.WriteString(`{"ok":true}`)
+	})
+	app.GET("/image", func(ctx *gramework.Context) {
+		ctx.SetContentType("image/png")
+		ctx.WriteString(body)
+	})
+	app.GET("/encoded", func(ctx *gramework.Context) {
+		ctx.SetContentType("text/plain")
+		ctx.Response.Header.Set(fasthttp.HeaderContentEncoding, "gzip")
+		ctx.WriteString(body)
+	})
+	app.GET("/notransform", func(ctx *gramework.Context) {
+		ctx.SetContentType("text/plain")
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "no-transform")
+		ctx.WriteString(body)
+	})
+	ln := testServe(t, app)
+
+	for _, encoding := range DefaultEncodings {
+		resp := testGet(t, ln, "/text", encoding)
+		if got := string(resp.Header.Peek(fasthttp.HeaderContentEncoding)); got != encoding {
+			t.Fatalf("expected %s encoding, got %q", encoding, got)
+		}
+		if len(resp.Body()) >= len(body) {
+			t.Errorf("%s: body is not compressed", encoding)
+		}
+		if got := decode(t, encoding, resp.Body()); got != body {
+			t.Errorf("%s: unexpected decoded body %q", encoding, got)
+		}
+		if got := string(resp.Header.Peek(fasthttp.HeaderVary)); got != fasthttp.HeaderAcceptEncoding {
+			t.Errorf("%s: unexpected Vary %q", encoding, got)
+		}
+		if etag := string(resp.Header.Peek(fasthttp.HeaderETag)); !strings.HasPrefix(etag, `W/"`) {
+			t.Errorf("%s: ETag of compressed response should be weak, got %q", encoding, etag)
+		}
+	}
+
+	resp := testGet(t, ln, "/text", "")
+	if len(resp.Header.Peek(fasthttp.HeaderContentEncoding)) > 0 || string(resp.Body()) != body ||
+		string(resp.Header.Peek(fasthttp.HeaderVary)) != fasthttp.HeaderAcceptEncoding {
+		t.Errorf("response should not be compressed without Accept-Encoding, but should vary by it")
+	}
+
+	for _, uri := range []string{"/small", "/image", "/notransform"} {
+		if resp = testGet(t, ln, uri, "gzip"); len(resp.Header.Peek(fasthttp.HeaderContentEncoding)) > 0 {
+			t.Errorf("%s: should not be compressed", uri)
+		}
+	}
+	if resp = testGet(t, ln, "/encoded", "br"); string(resp.Header.Peek(fasthttp.HeaderContentEncoding)) != "gzip" ||
+		string(resp.Body()) != body {
+		t.Errorf("already encoded response should not be compressed again")
+	}
+}
+
+func TestPrecompressed(t *testing.T) {
+	dir := t.TempDir()
+	js := strings.Repeat("console.log('gramework');\n", 100)
+	brotli := fasthttp.AppendBrotliBytes(nil, []byte(js))
+	files := map[string][]byte{
+		"app.js":      []byte(js),
+		"app.js.br":   brotli,
+		"style.css":   []byte("body{}"),
+		"style.css.x": []byte("unknown"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := gramework.New()
+	app.GET("/*any", c.Precompressed(dir, app.ServeDirNoCacheCustom(dir, 0, false, false, nil)))
+	ln := testServe(t, app)
+
+	resp := testGet(t, ln, "/app.js", "gzip, br")
+	if string(resp.Header.Peek(fasthttp.HeaderContentEncoding)) != EncodingBrotli || string(resp.Body()) != string(brotli) {
+		t.Fatalf("expected precompressed brotli file, got %q encoding", resp.Header.Peek(fasthttp.HeaderContentEncoding))
+	}
+	if ct := string(resp.Header.ContentType()); !strings.HasPrefix(ct, "text/javascript") && !strings.HasPrefix(ct, "application/javascript") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	if string(resp.Header.Peek(fasthttp.HeaderVary)) != fasthttp.HeaderAcceptEncoding {
+		t.Errorf("precompressed response should vary by Accept-Encoding")
+	}
+
+	resp = testGet(t, ln, "/app.js", "gzip")
+	if len(resp.Header.Peek(fasthttp.HeaderContentEncoding)) > 0 || string(resp.Body()) != js {
+		t.Fatalf("expected the original file without gzip sibling")
+	}
+
+	resp = testGet(t, ln, "/style.css", "br")
+	if len(resp.Header.Peek(fasthttp.HeaderContentEncoding)) > 0 || string(resp.Body()) != "body{}" {
+		t.Fatalf("expected the original file without siblings")
+	}
+
+	resp = testGet(t, ln, "/../../etc/passwd", "br")
+	if resp.StatusCode() == fasthttp.StatusOK {
+		t.Fatalf("path traversal should not be served")
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package compress
+
+import (
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/gramework/gramework"
+	"github.com/valyala/fasthttp"
+)
+
+// PrecompressedSuffixes are the file name suffixes of precompressed files by encoding
+var PrecompressedSuffixes = map[string]string{
+	EncodingBrotli: ".br",
+	EncodingZstd:   ".zst",
+	EncodingGzip:   ".gz",
+}
+
+// Precompressed serves precompressed siblings of static files from the root directory,
+// e.g. app.js.br or app.js.gz for /app.js, negotiated with Accept-Encoding.
+// Requests for files without precompressed siblings are passed to next,
+// e.g. a handler returned by App.ServeDir:
+//
+//	app.GET("/static/*any", c.Precompressed("./public", app.ServeDir("./public")))
+//
+// The request path is resolved relative to the root, as App.ServeDir does.
+func (c *Compressor) Precompressed(root string, next func(*gramework.Context)) func(*gramework.Context) {
+	return func(ctx *gramework.Context) {
+		if !ctx.IsGet() && !ctx.IsHead() {
+			next(ctx)
+			return
+		}
+
+		filePath := filepath.Join(root, filepath.FromSlash(path.Clean("/"+string(ctx.Path()))))
+		if info, err := os.Stat(filePath); err != nil || !info.Mode().IsRegular() {
+			next(ctx)
+			return
+		}
+
+		encoding := c.negotiate(ctx.Request.Header.Peek(fasthttp.HeaderAcceptEncoding), func(encoding string) bool {
+			suffix, ok := PrecompressedSuffixes[encoding]
+			if !ok {
+				return false
+			}
+			info, err := os.Stat(filePath + suffix)
+			return err == nil && info.Mode().IsRegular()
+		})
+		if encoding == "" {
+			next(ctx)
+			return
+		}
+
+		// fasthttp.ServeFileUncompressed rewrites the request URI and drops Accept-Encoding
+		uri := append([]byte(nil), ctx.Request.Header.RequestURI()...)
+		acceptEncoding := append([]byte(nil), ctx.Request.Header.Peek(fasthttp.HeaderAcceptEncoding)...)
+		fasthttp.ServeFileUncompressed(ctx.RequestCtx, filePath+PrecompressedSuffixes[encoding])
+		ctx.Request.SetRequestURIBytes(uri)
+		ctx.Request.Header.SetBytesV(fasthttp.HeaderAcceptEncoding, acceptEncoding)
+
+		switch ctx.Response.StatusCode() {
+		case fasthttp.StatusOK, fasthttp.StatusPartialContent, fasthttp.StatusNotModified:
+		default:
+			return
+		}
+		contentType := mime.TypeByExtension(filepath.Ext(filePath))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		ctx.Response.Header.SetContentType(contentType)
+		ctx.Response.Header.Set(fasthttp.HeaderContentEncoding, encoding)
+		addVary(&ctx.Response)
+	}
+}
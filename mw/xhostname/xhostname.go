@@ -41,7 +41,7 @@ func init() {
 func Setup(app *gramework.App) {
 	err := app.UseAfterRequest(serveXHost)
 	if err != nil {
-		app.Logger.WithError(err).WithField("package", "mw/xhostname").Error("could not register middleware")
+		app.PackageLogger("mw/xhostname").WithError(err).Error("could not register middleware")
 	}
 }
 
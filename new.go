@@ -14,7 +14,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/apex/log"
 	"github.com/microcosm-cc/bluemonday"
 	"github.com/valyala/fasthttp"
 )
@@ -24,15 +23,6 @@ var defaultMaxHackAttempts int32 = 5
 // New App
 func New(opts ...func(*App)) *App {
 	logger := Logger
-	internalLog = func() *log.Entry {
-		glogger := Logger.WithField("package", "gramework")
-		glogger.Level = log.DebugLevel
-		if !enableDebug {
-			glogger.Level = log.InfoLevel
-		}
-
-		return glogger
-	}()
 	flags := &Flags{
 		values: make(map[string]Flag),
 	}
@@ -62,9 +52,18 @@ func New(opts ...func(*App)) *App {
 		seed:                      uintptr(time.Now().Nanosecond()),
 		maxHackAttempts:           &maxHackAttempts,
 		runningServersMu:          new(sync.Mutex),
-		internalLog:               internalLog,
 		cookieExpire:              6 * time.Hour,
 		cookiePath:                defaultCookiePath,
+		lifecycleMu:               new(sync.Mutex),
+		healthMu:                  new(sync.RWMutex),
+		requestLimitsMu:           new(sync.RWMutex),
+		cache:                     newSharedCache(),
+		shutdownTimeout:           DefaultShutdownTimeout,
+		upgradeTimeout:            DefaultUpgradeTimeout,
+		tlsCerts:                  newCertStore(),
+		tlsReloadInterval:         DefaultTLSReloadInterval,
+		acmeMu:                    new(sync.Mutex),
+		acmeMountOnce:             new(sync.Once),
 
 		sanitizerPolicy: bluemonday.StrictPolicy(),
 	}
@@ -79,7 +78,11 @@ func New(opts ...func(*App)) *App {
 	// avoid race condition then OptUseServer becomes before OptAppName
 	// or OptUseCustomLogger becomes before OptUseServer
 	app.serverBase.Name = app.name
-	app.serverBase.Logger = NewFastHTTPLoggerAdapter(&app.Logger)
+	if app.logLevel != nil {
+		app.SetLogLevel(*app.logLevel)
+	} else {
+		app.initLoggers()
+	}
 
 	app.defaultRouter = &Router{
 		router: newRouter(),
@@ -12,6 +12,7 @@ package gramework
 
 import (
 	"errors"
+	"net"
 
 	"github.com/apex/log"
 	"github.com/valyala/fasthttp"
@@ -72,6 +73,19 @@ func OptKeepHijackedConns(keep bool) func(*App) {
 	}
 }
 
+// OptListenerWrapper adds a wrapper that will be applied to every listener
+// the app serves on, e.g. a PROXY protocol parser from behind/proxyproto.
+// Wrappers are applied in the order they were added.
+func OptListenerWrapper(wrapper func(net.Listener) net.Listener) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		if wrapper == nil {
+			panic(errors.New("cannot use nil as listener wrapper"))
+		}
+		app.listenerWrappers = append(app.listenerWrappers, wrapper)
+	}
+}
+
 func assertAppNotNill(app *App) {
 	if app == nil {
 		panic(errors.New("option can be implemented only to already creaded app object not to nil"))
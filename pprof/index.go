@@ -0,0 +1,77 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package pprof
+
+import (
+	"html/template"
+	rpprof "runtime/pprof"
+	"sort"
+	"strings"
+
+	"github.com/gramework/gramework"
+)
+
+type indexProfile struct {
+	Name  string
+	Count int
+	Href  string
+}
+
+// indexTmpl mirrors the net/http/pprof index, but uses absolute links,
+// because gramework routes have no trailing slash
+var indexTmpl = template.Must(template.New("index").Parse(`<html>
+<head>
+<title>{{.Prefix}}/</title>
+</head>
+<body>
+{{.Prefix}}/<br>
+<p>Set debug=1 as a query parameter to export in legacy text format</p>
+<br>
+Types of profiles available:
+<table>
+<thead><td>Count</td><td>Profile</td></thead>
+{{range .Profiles}}<tr><td>{{if .Count}}{{.Count}}{{end}}</td><td><a href="{{.Href}}">{{.Name}}</a></td></tr>
+{{end}}</table>
+<a href="{{.Prefix}}/goroutine?debug=2">full goroutine stack dump</a>
+</body>
+</html>
+`))
+
+func serveIndex(ctx *gramework.Context, prefix string) {
+	prefix = strings.TrimRight(prefix, "/")
+
+	var profiles []indexProfile
+	for _, p := range rpprof.Profiles() {
+		profiles = append(profiles, indexProfile{
+			Name:  p.Name(),
+			Count: p.Count(),
+			Href:  prefix + "/" + p.Name() + "?debug=1",
+		})
+	}
+	for _, name := range []string{"cmdline", "profile", "symbol", "trace"} {
+		profiles = append(profiles, indexProfile{
+			Name: name,
+			Href: prefix + "/" + name,
+		})
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+
+	ctx.HTML()
+	err := indexTmpl.Execute(ctx, struct {
+		Prefix   string
+		Profiles []indexProfile
+	}{prefix, profiles})
+	if err != nil {
+		ctx.Err500(err)
+	}
+}
@@ -1,7 +1,10 @@
+// Package pprof serves runtime profiling data in the format expected
+// by the pprof visualization tool, see Register.
 package pprof
 
 import (
 	"net/http/pprof"
+	rpprof "runtime/pprof"
 
 	"github.com/gramework/gramework"
 )
@@ -11,15 +14,18 @@ var (
 	profile = gramework.NewGrameHandlerFunc(pprof.Profile)
 	symbol  = gramework.NewGrameHandlerFunc(pprof.Symbol)
 	trace   = gramework.NewGrameHandlerFunc(pprof.Trace)
-	index   = gramework.NewGrameHandlerFunc(pprof.Index)
 )
 
 // Handler serves server runtime profiling data in the format expected by the pprof visualization tool.
+// The profile name is read from the "type" route argument, e.g. "/debug/pprof/:type",
+// and the profiles index is served if it is empty. Named profiles, e.g. heap, goroutine,
+// allocs, block and mutex, are served by their names.
+//
+// Handler is not protected, see Register.
 //
 // See https://golang.org/pkg/net/http/pprof/ for details.
 func Handler(ctx *gramework.Context) {
-	ctx.HTML()
-	switch ctx.RouteArg("type") {
+	switch name := ctx.RouteArg("type"); name {
 	case "cmdline":
 		cmdline(ctx)
 	case "symbol":
@@ -28,7 +34,14 @@ func Handler(ctx *gramework.Context) {
 		profile(ctx)
 	case "trace":
 		trace(ctx)
+	case "":
+		serveIndex(ctx, string(ctx.Path()))
 	default:
-		index(ctx)
+		if rpprof.Lookup(name) == nil {
+			ctx.SetStatusCode(404)
+			ctx.SetBodyString("Unknown profile")
+			return
+		}
+		gramework.NewGrameHandler(pprof.Handler(name))(ctx)
 	}
 }
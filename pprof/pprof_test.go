@@ -0,0 +1,183 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package pprof
+
+import (
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/gramework/gramework"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func testServe(t *testing.T, app *gramework.App) *fasthttp.Client {
+	ln := fasthttputil.NewInmemoryListener()
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	go func() {
+		_ = app.Serve(ln)
+	}()
+	return &fasthttp.Client{
+		Dial: func(string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+}
+
+func testDo(t *testing.T, c *fasthttp.Client, method, uri, login, password string) (int, string, error) {
+	t.Helper()
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.Header.SetMethod(method)
+	req.SetRequestURI("http://localhost" + uri)
+	if login != "" {
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(login+":"+password)))
+	}
+	err := c.Do(req, resp)
+	return resp.StatusCode(), string(resp.Body()), err
+}
+
+func TestRegister(t *testing.T) {
+	app := gramework.New()
+	app.MaxHackAttempts(-1)
+	if err := Register(app, WithBasicAuth("admin", "secret")); err != nil {
+		t.Fatal(err)
+	}
+	c := testServe(t, app)
+
+	code, _, err := testDo(t, c, "GET", "/debug/pprof/heap", "", "")
+	if err != nil || code != fasthttp.StatusUnauthorized {
+		t.Fatalf("unauthorized request should fail, got %d %v", code, err)
+	}
+	if code, _, err = testDo(t, c, "GET", "/debug/pprof/heap", "admin", "wrong"); err != nil || code != fasthttp.StatusUnauthorized {
+		t.Fatalf("request with wrong password should fail, got %d %v", code, err)
+	}
+
+	for uri, expected := range map[string]string{
+		"/debug/pprof":                      `<a href="/debug/pprof/heap?debug=1">heap</a>`,
+		"/debug/pprof/goroutine?debug=1":    "goroutine profile:",
+		"/debug/pprof/heap?debug=1":         "heap profile:",
+		"/debug/pprof/allocs?debug=1":       "heap profile:",
+		"/debug/pprof/threadcreate?debug=1": "threadcreate profile:",
+		"/debug/pprof/cmdline":              "pprof.test",
+	} {
+		code, body, err := testDo(t, c, "GET", uri, "admin", "secret")
+		if err != nil || code != fasthttp.StatusOK || !strings.Contains(body, expected) {
+			t.Errorf("%s: expected %q, got %d %v: %.200s", uri, expected, code, err, body)
+		}
+	}
+
+	if code, _, err = testDo(t, c, "GET", "/debug/pprof/unknown", "admin", "secret"); err != nil || code != fasthttp.StatusNotFound {
+		t.Errorf("unknown profile should not be found, got %d %v", code, err)
+	}
+}
+
+func TestRates(t *testing.T) {
+	prevMutex := MutexProfileFraction()
+	defer func() {
+		SetBlockProfileRate(0)
+		SetMutexProfileFraction(prevMutex)
+	}()
+
+	app := gramework.New()
+	if err := Register(app, WithPrefix("/internal/pprof/"), WithAuth(func(ctx *gramework.Context) bool {
+		return string(ctx.Request.Header.Peek("X-Token")) == "token"
+	}), WithBlockProfileRate(10)); err != nil {
+		t.Fatal(err)
+	}
+	c := testServe(t, app)
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+	req.Header.SetMethod("POST")
+	req.SetRequestURI("http://localhost/internal/pprof/rates?block=1&mutex=5")
+	if err := c.Do(req, resp); err != nil || resp.StatusCode() != fasthttp.StatusForbidden {
+		t.Fatalf("unauthorized request should fail, got %d %v", resp.StatusCode(), err)
+	}
+	if BlockProfileRate() != 10 {
+		t.Fatalf("unexpected block profile rate %d", BlockProfileRate())
+	}
+
+	req.Header.Set("X-Token", "token")
+	if err := c.Do(req, resp); err != nil || resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("unexpected response %d %v", resp.StatusCode(), err)
+	}
+	if body := strings.TrimSpace(string(resp.Body())); body != `{"block":1,"mutex":5}` {
+		t.Fatalf("unexpected rates %s", body)
+	}
+	if BlockProfileRate() != 1 || MutexProfileFraction() != 5 {
+		t.Fatalf("rates were not applied: %d, %d", BlockProfileRate(), MutexProfileFraction())
+	}
+}
+
+func TestTrustedIPs(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	app := gramework.New()
+	if err = Register(app.Sub("/trusted")); err != nil {
+		t.Fatal(err)
+	}
+	if err = Register(app.Sub("/untrusted"), WithoutTrustedIPs()); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = app.Serve(ln)
+	}()
+
+	base := "http://" + ln.Addr().String()
+	if code, _, err := fasthttp.Get(nil, base+"/trusted/debug/pprof/goroutine"); err != nil || code != fasthttp.StatusOK {
+		t.Fatalf("loopback client should be trusted, got %d %v", code, err)
+	}
+	if code, _, err := fasthttp.Get(nil, base+"/untrusted/debug/pprof/goroutine"); err != nil || code != fasthttp.StatusForbidden {
+		t.Fatalf("loopback client should not be trusted, got %d %v", code, err)
+	}
+}
+
+func TestBlacklist(t *testing.T) {
+	app := gramework.New()
+	app.MaxHackAttempts(2)
+	if err := Register(app, WithBasicAuth("admin", "secret")); err != nil {
+		t.Fatal(err)
+	}
+	c := testServe(t, app)
+
+	// requests without credentials, e.g. before the browser's auth prompt, are not hack attempts
+	for i := 0; i < 3; i++ {
+		if code, _, err := testDo(t, c, "GET", "/debug/pprof/heap", "", ""); err != nil || code != fasthttp.StatusUnauthorized {
+			t.Fatalf("request without credentials should be challenged, got %d %v", code, err)
+		}
+	}
+	if code, _, err := testDo(t, c, "GET", "/debug/pprof/cmdline", "admin", "secret"); err != nil || code != fasthttp.StatusOK {
+		t.Fatalf("client should not be blacklisted without rejected credentials, got %d %v", code, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if code, _, err := testDo(t, c, "GET", "/debug/pprof/heap", "admin", "wrong"); err != nil || code != fasthttp.StatusUnauthorized {
+			t.Fatalf("request with wrong password should fail, got %d %v", code, err)
+		}
+	}
+	if code, _, err := testDo(t, c, "GET", "/debug/pprof/cmdline", "admin", "secret"); err == nil && code != fasthttp.StatusForbidden {
+		t.Fatalf("blacklisted client should be rejected, got %d", code)
+	}
+}
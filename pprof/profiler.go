@@ -0,0 +1,510 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package pprof
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	rpprof "runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gramework/gramework"
+	"github.com/valyala/fasthttp"
+)
+
+// Snapshot kinds
+const (
+	KindCPU       = "cpu"
+	KindHeap      = "heap"
+	KindGoroutine = "goroutine"
+)
+
+// Snapshot reasons
+const (
+	// ReasonPeriodic is the reason of snapshots captured every interval
+	ReasonPeriodic = "periodic"
+	// ReasonLatency is the reason of snapshots triggered by a slow request
+	ReasonLatency = "latency"
+	// ReasonInFlight is the reason of snapshots triggered by too many requests in flight
+	ReasonInFlight = "in_flight"
+	// ReasonManual is the reason of snapshots requested with Profiler.Capture
+	ReasonManual = "manual"
+)
+
+const (
+	// DefaultProfilerInterval is the default interval of periodic snapshots
+	DefaultProfilerInterval = time.Minute
+	// DefaultProfilerCPUDuration is the default duration of CPU profiling
+	DefaultProfilerCPUDuration = 10 * time.Second
+	// DefaultProfilerKeep is the default count of snapshots kept
+	DefaultProfilerKeep = 30
+	// DefaultProfilerTriggerCooldown is the default min time between triggered snapshots
+	DefaultProfilerTriggerCooldown = time.Minute
+
+	profilerUVKey = "gramework.pprof.profiler.startTime"
+
+	snapshotFileExt = ".pb.gz"
+)
+
+// ErrSnapshotNotFound is returned by Profiler.Snapshot if the snapshot
+// does not exist or was evicted from the ring buffer
+var ErrSnapshotNotFound = errors.New("pprof: snapshot not found")
+
+type (
+	// Snapshot describes a captured profile
+	Snapshot struct {
+		ID     string    `json:"id"`
+		Kind   string    `json:"kind"`
+		Reason string    `json:"reason"`
+		Time   time.Time `json:"time"`
+		Size   int       `json:"size"`
+
+		data []byte
+		path string
+	}
+
+	// Profiler periodically captures profiles and keeps the last of them
+	// in a ring buffer in memory or on disk. Captures are also triggered
+	// by slow requests and request spikes, see Profiler.Setup.
+	Profiler struct {
+		interval          time.Duration
+		cpuDuration       time.Duration
+		kinds             []string
+		keep              int
+		dir               string
+		latencyThreshold  time.Duration
+		inFlightThreshold int64
+		triggerCooldown   time.Duration
+		onError           func(error)
+
+		mu        sync.RWMutex
+		snapshots []Snapshot
+		seq       uint64
+
+		inFlight      int64
+		lastTriggered int64
+		trigger       chan string
+		stop          chan struct{}
+		done          chan struct{}
+		closeOnce     sync.Once
+	}
+
+	// ProfilerOption configures the Profiler
+	ProfilerOption func(*Profiler)
+)
+
+// ProfilerInterval sets the interval of periodic snapshots,
+// DefaultProfilerInterval is used by default. Negative interval disables periodic snapshots.
+func ProfilerInterval(d time.Duration) ProfilerOption {
+	return func(p *Profiler) {
+		if d != 0 {
+			p.interval = d
+		}
+	}
+}
+
+// ProfilerCPUDuration sets the duration of CPU profiling, DefaultProfilerCPUDuration is used by default
+func ProfilerCPUDuration(d time.Duration) ProfilerOption {
+	return func(p *Profiler) {
+		if d > 0 {
+			p.cpuDuration = d
+		}
+	}
+}
+
+// ProfilerKinds sets the kinds of captured profiles: KindCPU, KindHeap, KindGoroutine
+// or any other runtime/pprof profile name. All three kinds are captured by default.
+func ProfilerKinds(kinds ...string) ProfilerOption {
+	return func(p *Profiler) {
+		p.kinds = kinds
+	}
+}
+
+// ProfilerKeep sets the count of snapshots kept, DefaultProfilerKeep is used by default.
+// Older snapshots are evicted.
+func ProfilerKeep(n int) ProfilerOption {
+	return func(p *Profiler) {
+		if n > 0 {
+			p.keep = n
+		}
+	}
+}
+
+// ProfilerDir makes the Profiler keep snapshots in the dir instead of memory.
+// Snapshots, left in the dir by the previous process, are kept in the ring buffer
+// and new snapshot IDs continue their sequence. Their reason is unknown.
+func ProfilerDir(dir string) ProfilerOption {
+	return func(p *Profiler) {
+		p.dir = dir
+	}
+}
+
+// ProfilerLatencyThreshold triggers a capture when a request takes longer than d
+func ProfilerLatencyThreshold(d time.Duration) ProfilerOption {
+	return func(p *Profiler) {
+		p.latencyThreshold = d
+	}
+}
+
+// ProfilerInFlightThreshold triggers a capture when more than n requests are in flight
+func ProfilerInFlightThreshold(n int) ProfilerOption {
+	return func(p *Profiler) {
+		p.inFlightThreshold = int64(n)
+	}
+}
+
+// ProfilerTriggerCooldown sets the min time between triggered captures,
+// DefaultProfilerTriggerCooldown is used by default
+func ProfilerTriggerCooldown(d time.Duration) ProfilerOption {
+	return func(p *Profiler) {
+		if d >= 0 {
+			p.triggerCooldown = d
+		}
+	}
+}
+
+// ProfilerOnError sets the handler of capture and storage errors
+func ProfilerOnError(handler func(error)) ProfilerOption {
+	return func(p *Profiler) {
+		p.onError = handler
+	}
+}
+
+// NewProfiler creates the Profiler and starts capturing snapshots.
+// Call Close to stop it.
+func NewProfiler(opts ...ProfilerOption) (*Profiler, error) {
+	p := &Profiler{
+		interval:        DefaultProfilerInterval,
+		cpuDuration:     DefaultProfilerCPUDuration,
+		kinds:           []string{KindCPU, KindHeap, KindGoroutine},
+		keep:            DefaultProfilerKeep,
+		triggerCooldown: DefaultProfilerTriggerCooldown,
+		trigger:         make(chan string, 1),
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	for _, kind := range p.kinds {
+		if kind != KindCPU && rpprof.Lookup(kind) == nil {
+			return nil, errors.New("pprof: unknown profile " + strconv.Quote(kind))
+		}
+	}
+	if p.dir != "" {
+		if err := os.MkdirAll(p.dir, 0700); err != nil {
+			return nil, err
+		}
+		if err := p.restore(); err != nil {
+			return nil, err
+		}
+	}
+
+	go p.loop()
+	return p, nil
+}
+
+// Setup registers middlewares, that track request latency and requests in flight
+// to trigger captures, and closes the Profiler on app shutdown
+func (p *Profiler) Setup(app *gramework.App) error {
+	if err := app.UsePre(p.startReq); err != nil {
+		return err
+	}
+	if err := app.UseAfterRequest(p.endReq); err != nil {
+		return err
+	}
+	app.OnShutdown(func(context.Context) error {
+		return p.Close()
+	})
+
+	return nil
+}
+
+// Capture requests a snapshot of all kinds. It does not block:
+// the request is ignored if a capture is already pending.
+func (p *Profiler) Capture(reason string) {
+	select {
+	case p.trigger <- reason:
+	default:
+	}
+}
+
+// Snapshots returns kept snapshots, oldest first
+func (p *Profiler) Snapshots() []Snapshot {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return append([]Snapshot(nil), p.snapshots...)
+}
+
+// Snapshot returns the snapshot and its profile data in the pprof format
+func (p *Profiler) Snapshot(id string) (Snapshot, []byte, error) {
+	p.mu.RLock()
+	var (
+		s     Snapshot
+		found bool
+	)
+	for i := range p.snapshots {
+		if p.snapshots[i].ID == id {
+			s, found = p.snapshots[i], true
+			break
+		}
+	}
+	p.mu.RUnlock()
+
+	if !found {
+		return Snapshot{}, nil, ErrSnapshotNotFound
+	}
+	if s.path == "" {
+		return s, s.data, nil
+	}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		err = ErrSnapshotNotFound
+	}
+	return s, data, err
+}
+
+// Handler serves the list of snapshots as JSON, or the snapshot profile
+// requested with ?id= as a file to download.
+//
+// Handler is not protected, see Register and WithProfiler.
+func (p *Profiler) Handler(ctx *gramework.Context) {
+	id := string(ctx.QueryArgs().Peek("id"))
+	if id == "" {
+		_ = ctx.JSON(p.Snapshots())
+		return
+	}
+
+	s, data, err := p.Snapshot(id)
+	if err == ErrSnapshotNotFound {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		ctx.SetBodyString(err.Error())
+		return
+	}
+	if err != nil {
+		ctx.Err500(err)
+		return
+	}
+
+	ctx.SetContentType("application/octet-stream")
+	ctx.Response.Header.Set("Content-Disposition", `attachment; filename="`+s.ID+`.pb.gz"`)
+	ctx.SetBody(data)
+}
+
+// Close stops capturing snapshots. Snapshots are still available.
+func (p *Profiler) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.stop)
+	})
+	<-p.done
+	return nil
+}
+
+func (p *Profiler) startReq(ctx *gramework.Context) {
+	inFlight := atomic.AddInt64(&p.inFlight, 1)
+	ctx.SetUserValue(profilerUVKey, gramework.Nanotime())
+	if p.inFlightThreshold > 0 && inFlight > p.inFlightThreshold {
+		p.triggerCapture(ReasonInFlight)
+	}
+}
+
+func (p *Profiler) endReq(ctx *gramework.Context) {
+	startTime, ok := ctx.UserValue(profilerUVKey).(int64)
+	if !ok {
+		return
+	}
+	atomic.AddInt64(&p.inFlight, -1)
+	if p.latencyThreshold > 0 && time.Duration(gramework.Nanotime()-startTime) > p.latencyThreshold {
+		p.triggerCapture(ReasonLatency)
+	}
+}
+
+// triggerCapture requests a capture, if the cooldown has passed since the previous one
+func (p *Profiler) triggerCapture(reason string) {
+	now := gramework.Nanotime()
+	last := atomic.LoadInt64(&p.lastTriggered)
+	if last != 0 && time.Duration(now-last) < p.triggerCooldown {
+		return
+	}
+	if atomic.CompareAndSwapInt64(&p.lastTriggered, last, now) {
+		p.Capture(reason)
+	}
+}
+
+func (p *Profiler) loop() {
+	defer close(p.done)
+
+	var tick <-chan time.Time
+	if p.interval > 0 {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+			p.capture(ReasonPeriodic)
+		case reason := <-p.trigger:
+			p.capture(reason)
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+func (p *Profiler) capture(reason string) {
+	for _, kind := range p.kinds {
+		var (
+			buf bytes.Buffer
+			err error
+		)
+		if kind == KindCPU {
+			err = p.captureCPU(&buf)
+		} else {
+			err = rpprof.Lookup(kind).WriteTo(&buf, 0)
+		}
+		if err != nil {
+			p.reportError(err)
+			continue
+		}
+		if err = p.add(kind, reason, buf.Bytes()); err != nil {
+			p.reportError(err)
+		}
+	}
+}
+
+func (p *Profiler) captureCPU(buf *bytes.Buffer) error {
+	// fails if CPU profiling is already running, e.g. by the /profile endpoint
+	if err := rpprof.StartCPUProfile(buf); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(p.cpuDuration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-p.stop:
+	}
+	rpprof.StopCPUProfile()
+	return nil
+}
+
+func (p *Profiler) add(kind, reason string, data []byte) error {
+	s := Snapshot{
+		ID:     strconv.FormatUint(atomic.AddUint64(&p.seq, 1), 10) + "-" + kind,
+		Kind:   kind,
+		Reason: reason,
+		Time:   time.Now(),
+		Size:   len(data),
+	}
+	if p.dir == "" {
+		s.data = data
+	} else {
+		s.path = filepath.Join(p.dir, s.ID+snapshotFileExt)
+		if err := os.WriteFile(s.path, data, 0600); err != nil {
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	var evicted []Snapshot
+	if len(p.snapshots) >= p.keep {
+		n := len(p.snapshots) - p.keep + 1
+		evicted = append(evicted, p.snapshots[:n]...)
+		p.snapshots = append(p.snapshots[:0], p.snapshots[n:]...)
+	}
+	p.snapshots = append(p.snapshots, s)
+	p.mu.Unlock()
+
+	p.remove(evicted)
+	return nil
+}
+
+// restore loads snapshots, left in the dir by the previous process,
+// so new snapshots do not overwrite them and old ones are evicted
+func (p *Profiler) restore() error {
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		return err
+	}
+
+	var seqs []uint64
+	bySeq := make(map[uint64]Snapshot)
+	for _, e := range entries {
+		id := strings.TrimSuffix(e.Name(), snapshotFileExt)
+		sep := strings.IndexByte(id, '-')
+		if e.IsDir() || id == e.Name() || sep <= 0 {
+			continue
+		}
+		seq, err := strconv.ParseUint(id[:sep], 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+
+		seqs = append(seqs, seq)
+		bySeq[seq] = Snapshot{
+			ID:   id,
+			Kind: id[sep+1:],
+			Time: info.ModTime(),
+			Size: int(info.Size()),
+			path: filepath.Join(p.dir, e.Name()),
+		}
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqs[i] < seqs[j]
+	})
+
+	var evicted []Snapshot
+	for i, seq := range seqs {
+		if i < len(seqs)-p.keep {
+			evicted = append(evicted, bySeq[seq])
+			continue
+		}
+		p.snapshots = append(p.snapshots, bySeq[seq])
+	}
+	if len(seqs) > 0 {
+		p.seq = seqs[len(seqs)-1]
+	}
+
+	p.remove(evicted)
+	return nil
+}
+
+// remove removes files of evicted snapshots
+func (p *Profiler) remove(evicted []Snapshot) {
+	for _, e := range evicted {
+		if e.path != "" {
+			if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+				p.reportError(err)
+			}
+		}
+	}
+}
+
+func (p *Profiler) reportError(err error) {
+	if p.onError != nil {
+		p.onError(err)
+	}
+}
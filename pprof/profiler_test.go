@@ -0,0 +1,185 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package pprof
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gramework/gramework"
+	"github.com/valyala/fasthttp"
+)
+
+func waitSnapshots(t *testing.T, p *Profiler, n int) []Snapshot {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if s := p.Snapshots(); len(s) >= n {
+			return s
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %d snapshots, got %d", n, len(p.Snapshots()))
+	return nil
+}
+
+func TestProfilerRingBuffer(t *testing.T) {
+	dir := t.TempDir()
+	p, err := NewProfiler(
+		ProfilerInterval(-1),
+		ProfilerCPUDuration(10*time.Millisecond),
+		ProfilerKeep(4),
+		ProfilerDir(dir),
+		ProfilerOnError(func(err error) {
+			t.Error(err)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	p.Capture(ReasonManual)
+	first := waitSnapshots(t, p, 3)
+	if first[0].Kind != KindCPU || first[1].Kind != KindHeap || first[2].Kind != KindGoroutine || first[0].Reason != ReasonManual {
+		t.Fatalf("unexpected snapshots: %+v", first)
+	}
+
+	p.Capture(ReasonManual)
+	for i := 0; i < 200 && p.Snapshots()[0].ID == first[0].ID; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	snapshots := waitSnapshots(t, p, 4)
+	if len(snapshots) != 4 || snapshots[3].Kind != KindGoroutine {
+		t.Fatalf("ring buffer should keep the last 4 snapshots: %+v", snapshots)
+	}
+	if _, _, err = p.Snapshot(first[0].ID); err != ErrSnapshotNotFound {
+		t.Fatalf("evicted snapshot should not be found, got %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, first[0].ID+".pb.gz")); !os.IsNotExist(err) {
+		t.Fatalf("evicted snapshot file should be removed, got %v", err)
+	}
+
+	s, data, err := p.Snapshot(snapshots[3].ID)
+	if err != nil || s.Size != len(data) || !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		t.Fatalf("unexpected snapshot %+v: %d bytes, %v", s, len(data), err)
+	}
+}
+
+func TestProfilerRestart(t *testing.T) {
+	dir := t.TempDir()
+	newProfiler := func(keep int) *Profiler {
+		p, err := NewProfiler(
+			ProfilerInterval(-1),
+			ProfilerKinds(KindHeap),
+			ProfilerKeep(keep),
+			ProfilerDir(dir),
+			ProfilerOnError(func(err error) {
+				t.Error(err)
+			}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	capture := func(p *Profiler, n int) []Snapshot {
+		for i := len(p.Snapshots()); i < n; i++ {
+			p.Capture(ReasonManual)
+			waitSnapshots(t, p, i+1)
+		}
+		return p.Snapshots()
+	}
+
+	p := newProfiler(3)
+	before := capture(p, 3)
+	_ = p.Close()
+
+	// the restarted profiler keeps the ring and continues the sequence
+	p = newProfiler(2)
+	defer p.Close()
+	restored := p.Snapshots()
+	if len(restored) != 2 || restored[0].ID != before[1].ID || restored[1].ID != before[2].ID || restored[1].Kind != KindHeap {
+		t.Fatalf("unexpected restored snapshots: %+v", restored)
+	}
+	if _, err := os.Stat(filepath.Join(dir, before[0].ID+".pb.gz")); !os.IsNotExist(err) {
+		t.Fatalf("snapshot exceeding the ring size should be removed, got %v", err)
+	}
+
+	p.Capture(ReasonManual)
+	for i := 0; i < 200 && p.Snapshots()[1].ID == before[2].ID; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	after := p.Snapshots()
+	if len(after) != 2 || after[0].ID != before[2].ID || after[1].ID != "4-heap" {
+		t.Fatalf("new snapshot should not overwrite restored ones: %+v", after)
+	}
+	s, data, err := p.Snapshot(before[2].ID)
+	if err != nil || s.Size != len(data) || !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		t.Fatalf("unexpected restored snapshot %+v: %d bytes, %v", s, len(data), err)
+	}
+}
+
+func TestProfilerTrigger(t *testing.T) {
+	p, err := NewProfiler(
+		ProfilerInterval(-1),
+		ProfilerKinds(KindGoroutine),
+		ProfilerLatencyThreshold(20*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := gramework.New()
+	if err = p.Setup(app); err != nil {
+		t.Fatal(err)
+	}
+	if err = Register(app, WithProfiler(p), WithBasicAuth("admin", "secret")); err != nil {
+		t.Fatal(err)
+	}
+	app.GET("/slow", func(ctx *gramework.Context) {
+		time.Sleep(30 * time.Millisecond)
+	})
+	c := testServe(t, app)
+
+	for i := 0; i < 3; i++ {
+		if code, _, err := testDo(t, c, "GET", "/slow", "", ""); err != nil || code != fasthttp.StatusOK {
+			t.Fatalf("unexpected response %d %v", code, err)
+		}
+	}
+	snapshots := waitSnapshots(t, p, 1)
+	time.Sleep(50 * time.Millisecond)
+	if snapshots = p.Snapshots(); len(snapshots) != 1 || snapshots[0].Reason != ReasonLatency {
+		t.Fatalf("one snapshot should be triggered within the cooldown: %+v", snapshots)
+	}
+
+	if code, _, err := testDo(t, c, "GET", "/debug/pprof/snapshots", "", ""); err != nil || code != fasthttp.StatusUnauthorized {
+		t.Fatalf("snapshots should be protected, got %d %v", code, err)
+	}
+	code, body, err := testDo(t, c, "GET", "/debug/pprof/snapshots", "admin", "secret")
+	var list []Snapshot
+	if err != nil || code != fasthttp.StatusOK || json.Unmarshal([]byte(body), &list) != nil || len(list) != 1 || list[0].ID != snapshots[0].ID {
+		t.Fatalf("unexpected snapshots response %d %v: %s", code, err, body)
+	}
+	if code, body, err = testDo(t, c, "GET", "/debug/pprof/snapshots?id="+list[0].ID, "admin", "secret"); err != nil || code != fasthttp.StatusOK || len(body) != list[0].Size {
+		t.Fatalf("unexpected snapshot download %d %v: %d bytes", code, err, len(body))
+	}
+	if code, _, err = testDo(t, c, "GET", "/debug/pprof/snapshots?id=unknown", "admin", "secret"); err != nil || code != fasthttp.StatusNotFound {
+		t.Fatalf("unknown snapshot should not be found, got %d %v", code, err)
+	}
+
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package pprof
+
+import (
+	"runtime"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/gramework/gramework"
+)
+
+// runtime has no getter for the block profile rate
+var blockProfileRate int64
+
+// Rates are the block and mutex profiling rates
+type Rates struct {
+	Block int `json:"block"`
+	Mutex int `json:"mutex"`
+}
+
+// SetBlockProfileRate sets the block profiling rate, see runtime.SetBlockProfileRate.
+// Zero disables block profiling.
+func SetBlockProfileRate(rate int) {
+	if rate < 0 {
+		rate = 0
+	}
+	runtime.SetBlockProfileRate(rate)
+	atomic.StoreInt64(&blockProfileRate, int64(rate))
+}
+
+// BlockProfileRate returns the block profiling rate set with SetBlockProfileRate
+func BlockProfileRate() int {
+	return int(atomic.LoadInt64(&blockProfileRate))
+}
+
+// SetMutexProfileFraction sets the mutex profiling fraction and returns the previous one,
+// see runtime.SetMutexProfileFraction. Zero disables mutex profiling.
+func SetMutexProfileFraction(fraction int) int {
+	if fraction < 0 {
+		fraction = 0
+	}
+	return runtime.SetMutexProfileFraction(fraction)
+}
+
+// MutexProfileFraction returns the mutex profiling fraction
+func MutexProfileFraction() int {
+	return runtime.SetMutexProfileFraction(-1)
+}
+
+// RatesHandler serves current Rates as JSON. POST requests change them
+// with "block" and "mutex" query arguments first, e.g. POST ?block=1&mutex=5.
+//
+// RatesHandler is not protected, see Register.
+func RatesHandler(ctx *gramework.Context) {
+	if ctx.IsPost() {
+		rates := make(map[string]int, 2)
+		for _, key := range []string{"block", "mutex"} {
+			if !ctx.QueryArgs().Has(key) {
+				continue
+			}
+			v, err := strconv.Atoi(string(ctx.QueryArgs().Peek(key)))
+			if err != nil || v < 0 {
+				ctx.BadRequest()
+				return
+			}
+			rates[key] = v
+		}
+		if v, ok := rates["block"]; ok {
+			SetBlockProfileRate(v)
+		}
+		if v, ok := rates["mutex"]; ok {
+			SetMutexProfileFraction(v)
+		}
+	}
+
+	_ = ctx.JSON(Rates{
+		Block: BlockProfileRate(),
+		Mutex: MutexProfileFraction(),
+	})
+}
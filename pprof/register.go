@@ -0,0 +1,192 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package pprof
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+
+	"github.com/gramework/gramework"
+	"github.com/valyala/fasthttp"
+)
+
+// DefaultPrefix is the default path prefix of profiling endpoints
+const DefaultPrefix = "/debug/pprof"
+
+// Option configures profiling endpoints, see Register
+type Option func(*config)
+
+type config struct {
+	prefix        string
+	trustIPs      bool
+	auth          []func(*gramework.Context) bool
+	basicAuth     bool
+	blockRate     *int
+	mutexFraction *int
+	profiler      *Profiler
+}
+
+// WithPrefix sets the path prefix of profiling endpoints, DefaultPrefix is used by default
+func WithPrefix(prefix string) Option {
+	return func(c *config) {
+		c.prefix = "/" + strings.Trim(prefix, "/")
+	}
+}
+
+// WithAuth allows clients, authorized by the given function
+func WithAuth(authorize func(ctx *gramework.Context) bool) Option {
+	return func(c *config) {
+		if authorize != nil {
+			c.auth = append(c.auth, authorize)
+		}
+	}
+}
+
+// WithBasicAuth allows clients with given HTTP basic auth credentials
+func WithBasicAuth(login, password string) Option {
+	return func(c *config) {
+		c.basicAuth = true
+		c.auth = append(c.auth, func(ctx *gramework.Context) bool {
+			l, err := ctx.Auth().GetLogin()
+			if err != nil {
+				return false
+			}
+			p, err := ctx.Auth().GetPass()
+			if err != nil {
+				return false
+			}
+			loginOK := subtle.ConstantTimeCompare([]byte(l), []byte(login)) == 1
+			passOK := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+			return loginOK && passOK
+		})
+	}
+}
+
+// WithoutTrustedIPs requires auth from all clients, including loopback
+// and whitelisted ones. Use it when the app is behind a local reverse proxy.
+func WithoutTrustedIPs() Option {
+	return func(c *config) {
+		c.trustIPs = false
+	}
+}
+
+// WithBlockProfileRate sets the block profiling rate on Register,
+// see runtime.SetBlockProfileRate
+func WithBlockProfileRate(rate int) Option {
+	return func(c *config) {
+		c.blockRate = &rate
+	}
+}
+
+// WithMutexProfileFraction sets the mutex profiling fraction on Register,
+// see runtime.SetMutexProfileFraction
+func WithMutexProfileFraction(fraction int) Option {
+	return func(c *config) {
+		c.mutexFraction = &fraction
+	}
+}
+
+// WithProfiler serves snapshots of the Profiler on {prefix}/snapshots,
+// see Profiler.Handler
+func WithProfiler(p *Profiler) Option {
+	return func(c *config) {
+		c.profiler = p
+	}
+}
+
+// Register registers profiling endpoints on the *gramework.App or *gramework.SubRouter:
+//
+//	{prefix}                index of available profiles
+//	{prefix}/cmdline        command line of the program
+//	{prefix}/profile        CPU profile, ?seconds=N
+//	{prefix}/symbol         symbol lookup
+//	{prefix}/trace          execution trace, ?seconds=N
+//	{prefix}/{name}         named profiles: heap, goroutine, allocs, block, mutex, threadcreate
+//	                        and custom ones, see runtime/pprof.NewProfile
+//	{prefix}/rates          current block and mutex profiling rates,
+//	                        POST ?block=N&mutex=N changes them
+//	{prefix}/snapshots      Profiler snapshots, ?id=ID downloads one, see WithProfiler
+//
+// Endpoints are protected, see Context.IsAuthorized: whitelisted clients are trusted
+// unless WithoutTrustedIPs is set, WithAuth and WithBasicAuth authorize other clients.
+// Requests with rejected credentials in the Authorization header are reported
+// with Context.HackAttemptDetected, so repeated attempts get the client blacklisted.
+// If r is an *App, Register enables App.Protect for the prefix. For a *SubRouter,
+// call App.Protect before Register to drop blacklisted clients.
+func Register(r interface{}, opts ...Option) error {
+	c := &config{
+		prefix:   DefaultPrefix,
+		trustIPs: true,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	app, isApp := r.(*gramework.App)
+	sr, isSr := r.(*gramework.SubRouter)
+	if !isApp && !isSr {
+		return errors.New("unsupported handler type")
+	}
+	if isApp {
+		app.Protect(c.prefix)
+		sr = app.Sub("")
+	}
+
+	if c.blockRate != nil {
+		SetBlockProfileRate(*c.blockRate)
+	}
+	if c.mutexFraction != nil {
+		SetMutexProfileFraction(*c.mutexFraction)
+	}
+
+	handler := c.protect(func(ctx *gramework.Context) {
+		switch ctx.RouteArg("type") {
+		case "rates":
+			RatesHandler(ctx)
+		case "snapshots":
+			if c.profiler == nil {
+				ctx.SetStatusCode(fasthttp.StatusNotFound)
+				return
+			}
+			c.profiler.Handler(ctx)
+		default:
+			Handler(ctx)
+		}
+	})
+	sr.GET(c.prefix, handler)
+	sr.GET(c.prefix+"/:type", handler)
+	sr.POST(c.prefix+"/:type", handler)
+
+	return nil
+}
+
+func (c *config) protect(handler func(*gramework.Context)) func(*gramework.Context) {
+	return func(ctx *gramework.Context) {
+		if ctx.IsAuthorized(c.trustIPs, c.auth...) {
+			handler(ctx)
+			return
+		}
+
+		// browsers send the first request without credentials
+		// and retry with them after the 401 challenge
+		if len(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization)) > 0 {
+			ctx.HackAttemptDetected()
+		}
+		if c.basicAuth {
+			ctx.Response.Header.Set("WWW-Authenticate", `Basic realm="pprof"`)
+			ctx.SetStatusCode(fasthttp.StatusUnauthorized)
+			ctx.SetBodyString(fasthttp.StatusMessage(fasthttp.StatusUnauthorized))
+			return
+		}
+		ctx.Forbidden()
+	}
+}
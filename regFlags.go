@@ -40,6 +40,10 @@ func (app *App) RegFlags() {
 	}
 	app.flagsRegistered = true
 	for _, v := range app.flagsQueue {
+		if flag.Lookup(v.Name) != nil {
+			// already registered by another app or by App.LoadConfig
+			continue
+		}
 		app.Flags.values[v.Name] = Flag{
 			Name:        v.Name,
 			Description: v.Description,
@@ -69,3 +73,14 @@ func DisableFlags() {
 	flagsDisabled = true
 	flagsToRegister = []Flag{}
 }
+
+// flagSet reports if the flag was set on the command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
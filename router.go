@@ -113,7 +113,7 @@ func (r *Router) Sub(path string) *SubRouter {
 func (r *Router) handleReg(method, route string, handler interface{}, prefixes []string) {
 	r.initRouter()
 	r.app.internalLog.Debugf("registering %s %s", method, route)
-	typedHandler := r.determineHandler(handler)
+	typedHandler := withRouteTemplate(route, r.determineHandler(handler))
 	for prefix := range r.app.protectedPrefixes {
 		if strings.HasPrefix(strings.TrimLeft(route, "/"), strings.TrimLeft(prefix, "/")) {
 			r.app.internalLog.
@@ -137,6 +137,23 @@ func (r *Router) handleReg(method, route string, handler interface{}, prefixes [
 	r.router.Handle(method, route, typedHandler, prefixes)
 }
 
+// withRouteTemplate wraps the handler to store the route in the context,
+// see Context.RouteTemplate
+func withRouteTemplate(route string, handler func(*Context)) func(*Context) {
+	if handler == nil {
+		return nil
+	}
+
+	return func(ctx *Context) {
+		ctx.routeTemplate = route
+		ctx.setSpanRoute(route)
+		if ctx.Logger != nil {
+			ctx.Logger = ctx.Logger.WithField(RouteLogField, route)
+		}
+		handler(ctx)
+	}
+}
+
 func (r *Router) getEFuncStrHandler(h func() string) func(*Context) {
 	return func(ctx *Context) {
 		ctx.Response.SetBodyRaw([]byte(h()))
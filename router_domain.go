@@ -17,6 +17,7 @@ func (app *App) Domain(domain string) *Router {
 		app.domains[domain] = &Router{
 			router: newRouter(),
 			app:    app,
+			domain: domain,
 		}
 	}
 	app.domainListLock.Unlock()
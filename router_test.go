@@ -1142,3 +1142,41 @@ func TestHTTPSRouter(t *testing.T) {
 	_, err = http.Get("http://127.0.0.1" + bindAddr) // just should not panic, twice
 	_ = err
 }
+
+func TestRouteTemplateAndAfterRequest(t *testing.T) {
+	app := New()
+	var (
+		routes   []string
+		statuses []int
+	)
+	if err := app.UseAfterRequest(func(ctx *Context) {
+		routes = append(routes, ctx.RouteTemplate())
+		statuses = append(statuses, ctx.Response.StatusCode())
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.UsePre(func(ctx *Context) {
+		if string(ctx.Path()) == "/killed" {
+			ctx.Forbidden()
+			ctx.MWKill()
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	app.GET("/users/:id", "user")
+	app.GET("/panic", func() {
+		panic("test panic")
+	})
+
+	for _, path := range []string{"/users/42", "/unknown", "/killed", "/panic"} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(path)
+		app.handler()(ctx)
+	}
+
+	expectedRoutes := []string{"/users/:id", "", "", "/panic"}
+	expectedStatuses := []int{200, 404, 403, 500}
+	if !reflect.DeepEqual(routes, expectedRoutes) || !reflect.DeepEqual(statuses, expectedStatuses) {
+		t.Fatalf("unexpected after request calls: %v %v", routes, statuses)
+	}
+}
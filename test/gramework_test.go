@@ -260,6 +260,7 @@ func TestGrameworkHTTPS(t *testing.T) {
 
 	_, port, _ := net.SplitHostPort(ln.Addr().String())
 	bindAddr := fmt.Sprintf(":%s", port)
+	errCheck(t, ln.Close())
 
 	go func() {
 		err := app.ListenAndServeAutoTLS(bindAddr)
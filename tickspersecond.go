@@ -8,6 +8,9 @@
 //     http://www.apache.org/licenses/LICENSE-2.0
 //
 
+//go:build !go1.21
+// +build !go1.21
+
 package gramework
 
 import (
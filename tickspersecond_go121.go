@@ -0,0 +1,23 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+//go:build go1.21
+// +build go1.21
+
+package gramework
+
+import "time"
+
+// TicksPerSecond reports cpu ticks per second counter.
+// Since Go 1.21 the runtime tick counter can not be linked,
+// so the monotonic clock resolution is reported instead.
+func TicksPerSecond() int64 {
+	return int64(time.Second)
+}
@@ -0,0 +1,207 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTLSReloadInterval is the default interval of checking
+// certificate and key files for changes
+const DefaultTLSReloadInterval = 10 * time.Second
+
+// ErrTLSNoCertificates occurs when ListenAndServeTLS called without
+// a certificate and no certificates were added with AddTLSCertificate
+var ErrTLSNoCertificates = errors.New("tls: no certificates provided")
+
+type certEntry struct {
+	certFile string
+	keyFile  string
+	certMod  time.Time
+	keyMod   time.Time
+	cert     *tls.Certificate
+}
+
+// certStore holds certificates loaded from files and selects them by SNI
+type certStore struct {
+	mu           sync.RWMutex
+	entries      []*certEntry
+	defaultEntry *certEntry
+}
+
+func newCertStore() *certStore {
+	return &certStore{}
+}
+
+func loadCertEntry(certFile, keyFile string) (*certEntry, error) {
+	certInfo, err := os.Stat(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyInfo, err := os.Stat(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &certEntry{
+		certFile: certFile,
+		keyFile:  keyFile,
+		certMod:  certInfo.ModTime(),
+		keyMod:   keyInfo.ModTime(),
+		cert:     &cert,
+	}, nil
+}
+
+// add loads the certificate. If the same pair of files was already added,
+// it is reloaded instead.
+func (s *certStore) add(certFile, keyFile string, isDefault bool) error {
+	entry, err := loadCertEntry(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, e := range s.entries {
+		if e.certFile == certFile && e.keyFile == keyFile {
+			s.entries[i] = entry
+			if isDefault || s.defaultEntry == e {
+				s.defaultEntry = entry
+			}
+			return nil
+		}
+	}
+
+	s.entries = append(s.entries, entry)
+	if isDefault {
+		s.defaultEntry = entry
+	}
+
+	return nil
+}
+
+func (s *certStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.entries)
+}
+
+// getCertificate implements tls.Config.GetCertificate. The first certificate
+// valid for the requested server name is used, otherwise the default one.
+func (s *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if name := strings.TrimSuffix(strings.ToLower(hello.ServerName), "."); len(name) > 0 {
+		for _, e := range s.entries {
+			if e.cert.Leaf.VerifyHostname(name) == nil {
+				return e.cert, nil
+			}
+		}
+	}
+
+	if s.defaultEntry != nil {
+		return s.defaultEntry.cert, nil
+	}
+	if len(s.entries) > 0 {
+		return s.entries[0].cert, nil
+	}
+
+	return nil, ErrTLSNoCertificates
+}
+
+// covers reports if any of the certificates is valid for the domain
+func (s *certStore) covers(domain string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, e := range s.entries {
+		if e.cert.Leaf.VerifyHostname(domain) == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reload reloads certificates, which files were modified.
+// If the new files are invalid, the old certificate is kept.
+func (s *certStore) reload(app *App) {
+	s.mu.RLock()
+	entries := append([]*certEntry(nil), s.entries...)
+	s.mu.RUnlock()
+
+	for _, e := range entries {
+		certInfo, err := os.Stat(e.certFile)
+		if err != nil {
+			app.internalLog.WithError(err).WithField("cert", e.certFile).Error("could not check certificate")
+			continue
+		}
+		keyInfo, err := os.Stat(e.keyFile)
+		if err != nil {
+			app.internalLog.WithError(err).WithField("key", e.keyFile).Error("could not check certificate key")
+			continue
+		}
+		if certInfo.ModTime().Equal(e.certMod) && keyInfo.ModTime().Equal(e.keyMod) {
+			continue
+		}
+
+		if err = s.add(e.certFile, e.keyFile, false); err != nil {
+			app.internalLog.WithError(err).WithField("cert", e.certFile).Error("could not reload certificate, keep using the old one")
+			continue
+		}
+		app.internalLog.WithField("cert", e.certFile).Info("certificate reloaded")
+	}
+}
+
+// watch reloads certificates every interval until stop is closed
+func (s *certStore) watch(app *App, interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.reload(app)
+		}
+	}
+}
+
+// AddTLSCertificate adds a certificate that ListenAndServeTLS will serve
+// to clients requesting any of the certificate names via SNI, e.g.
+// for the domains, registered with app.Domain(). Files are watched for
+// changes and reloaded without restart, see OptTLSReloadInterval.
+func (app *App) AddTLSCertificate(certFile, keyFile string) error {
+	return app.tlsCerts.add(certFile, keyFile, false)
+}
@@ -0,0 +1,205 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testWriteCert writes a self-signed certificate for the names
+// and returns paths to the certificate and key files
+func testWriteCert(t *testing.T, dir, cn string, names ...string) (string, string) {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     names,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, cn+".crt")
+	keyFile := filepath.Join(dir, cn+".key")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestCertStoreSNI(t *testing.T) {
+	dir := t.TempDir()
+	s := newCertStore()
+
+	if _, err := s.getCertificate(&tls.ClientHelloInfo{ServerName: "example.com"}); err != ErrTLSNoCertificates {
+		t.Fatalf("expected ErrTLSNoCertificates, got %v", err)
+	}
+
+	defCert, defKey := testWriteCert(t, dir, "default", "default.local")
+	apiCert, apiKey := testWriteCert(t, dir, "api", "api.example.com")
+	wildCert, wildKey := testWriteCert(t, dir, "wildcard", "*.example.org")
+	if err := s.add(apiCert, apiKey, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.add(defCert, defKey, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.add(wildCert, wildKey, false); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"api.example.com":  "api",
+		"API.example.com.": "api",
+		"www.example.org":  "wildcard",
+		"unknown.com":      "default",
+		"":                 "default",
+	}
+	for serverName, expected := range cases {
+		cert, err := s.getCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %s", serverName, err)
+		}
+		if cn := cert.Leaf.Subject.CommonName; cn != expected {
+			t.Errorf("%q: expected %q certificate, got %q", serverName, expected, cn)
+		}
+	}
+
+	if !s.covers("a.example.org") || s.covers("example.net") {
+		t.Errorf("unexpected domain coverage")
+	}
+}
+
+func TestCertStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	app := New()
+	certFile, keyFile := testWriteCert(t, dir, "site", "old.example.com")
+	if err := app.AddTLSCertificate(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// broken files are ignored, the old certificate is kept
+	if err := os.WriteFile(certFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(certFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+	app.tlsCerts.reload(app)
+	if !app.tlsCerts.covers("old.example.com") {
+		t.Fatalf("old certificate should be kept")
+	}
+
+	newCert, newKey := testWriteCert(t, t.TempDir(), "site", "new.example.com")
+	for src, dst := range map[string]string{newCert: certFile, newKey: keyFile} {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(dst, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		future = future.Add(time.Minute)
+		if err = os.Chtimes(dst, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app.tlsCerts.reload(app)
+	if !app.tlsCerts.covers("new.example.com") || app.tlsCerts.covers("old.example.com") {
+		t.Fatalf("certificate was not reloaded")
+	}
+	if app.tlsCerts.len() != 1 {
+		t.Fatalf("reload should replace the certificate, got %d", app.tlsCerts.len())
+	}
+}
+
+func TestAppListenAndServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := testWriteCert(t, dir, "default", "default.local")
+	apiCert, apiKey := testWriteCert(t, dir, "api", "api.example.com")
+
+	app := New(OptTLSMinVersion(tls.VersionTLS12))
+	app.GET("/", "ok")
+	if err := app.AddTLSCertificate(apiCert, apiKey); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := testFreeAddr(t)
+	done := make(chan error, 1)
+	go func() {
+		done <- app.ListenAndServeTLS(addr, certFile, keyFile)
+	}()
+	defer func() {
+		_ = app.Shutdown()
+		<-done
+	}()
+
+	var (
+		conn *tls.Conn
+		err  error
+	)
+	for i := 0; i < 50; i++ {
+		conn, err = tls.Dial("tcp", addr, &tls.Config{
+			ServerName:         "api.example.com",
+			InsecureSkipVerify: true,
+		})
+		if err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("could not connect: %s", err)
+	}
+	defer conn.Close()
+
+	if cn := conn.ConnectionState().PeerCertificates[0].Subject.CommonName; cn != "api" {
+		t.Fatalf("expected api certificate, got %q", cn)
+	}
+
+	_, err = tls.Dial("tcp", addr, &tls.Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS11,
+	})
+	if err == nil {
+		t.Fatalf("TLS 1.1 should be rejected")
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrTLSNoClientCAs occurs when TLS servers are started with
+// the client certificate verification, but without client CAs
+var ErrTLSNoClientCAs = errors.New("tls: client certificate verification requires client CAs")
+
+// OptTLSClientAuth enables TLS client authentication (mTLS) for all TLS servers.
+// Client certificates are verified against the clientCAs pool,
+// e.g. loaded with LoadCertPool. Use tls.RequireAndVerifyClientCert
+// to reject clients without a valid certificate, or tls.VerifyClientCertIfGiven
+// to authorize them per-route with AuthorizePeer.
+// TLS servers fail with ErrTLSNoClientCAs if a verifying authType has no clientCAs.
+//
+// ListenAndServeAutoTLS does not request client certificates
+// from ACME TLS-ALPN-01 validators, that can't provide them.
+func OptTLSClientAuth(authType tls.ClientAuthType, clientCAs *x509.CertPool) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.tlsClientAuth = authType
+		app.tlsClientCAs = clientCAs
+	}
+}
+
+// LoadCertPool loads PEM-encoded CA certificates from the files
+func LoadCertPool(files ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, file := range files {
+		pemCerts, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pemCerts) {
+			return nil, fmt.Errorf("tls: no certificates found in %q", file)
+		}
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// OptTLSMinVersion sets the minimum TLS version the app accepts,
+// e.g. tls.VersionTLS12
+func OptTLSMinVersion(version uint16) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.tlsMinVersion = version
+	}
+}
+
+// OptTLSCipherSuites sets the list of enabled TLS 1.0-1.2 cipher suites.
+// TLS 1.3 cipher suites are not configurable.
+func OptTLSCipherSuites(suites ...uint16) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.tlsCipherSuites = suites
+	}
+}
+
+// OptTLSReloadInterval sets how often ListenAndServeTLS checks certificate
+// and key files for changes. Zero disables the reload.
+func OptTLSReloadInterval(d time.Duration) func(*App) {
+	return func(app *App) {
+		assertAppNotNill(app)
+		app.tlsReloadInterval = d
+	}
+}
+
+// tlsConfig returns the default TLS config with app options applied
+func (app *App) tlsConfig() (*tls.Config, error) {
+	tlsConfig := getDefaultTLSConfig()
+	if app.tlsMinVersion != 0 {
+		tlsConfig.MinVersion = app.tlsMinVersion
+	}
+	if len(app.tlsCipherSuites) > 0 {
+		tlsConfig.CipherSuites = app.tlsCipherSuites
+	}
+	// nil client CAs would verify client certificates against the system roots
+	if app.tlsClientAuth >= tls.VerifyClientCertIfGiven && app.tlsClientCAs == nil {
+		return nil, ErrTLSNoClientCAs
+	}
+	tlsConfig.ClientAuth = app.tlsClientAuth
+	tlsConfig.ClientCAs = app.tlsClientCAs
+
+	return tlsConfig, nil
+}
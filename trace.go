@@ -0,0 +1,186 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// TraceParentHeader is the W3C Trace Context header
+	// that contains the trace ID, parent span ID and trace flags
+	TraceParentHeader = "traceparent"
+	// TraceStateHeader is the W3C Trace Context header
+	// that contains vendor-specific trace data
+	TraceStateHeader = "tracestate"
+
+	// TraceFlagSampled is the trace flag that marks the trace as sampled
+	TraceFlagSampled byte = 0x01
+
+	traceParentVersion = "00"
+	traceParentLen     = 55
+)
+
+// ErrInvalidTraceParent occurs when traceparent header could not be parsed
+var ErrInvalidTraceParent = errors.New("invalid traceparent")
+
+type (
+	// TraceID is a W3C Trace Context trace ID
+	TraceID [16]byte
+
+	// SpanID is a W3C Trace Context span ID
+	SpanID [8]byte
+
+	// SpanContext is the part of the span, that is propagated to other services
+	SpanContext struct {
+		TraceID    TraceID
+		SpanID     SpanID
+		TraceFlags byte
+		// TraceState is the raw tracestate header value
+		TraceState string
+		// Remote reports if the span context was received from other service
+		Remote bool
+	}
+)
+
+// IsValid reports if the trace ID is not zero
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports if the span ID is not zero
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports if the span context has valid trace and span IDs
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// IsSampled reports if the sampled flag is set
+func (sc SpanContext) IsSampled() bool {
+	return sc.TraceFlags&TraceFlagSampled != 0
+}
+
+// TraceParent returns the traceparent header value,
+// or empty string if the span context is invalid
+func (sc SpanContext) TraceParent() string {
+	if !sc.IsValid() {
+		return emptyString
+	}
+
+	b := make([]byte, 0, traceParentLen)
+	b = append(b, traceParentVersion...)
+	b = append(b, '-')
+	b = append(b, sc.TraceID.String()...)
+	b = append(b, '-')
+	b = append(b, sc.SpanID.String()...)
+	b = append(b, '-')
+	b = append(b, hex.EncodeToString([]byte{sc.TraceFlags})...)
+	return string(b)
+}
+
+// ParseTraceParent parses the traceparent header value.
+// Versions higher than 00 are parsed as 00, as the specification requires.
+func ParseTraceParent(s string) (SpanContext, error) {
+	var sc SpanContext
+	if len(s) < traceParentLen || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, ErrInvalidTraceParent
+	}
+	version := s[:2]
+	if !isLowerHex(version) || version == "ff" ||
+		(version == traceParentVersion && len(s) != traceParentLen) ||
+		(len(s) > traceParentLen && s[traceParentLen] != '-') {
+		return sc, ErrInvalidTraceParent
+	}
+
+	traceID, spanID, flags := s[3:35], s[36:52], s[53:55]
+	if !isLowerHex(traceID) || !isLowerHex(spanID) || !isLowerHex(flags) {
+		return sc, ErrInvalidTraceParent
+	}
+	_, _ = hex.Decode(sc.TraceID[:], []byte(traceID))
+	_, _ = hex.Decode(sc.SpanID[:], []byte(spanID))
+	var f [1]byte
+	_, _ = hex.Decode(f[:], []byte(flags))
+	sc.TraceFlags = f[0]
+	sc.Remote = true
+
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceParent
+	}
+
+	return sc, nil
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ExtractTraceContext returns the span context from traceparent
+// and tracestate request headers
+func ExtractTraceContext(h *fasthttp.RequestHeader) (SpanContext, bool) {
+	sc, err := ParseTraceParent(string(h.Peek(TraceParentHeader)))
+	if err != nil {
+		return SpanContext{}, false
+	}
+
+	sc.TraceState = strings.TrimSpace(string(h.Peek(TraceStateHeader)))
+	return sc, true
+}
+
+// InjectTraceContext sets traceparent and tracestate request headers,
+// so the called service continues the trace. Invalid span context is ignored.
+func InjectTraceContext(h *fasthttp.RequestHeader, sc SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+
+	h.Set(TraceParentHeader, sc.TraceParent())
+	if len(sc.TraceState) > 0 {
+		h.Set(TraceStateHeader, sc.TraceState)
+	} else {
+		h.Del(TraceStateHeader)
+	}
+}
+
+func newTraceID() (id TraceID) {
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
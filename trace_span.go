@@ -0,0 +1,249 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// SpanKind describes the relationship between the span and its parent
+type SpanKind int
+
+const (
+	// SpanKindInternal is an internal operation, e.g. middleware phase
+	SpanKindInternal SpanKind = iota + 1
+	// SpanKindServer is an incoming request
+	SpanKindServer
+	// SpanKindClient is an outgoing request
+	SpanKindClient
+)
+
+// SpanStatus is the status of the span operation
+type SpanStatus int
+
+const (
+	// SpanStatusUnset is the default span status
+	SpanStatusUnset SpanStatus = iota
+	// SpanStatusOK means the operation completed successfully
+	SpanStatusOK
+	// SpanStatusError means the operation failed
+	SpanStatusError
+)
+
+const (
+	// SpanAttrHTTPMethod is the span attribute with the request method
+	SpanAttrHTTPMethod = "http.request.method"
+	// SpanAttrHTTPRoute is the span attribute with the route template
+	SpanAttrHTTPRoute = "http.route"
+	// SpanAttrHTTPStatusCode is the span attribute with the response status code
+	SpanAttrHTTPStatusCode = "http.response.status_code"
+	// SpanAttrURLPath is the span attribute with the request path
+	SpanAttrURLPath = "url.path"
+	// SpanAttrURLFull is the span attribute with the full URL of outgoing request
+	SpanAttrURLFull = "url.full"
+	// SpanAttrClientAddress is the span attribute with the client IP
+	SpanAttrClientAddress = "client.address"
+	// SpanAttrRequestID is the span attribute with the X-Request-ID
+	SpanAttrRequestID = "http.request.id"
+)
+
+type (
+	// SpanAttribute is a span attribute
+	SpanAttribute struct {
+		Key   string
+		Value interface{}
+	}
+
+	// SpanData is a finished span, passed to SpanExporter
+	SpanData struct {
+		Name          string
+		SpanContext   SpanContext
+		ParentSpanID  SpanID
+		Kind          SpanKind
+		Start         time.Time
+		End           time.Time
+		Attributes    []SpanAttribute
+		Status        SpanStatus
+		StatusMessage string
+	}
+
+	// Span is a traced operation. All Span methods are safe to call
+	// on nil Span, so the code doesn't need to check if tracing is enabled.
+	Span struct {
+		tracer *Tracer
+		mu     sync.Mutex
+		data   SpanData
+		ended  bool
+	}
+)
+
+// StartSpan starts a new span. If the parent span context is valid,
+// the span continues its trace and sampling decision.
+func (t *Tracer) StartSpan(parent SpanContext, name string, kind SpanKind) *Span {
+	if t == nil {
+		return nil
+	}
+
+	s := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:  name,
+			Kind:  kind,
+			Start: time.Now(),
+		},
+	}
+
+	if parent.IsValid() {
+		s.data.SpanContext = SpanContext{
+			TraceID:    parent.TraceID,
+			TraceFlags: parent.TraceFlags,
+			TraceState: parent.TraceState,
+		}
+		s.data.ParentSpanID = parent.SpanID
+	} else {
+		s.data.SpanContext.TraceID = newTraceID()
+		if t.sample(s.data.SpanContext.TraceID) {
+			s.data.SpanContext.TraceFlags = TraceFlagSampled
+		}
+	}
+	s.data.SpanContext.SpanID = newSpanID()
+
+	return s
+}
+
+// sample makes the sampling decision for a new trace
+func (t *Tracer) sample(id TraceID) bool {
+	if t.sampleRatio >= 1 {
+		return true
+	}
+	if t.sampleRatio <= 0 {
+		return false
+	}
+
+	return binary.BigEndian.Uint64(id[8:]) < uint64(t.sampleRatio*(1<<63))<<1
+}
+
+// StartChild starts a child span of the span
+func (s *Span) StartChild(name string, kind SpanKind) *Span {
+	if s == nil {
+		return nil
+	}
+
+	return s.tracer.StartSpan(s.Context(), name, kind)
+}
+
+// Context returns the span context, or zero span context for nil span
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return s.data.SpanContext
+}
+
+// IsRecording reports if the span will be exported when ended
+func (s *Span) IsRecording() bool {
+	return s != nil && s.data.SpanContext.IsSampled()
+}
+
+// SetName sets the span name
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.data.Name = name
+	s.mu.Unlock()
+}
+
+// SetAttribute sets the span attribute
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if !s.IsRecording() {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	for i := range s.data.Attributes {
+		if s.data.Attributes[i].Key == key {
+			s.data.Attributes[i].Value = value
+			return
+		}
+	}
+	s.data.Attributes = append(s.data.Attributes, SpanAttribute{Key: key, Value: value})
+}
+
+// SetStatus sets the span status. The message is used only for SpanStatusError.
+func (s *Span) SetStatus(status SpanStatus, msg string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.data.Status = status
+	s.data.StatusMessage = emptyString
+	if status == SpanStatusError {
+		s.data.StatusMessage = msg
+	}
+	s.mu.Unlock()
+}
+
+// RecordError marks the span as failed, if err is not nil
+func (s *Span) RecordError(err error) {
+	if err != nil {
+		s.SetStatus(SpanStatusError, err.Error())
+	}
+}
+
+// SetHTTPStatus sets the response status code attribute.
+// Server spans are marked as failed on 5xx responses,
+// client spans on 4xx and 5xx responses.
+func (s *Span) SetHTTPStatus(code int) {
+	if s == nil {
+		return
+	}
+
+	s.SetAttribute(SpanAttrHTTPStatusCode, code)
+	s.mu.Lock()
+	failed := code >= 500 || (code >= 400 && s.data.Kind == SpanKindClient)
+	if failed && s.data.Status == SpanStatusUnset {
+		s.data.Status = SpanStatusError
+	}
+	s.mu.Unlock()
+}
+
+// End finishes the span and passes it to the tracer exporter.
+// Subsequent calls are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.SpanContext.IsSampled() {
+		s.tracer.enqueue(data)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceParent(t *testing.T) {
+	sc, err := ParseTraceParent(testTraceParent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Fatalf("unexpected span context: %+v", sc)
+	}
+	if !sc.IsSampled() || !sc.Remote {
+		t.Fatalf("span context should be sampled and remote")
+	}
+	if got := sc.TraceParent(); got != testTraceParent {
+		t.Fatalf("unexpected traceparent: %q", got)
+	}
+
+	if _, err := ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future"); err != nil {
+		t.Errorf("future versions should be parsed: %s", err)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceParent(invalid); err != ErrInvalidTraceParent {
+			t.Errorf("expected error for %q, got %v", invalid, err)
+		}
+	}
+}
+
+func TestTraceContextInjectExtract(t *testing.T) {
+	var h fasthttp.RequestHeader
+	h.Set(TraceParentHeader, testTraceParent)
+	h.Set(TraceStateHeader, "congo=t61rcWkgMzE")
+
+	sc, ok := ExtractTraceContext(&h)
+	if !ok || sc.TraceState != "congo=t61rcWkgMzE" {
+		t.Fatalf("unexpected span context: %+v, %v", sc, ok)
+	}
+
+	var out fasthttp.RequestHeader
+	InjectTraceContext(&out, sc)
+	if string(out.Peek(TraceParentHeader)) != testTraceParent || string(out.Peek(TraceStateHeader)) != sc.TraceState {
+		t.Fatalf("unexpected injected headers: %s", out.String())
+	}
+
+	var empty fasthttp.RequestHeader
+	InjectTraceContext(&empty, SpanContext{})
+	if len(empty.Peek(TraceParentHeader)) > 0 {
+		t.Fatalf("invalid span context should not be injected")
+	}
+}
+
+func TestTracerSampling(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter, TracerSampleRatio(0))
+	defer tracer.Shutdown(context.Background())
+
+	root := tracer.StartSpan(SpanContext{}, "root", SpanKindInternal)
+	if root.IsRecording() || !root.Context().IsValid() {
+		t.Fatalf("new trace should be valid and not sampled")
+	}
+	root.End()
+
+	parent, _ := ParseTraceParent(testTraceParent)
+	child := tracer.StartSpan(parent, "child", SpanKindServer)
+	if !child.IsRecording() || child.Context().TraceID != parent.TraceID {
+		t.Fatalf("child should continue the sampled trace")
+	}
+	child.SetAttribute("key", "value")
+	child.SetHTTPStatus(503)
+	child.End()
+	child.End()
+
+	if err := tracer.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	spans := exporter.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("expected one exported span, got %d", len(spans))
+	}
+	if spans[0].ParentSpanID != parent.SpanID || spans[0].Status != SpanStatusError || len(spans[0].Attributes) != 2 {
+		t.Fatalf("unexpected span: %+v", spans[0])
+	}
+}
+
+func TestTracerBatchExport(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter, TracerBatchSize(2), TracerFlushInterval(time.Hour))
+
+	for i := 0; i < 3; i++ {
+		tracer.StartSpan(SpanContext{}, "span", SpanKindInternal).End()
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(exporter.Spans()); n != 3 {
+		t.Fatalf("expected 3 spans exported on shutdown, got %d", n)
+	}
+
+	tracer.StartSpan(SpanContext{}, "late", SpanKindInternal).End()
+	if tracer.Dropped() != 1 {
+		t.Fatalf("span ended after shutdown should be dropped")
+	}
+}
+
+func TestNilSpan(t *testing.T) {
+	var s *Span
+	s.SetName("name")
+	s.SetAttribute("key", "value")
+	s.SetHTTPStatus(500)
+	s.RecordError(context.Canceled)
+	s.End()
+	if s.StartChild("child", SpanKindInternal) != nil || s.Context().IsValid() {
+		t.Fatalf("nil span should not start children")
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package gramework
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultTraceBatchSize is the default max count of spans exported at once
+	DefaultTraceBatchSize = 512
+	// DefaultTraceQueueSize is the default count of finished spans,
+	// that wait for the export. Spans are dropped if the queue is full.
+	DefaultTraceQueueSize = 2048
+	// DefaultTraceFlushInterval is the default max time finished spans wait for the export
+	DefaultTraceFlushInterval = 5 * time.Second
+	// DefaultTraceExportTimeout is the default timeout of a single export
+	DefaultTraceExportTimeout = 30 * time.Second
+)
+
+type (
+	// SpanExporter sends finished spans to a tracing backend,
+	// see InMemoryExporter and the tracing/otlphttp package.
+	// Exporter must not retain the spans slice after ExportSpans returns.
+	SpanExporter interface {
+		ExportSpans(ctx context.Context, spans []SpanData) error
+		Shutdown(ctx context.Context) error
+	}
+
+	// Tracer creates spans and exports them in batches
+	Tracer struct {
+		exporter      SpanExporter
+		sampleRatio   float64
+		batchSize     int
+		queueSize     int
+		flushInterval time.Duration
+		exportTimeout time.Duration
+		onError       func(error)
+
+		queue     chan SpanData
+		flushReq  chan chan struct{}
+		stop      chan struct{}
+		done      chan struct{}
+		closeOnce sync.Once
+		dropped   uint64
+	}
+
+	// TracerOption configures the Tracer
+	TracerOption func(*Tracer)
+)
+
+// TracerSampleRatio sets the ratio of new traces that are sampled, from 0 to 1.
+// Traces continued from a traceparent header keep their sampling decision.
+// By default, all traces are sampled.
+func TracerSampleRatio(ratio float64) TracerOption {
+	return func(t *Tracer) {
+		t.sampleRatio = ratio
+	}
+}
+
+// TracerBatchSize sets the max count of spans exported at once
+func TracerBatchSize(size int) TracerOption {
+	return func(t *Tracer) {
+		if size > 0 {
+			t.batchSize = size
+		}
+	}
+}
+
+// TracerQueueSize sets the count of finished spans, that wait for the export
+func TracerQueueSize(size int) TracerOption {
+	return func(t *Tracer) {
+		if size > 0 {
+			t.queueSize = size
+		}
+	}
+}
+
+// TracerFlushInterval sets the max time finished spans wait for the export
+func TracerFlushInterval(d time.Duration) TracerOption {
+	return func(t *Tracer) {
+		if d > 0 {
+			t.flushInterval = d
+		}
+	}
+}
+
+// TracerExportTimeout sets the timeout of a single export
+func TracerExportTimeout(d time.Duration) TracerOption {
+	return func(t *Tracer) {
+		if d > 0 {
+			t.exportTimeout = d
+		}
+	}
+}
+
+// TracerOnError sets the export error handler. By default, errors are logged.
+func TracerOnError(handler func(error)) TracerOption {
+	return func(t *Tracer) {
+		t.onError = handler
+	}
+}
+
+// NewTracer returns a new Tracer and starts its export loop.
+// Use OptTracer to trace app requests.
+func NewTracer(exporter SpanExporter, opts ...TracerOption) *Tracer {
+	t := &Tracer{
+		exporter:      exporter,
+		sampleRatio:   1,
+		batchSize:     DefaultTraceBatchSize,
+		queueSize:     DefaultTraceQueueSize,
+		flushInterval: DefaultTraceFlushInterval,
+		exportTimeout: DefaultTraceExportTimeout,
+		onError: func(err error) {
+			internalLog.WithError(err).Error("could not export spans")
+		},
+		flushReq: make(chan chan struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.queue = make(chan SpanData, t.queueSize)
+
+	go t.loop()
+	return t
+}
+
+// Dropped returns the count of spans dropped because the queue was full
+func (t *Tracer) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// Flush exports all finished spans
+func (t *Tracer) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case t.flushReq <- done:
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown exports all finished spans and shuts the exporter down.
+// Spans, finished after the shutdown, are dropped.
+// It may be used as an App.OnShutdown hook.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.closeOnce.Do(func() {
+		close(t.stop)
+	})
+
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return t.exporter.Shutdown(ctx)
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	select {
+	case <-t.stop:
+		atomic.AddUint64(&t.dropped, 1)
+		return
+	default:
+	}
+
+	select {
+	case t.queue <- data:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+func (t *Tracer) loop() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(t.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, t.batchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), t.exportTimeout)
+		if err := t.exporter.ExportSpans(ctx, batch); err != nil && t.onError != nil {
+			t.onError(err)
+		}
+		cancel()
+		batch = batch[:0]
+	}
+	drain := func() {
+		for {
+			select {
+			case data := <-t.queue:
+				batch = append(batch, data)
+				if len(batch) >= t.batchSize {
+					export()
+				}
+			default:
+				export()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= t.batchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case done := <-t.flushReq:
+			drain()
+			close(done)
+		case <-t.stop:
+			drain()
+			return
+		}
+	}
+}
+
+// InMemoryExporter keeps exported spans in memory. It's useful in tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// NewInMemoryExporter returns a new InMemoryExporter
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+// ExportSpans stores the spans
+func (e *InMemoryExporter) ExportSpans(_ context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	e.spans = append(e.spans, spans...)
+	e.mu.Unlock()
+	return nil
+}
+
+// Shutdown does nothing
+func (e *InMemoryExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// Spans returns a copy of exported spans
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset removes all exported spans
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	e.spans = nil
+	e.mu.Unlock()
+}
@@ -0,0 +1,321 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+// Package otlphttp provides a gramework.SpanExporter, that sends spans
+// to an OpenTelemetry collector using OTLP/HTTP with JSON encoding.
+package otlphttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gramework/gramework"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// DefaultEndpoint is the default collector endpoint
+	DefaultEndpoint = "http://localhost:4318"
+	// TracesPath is the path of OTLP/HTTP traces endpoint,
+	// appended to endpoints without a path
+	TracesPath = "/v1/traces"
+	// DefaultTimeout is the default export timeout
+	DefaultTimeout = 10 * time.Second
+
+	scopeName = "github.com/gramework/gramework"
+)
+
+// Option configures the Exporter
+type Option func(*Exporter)
+
+// Exporter sends spans to an OpenTelemetry collector
+type Exporter struct {
+	url      string
+	headers  map[string]string
+	timeout  time.Duration
+	resource []keyValue
+	client   *fasthttp.Client
+}
+
+// WithHeader adds the header to export requests, e.g. an API key
+func WithHeader(key, value string) Option {
+	return func(e *Exporter) {
+		e.headers[key] = value
+	}
+}
+
+// WithTimeout sets the export timeout, if the export context has no deadline
+func WithTimeout(d time.Duration) Option {
+	return func(e *Exporter) {
+		e.timeout = d
+	}
+}
+
+// WithServiceName sets the service.name resource attribute.
+// By default, "unknown_service:" with the executable name is used.
+func WithServiceName(name string) Option {
+	return WithResourceAttribute("service.name", name)
+}
+
+// WithResourceAttribute adds the resource attribute, e.g. "deployment.environment"
+func WithResourceAttribute(key string, value interface{}) Option {
+	return func(e *Exporter) {
+		for i := range e.resource {
+			if e.resource[i].Key == key {
+				e.resource[i].Value = newAnyValue(value)
+				return
+			}
+		}
+		e.resource = append(e.resource, keyValue{Key: key, Value: newAnyValue(value)})
+	}
+}
+
+// WithClient sets the HTTP client used for the export
+func WithClient(client *fasthttp.Client) Option {
+	return func(e *Exporter) {
+		e.client = client
+	}
+}
+
+// New returns an exporter, that sends spans to the collector endpoint,
+// e.g. "http://otel-collector:4318". If the endpoint has no path,
+// TracesPath is used. Empty endpoint means DefaultEndpoint.
+func New(endpoint string, opts ...Option) (*Exporter, error) {
+	if len(endpoint) == 0 {
+		endpoint = DefaultEndpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("otlphttp: invalid endpoint: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("otlphttp: unsupported endpoint scheme %q", u.Scheme)
+	}
+	if len(u.Path) == 0 || u.Path == "/" {
+		u.Path = TracesPath
+	}
+
+	e := &Exporter{
+		url:     u.String(),
+		headers: make(map[string]string),
+		timeout: DefaultTimeout,
+		client:  &fasthttp.Client{},
+	}
+	WithServiceName("unknown_service:" + filepath.Base(os.Args[0]))(e)
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	return e, nil
+}
+
+// ExportSpans sends the spans to the collector
+func (e *Exporter) ExportSpans(ctx context.Context, spans []gramework.SpanData) error {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return fmt.Errorf("otlphttp: %s", err)
+	}
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(e.url)
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	req.SetBodyRaw(body)
+
+	timeout := e.timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	if err = e.client.DoTimeout(req, resp, timeout); err != nil {
+		return fmt.Errorf("otlphttp: %s", err)
+	}
+	if code := resp.StatusCode(); code < 200 || code > 299 {
+		return fmt.Errorf("otlphttp: collector responded with %d: %s", code, resp.Body())
+	}
+
+	return nil
+}
+
+// Shutdown does nothing, the exporter has no background work
+func (e *Exporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// OTLP JSON encoding, see opentelemetry-proto/opentelemetry/proto/trace/v1/trace.proto
+type (
+	exportRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+
+	resource struct {
+		Attributes []keyValue `json:"attributes"`
+	}
+
+	scopeSpans struct {
+		Scope scope  `json:"scope"`
+		Spans []span `json:"spans"`
+	}
+
+	scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+
+	span struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		TraceState        string     `json:"traceState,omitempty"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []keyValue `json:"attributes,omitempty"`
+		Status            status     `json:"status"`
+	}
+
+	status struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+
+	keyValue struct {
+		Key   string   `json:"key"`
+		Value anyValue `json:"value"`
+	}
+
+	anyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+func (e *Exporter) request(spans []gramework.SpanData) *exportRequest {
+	out := make([]span, 0, len(spans))
+	for i := range spans {
+		out = append(out, newSpan(&spans[i]))
+	}
+
+	return &exportRequest{
+		ResourceSpans: []resourceSpans{{
+			Resource: resource{Attributes: e.resource},
+			ScopeSpans: []scopeSpans{{
+				Scope: scope{Name: scopeName, Version: gramework.Version},
+				Spans: out,
+			}},
+		}},
+	}
+}
+
+func newSpan(s *gramework.SpanData) span {
+	out := span{
+		TraceID:           s.SpanContext.TraceID.String(),
+		SpanID:            s.SpanContext.SpanID.String(),
+		TraceState:        s.SpanContext.TraceState,
+		Name:              s.Name,
+		Kind:              spanKind(s.Kind),
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		Status: status{
+			Code:    int(s.Status),
+			Message: s.StatusMessage,
+		},
+	}
+	if s.ParentSpanID.IsValid() {
+		out.ParentSpanID = s.ParentSpanID.String()
+	}
+	for _, attr := range s.Attributes {
+		out.Attributes = append(out.Attributes, keyValue{Key: attr.Key, Value: newAnyValue(attr.Value)})
+	}
+
+	return out
+}
+
+// spanKind converts the span kind to OTLP SpanKind:
+// INTERNAL = 1, SERVER = 2, CLIENT = 3
+func spanKind(kind gramework.SpanKind) int {
+	switch kind {
+	case gramework.SpanKindServer:
+		return 2
+	case gramework.SpanKindClient:
+		return 3
+	default:
+		return 1
+	}
+}
+
+func newAnyValue(v interface{}) anyValue {
+	var (
+		i     int64
+		isInt = true
+	)
+	switch val := v.(type) {
+	case string:
+		return anyValue{StringValue: &val}
+	case bool:
+		return anyValue{BoolValue: &val}
+	case float64:
+		return anyValue{DoubleValue: &val}
+	case float32:
+		f := float64(val)
+		return anyValue{DoubleValue: &f}
+	case int:
+		i = int64(val)
+	case int8:
+		i = int64(val)
+	case int16:
+		i = int64(val)
+	case int32:
+		i = int64(val)
+	case int64:
+		i = val
+	case uint:
+		i = int64(val)
+	case uint8:
+		i = int64(val)
+	case uint16:
+		i = int64(val)
+	case uint32:
+		i = int64(val)
+	default:
+		isInt = false
+	}
+	if isInt {
+		s := strconv.FormatInt(i, 10)
+		return anyValue{IntValue: &s}
+	}
+
+	s := fmt.Sprint(v)
+	return anyValue{StringValue: &s}
+}
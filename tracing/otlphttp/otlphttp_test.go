@@ -0,0 +1,117 @@
+// Copyright 2017-present Kirill Danshin and Gramework contributors
+// Copyright 2019-present Highload LTD (UK CN: 11893420)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+
+package otlphttp
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gramework/gramework"
+	"github.com/valyala/fasthttp"
+)
+
+type testRequest struct {
+	path    string
+	apiKey  string
+	payload map[string]interface{}
+}
+
+func TestExporter(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan testRequest, 1)
+	go func() {
+		_ = fasthttp.Serve(ln, func(ctx *fasthttp.RequestCtx) {
+			req := testRequest{
+				path:   string(ctx.Path()),
+				apiKey: string(ctx.Request.Header.Peek("X-Api-Key")),
+			}
+			_ = json.Unmarshal(ctx.PostBody(), &req.payload)
+			received <- req
+		})
+	}()
+
+	e, err := New("http://"+ln.Addr().String(), WithServiceName("users"), WithHeader("X-Api-Key", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parent, _ := gramework.ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	start := time.Unix(1, 0)
+	err = e.ExportSpans(context.Background(), []gramework.SpanData{{
+		Name:         "GET /users/:id",
+		SpanContext:  gramework.SpanContext{TraceID: parent.TraceID, SpanID: gramework.SpanID{1}, TraceFlags: 1},
+		ParentSpanID: parent.SpanID,
+		Kind:         gramework.SpanKindServer,
+		Start:        start,
+		End:          start.Add(time.Second),
+		Attributes: []gramework.SpanAttribute{
+			{Key: gramework.SpanAttrHTTPStatusCode, Value: 500},
+			{Key: gramework.SpanAttrHTTPRoute, Value: "/users/:id"},
+		},
+		Status:        gramework.SpanStatusError,
+		StatusMessage: "failed",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-received
+	if req.path != TracesPath || req.apiKey != "secret" {
+		t.Fatalf("unexpected request: %+v", req)
+	}
+
+	rs := req.payload["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	resourceAttr := rs["resource"].(map[string]interface{})["attributes"].([]interface{})[0].(map[string]interface{})
+	if resourceAttr["key"] != "service.name" || resourceAttr["value"].(map[string]interface{})["stringValue"] != "users" {
+		t.Errorf("unexpected resource attribute: %v", resourceAttr)
+	}
+
+	span := rs["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})[0].(map[string]interface{})
+	expected := map[string]interface{}{
+		"traceId":           "4bf92f3577b34da6a3ce929d0e0e4736",
+		"spanId":            "0100000000000000",
+		"parentSpanId":      "00f067aa0ba902b7",
+		"name":              "GET /users/:id",
+		"kind":              float64(2),
+		"startTimeUnixNano": "1000000000",
+		"endTimeUnixNano":   "2000000000",
+	}
+	for k, v := range expected {
+		if span[k] != v {
+			t.Errorf("span %s = %v, expected %v", k, span[k], v)
+		}
+	}
+	if status := span["status"].(map[string]interface{}); status["code"] != float64(2) || status["message"] != "failed" {
+		t.Errorf("unexpected status: %v", status)
+	}
+	statusAttr := span["attributes"].([]interface{})[0].(map[string]interface{})
+	if statusAttr["value"].(map[string]interface{})["intValue"] != "500" {
+		t.Errorf("unexpected int attribute: %v", statusAttr)
+	}
+}
+
+func TestNewInvalidEndpoint(t *testing.T) {
+	if _, err := New("grpc://localhost:4317"); err == nil {
+		t.Fatalf("expected error for unsupported scheme")
+	}
+	e, err := New("https://collector.local/custom/traces")
+	if err != nil || e.url != "https://collector.local/custom/traces" {
+		t.Fatalf("custom path should be kept: %v %v", e, err)
+	}
+}
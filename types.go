@@ -11,7 +11,11 @@
 package gramework
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
+	"os"
 	"sync"
 	"time"
 
@@ -20,6 +24,7 @@ import (
 	"github.com/apex/log"
 	"github.com/gramework/utils/nocopy"
 	"github.com/valyala/fasthttp"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 type (
@@ -68,6 +73,8 @@ type (
 		PanicHandlerNoPoweredBy   bool
 		PanicHandlerCustomLayout  string
 		internalLog               *log.Entry
+		logLevel                  *LogLevel
+		packageLogLevels          map[string]LogLevel
 
 		cookieExpire time.Duration
 
@@ -90,9 +97,53 @@ type (
 
 		behind Behind
 
+		listenerWrappers []func(net.Listener) net.Listener
+		unixSocketMode   os.FileMode
+		unixSocketUID    int
+		unixSocketGID    int
+		unixSocketChown  bool
+		tlsBind          string
+
+		tlsCerts          *certStore
+		tlsMinVersion     uint16
+		tlsCipherSuites   []uint16
+		tlsReloadInterval time.Duration
+		tlsClientAuth     tls.ClientAuthType
+		tlsClientCAs      *x509.CertPool
+
+		acme             *autocert.Manager
+		acmeMu           *sync.Mutex
+		acmeMountOnce    *sync.Once
+		acmeDirectoryURL string
+		acmeHosts        []string
+		acmeHostPolicy   autocert.HostPolicy
+		acmeCache        autocert.Cache
+		acmeEvents       func(ACMEEvent)
+
+		lifecycleMu     *sync.Mutex
+		onStart         []LifecycleHook
+		onShutdown      []LifecycleHook
+		ready           int32
+		started         int32
+		shutdownTimeout time.Duration
+		preStopDelay    time.Duration
+		shutdownSignals []os.Signal
+		upgradeSignal   os.Signal
+		upgradeTimeout  time.Duration
+
+		tracer *Tracer
+
+		healthMu     *sync.RWMutex
+		healthChecks []*healthCheck
+
 		sanitizerPolicy *bluemonday.Policy
 
 		DefaultCacheOptions *CacheOptions
+		cache               *sharedCache
+		etag                *etagConfig
+
+		requestLimitsMu *sync.RWMutex
+		requestLimits   []requestLimitRule
 	}
 
 	// CacheOptions is a handler cache configuration structure.
@@ -113,19 +164,42 @@ type (
 		// StoreCache allows for cache engine replacement. By default, gramework uses github.com/VictoriaMetrics/fastcache.
 		StoreCache func(ctx *Context, key, value []byte, ttl time.Duration)
 
+		// StaleWhileRevalidate is how long an expired response may be served
+		// while it is refreshed in the background, if the response has no
+		// stale-while-revalidate directive. Zero disables it.
+		StaleWhileRevalidate time.Duration
+		// StaleIfError is how long an expired response may be served
+		// if the handler fails with 5xx status code, if the response has no
+		// stale-if-error directive. Zero disables it.
+		StaleIfError time.Duration
+		// HardTTL is the time that cached response is kept in the cache,
+		// including the time it may be served stale. TTL and the response freshness
+		// are the soft TTL. By default, responses are kept while they may be served stale.
+		HardTTL time.Duration
+		// DisableCoalescing disables request coalescing. By default, concurrent requests
+		// for the same missing or expired response wait for the first one to run the handler.
+		DisableCoalescing bool
+
+		// CacheableStatusCodes is a list of status codes, responses with which can be cached.
+		// DefaultCacheableStatusCodes are used by default.
+		CacheableStatusCodes []int
+
 		// CacheableHeaders is a list of headers that gramework can cache.
-		// Note, that if X-ABC is present both in cacheable and noncacheable header lists,
-		// it will not be cached.
+		//
+		// Deprecated: all end-to-end response headers are cached now,
+		// use NonCacheableHeaders to exclude some of them.
 		CacheableHeaders []string // slice of canonical header names
 		// NonCacheableHeaders is a list of headers that gramework can not cache.
-		// Note, that if X-ABC is present both in cacheable and noncacheable header lists,
-		// it will not be cached.
+		// Connection-specific headers, Set-Cookie and Date are never cached.
 		NonCacheableHeaders []string
 	}
 
 	runningServerInfo struct {
 		bind string
 		srv  *fasthttp.Server
+		// ln is the listener before wrapping,
+		// used to pass it to the upgraded process
+		ln net.Listener
 	}
 
 	contextKey string
@@ -139,8 +213,16 @@ type (
 		auth      *Auth
 		Cookies   Cookies
 		requestID string
+		// routeTemplate is the registered route, that matched the request
+		routeTemplate string
+		// domain is the app.Domain() router, that served the request
+		domain string
+		span   *Span
+		// cacheTags are App.Cache tags of the response, see CacheTags
+		cacheTags []string
 
 		middlewaresShouldStopProcessing bool
+		afterRequestStarted             bool
 		subPrefixes                     []string
 		middlewareKilledReq             bool
 		writer                          func(p []byte) (int, error)
@@ -204,6 +286,7 @@ type (
 		httpsrouter *Router
 		root        *Router
 		app         *App
+		domain      string
 		mu          sync.RWMutex
 
 		rootHandler []staticHandler
@@ -12,9 +12,15 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+
+	"github.com/gramework/gramework"
+	"github.com/valyala/fasthttp"
 )
 
+const maxRedirectsCount = 16
+
 // GET sends a request with GET method
 func (client *Instance) GET() (statusCode int, body []byte, err error) {
 	api, err := client.nextServer()
@@ -22,7 +28,45 @@ func (client *Instance) GET() (statusCode int, body []byte, err error) {
 		return 0, nil, err
 	}
 
-	return api.HostClient.Get(nil, api.Addr)
+	return api.get(nil, nil)
+}
+
+// GETContext sends a request with GET method. If the context has a span
+// (see gramework.SpanFromContext), the request continues its trace.
+func (client *Instance) GETContext(ctx context.Context) (statusCode int, body []byte, err error) {
+	api, err := client.nextServer()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return api.get(gramework.SpanFromContext(ctx), nil)
+}
+
+// get sends a GET request and appends the response body to dst.
+// If parent span is not nil, the request is traced.
+func (api *requestInfo) get(parent *gramework.Span, dst []byte) (statusCode int, body []byte, err error) {
+	if parent == nil {
+		return api.HostClient.Get(dst, api.Addr)
+	}
+
+	span := parent.StartChild(fasthttp.MethodGet, gramework.SpanKindClient)
+	defer span.End()
+	span.SetAttribute(gramework.SpanAttrURLFull, api.Addr)
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(api.Addr)
+	gramework.InjectTraceContext(&req.Header, span.Context())
+	if err = api.HostClient.DoRedirects(req, resp, maxRedirectsCount); err != nil {
+		span.RecordError(err)
+		return 0, dst, err
+	}
+
+	span.SetHTTPStatus(resp.StatusCode())
+	return resp.StatusCode(), append(dst, resp.Body()...), nil
 }
 
 // GetJSON sends a GET request and deserializes response in a provided variable
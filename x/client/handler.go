@@ -28,7 +28,7 @@ func (client *Instance) handleHTTP(ctx *gramework.Context) error {
 
 	bytes := buffer.Get()
 	defer buffer.Put(bytes)
-	statusCode, body, err := api.HostClient.Get(bytes.B, api.Addr)
+	statusCode, body, err := api.get(ctx.Span(), bytes.B)
 	if err != nil {
 		ctx.Logger.Errorf("error while .Do() the request %s", err)
 		return err
@@ -51,7 +51,11 @@ func (pc *PortChooser) Used() *PortChooser {
 
 // Acquire applies all filters defined before and returns a port number.
 func (pc *PortChooser) Acquire() int {
-	_, port := pc.determinePort()
+	ln, port := pc.determinePort()
+	if ln != nil {
+		// the port should be free for the caller
+		_ = ln.Close()
+	}
 
 	portsRegisterMu.Lock()
 	portsRegister[uint16(port)] = struct{}{}